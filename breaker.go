@@ -0,0 +1,117 @@
+// Copyright 2024, OpenCensus Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package stackdriver
+
+import (
+	"sync"
+	"time"
+
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// defaultCircuitBreakerCoolDown is the time writes are skipped for once the
+// circuit breaker opened, when Options.CircuitBreakerCoolDown is not set.
+const defaultCircuitBreakerCoolDown = 30 * time.Second
+
+// breakerFailureCodes are the gRPC codes of the failed calls counted by the
+// circuit breaker. Other errors, such as time series rejected with
+// InvalidArgument, show that Stackdriver Monitoring is reachable.
+var breakerFailureCodes = map[codes.Code]bool{
+	codes.Unavailable:       true,
+	codes.DeadlineExceeded:  true,
+	codes.ResourceExhausted: true,
+	codes.Internal:          true,
+}
+
+// isBreakerFailure reports whether err is a whole-call failure counted by the
+// circuit breaker.
+func isBreakerFailure(err error) bool {
+	return err != nil && breakerFailureCodes[status.Code(err)]
+}
+
+// circuitBreaker skips writes for a cool-down period after a number of
+// consecutive failed writes. A nil *circuitBreaker allows every write.
+type circuitBreaker struct {
+	threshold     int
+	coolDown      time.Duration
+	onStateChange func(open bool)
+	now           func() time.Time
+
+	mu        sync.Mutex
+	failures  int
+	open      bool
+	openUntil time.Time
+	probing   bool // a write is attempted after the cool-down
+}
+
+func newCircuitBreaker(threshold int, coolDown time.Duration, onStateChange func(open bool)) *circuitBreaker {
+	if coolDown <= 0 {
+		coolDown = defaultCircuitBreakerCoolDown
+	}
+	return &circuitBreaker{
+		threshold:     threshold,
+		coolDown:      coolDown,
+		onStateChange: onStateChange,
+		now:           time.Now,
+	}
+}
+
+// allow reports whether a write may be attempted. Once the cool-down elapsed,
+// a single write is attempted until its outcome is recorded; the breaker
+// closes if it succeeds and opens again if it fails.
+func (b *circuitBreaker) allow() bool {
+	if b == nil {
+		return true
+	}
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if !b.open {
+		return true
+	}
+	if b.probing || b.now().Before(b.openUntil) {
+		return false
+	}
+	b.probing = true
+	return true
+}
+
+// record records the outcome of an attempted write.
+func (b *circuitBreaker) record(failed bool) {
+	if b == nil {
+		return
+	}
+	b.mu.Lock()
+	b.probing = false
+	var changed, open bool
+	if failed {
+		b.failures++
+		if b.failures >= b.threshold {
+			changed = !b.open
+			b.open = true
+			b.openUntil = b.now().Add(b.coolDown)
+		}
+	} else {
+		changed = b.open
+		b.open = false
+		b.failures = 0
+	}
+	open = b.open
+	b.mu.Unlock()
+
+	if changed && b.onStateChange != nil {
+		b.onStateChange(open)
+	}
+}
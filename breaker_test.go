@@ -0,0 +1,141 @@
+// Copyright 2024, OpenCensus Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package stackdriver
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"testing"
+	"time"
+
+	monitoring "cloud.google.com/go/monitoring/apiv3/v2"
+	monitoringpb "google.golang.org/genproto/googleapis/monitoring/v3" //nolint: staticcheck
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+func TestSendReqCircuitBreaker(t *testing.T) {
+	calls := 0
+	fail := true
+	persisted := createTimeSeries
+	createTimeSeries = func(ctx context.Context, c *monitoring.MetricClient, req *monitoringpb.CreateTimeSeriesRequest) error { //nolint: staticcheck
+		calls++
+		if fail {
+			return status.Error(codes.Unavailable, "unavailable")
+		}
+		return nil
+	}
+	defer func() { createTimeSeries = persisted }()
+
+	var states []bool
+	now := time.Now()
+	b := newCircuitBreaker(2, time.Minute, func(open bool) { states = append(states, open) })
+	b.now = func() time.Time { return now }
+
+	mc, _ := monitoring.NewMetricClient(context.Background())
+	send := func() int {
+		d, _ := sendReq(context.Background(), mc, &monitoringpb.CreateTimeSeriesRequest{TimeSeries: makeTs(3, false)}, sendOptions{breaker: b}) //nolint: staticcheck
+		return d
+	}
+
+	// Two consecutive failures open the breaker.
+	send()
+	send()
+	if calls != 2 || len(states) != 1 || !states[0] {
+		t.Fatalf("After 2 failures: calls = %d, states = %v; want 2 calls and the breaker open", calls, states)
+	}
+
+	// Writes are skipped during the cool-down and count as dropped.
+	if d := send(); d != 3 {
+		t.Errorf("Dropped during cool-down = %d; want 3", d)
+	}
+	if calls != 2 {
+		t.Errorf("Calls during cool-down = %d; want none", calls-2)
+	}
+
+	// After the cool-down a successful write closes the breaker.
+	now = now.Add(time.Minute)
+	fail = false
+	if d := send(); d != 0 {
+		t.Errorf("Dropped after cool-down = %d; want 0", d)
+	}
+	if calls != 3 || len(states) != 2 || states[1] {
+		t.Errorf("After cool-down: calls = %d, states = %v; want 3 calls and the breaker closed", calls, states)
+	}
+}
+
+func TestSendReqCircuitBreakerIgnoresRejectedTimeSeries(t *testing.T) {
+	var err error
+	persisted := createTimeSeries
+	createTimeSeries = func(ctx context.Context, c *monitoring.MetricClient, req *monitoringpb.CreateTimeSeriesRequest) error { //nolint: staticcheck
+		return err
+	}
+	defer func() { createTimeSeries = persisted }()
+
+	b := newCircuitBreaker(1, time.Minute, nil)
+	mc, _ := monitoring.NewMetricClient(context.Background())
+	for _, err = range []error{
+		status.Error(codes.InvalidArgument, "One or more TimeSeries could not be written"),
+		errors.New("unknown failure"),
+	} {
+		sendReq(context.Background(), mc, &monitoringpb.CreateTimeSeriesRequest{TimeSeries: makeTs(3, false)}, sendOptions{breaker: b}) //nolint: staticcheck
+		if !b.allow() {
+			t.Errorf("Breaker opened after %v; want it closed", err)
+		}
+	}
+}
+
+func TestCircuitBreakerSingleProbe(t *testing.T) {
+	now := time.Now()
+	b := newCircuitBreaker(1, time.Minute, nil)
+	b.now = func() time.Time { return now }
+	b.record(true)
+	now = now.Add(time.Minute)
+
+	var wg sync.WaitGroup
+	var mu sync.Mutex
+	allowed := 0
+	for i := 0; i < 10; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if b.allow() {
+				mu.Lock()
+				allowed++
+				mu.Unlock()
+			}
+		}()
+	}
+	wg.Wait()
+	if allowed != 1 {
+		t.Fatalf("Got %d writes allowed after the cool-down; want a single probe", allowed)
+	}
+
+	// A failed probe opens the breaker for another cool-down.
+	b.record(true)
+	if b.allow() {
+		t.Error("Write allowed after a failed probe; want the breaker open")
+	}
+	now = now.Add(time.Minute)
+	if !b.allow() {
+		t.Fatal("Probe not allowed after the second cool-down")
+	}
+	// A successful probe closes the breaker.
+	b.record(false)
+	if !b.allow() || !b.allow() {
+		t.Error("Writes skipped after a successful probe; want the breaker closed")
+	}
+}
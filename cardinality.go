@@ -0,0 +1,131 @@
+// Copyright 2024, OpenCensus Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package stackdriver
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	monitoringpb "google.golang.org/genproto/googleapis/monitoring/v3" //nolint: staticcheck
+)
+
+// defaultSeriesWindow is the reporting window used to count the distinct label
+// combinations of a metric when Options.ReportingInterval is not set.
+const defaultSeriesWindow = 60 * time.Second
+
+// seriesLimiter tracks the distinct label combinations exported for every
+// metric type within a reporting window, and rejects new combinations once
+// a metric reached the limit.
+type seriesLimiter struct {
+	max    int
+	window time.Duration
+	now    func() time.Time
+
+	mu          sync.Mutex
+	windowStart time.Time
+	series      map[string]map[string]bool // metric type -> label combinations
+	exceeded    map[string]bool            // metric types already reported in this window
+}
+
+func newSeriesLimiter(max int, window time.Duration) *seriesLimiter {
+	if window <= 0 {
+		window = defaultSeriesWindow
+	}
+	return &seriesLimiter{
+		max:    max,
+		window: window,
+		now:    time.Now,
+	}
+}
+
+// allow reports whether the time series may be exported. The returned error,
+// if non-nil, must be reported; it is only returned for the first rejected
+// time series of a metric in each window.
+func (l *seriesLimiter) allow(ts *monitoringpb.TimeSeries) (bool, error) { //nolint: staticcheck
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	if now := l.now(); l.series == nil || now.Sub(l.windowStart) >= l.window {
+		l.windowStart = now
+		l.series = make(map[string]map[string]bool)
+		l.exceeded = make(map[string]bool)
+	}
+
+	metricType := ts.GetMetric().GetType()
+	key := labelsKey(ts.GetMetric().GetLabels())
+	seen, ok := l.series[metricType]
+	if !ok {
+		seen = make(map[string]bool)
+		l.series[metricType] = seen
+	}
+	if seen[key] {
+		return true, nil
+	}
+	if len(seen) < l.max {
+		seen[key] = true
+		return true, nil
+	}
+	if l.exceeded[metricType] {
+		return false, nil
+	}
+	l.exceeded[metricType] = true
+	return false, fmt.Errorf("metric %q exceeded %d label combinations in the current reporting window, dropping new combinations", metricType, l.max)
+}
+
+// labelsKey returns a string uniquely identifying the label combination.
+func labelsKey(labels map[string]string) string {
+	keys := make([]string, 0, len(labels))
+	for k := range labels {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+
+	var b strings.Builder
+	for _, k := range keys {
+		fmt.Fprintf(&b, "%q=%q,", k, labels[k])
+	}
+	return b.String()
+}
+
+// allowSeries reports whether the time series is within the MaxSeriesPerMetric
+// limit, reporting the first rejection of a metric per window via OnError.
+func (e *statsExporter) allowSeries(ts *monitoringpb.TimeSeries) bool { //nolint: staticcheck
+	if e.seriesLimiter == nil {
+		return true
+	}
+	ok, err := e.seriesLimiter.allow(ts)
+	if err != nil {
+		e.o.handleError(err)
+	}
+	return ok
+}
+
+// limitSeries returns the time series of tsl that are within the
+// MaxSeriesPerMetric limit.
+func (e *statsExporter) limitSeries(tsl []*monitoringpb.TimeSeries) []*monitoringpb.TimeSeries { //nolint: staticcheck
+	if e.seriesLimiter == nil {
+		return tsl
+	}
+	allowed := tsl[:0]
+	for _, ts := range tsl {
+		if e.allowSeries(ts) {
+			allowed = append(allowed, ts)
+		}
+	}
+	return allowed
+}
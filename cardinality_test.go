@@ -0,0 +1,115 @@
+// Copyright 2024, OpenCensus Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package stackdriver
+
+import (
+	"context"
+	"fmt"
+	"testing"
+	"time"
+
+	monitoring "cloud.google.com/go/monitoring/apiv3/v2"
+	"go.opencensus.io/stats"
+	"go.opencensus.io/stats/view"
+	"go.opencensus.io/tag"
+	metricpb "google.golang.org/genproto/googleapis/api/metric"
+	monitoringpb "google.golang.org/genproto/googleapis/monitoring/v3" //nolint: staticcheck
+)
+
+func TestMaxSeriesPerMetricDropsExcessCombinations(t *testing.T) {
+	oldCreateTimeSeries := createTimeSeries
+	defer func() {
+		createTimeSeries = oldCreateTimeSeries
+	}()
+
+	var got []*monitoringpb.TimeSeries                                                                                          //nolint: staticcheck
+	createTimeSeries = func(ctx context.Context, c *monitoring.MetricClient, req *monitoringpb.CreateTimeSeriesRequest) error { //nolint: staticcheck
+		got = append(got, req.TimeSeries...)
+		return nil
+	}
+
+	key := tag.MustNewKey("user_id")
+	v := &view.View{
+		Name:        "test_view_cardinality",
+		Measure:     stats.Int64("test-measure/TestMaxSeriesPerMetricDropsExcessCombinations", "measure desc", stats.UnitDimensionless),
+		Aggregation: view.Count(),
+		TagKeys:     []tag.Key{key},
+	}
+	vd := &view.Data{View: v, Start: time.Now(), End: time.Now()}
+	for i := 0; i < 5; i++ {
+		vd.Rows = append(vd.Rows, &view.Row{
+			Tags: []tag.Tag{{Key: key, Value: fmt.Sprintf("user-%d", i)}},
+			Data: &view.CountData{Value: 1},
+		})
+	}
+
+	var gotErrs []error
+	e := &statsExporter{
+		o: Options{
+			ProjectID:               "test_project",
+			SkipCMD:                 true,
+			DefaultMonitoringLabels: &Labels{},
+			OnError:                 func(err error) { gotErrs = append(gotErrs, err) },
+		},
+		seriesLimiter: newSeriesLimiter(3, time.Minute),
+	}
+	for i := 0; i < 2; i++ {
+		if err := e.uploadStats([]*view.Data{vd}); err != nil {
+			t.Fatalf("Exporter.uploadStats() error = %v", err)
+		}
+	}
+
+	// The same three combinations are accepted on every upload.
+	if len(got) != 6 {
+		t.Fatalf("got %d time series uploaded; want 6", len(got))
+	}
+	for _, ts := range got {
+		switch id := ts.Metric.Labels["user_id"]; id {
+		case "user-0", "user-1", "user-2":
+		default:
+			t.Errorf("unexpected time series uploaded for %q", id)
+		}
+	}
+	if len(gotErrs) != 1 {
+		t.Errorf("got %d errors reported; want 1: %v", len(gotErrs), gotErrs)
+	}
+}
+
+func TestSeriesLimiterResetsEachWindow(t *testing.T) {
+	now := time.Now()
+	l := newSeriesLimiter(1, time.Minute)
+	l.now = func() time.Time { return now }
+
+	newTs := func(value string) *monitoringpb.TimeSeries { //nolint: staticcheck
+		return &monitoringpb.TimeSeries{ //nolint: staticcheck
+			Metric: &metricpb.Metric{Type: "custom.googleapis.com/opencensus/m", Labels: map[string]string{"k": value}},
+		}
+	}
+
+	if ok, err := l.allow(newTs("a")); !ok || err != nil {
+		t.Fatalf("allow(a) = %v, %v; want true, nil", ok, err)
+	}
+	if ok, err := l.allow(newTs("b")); ok || err == nil {
+		t.Fatalf("allow(b) = %v, %v; want false and an error", ok, err)
+	}
+	if ok, err := l.allow(newTs("c")); ok || err != nil {
+		t.Fatalf("allow(c) = %v, %v; want false, nil", ok, err)
+	}
+
+	now = now.Add(time.Minute)
+	if ok, err := l.allow(newTs("b")); !ok || err != nil {
+		t.Fatalf("allow(b) in new window = %v, %v; want true, nil", ok, err)
+	}
+}
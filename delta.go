@@ -0,0 +1,257 @@
+// Copyright 2024, OpenCensus Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package stackdriver
+
+import (
+	"sync"
+	"time"
+
+	distributionpb "google.golang.org/genproto/googleapis/api/distribution"
+	monitoredrespb "google.golang.org/genproto/googleapis/api/monitoredres"
+	monitoringpb "google.golang.org/genproto/googleapis/monitoring/v3" //nolint: staticcheck
+	"google.golang.org/protobuf/proto"
+	"google.golang.org/protobuf/types/known/timestamppb"
+)
+
+// staleSeriesAge is how long the state of a time series that is no longer
+// exported is kept before it is evicted.
+const staleSeriesAge = time.Hour
+
+// staleSeries tracks when time series were last exported, to evict the state
+// kept for the ones that are no longer exported. The zero value is ready to use.
+type staleSeries struct {
+	now       func() time.Time // time.Now if nil
+	lastUsed  map[string]time.Time
+	lastSweep time.Time
+}
+
+// touch records that the time series identified by key is exported, and
+// returns the keys of the time series not exported for staleSeriesAge, which
+// it stops tracking. The time series are only swept once per staleSeriesAge.
+func (s *staleSeries) touch(key string) []string {
+	now := time.Now
+	if s.now != nil {
+		now = s.now
+	}
+	t := now()
+	if s.lastUsed == nil {
+		s.lastUsed = make(map[string]time.Time)
+	}
+	s.lastUsed[key] = t
+	if t.Sub(s.lastSweep) < staleSeriesAge {
+		return nil
+	}
+	s.lastSweep = t
+	var stale []string
+	for k, used := range s.lastUsed {
+		if t.Sub(used) >= staleSeriesAge {
+			stale = append(stale, k)
+			delete(s.lastUsed, k)
+		}
+	}
+	return stale
+}
+
+// distributionDeltas converts consecutive cumulative distribution points of the
+// same time series into delta points. The zero value is ready to use.
+type distributionDeltas struct {
+	mu sync.Mutex
+	// last holds the last cumulative point written of every time series, and
+	// pending the last one converted, until commit records it as written.
+	last    map[string]*monitoringpb.Point //nolint: staticcheck
+	pending map[string]*monitoringpb.Point //nolint: staticcheck
+	series  staleSeries
+}
+
+// seriesSignature returns the key identifying a time series.
+func seriesSignature(metricType string, labels map[string]string, rsc *monitoredrespb.MonitoredResource) string {
+	return metricType + "|" + labelsKey(labels) + "|" + rsc.GetType() + "|" + labelsKey(rsc.GetLabels())
+}
+
+// toDelta returns the difference between the cumulative point pt and the
+// previous cumulative point written of the time series identified by key. The
+// first point of a time series, and the first point after the time series was
+// reset, are returned unchanged so the full distribution is sent.
+func (d *distributionDeltas) toDelta(key string, pt *monitoringpb.Point) *monitoringpb.Point { //nolint: staticcheck
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	if d.last == nil {
+		d.last = make(map[string]*monitoringpb.Point)    //nolint: staticcheck
+		d.pending = make(map[string]*monitoringpb.Point) //nolint: staticcheck
+	}
+	for _, stale := range d.series.touch(key) {
+		delete(d.last, stale)
+		delete(d.pending, stale)
+	}
+	prev := d.last[key]
+	d.pending[key] = pt
+
+	curDist := pt.GetValue().GetDistributionValue()
+	prevDist := prev.GetValue().GetDistributionValue()
+	if curDist == nil || prevDist == nil || distributionReset(prev, pt) {
+		return pt
+	}
+
+	return &monitoringpb.Point{ //nolint: staticcheck
+		Interval: &monitoringpb.TimeInterval{ //nolint: staticcheck
+			StartTime: prev.GetInterval().GetEndTime(),
+			EndTime:   pt.GetInterval().GetEndTime(),
+		},
+		Value: &monitoringpb.TypedValue{ //nolint: staticcheck
+			Value: &monitoringpb.TypedValue_DistributionValue{
+				DistributionValue: distributionDelta(prevDist, curDist),
+			},
+		},
+	}
+}
+
+// commit records the point last converted of the time series identified by
+// key as written, so the next delta of the time series starts from it. Until
+// then, the deltas of the time series that failed to be written keep starting
+// from the last point written.
+func (d *distributionDeltas) commit(key string) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	if pt, ok := d.pending[key]; ok {
+		d.last[key] = pt
+		delete(d.pending, key)
+	}
+}
+
+// commitSeries records the time series of tsl as written, see
+// distributionDeltas.commit and deltaWindows.commit.
+func (se *statsExporter) commitSeries(tsl []*monitoringpb.TimeSeries) { //nolint: staticcheck
+	if !se.o.CumulativeDistributionsAsDelta && se.o.MetricKindOverride == nil {
+		return
+	}
+	for _, ts := range tsl {
+		key := seriesSignature(ts.GetMetric().GetType(), ts.GetMetric().GetLabels(), ts.GetResource())
+		se.distDeltas.commit(key)
+		se.deltaWindows.commit(key)
+	}
+}
+
+// distributionReset reports whether cur does not continue the cumulative
+// distribution of prev, i.e. the time series was restarted or its buckets changed.
+func distributionReset(prev, cur *monitoringpb.Point) bool { //nolint: staticcheck
+	if !proto.Equal(prev.GetInterval().GetStartTime(), cur.GetInterval().GetStartTime()) {
+		return true
+	}
+	prevDist := prev.GetValue().GetDistributionValue()
+	curDist := cur.GetValue().GetDistributionValue()
+	if curDist.Count < prevDist.Count ||
+		len(curDist.BucketCounts) != len(prevDist.BucketCounts) ||
+		!proto.Equal(curDist.BucketOptions, prevDist.BucketOptions) {
+		return true
+	}
+	for i, c := range curDist.BucketCounts {
+		if c < prevDist.BucketCounts[i] {
+			return true
+		}
+	}
+	return false
+}
+
+// distributionDelta returns the distribution of the values recorded in cur
+// but not in prev.
+func distributionDelta(prev, cur *distributionpb.Distribution) *distributionpb.Distribution {
+	delta := &distributionpb.Distribution{
+		Count:         cur.Count - prev.Count,
+		BucketOptions: cur.BucketOptions,
+		BucketCounts:  make([]int64, len(cur.BucketCounts)),
+		Exemplars:     cur.Exemplars,
+	}
+	for i, c := range cur.BucketCounts {
+		delta.BucketCounts[i] = c - prev.BucketCounts[i]
+	}
+	if delta.Count == 0 {
+		return delta
+	}
+
+	prevCount, deltaCount := float64(prev.Count), float64(delta.Count)
+	delta.Mean = (cur.Mean*float64(cur.Count) - prev.Mean*prevCount) / deltaCount
+	// Remove the contribution of prev from the combined sum of squared deviation.
+	meanDiff := prev.Mean - delta.Mean
+	ssd := cur.SumOfSquaredDeviation - prev.SumOfSquaredDeviation - meanDiff*meanDiff*prevCount*deltaCount/float64(cur.Count)
+	if ssd > 0 {
+		delta.SumOfSquaredDeviation = ssd
+	}
+	return delta
+}
+
+// minDeltaWindow is the length of the interval of a DELTA point that has no
+// previous point and no start time before its end time.
+const minDeltaWindow = time.Millisecond
+
+// deltaWindows assigns consecutive intervals to the points of time series
+// exported with the DELTA metric kind. The zero value is ready to use.
+type deltaWindows struct {
+	mu sync.Mutex
+	// lastEnd holds the end time of the last point written of every time
+	// series, and pending the one of the last point windowed, until commit
+	// records it as written.
+	lastEnd map[string]*timestamppb.Timestamp
+	pending map[string]*timestamppb.Timestamp
+	series  staleSeries
+}
+
+// window sets the start time of every point of pts, in order, to the end time
+// of the previous point of the time series identified by key. A point without
+// a previous point keeps its start time if it is before its end time, since
+// Stackdriver Monitoring rejects empty DELTA intervals.
+func (d *deltaWindows) window(key string, pts []*monitoringpb.Point) { //nolint: staticcheck
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	if d.lastEnd == nil {
+		d.lastEnd = make(map[string]*timestamppb.Timestamp)
+		d.pending = make(map[string]*timestamppb.Timestamp)
+	}
+	for _, stale := range d.series.touch(key) {
+		delete(d.lastEnd, stale)
+		delete(d.pending, stale)
+	}
+	last, hasLast := d.lastEnd[key]
+	for _, pt := range pts {
+		if pt.GetInterval() == nil || pt.Interval.EndTime == nil {
+			continue
+		}
+		end := pt.Interval.EndTime.AsTime()
+		start := pt.Interval.StartTime
+		if hasLast && last.AsTime().Before(end) {
+			start = last
+		}
+		if start == nil || !start.AsTime().Before(end) {
+			start = timestampProto(end.Add(-minDeltaWindow))
+		}
+		pt.Interval.StartTime = start
+		last, hasLast = pt.Interval.EndTime, true
+		d.pending[key] = last
+	}
+}
+
+// commit records the point last windowed of the time series identified by
+// key as written, so the next point of the time series starts at its end.
+func (d *deltaWindows) commit(key string) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	if end, ok := d.pending[key]; ok {
+		d.lastEnd[key] = end
+		delete(d.pending, key)
+	}
+}
@@ -0,0 +1,124 @@
+// Copyright 2024, OpenCensus Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package stackdriver
+
+import (
+	"strconv"
+
+	"go.opencensus.io/metric/metricdata"
+)
+
+const (
+	bucketMetricSuffix = "_bucket"
+	sumMetricSuffix    = "_sum"
+	countMetricSuffix  = "_count"
+
+	// bucketUpperBoundLabel is the label holding the inclusive upper bound
+	// of the buckets of an exploded distribution.
+	bucketUpperBoundLabel = "le"
+)
+
+// explodeDistributions replaces every distribution metric with Prometheus-style
+// counters: "<name>_bucket" with the cumulative count of values less than or
+// equal to the "le" label, "<name>_sum" and "<name>_count". Other metrics are
+// returned unchanged.
+func explodeDistributions(metrics []*metricdata.Metric) []*metricdata.Metric {
+	out := make([]*metricdata.Metric, 0, len(metrics))
+	for _, metric := range metrics {
+		switch metric.Descriptor.Type {
+		case metricdata.TypeCumulativeDistribution:
+			out = append(out, explodeDistribution(metric, metricdata.TypeCumulativeInt64, metricdata.TypeCumulativeFloat64)...)
+		case metricdata.TypeGaugeDistribution:
+			out = append(out, explodeDistribution(metric, metricdata.TypeGaugeInt64, metricdata.TypeGaugeFloat64)...)
+		default:
+			out = append(out, metric)
+		}
+	}
+	return out
+}
+
+func explodeDistribution(metric *metricdata.Metric, intType, floatType metricdata.Type) []*metricdata.Metric {
+	desc := metric.Descriptor
+	bucketKeys := append(append([]metricdata.LabelKey(nil), desc.LabelKeys...), metricdata.LabelKey{
+		Key:         bucketUpperBoundLabel,
+		Description: "Inclusive upper bound of the bucket",
+	})
+	newMetric := func(suffix string, typ metricdata.Type, unit metricdata.Unit, keys []metricdata.LabelKey) *metricdata.Metric {
+		return &metricdata.Metric{
+			Descriptor: metricdata.Descriptor{
+				Name:        desc.Name + suffix,
+				Description: desc.Description,
+				Unit:        unit,
+				Type:        typ,
+				LabelKeys:   keys,
+			},
+			Resource: metric.Resource,
+		}
+	}
+	bucketMetric := newMetric(bucketMetricSuffix, intType, metricdata.UnitDimensionless, bucketKeys)
+	sumMetric := newMetric(sumMetricSuffix, floatType, desc.Unit, desc.LabelKeys)
+	countMetric := newMetric(countMetricSuffix, intType, metricdata.UnitDimensionless, desc.LabelKeys)
+
+	for _, ts := range metric.TimeSeries {
+		newTs := func(labelValues []metricdata.LabelValue, pts []metricdata.Point) *metricdata.TimeSeries {
+			return &metricdata.TimeSeries{LabelValues: labelValues, Points: pts, StartTime: ts.StartTime}
+		}
+		var sumPts, countPts []metricdata.Point
+		var bucketPts [][]metricdata.Point
+		for _, pt := range ts.Points {
+			dist, ok := pt.Value.(*metricdata.Distribution)
+			if !ok {
+				continue
+			}
+			sumPts = append(sumPts, metricdata.NewFloat64Point(pt.Time, dist.Sum))
+			countPts = append(countPts, metricdata.NewInt64Point(pt.Time, dist.Count))
+
+			var cumulative int64
+			for i, bucket := range dist.Buckets {
+				cumulative += bucket.Count
+				if i == len(bucketPts) {
+					bucketPts = append(bucketPts, nil)
+				}
+				bucketPts[i] = append(bucketPts[i], metricdata.NewInt64Point(pt.Time, cumulative))
+			}
+		}
+		if len(sumPts) == 0 {
+			continue
+		}
+		sumMetric.TimeSeries = append(sumMetric.TimeSeries, newTs(ts.LabelValues, sumPts))
+		countMetric.TimeSeries = append(countMetric.TimeSeries, newTs(ts.LabelValues, countPts))
+
+		bounds := distributionBounds(ts.Points)
+		for i, pts := range bucketPts {
+			le := "+Inf"
+			if i < len(bounds) {
+				le = strconv.FormatFloat(bounds[i], 'g', -1, 64)
+			}
+			labelValues := append(append([]metricdata.LabelValue(nil), ts.LabelValues...), metricdata.NewLabelValue(le))
+			bucketMetric.TimeSeries = append(bucketMetric.TimeSeries, newTs(labelValues, pts))
+		}
+	}
+	return []*metricdata.Metric{bucketMetric, sumMetric, countMetric}
+}
+
+// distributionBounds returns the bucket bounds of the first distribution point.
+func distributionBounds(pts []metricdata.Point) []float64 {
+	for _, pt := range pts {
+		if dist, ok := pt.Value.(*metricdata.Distribution); ok && dist.BucketOptions != nil {
+			return dist.BucketOptions.Bounds
+		}
+	}
+	return nil
+}
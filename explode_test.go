@@ -0,0 +1,102 @@
+// Copyright 2024, OpenCensus Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package stackdriver
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	monitoring "cloud.google.com/go/monitoring/apiv3/v2"
+	"github.com/google/go-cmp/cmp"
+	"go.opencensus.io/metric/metricdata"
+	monitoringpb "google.golang.org/genproto/googleapis/monitoring/v3" //nolint: staticcheck
+)
+
+func TestExplodeDistributions(t *testing.T) {
+	oldCreateTimeSeries := createTimeSeries
+	defer func() {
+		createTimeSeries = oldCreateTimeSeries
+	}()
+
+	type series struct {
+		metricType string
+		labels     map[string]string
+		value      interface{}
+	}
+	var got []series
+	createTimeSeries = func(ctx context.Context, c *monitoring.MetricClient, req *monitoringpb.CreateTimeSeriesRequest) error { //nolint: staticcheck
+		for _, ts := range req.TimeSeries {
+			var value interface{}
+			switch v := ts.Points[0].Value.Value.(type) {
+			case *monitoringpb.TypedValue_Int64Value:
+				value = v.Int64Value
+			case *monitoringpb.TypedValue_DoubleValue:
+				value = v.DoubleValue
+			default:
+				t.Errorf("unexpected value type %T for %q", v, ts.Metric.Type)
+			}
+			got = append(got, series{ts.Metric.Type, ts.Metric.Labels, value})
+		}
+		return nil
+	}
+
+	now := time.Now()
+	metric := &metricdata.Metric{
+		Descriptor: metricdata.Descriptor{
+			Name:      "latency",
+			Unit:      metricdata.UnitMilliseconds,
+			Type:      metricdata.TypeCumulativeDistribution,
+			LabelKeys: []metricdata.LabelKey{{Key: "method"}},
+		},
+		TimeSeries: []*metricdata.TimeSeries{{
+			StartTime:   now.Add(-time.Minute),
+			LabelValues: []metricdata.LabelValue{metricdata.NewLabelValue("GET")},
+			Points: []metricdata.Point{{
+				Time: now,
+				Value: &metricdata.Distribution{
+					Count:         6,
+					Sum:           130,
+					BucketOptions: &metricdata.BucketOptions{Bounds: []float64{10, 50}},
+					Buckets:       []metricdata.Bucket{{Count: 1}, {Count: 3}, {Count: 2}},
+				},
+			}},
+		}},
+	}
+
+	se := &statsExporter{
+		o: Options{
+			ProjectID:               "foo",
+			SkipCMD:                 true,
+			DefaultMonitoringLabels: &Labels{},
+			ExplodeDistributions:    true,
+		},
+	}
+	if err := se.uploadMetrics(context.Background(), []*metricdata.Metric{metric}); err != nil {
+		t.Fatalf("uploadMetrics() error = %v", err)
+	}
+
+	const prefix = "custom.googleapis.com/opencensus/"
+	want := []series{
+		{prefix + "latency_bucket", map[string]string{"method": "GET", "le": "10"}, int64(1)},
+		{prefix + "latency_bucket", map[string]string{"method": "GET", "le": "50"}, int64(4)},
+		{prefix + "latency_bucket", map[string]string{"method": "GET", "le": "+Inf"}, int64(6)},
+		{prefix + "latency_sum", map[string]string{"method": "GET"}, float64(130)},
+		{prefix + "latency_count", map[string]string{"method": "GET"}, int64(6)},
+	}
+	if diff := cmp.Diff(got, want, cmp.AllowUnexported(series{})); diff != "" {
+		t.Errorf("exploded time series -got +want: %s", diff)
+	}
+}
@@ -22,7 +22,7 @@ directly to Stackdriver Metrics.
 import (
 	"context"
 	"fmt"
-	"strings"
+	"time"
 
 	"github.com/golang/protobuf/ptypes/any"
 	"github.com/golang/protobuf/ptypes/timestamp"
@@ -37,6 +37,7 @@ import (
 
 	"github.com/launchdarkly/opencensus-go-exporter-stackdriver/monitoredresource"
 	"go.opencensus.io/metric/metricdata"
+	"go.opencensus.io/metric/metricproducer"
 	"go.opencensus.io/resource"
 )
 
@@ -46,6 +47,10 @@ const (
 
 	// TODO(songy23): add support for this.
 	// exemplarAttachmentTypeDroppedLabels = "type.googleapis.com/google.monitoring.v3.DroppedLabels"
+
+	// maxPointFutureSkew is how far in the future a point end time may be
+	// when MaxPointAge is set.
+	maxPointFutureSkew = 5 * time.Minute
 )
 
 // ExportMetrics exports OpenCensus Metrics to Stackdriver Monitoring.
@@ -55,6 +60,9 @@ func (se *statsExporter) ExportMetrics(ctx context.Context, metrics []*metricdat
 	}
 
 	for _, metric := range metrics {
+		if !se.o.exportMetric(metric) {
+			continue
+		}
 		se.metricsBundler.Add(metric, 1) //nolint: errcheck
 		// TODO: [rghetia] handle errors.
 	}
@@ -63,17 +71,28 @@ func (se *statsExporter) ExportMetrics(ctx context.Context, metrics []*metricdat
 }
 
 func (se *statsExporter) handleMetricsUpload(metrics []*metricdata.Metric) {
-	err := se.uploadMetrics(metrics)
+	err := se.uploadMetrics(se.o.Context, metrics)
 	if err != nil {
 		se.o.handleError(err)
 	}
 }
 
-func (se *statsExporter) uploadMetrics(metrics []*metricdata.Metric) error {
-	ctx, cancel := newContextWithTimeout(se.o.Context, se.o.Timeout)
-	defer cancel()
+// forceExport reads the current state of all registered metric producers
+// and uploads it synchronously, bypassing the bundler.
+func (se *statsExporter) forceExport(ctx context.Context) error {
+	var metrics []*metricdata.Metric
+	for _, producer := range metricproducer.GlobalManager().GetAll() {
+		metrics = append(metrics, producer.Read()...)
+	}
+	if len(metrics) == 0 {
+		return nil
+	}
+	return se.uploadMetrics(ctx, metrics)
+}
 
-	var errors []error
+func (se *statsExporter) uploadMetrics(ctx context.Context, metrics []*metricdata.Metric) error {
+	ctx, cancel := newContextWithTimeout(ctx, se.o.Timeout)
+	defer cancel()
 
 	ctx, span := trace.StartSpan(
 		ctx,
@@ -82,20 +101,136 @@ func (se *statsExporter) uploadMetrics(metrics []*metricdata.Metric) error {
 	)
 	defer span.End()
 
+	metrics = se.o.filterMetrics(metrics)
+	if se.o.ExplodeDistributions {
+		metrics = explodeDistributions(metrics)
+	}
+
+	budget := se.o.newRetryBudget()
+	chunkSize := se.o.syncFlushChunkSize(len(metrics))
+	var errors []error
+	for start := 0; start < len(metrics); start += chunkSize {
+		end := start + chunkSize
+		if end > len(metrics) {
+			end = len(metrics)
+		}
+		errors = append(errors, se.uploadMetricsChunk(ctx, metrics[start:end], budget)...)
+	}
+	if len(errors) > 0 {
+		span.SetStatus(trace.Status{Code: trace.StatusCodeUnknown, Message: errors[len(errors)-1].Error()})
+	}
+	return combineErrors(errors)
+}
+
+// PushMetrics exports OpenCensus metrics to Stackdriver Monitoring synchronously,
+// without adding them to the bundler, and returns the number of dropped time
+// series. The time series of a metric whose descriptor could not be created
+// are dropped.
+func (se *statsExporter) PushMetrics(ctx context.Context, metrics []*metricdata.Metric) (int, error) {
+	if len(metrics) == 0 {
+		return 0, errNilMetricOrMetricDescriptor
+	}
+	metrics = se.o.filterMetrics(metrics)
+	if se.o.ExplodeDistributions {
+		metrics = explodeDistributions(metrics)
+	}
+
+	mb := newMetricsBatcher(ctx, se.o.requestName(), se.o.numberOfWorkers(ctx), se.c, se.o.WorkerTimeout, se.sendOptions(), se.o.TransformRequest)
+	chunkSize := se.o.syncFlushChunkSize(len(metrics))
+	for start := 0; start < len(metrics); start += chunkSize {
+		end := start + chunkSize
+		if end > len(metrics) {
+			end = len(metrics)
+		}
+		se.pushMetricsChunk(ctx, mb, metrics[start:end])
+	}
+
+	return mb.droppedTimeSeries, mb.close(ctx)
+}
+
+// pushMetricsChunk converts metrics and adds their time series to mb.
+func (se *statsExporter) pushMetricsChunk(ctx context.Context, mb *metricsBatcher, metrics []*metricdata.Metric) {
+	var allTimeSeries []*monitoringpb.TimeSeries //nolint: staticcheck
+	for _, metric := range metrics {
+		if metric == nil {
+			mb.recordDroppedTimeseries(0, errNilMetricOrMetricDescriptor)
+			continue
+		}
+		if err := se.createMetricDescriptorFromMetric(ctx, metric); err != nil {
+			mb.recordDroppedTimeseries(len(metric.TimeSeries), err)
+			continue
+		}
+		tsl, err := se.metricToMpbTs(ctx, metric)
+		if err != nil {
+			mb.recordDroppedTimeseries(len(metric.TimeSeries), err)
+			continue
+		}
+		allowed := se.limitSeries(tsl)
+		mb.recordDroppedTimeseries(len(tsl) - len(allowed))
+		allTimeSeries = append(allTimeSeries, allowed...)
+	}
+
+	// A time series may occur more than once, e.g. after its reset point, and
+	// each occurrence is only sent once the previous ones are written.
+	for i, req := range se.combineTimeSeriesToCreateTimeSeriesRequest(allTimeSeries) {
+		if i > 0 {
+			mb.flush()
+		}
+		for _, ts := range req.TimeSeries {
+			mb.addTimeSeries(ts)
+		}
+	}
+}
+
+// syncFlushChunkSize returns the number of metrics, out of n, converted and
+// uploaded at once.
+func (o Options) syncFlushChunkSize(n int) int {
+	if o.SyncFlushChunkSize > 0 && o.SyncFlushChunkSize < n {
+		return o.SyncFlushChunkSize
+	}
+	return n
+}
+
+// exportMetric reports whether the metric passes Options.MetricFilter.
+// Nil metrics are left for the export path to report.
+func (o Options) exportMetric(metric *metricdata.Metric) bool {
+	return o.MetricFilter == nil || metric == nil || o.MetricFilter(metric)
+}
+
+// filterMetrics returns the metrics that pass Options.MetricFilter.
+func (o Options) filterMetrics(metrics []*metricdata.Metric) []*metricdata.Metric {
+	if o.MetricFilter == nil {
+		return metrics
+	}
+	out := make([]*metricdata.Metric, 0, len(metrics))
+	for _, metric := range metrics {
+		if o.exportMetric(metric) {
+			out = append(out, metric)
+		}
+	}
+	return out
+}
+
+// uploadMetricsChunk converts and uploads metrics, and returns the errors.
+func (se *statsExporter) uploadMetricsChunk(ctx context.Context, metrics []*metricdata.Metric, budget *retryBudget) []error {
+	var errors []error
+
+	exportable := make([]*metricdata.Metric, 0, len(metrics))
 	for _, metric := range metrics {
 		// Now create the metric descriptor remotely.
 		if err := se.createMetricDescriptorFromMetric(ctx, metric); err != nil {
-			span.SetStatus(trace.Status{Code: trace.StatusCodeUnknown, Message: err.Error()})
 			errors = append(errors, err)
-			continue
+			if se.o.RequireExistingDescriptor || se.o.SkipUnconfirmedMetrics {
+				continue
+			}
 		}
+		exportable = append(exportable, metric)
 	}
 
 	var allTimeSeries []*monitoringpb.TimeSeries //nolint: staticcheck
-	for _, metric := range metrics {
+	for _, metric := range exportable {
 		tsl, err := se.metricToMpbTs(ctx, metric)
 		if err != nil {
-			span.SetStatus(trace.Status{Code: trace.StatusCodeUnknown, Message: err.Error()})
 			errors = append(errors, err)
 			continue
 		}
@@ -104,6 +239,8 @@ func (se *statsExporter) uploadMetrics(metrics []*metricdata.Metric) error {
 		}
 	}
 
+	allTimeSeries = se.limitSeries(allTimeSeries)
+
 	// Now batch timeseries up and then export.
 	for start, end := 0, 0; start < len(allTimeSeries); start = end {
 		end = start + maxTimeSeriesPerUpload
@@ -111,13 +248,20 @@ func (se *statsExporter) uploadMetrics(metrics []*metricdata.Metric) error {
 			end = len(allTimeSeries)
 		}
 		batch := allTimeSeries[start:end]
-		serviceTsBatch, nonServiceTsBatch := splitTimeSeries(batch)
+		var serviceTsBatch, nonServiceTsBatch []*monitoringpb.TimeSeries //nolint: staticcheck
+		if se.o.DisableServiceTimeSeries {
+			nonServiceTsBatch = batch
+		} else {
+			serviceTsBatch, nonServiceTsBatch = splitTimeSeries(batch)
+		}
 
 		if len(nonServiceTsBatch) > 0 {
 			nonServiceReql := se.combineTimeSeriesToCreateTimeSeriesRequest(nonServiceTsBatch)
 			for _, ctsreq := range nonServiceReql {
-				if err := createTimeSeries(ctx, se.c, ctsreq); err != nil {
-					span.SetStatus(trace.Status{Code: trace.StatusCodeUnknown, Message: err.Error()})
+				if ctsreq = se.o.transformRequest(ctsreq); ctsreq == nil {
+					continue
+				}
+				if err := se.sendTimeSeriesReq(ctx, ctsreq, createTimeSeriesFunc(se.o.Sink), budget); err != nil {
 					errors = append(errors, err)
 				}
 			}
@@ -125,25 +269,17 @@ func (se *statsExporter) uploadMetrics(metrics []*metricdata.Metric) error {
 		if len(serviceTsBatch) > 0 {
 			serviceReql := se.combineTimeSeriesToCreateTimeSeriesRequest(serviceTsBatch)
 			for _, ctsreq := range serviceReql {
-				if err := createServiceTimeSeries(ctx, se.c, ctsreq); err != nil {
-					span.SetStatus(trace.Status{Code: trace.StatusCodeUnknown, Message: err.Error()})
+				if ctsreq = se.o.transformRequest(ctsreq); ctsreq == nil {
+					continue
+				}
+				if err := se.sendTimeSeriesReq(ctx, ctsreq, createServiceTimeSeriesFunc(se.o.Sink), budget); err != nil {
 					errors = append(errors, err)
 				}
 			}
 		}
 	}
 
-	numErrors := len(errors)
-	if numErrors == 0 {
-		return nil
-	} else if numErrors == 1 {
-		return errors[0]
-	}
-	errMsgs := make([]string, 0, numErrors)
-	for _, err := range errors {
-		errMsgs = append(errMsgs, err.Error())
-	}
-	return fmt.Errorf("[%s]", strings.Join(errMsgs, "; "))
+	return errors
 }
 
 // metricToMpbTs converts a metric into a list of Stackdriver Monitoring v3 API TimeSeries
@@ -153,33 +289,48 @@ func (se *statsExporter) metricToMpbTs(ctx context.Context, metric *metricdata.M
 		return nil, errNilMetricOrMetricDescriptor
 	}
 
-	resource := se.metricRscToMpbRsc(metric.Resource)
+	resource := se.resourceFromContext(ctx)
+	if resource == nil {
+		resource = se.o.withGeneratedTaskID(se.metricRscToMpbRsc(metric.Resource))
+	}
 
 	metricName := metric.Descriptor.Name
 	metricType := se.metricTypeFromProto(metricName)
 	metricLabelKeys := metric.Descriptor.LabelKeys
-	metricKind, _ := metricDescriptorTypeToMetricKind(metric)
+	metricKind, _ := se.metricKind(metric)
 
 	if metricKind == googlemetricpb.MetricDescriptor_METRIC_KIND_UNSPECIFIED {
 		// ignore these Timeserieses. TODO [rghetia] log errors.
 		return nil, nil
 	}
 
+	defaultLabels := se.metricLabels(metricName, len(metricLabelKeys))
 	timeSeries := make([]*monitoringpb.TimeSeries, 0, len(metric.TimeSeries)) //nolint: staticcheck
 	for _, ts := range metric.TimeSeries {
-		sdPoints, err := se.metricTsToMpbPoint(ts, metricKind)
+		sdPoints, err := se.metricTsToMpbPoint(metricType, ts, metricKind)
 		if err != nil {
 			// TODO(@rghetia): record error metrics
+			se.o.handleError(fmt.Errorf("dropping time series of metric %q: %v", metricName, err))
 			continue
 		}
 
 		// Each TimeSeries has labelValues which MUST be correlated
 		// with that from the MetricDescriptor
-		labels, err := metricLabelsToTsLabels(se.defaultLabels, metricLabelKeys, ts.LabelValues)
+		labels, err := se.metricLabelsToTsLabels(defaultLabels, metricLabelKeys, ts.LabelValues)
 		if err != nil {
 			// TODO: (@rghetia) perhaps log this error from labels extraction, if non-nil.
 			continue
 		}
+		if !se.sampleSeries(metricType, labels) {
+			continue
+		}
+		if se.o.DropEmptyDistributions {
+			// Only the empty points are dropped, so the time series is exported
+			// again as soon as it has values.
+			if sdPoints = dropEmptyDistributions(sdPoints); len(sdPoints) == 0 {
+				continue
+			}
+		}
 
 		var rsc *monitoredrespb.MonitoredResource
 		var mr monitoredresource.Interface
@@ -188,12 +339,45 @@ func (se *statsExporter) metricToMpbTs(ctx context.Context, metric *metricdata.M
 			// TODO(rghetia): optimize this. It is inefficient to convert this for all metrics.
 			rsc = convertMonitoredResourceToPB(mr)
 			if rsc.Type == "" {
-				rsc.Type = "global"
-				rsc.Labels = nil
+				rsc = se.o.fallbackResource()
 			}
+			rsc = se.o.withGeneratedTaskID(rsc)
+		} else if tsRsc := se.timeSeriesResource(metric, ts); tsRsc != nil {
+			rsc = tsRsc
 		} else {
 			rsc = resource
 		}
+		if se.o.ResourceTypeFilter != nil && !se.o.ResourceTypeFilter(rsc.GetType()) {
+			continue
+		}
+		if se.o.OmitCumulativeStartTime && metricKind == googlemetricpb.MetricDescriptor_CUMULATIVE && !se.distributionAsDelta(metric) {
+			for _, pt := range sdPoints {
+				pt.Interval.StartTime = nil
+			}
+		}
+		if se.distributionAsDelta(metric) {
+			key := seriesSignature(metricType, labels, rsc)
+			for i, pt := range sdPoints {
+				sdPoints[i] = se.distDeltas.toDelta(key, pt)
+			}
+		} else if metricKind == googlemetricpb.MetricDescriptor_DELTA {
+			se.deltaWindows.window(seriesSignature(metricType, labels, rsc), sdPoints)
+		} else if se.o.EmitResetPoint && metricKind == googlemetricpb.MetricDescriptor_CUMULATIVE && len(sdPoints) > 0 &&
+			se.exportedSeries.add(seriesSignature(metricType, labels, rsc)) {
+			if pt := resetPoint(sdPoints[0]); pt != nil {
+				// The reset point and the real point are sent in separate requests,
+				// in this order, as combineTimeSeriesToCreateTimeSeriesRequest
+				// groups them.
+				timeSeries = append(timeSeries, &monitoringpb.TimeSeries{ //nolint: staticcheck
+					Metric: &googlemetricpb.Metric{
+						Type:   metricType,
+						Labels: labels,
+					},
+					Resource: rsc,
+					Points:   []*monitoringpb.Point{pt}, //nolint: staticcheck
+				})
+			}
+		}
 		timeSeries = append(timeSeries, &monitoringpb.TimeSeries{ //nolint: staticcheck
 			Metric: &googlemetricpb.Metric{
 				Type:   metricType,
@@ -207,7 +391,7 @@ func (se *statsExporter) metricToMpbTs(ctx context.Context, metric *metricdata.M
 	return timeSeries, nil
 }
 
-func metricLabelsToTsLabels(defaults map[string]labelValue, labelKeys []metricdata.LabelKey, labelValues []metricdata.LabelValue) (map[string]string, error) {
+func (se *statsExporter) metricLabelsToTsLabels(defaults map[string]labelValue, labelKeys []metricdata.LabelKey, labelValues []metricdata.LabelValue) (map[string]string, error) {
 	// Perform this sanity check now.
 	if len(labelKeys) != len(labelValues) {
 		return nil, fmt.Errorf("length mismatch: len(labelKeys)=%d len(labelValues)=%d", len(labelKeys), len(labelValues))
@@ -220,13 +404,15 @@ func metricLabelsToTsLabels(defaults map[string]labelValue, labelKeys []metricda
 	labels := make(map[string]string)
 	// Fill in the defaults firstly, irrespective of if the labelKeys and labelValues are mismatched.
 	for key, label := range defaults {
-		labels[sanitize(key)] = label.val
+		key = se.o.sanitize(key)
+		labels[key] = se.o.sanitizeLabelValue(key, label.val)
 	}
 
 	for i, labelKey := range labelKeys {
 		labelValue := labelValues[i]
 		if labelValue.Present {
-			labels[sanitize(labelKey.Key)] = labelValue.Value
+			key := se.o.sanitize(labelKey.Key)
+			labels[key] = se.o.sanitizeLabelValue(key, labelValue.Value)
 		}
 	}
 
@@ -249,7 +435,7 @@ func (se *statsExporter) createMetricDescriptorFromMetric(ctx context.Context, m
 		return nil
 	}
 
-	if builtinMetric(se.metricTypeFromProto(name)) {
+	if se.skipDescriptorCreation(se.metricTypeFromProto(name)) {
 		se.metricDescriptors[name] = true
 		return nil
 	}
@@ -277,7 +463,14 @@ func (se *statsExporter) metricToMpbMetricDescriptor(metric *metricdata.Metric)
 
 	metricType := se.metricTypeFromProto(metric.Descriptor.Name)
 	displayName := se.displayName(metric.Descriptor.Name)
-	metricKind, valueType := metricDescriptorTypeToMetricKind(metric)
+	metricKind, valueType := se.metricKind(metric)
+	if se.distributionAsDelta(metric) {
+		metricKind = googlemetricpb.MetricDescriptor_DELTA
+	}
+	labelKeys := metric.Descriptor.LabelKeys
+	if se.o.OmitAbsentDescriptorLabels {
+		labelKeys = presentLabelKeys(metric)
+	}
 
 	sdm := &googlemetricpb.MetricDescriptor{
 		Name:        fmt.Sprintf("projects/%s/metricDescriptors/%s", se.o.ProjectID, metricType),
@@ -287,19 +480,65 @@ func (se *statsExporter) metricToMpbMetricDescriptor(metric *metricdata.Metric)
 		Type:        metricType,
 		MetricKind:  metricKind,
 		ValueType:   valueType,
-		Labels:      metricLableKeysToLabels(se.defaultLabels, metric.Descriptor.LabelKeys),
+		Labels:      se.metricLableKeysToLabels(se.metricLabels(metric.Descriptor.Name, len(metric.Descriptor.LabelKeys)), labelKeys),
 	}
 
 	return sdm, nil
 }
 
-func metricLableKeysToLabels(defaults map[string]labelValue, labelKeys []metricdata.LabelKey) []*labelpb.LabelDescriptor {
+// timeSeriesResource returns the resource Options.ResourceForTimeSeries
+// selects for ts, or nil if it is not set or returns nil.
+func (se *statsExporter) timeSeriesResource(metric *metricdata.Metric, ts *metricdata.TimeSeries) *monitoredrespb.MonitoredResource {
+	if se.o.ResourceForTimeSeries == nil {
+		return nil
+	}
+	if rsc := se.o.ResourceForTimeSeries(metric, ts); rsc != nil {
+		return se.o.withGeneratedTaskID(rsc)
+	}
+	return nil
+}
+
+// metricKind returns the metric kind and value type of the metric, applying
+// Options.MetricKindOverride.
+func (se *statsExporter) metricKind(metric *metricdata.Metric) (googlemetricpb.MetricDescriptor_MetricKind, googlemetricpb.MetricDescriptor_ValueType) {
+	metricKind, valueType := metricDescriptorTypeToMetricKind(metric)
+	if se.o.MetricKindOverride == nil || metricKind == googlemetricpb.MetricDescriptor_METRIC_KIND_UNSPECIFIED {
+		return metricKind, valueType
+	}
+	if kind, ok := se.o.MetricKindOverride(&metric.Descriptor); ok {
+		metricKind = kind
+	}
+	return metricKind, valueType
+}
+
+// distributionAsDelta reports whether the metric is a cumulative distribution
+// that must be exported as a delta distribution.
+func (se *statsExporter) distributionAsDelta(metric *metricdata.Metric) bool {
+	return se.o.CumulativeDistributionsAsDelta && metric.Descriptor.Type == metricdata.TypeCumulativeDistribution
+}
+
+// presentLabelKeys returns the label keys of the metric that have a present
+// value in at least one of its time series.
+func presentLabelKeys(metric *metricdata.Metric) []metricdata.LabelKey {
+	var keys []metricdata.LabelKey
+	for i, key := range metric.Descriptor.LabelKeys {
+		for _, ts := range metric.TimeSeries {
+			if i < len(ts.LabelValues) && ts.LabelValues[i].Present {
+				keys = append(keys, key)
+				break
+			}
+		}
+	}
+	return keys
+}
+
+func (se *statsExporter) metricLableKeysToLabels(defaults map[string]labelValue, labelKeys []metricdata.LabelKey) []*labelpb.LabelDescriptor {
 	labelDescriptors := make([]*labelpb.LabelDescriptor, 0, len(defaults)+len(labelKeys))
 
 	// Fill in the defaults first.
 	for key, lbl := range defaults {
 		labelDescriptors = append(labelDescriptors, &labelpb.LabelDescriptor{
-			Key:         sanitize(key),
+			Key:         se.o.sanitize(key),
 			Description: lbl.desc,
 			ValueType:   labelpb.LabelDescriptor_STRING,
 		})
@@ -308,7 +547,7 @@ func metricLableKeysToLabels(defaults map[string]labelValue, labelKeys []metricd
 	// Now fill in those from the metric.
 	for _, key := range labelKeys {
 		labelDescriptors = append(labelDescriptors, &labelpb.LabelDescriptor{
-			Key:         sanitize(key.Key),
+			Key:         se.o.sanitize(key.Key),
 			Description: key.Description,
 			ValueType:   labelpb.LabelDescriptor_STRING, // We only use string tags
 		})
@@ -354,12 +593,13 @@ func (se *statsExporter) metricRscToMpbRsc(rs *resource.Resource) *monitoredresp
 	if rs == nil {
 		resource := se.o.Resource
 		if resource == nil {
-			resource = &monitoredrespb.MonitoredResource{
-				Type: "global",
-			}
+			resource = se.o.fallbackResource()
 		}
 		return resource
 	}
+	if rs.Type == "" && se.o.DefaultMonitoredResource != nil {
+		return se.o.DefaultMonitoredResource
+	}
 	typ := rs.Type
 	if typ == "" {
 		typ = "global"
@@ -377,31 +617,68 @@ func (se *statsExporter) metricRscToMpbRsc(rs *resource.Resource) *monitoredresp
 	return mrsp
 }
 
-func (se *statsExporter) metricTsToMpbPoint(ts *metricdata.TimeSeries, metricKind googlemetricpb.MetricDescriptor_MetricKind) (sptl []*monitoringpb.Point, err error) { //nolint: staticcheck
+func (se *statsExporter) metricTsToMpbPoint(metricType string, ts *metricdata.TimeSeries, metricKind googlemetricpb.MetricDescriptor_MetricKind) (sptl []*monitoringpb.Point, err error) { //nolint: staticcheck
 	for _, pt := range ts.Points {
 
 		// If we have a last value aggregation point i.e. MetricDescriptor_GAUGE
-		// StartTime should be nil.
-		startTime := timestampProto(ts.StartTime)
-		if metricKind == googlemetricpb.MetricDescriptor_GAUGE {
+		// StartTime should be nil. DELTA points without a start time get one
+		// from deltaWindows.
+		startTime := timestampProto(se.correctClockSkew(ts.StartTime))
+		if metricKind == googlemetricpb.MetricDescriptor_GAUGE ||
+			(metricKind == googlemetricpb.MetricDescriptor_DELTA && ts.StartTime.IsZero()) {
 			startTime = nil
 		}
 
-		spt, err := metricPointToMpbPoint(startTime, &pt, se.o.ProjectID)
+		pt.Time = se.correctClockSkew(pt.Time)
+		if err := se.checkPointAge(pt.Time); err != nil {
+			return nil, err
+		}
+		spt, err := se.metricPointToMpbPoint(metricType, startTime, &pt)
 		if err != nil {
 			return nil, err
 		}
+		if err := se.checkDistributionCount(spt.GetValue()); err != nil {
+			return nil, err
+		}
+		if err := se.checkDistributionBuckets(spt.GetValue()); err != nil {
+			return nil, err
+		}
 		sptl = append(sptl, spt)
 	}
 	return sptl, nil
 }
 
-func metricPointToMpbPoint(startTime *timestamp.Timestamp, pt *metricdata.Point, projectID string) (*monitoringpb.Point, error) { //nolint: staticcheck
+// correctClockSkew returns t adjusted by the ClockSkew option.
+func (se *statsExporter) correctClockSkew(t time.Time) time.Time {
+	if se.o.ClockSkew == 0 || t.IsZero() {
+		return t
+	}
+	return t.Add(-se.o.ClockSkew)
+}
+
+// checkPointAge returns an error if MaxPointAge is set and the point end time
+// is older than MaxPointAge or further in the future than Stackdriver Monitoring
+// accepts.
+func (se *statsExporter) checkPointAge(end time.Time) error {
+	if se.o.MaxPointAge <= 0 {
+		return nil
+	}
+	now := time.Now()
+	if age := now.Sub(end); age > se.o.MaxPointAge {
+		return fmt.Errorf("point end time %v is %v old, older than the maximum age %v", end, age, se.o.MaxPointAge)
+	}
+	if skew := end.Sub(now); skew > maxPointFutureSkew {
+		return fmt.Errorf("point end time %v is %v in the future, more than the allowed %v", end, skew, maxPointFutureSkew)
+	}
+	return nil
+}
+
+func (se *statsExporter) metricPointToMpbPoint(metricType string, startTime *timestamp.Timestamp, pt *metricdata.Point) (*monitoringpb.Point, error) { //nolint: staticcheck
 	if pt == nil {
 		return nil, nil
 	}
 
-	mptv, err := metricPointToMpbValue(pt, projectID)
+	mptv, err := se.metricPointToMpbValue(pt)
 	if err != nil {
 		return nil, err
 	}
@@ -413,10 +690,15 @@ func metricPointToMpbPoint(startTime *timestamp.Timestamp, pt *metricdata.Point,
 			EndTime:   timestampProto(pt.Time),
 		},
 	}
+	if se.o.PointInterval != nil {
+		if interval := se.o.PointInterval(metricType, pt); interval != nil {
+			mpt.Interval = interval
+		}
+	}
 	return mpt, nil
 }
 
-func metricPointToMpbValue(pt *metricdata.Point, projectID string) (*monitoringpb.TypedValue, error) { //nolint: staticcheck
+func (se *statsExporter) metricPointToMpbValue(pt *metricdata.Point) (*monitoringpb.TypedValue, error) { //nolint: staticcheck
 	if pt == nil {
 		return nil, nil
 	}
@@ -457,20 +739,27 @@ func metricPointToMpbValue(pt *metricdata.Point, projectID string) (*monitoringp
 		}
 
 		insertZeroBound := false
+		if dv.BucketOptions == nil && len(dv.Buckets) > 0 {
+			// Stackdriver Monitoring rejects bucket counts without bucket bounds.
+			return nil, fmt.Errorf("distribution has %d buckets but no bucket options", len(dv.Buckets))
+		}
 		if bopts := dv.BucketOptions; bopts != nil {
+			if err := validateBucketBounds(bopts.Bounds); err != nil {
+				return nil, err
+			}
 			insertZeroBound = shouldInsertZeroBound(bopts.Bounds...)
 			mv.DistributionValue.BucketOptions = &distributionpb.Distribution_BucketOptions{
 				Options: &distributionpb.Distribution_BucketOptions_ExplicitBuckets{
 					ExplicitBuckets: &distributionpb.Distribution_BucketOptions_Explicit{
-						// The first bucket bound should be 0.0 because the Metrics first bucket is
-						// [0, first_bound) but Stackdriver monitoring bucket bounds begin with -infinity
-						// (first bucket is (-infinity, 0))
+						// A 0.0 bound is inserted before a positive first bound because Stackdriver
+						// monitoring bucket bounds begin with -infinity (first bucket is (-infinity, 0)),
+						// see shouldInsertZeroBound for how negative values are reported.
 						Bounds: addZeroBoundOnCondition(insertZeroBound, bopts.Bounds...),
 					},
 				},
 			}
 		}
-		bucketCounts, exemplars := metricBucketToBucketCountsAndExemplars(dv.Buckets, projectID)
+		bucketCounts, exemplars := se.metricBucketToBucketCountsAndExemplars(dv.Buckets)
 		mv.DistributionValue.BucketCounts = addZeroBucketCountOnCondition(insertZeroBound, bucketCounts...)
 		mv.DistributionValue.Exemplars = exemplars
 
@@ -480,18 +769,32 @@ func metricPointToMpbValue(pt *metricdata.Point, projectID string) (*monitoringp
 	return tval, err
 }
 
-func metricBucketToBucketCountsAndExemplars(buckets []metricdata.Bucket, projectID string) ([]int64, []*distributionpb.Distribution_Exemplar) {
+// metricBucketToBucketCountsAndExemplars returns the bucket counts and the
+// exemplars of buckets. Every metricdata.Bucket carries at most one exemplar,
+// which is also all Stackdriver Monitoring accepts per bucket, so there is no
+// choice of exemplar to make.
+func (se *statsExporter) metricBucketToBucketCountsAndExemplars(buckets []metricdata.Bucket) ([]int64, []*distributionpb.Distribution_Exemplar) {
 	bucketCounts := make([]int64, len(buckets))
 	var exemplars []*distributionpb.Distribution_Exemplar
 	for i, bucket := range buckets {
 		bucketCounts[i] = bucket.Count
-		if bucket.Exemplar != nil {
-			exemplars = append(exemplars, metricExemplarToPbExemplar(bucket.Exemplar, projectID))
+		if exemplar := bucket.Exemplar; exemplar != nil && se.exportExemplar(exemplar) {
+			exemplars = append(exemplars, metricExemplarToPbExemplar(exemplar, se.o.ProjectID))
 		}
 	}
 	return bucketCounts, exemplars
 }
 
+// exportExemplar reports whether the exemplar may be exported: with
+// ExemplarOnlyIfSampled, unless it is attached to an unsampled span context.
+func (se *statsExporter) exportExemplar(exemplar *metricdata.Exemplar) bool {
+	if !se.o.ExemplarOnlyIfSampled {
+		return true
+	}
+	spanCtx, ok := exemplar.Attachments[metricdata.AttachmentKeySpanContext].(trace.SpanContext)
+	return !ok || spanCtx.IsSampled()
+}
+
 func metricExemplarToPbExemplar(exemplar *metricdata.Exemplar, projectID string) *distributionpb.Distribution_Exemplar {
 	return &distributionpb.Distribution_Exemplar{
 		Value:       exemplar.Value,
@@ -522,6 +825,10 @@ func toPbStringAttachment(v interface{}) *any.Any {
 	}
 }
 
+// toPbSpanCtxAttachment converts spanCtx to a SpanContext attachment. The
+// SpanContext proto only holds the span name, so the sampling decision in
+// spanCtx.TraceOptions cannot be exported: sampled and unsampled span contexts
+// produce the same attachment.
 func toPbSpanCtxAttachment(spanCtx trace.SpanContext, projectID string) *any.Any {
 	pbSpanCtx := monitoringpb.SpanContext{ //nolint: staticcheck
 		SpanName: fmt.Sprintf("projects/%s/traces/%s/spans/%s", projectID, spanCtx.TraceID.String(), spanCtx.SpanID.String()),
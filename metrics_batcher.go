@@ -25,6 +25,7 @@ import (
 
 	monitoring "cloud.google.com/go/monitoring/apiv3/v2"
 	monitoringpb "google.golang.org/genproto/googleapis/monitoring/v3" //nolint: staticcheck
+	"google.golang.org/grpc/status"
 )
 
 const (
@@ -32,6 +33,24 @@ const (
 	minReqsChanSize = 5
 )
 
+type numberOfWorkersKey struct{}
+
+// WithNumberOfWorkers returns a copy of ctx that makes the PushMetricsProto and
+// ExportMetricsProto calls made with it send their requests with n workers
+// instead of Options.NumberOfWorkers, e.g. to export a large batch faster.
+func WithNumberOfWorkers(ctx context.Context, n int) context.Context {
+	return context.WithValue(ctx, numberOfWorkersKey{}, n)
+}
+
+// numberOfWorkers returns the number of workers set on ctx by
+// WithNumberOfWorkers, or NumberOfWorkers.
+func (o Options) numberOfWorkers(ctx context.Context) int {
+	if n, ok := ctx.Value(numberOfWorkersKey{}).(int); ok && n > 0 {
+		return n
+	}
+	return o.NumberOfWorkers
+}
+
 type metricsBatcher struct {
 	projectName string
 	allTss      []*monitoringpb.TimeSeries //nolint: staticcheck
@@ -40,14 +59,26 @@ type metricsBatcher struct {
 	// Counts all dropped TimeSeries by this metricsBatcher.
 	droppedTimeSeries int
 
+	// transform, if non-nil, is applied to every request before it is sent.
+	transform func(*monitoringpb.CreateTimeSeriesRequest) *monitoringpb.CreateTimeSeriesRequest //nolint: staticcheck
+
 	workers []*worker
-	// reqsChan, respsChan and wg are shared between metricsBatcher and worker goroutines.
+	// reqsChan, respsChan, wg and inflight are shared between metricsBatcher and worker goroutines.
 	reqsChan  chan *monitoringpb.CreateTimeSeriesRequest //nolint: staticcheck
 	respsChan chan *response
 	wg        *sync.WaitGroup
+	// inflight counts the requests sent to reqsChan that are not done yet.
+	inflight *sync.WaitGroup
 }
 
-func newMetricsBatcher(ctx context.Context, projectID string, numWorkers int, mc *monitoring.MetricClient, timeout time.Duration) *metricsBatcher {
+func newMetricsBatcher(
+	ctx context.Context,
+	projectName string,
+	numWorkers int,
+	mc *monitoring.MetricClient,
+	timeout time.Duration,
+	sendOpts sendOptions,
+	transform func(*monitoringpb.CreateTimeSeriesRequest) *monitoringpb.CreateTimeSeriesRequest) *metricsBatcher { //nolint: staticcheck
 	if numWorkers < minNumWorkers {
 		numWorkers = minNumWorkers
 	}
@@ -58,19 +89,24 @@ func newMetricsBatcher(ctx context.Context, projectID string, numWorkers int, mc
 	}
 	reqsChan := make(chan *monitoringpb.CreateTimeSeriesRequest, reqsChanSize) //nolint: staticcheck
 	respsChan := make(chan *response, numWorkers)
-	var wg sync.WaitGroup
+	var wg, inflight sync.WaitGroup
 	wg.Add(numWorkers)
+	send := func(ctx context.Context, req *monitoringpb.CreateTimeSeriesRequest) (int, []error) { //nolint: staticcheck
+		return sendReq(ctx, mc, req, sendOpts)
+	}
 	for i := 0; i < numWorkers; i++ {
-		w := newWorker(ctx, mc, reqsChan, respsChan, &wg, timeout)
+		w := newWorker(ctx, reqsChan, respsChan, &wg, &inflight, timeout, send)
 		workers = append(workers, w)
 		go w.start()
 	}
 	return &metricsBatcher{
-		projectName:       fmt.Sprintf("projects/%s", projectID),
+		projectName:       projectName,
 		allTss:            make([]*monitoringpb.TimeSeries, 0, maxTimeSeriesPerUpload), //nolint: staticcheck
 		droppedTimeSeries: 0,
+		transform:         transform,
 		workers:           workers,
 		wg:                &wg,
+		inflight:          &inflight,
 		reqsChan:          reqsChan,
 		respsChan:         respsChan,
 	}
@@ -93,6 +129,16 @@ func (mb *metricsBatcher) addTimeSeries(ts *monitoringpb.TimeSeries) { //nolint:
 	}
 }
 
+// flush sends the time series added so far and waits until all the sent
+// requests are done, so that the time series added next are written after them.
+func (mb *metricsBatcher) flush() {
+	if len(mb.allTss) > 0 {
+		mb.sendReqToChan()
+		mb.allTss = make([]*monitoringpb.TimeSeries, 0, maxTimeSeriesPerUpload) //nolint: staticcheck
+	}
+	mb.inflight.Wait()
+}
+
 func (mb *metricsBatcher) close(ctx context.Context) error {
 	// Send any remaining time series, must be <200
 	if len(mb.allTss) > 0 {
@@ -130,43 +176,305 @@ func (mb *metricsBatcher) sendReqToChan() {
 		Name:       mb.projectName,
 		TimeSeries: mb.allTss,
 	}
+	if mb.transform != nil {
+		if req = mb.transform(req); req == nil {
+			return
+		}
+	}
+	mb.inflight.Add(1)
 	mb.reqsChan <- req
 }
 
 // regex to extract min-max ranges from error response strings in the format "timeSeries[(min-max,...)] ..." (max is optional)
 var timeSeriesErrRegex = regexp.MustCompile(`: timeSeries\[([0-9]+(?:-[0-9]+)?(?:,[0-9]+(?:-[0-9]+)?)*)\]`)
 
+const partialTimeSeriesErrPrefix = "One or more TimeSeries could not be written:"
+
+// retryableTimeSeriesErrReasons are the per time series failure reasons reported by
+// CreateTimeSeries that are considered transient.
+var retryableTimeSeriesErrReasons = []string{
+	"Internal error encountered",
+	"Please retry",
+	"Deadline exceeded",
+}
+
+// sendOptions configures how sendReq sends requests.
+type sendOptions struct {
+	// retryDropped resends, once, the time series that were dropped for a transient reason.
+	retryDropped bool
+	// disableServiceTimeSeries sends service time series with CreateTimeSeries
+	// instead of CreateServiceTimeSeries.
+	disableServiceTimeSeries bool
+	// selfMetrics records the latency of the calls; nil records nothing.
+	selfMetrics *selfMetrics
+	// breaker skips the calls while it is open; nil never skips.
+	breaker *circuitBreaker
+	// onUploadSuccess is called with the metric types of every fully successful call.
+	onUploadSuccess func(metricTypes []string)
+	// retryPolicy retries the calls that failed with a transient error; nil never retries.
+	retryPolicy *RetryPolicy
+	// sink receives the requests instead of Stackdriver Monitoring if non-nil.
+	sink TimeSeriesSink
+	// onWritten is called with the time series written by every call.
+	onWritten func(tsl []*monitoringpb.TimeSeries) //nolint: staticcheck
+}
+
+// sendOptions returns the sendOptions configured for se.
+func (se *statsExporter) sendOptions() sendOptions {
+	return sendOptions{
+		retryDropped:             se.o.RetryDroppedTimeSeries,
+		disableServiceTimeSeries: se.o.DisableServiceTimeSeries,
+		selfMetrics:              se.selfMetrics,
+		breaker:                  se.breaker,
+		onUploadSuccess:          se.o.OnUploadSuccess,
+		retryPolicy:              se.o.RetryPolicy,
+		sink:                     se.o.Sink,
+		onWritten:                se.commitSeries,
+	}
+}
+
 // sendReq sends create time series requests to Stackdriver,
 // and returns the count of dropped time series and error.
-func sendReq(ctx context.Context, c *monitoring.MetricClient, req *monitoringpb.CreateTimeSeriesRequest) (int, []error) { //nolint: staticcheck
-	// c == nil only happens in unit tests where we don't make real calls to Stackdriver server
-	if c == nil {
+func sendReq(ctx context.Context, c *monitoring.MetricClient, req *monitoringpb.CreateTimeSeriesRequest, opts sendOptions) (int, []error) { //nolint: staticcheck
+	// c == nil without a sink only happens in unit tests where we don't make real calls to Stackdriver server
+	if c == nil && opts.sink == nil {
 		return 0, nil
 	}
 
+	if !opts.breaker.allow() {
+		return len(req.TimeSeries), []error{fmt.Errorf("circuit breaker open, skipped %d time series", len(req.TimeSeries))}
+	}
+
 	dropped := 0
 	errors := []error{}
-	serviceReq, nonServiceReq := splitCreateTimeSeriesRequest(req)
-	if nonServiceReq != nil {
-		err := createTimeSeries(ctx, c, nonServiceReq)
-		if err != nil {
-			dropped += droppedTimeSeriesFromMonitoringAPIError(nonServiceReq, err)
-			errors = append(errors, err)
+	var serviceReq, nonServiceReq *monitoringpb.CreateTimeSeriesRequest //nolint: staticcheck
+	if opts.disableServiceTimeSeries {
+		nonServiceReq = req
+	} else {
+		serviceReq, nonServiceReq = splitCreateTimeSeriesRequest(req)
+	}
+	var failed bool
+	send := func(req *monitoringpb.CreateTimeSeriesRequest, create func(context.Context, *monitoring.MetricClient, *monitoringpb.CreateTimeSeriesRequest) error) { //nolint: staticcheck
+		start := time.Now()
+		d, errs := sendCreateTimeSeriesReq(ctx, c, req, create, opts.retryDropped, nil)
+		// Only whole-call transient failures count for the circuit breaker, the
+		// first error being the one of the original call.
+		if len(errs) > 0 && isBreakerFailure(errs[0]) {
+			failed = true
+		}
+		opts.selfMetrics.recordExportLatency(ctx, time.Since(start))
+		if len(errs) == 0 && opts.onUploadSuccess != nil {
+			opts.onUploadSuccess(requestMetricTypes(req))
 		}
+		dropped += d
+		errors = append(errors, errs...)
+	}
+	if nonServiceReq != nil {
+		send(nonServiceReq, reportWritten(opts.retryPolicy.wrap(createTimeSeriesFunc(opts.sink), nil), opts.onWritten))
 	}
 	if serviceReq != nil {
-		err := createServiceTimeSeries(ctx, c, serviceReq)
-		if err != nil {
-			dropped += droppedTimeSeriesFromMonitoringAPIError(serviceReq, err)
-			errors = append(errors, err)
-		}
+		send(serviceReq, reportWritten(opts.retryPolicy.wrap(createServiceTimeSeriesFunc(opts.sink), nil), opts.onWritten))
 	}
+	opts.breaker.record(failed)
 	return dropped, errors
 }
 
+// sendCreateTimeSeriesReq sends req with send and returns the count of dropped time series and errors.
+// If retryDropped is true and the call partially fails, a smaller request holding only the time series
+// that failed for a transient reason is sent once more, provided budget allows one more retry.
+func sendCreateTimeSeriesReq(
+	ctx context.Context,
+	c *monitoring.MetricClient,
+	req *monitoringpb.CreateTimeSeriesRequest, //nolint: staticcheck
+	send func(context.Context, *monitoring.MetricClient, *monitoringpb.CreateTimeSeriesRequest) error, //nolint: staticcheck
+	retryDropped bool,
+	budget *retryBudget) (int, []error) {
+	err := send(ctx, c, req)
+	if err == nil {
+		return 0, nil
+	}
+	dropped := droppedTimeSeriesFromMonitoringAPIError(req, err)
+	if !retryDropped {
+		return dropped, []error{err}
+	}
+
+	retryReq := subsetCreateTimeSeriesRequest(req, retryableTimeSeriesFromMonitoringAPIError(err))
+	if len(retryReq.TimeSeries) == 0 || !budget.take() {
+		return dropped, []error{err}
+	}
+	dropped -= len(retryReq.TimeSeries)
+	if retryErr := send(ctx, c, retryReq); retryErr != nil {
+		dropped += droppedTimeSeriesFromMonitoringAPIError(retryReq, retryErr)
+		return dropped, []error{err, retryErr}
+	}
+	if dropped == 0 {
+		return 0, nil
+	}
+	return dropped, []error{err}
+}
+
+// retryBudget bounds the number of calls retried within a single flush.
+// A nil *retryBudget allows any number of retries.
+type retryBudget struct {
+	mu        sync.Mutex
+	remaining int
+}
+
+// newRetryBudget returns the retry budget of a flush, or nil if
+// Options.FlushRetryBudget is not set.
+func (o Options) newRetryBudget() *retryBudget {
+	if o.FlushRetryBudget <= 0 {
+		return nil
+	}
+	return &retryBudget{remaining: o.FlushRetryBudget}
+}
+
+// take reports whether one more call may be retried, consuming it from the budget.
+func (b *retryBudget) take() bool {
+	if b == nil {
+		return true
+	}
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if b.remaining <= 0 {
+		return false
+	}
+	b.remaining--
+	return true
+}
+
+// sendTimeSeriesReq sends req with create for a flush of the view or metricdata
+// path, retrying it according to RetryPolicy and resending the time series
+// dropped for a transient reason if RetryDroppedTimeSeriesOnExport is set, as long as
+// budget allows it.
+func (se *statsExporter) sendTimeSeriesReq(
+	ctx context.Context,
+	req *monitoringpb.CreateTimeSeriesRequest, //nolint: staticcheck
+	create func(context.Context, *monitoring.MetricClient, *monitoringpb.CreateTimeSeriesRequest) error, //nolint: staticcheck
+	budget *retryBudget) error {
+	_, errs := sendCreateTimeSeriesReq(ctx, se.c, req, reportWritten(se.o.RetryPolicy.wrap(create, budget), se.commitSeries), se.o.RetryDroppedTimeSeriesOnExport, budget)
+	if len(errs) == 0 {
+		se.o.reportUploadSuccess(req)
+	}
+	return combineErrors(errs)
+}
+
+// reportWritten returns create calling written, if it is not nil, with the time
+// series written by every call.
+func reportWritten(
+	create func(context.Context, *monitoring.MetricClient, *monitoringpb.CreateTimeSeriesRequest) error, //nolint: staticcheck
+	written func([]*monitoringpb.TimeSeries), //nolint: staticcheck
+) func(context.Context, *monitoring.MetricClient, *monitoringpb.CreateTimeSeriesRequest) error { //nolint: staticcheck
+	if written == nil {
+		return create
+	}
+	return func(ctx context.Context, c *monitoring.MetricClient, req *monitoringpb.CreateTimeSeriesRequest) error { //nolint: staticcheck
+		err := create(ctx, c, req)
+		if tsl := writtenTimeSeries(req, err); len(tsl) > 0 {
+			written(tsl)
+		}
+		return err
+	}
+}
+
+// writtenTimeSeries returns the time series of req written by a CreateTimeSeries
+// call that returned err: all of them if err is nil, the ones not reported as
+// dropped if the call partially failed, and none otherwise.
+func writtenTimeSeries(req *monitoringpb.CreateTimeSeriesRequest, err error) []*monitoringpb.TimeSeries { //nolint: staticcheck
+	if err == nil {
+		return req.TimeSeries
+	}
+	msg := status.Convert(err).Message()
+	matches := timeSeriesErrRegex.FindAllStringSubmatch(msg, -1)
+	if !strings.HasPrefix(msg, partialTimeSeriesErrPrefix) || len(matches) == 0 {
+		return nil
+	}
+	failed := make(map[int]bool)
+	for _, m := range matches {
+		for _, i := range timeSeriesIndicesFromRanges(m[1]) {
+			failed[i] = true
+		}
+	}
+	var written []*monitoringpb.TimeSeries //nolint: staticcheck
+	for i, ts := range req.TimeSeries {
+		if !failed[i] {
+			written = append(written, ts)
+		}
+	}
+	return written
+}
+
+// subsetCreateTimeSeriesRequest returns a copy of req that only holds the time series at the given indices.
+func subsetCreateTimeSeriesRequest(req *monitoringpb.CreateTimeSeriesRequest, indices []int) *monitoringpb.CreateTimeSeriesRequest { //nolint: staticcheck
+	subset := &monitoringpb.CreateTimeSeriesRequest{ //nolint: staticcheck
+		Name: req.Name,
+	}
+	for _, i := range indices {
+		if i < len(req.TimeSeries) {
+			subset.TimeSeries = append(subset.TimeSeries, req.TimeSeries[i])
+		}
+	}
+	return subset
+}
+
+// retryableTimeSeriesFromMonitoringAPIError returns the indices of the time series that a partially
+// failed CreateTimeSeries call reported as dropped for a transient reason.
+func retryableTimeSeriesFromMonitoringAPIError(monitoringAPIerr error) []int {
+	msg := status.Convert(monitoringAPIerr).Message()
+	if !strings.HasPrefix(msg, partialTimeSeriesErrPrefix) {
+		return nil
+	}
+
+	seen := make(map[int]bool)
+	var indices []int
+	for _, reason := range strings.Split(strings.TrimPrefix(msg, partialTimeSeriesErrPrefix), "; ") {
+		loc := timeSeriesErrRegex.FindStringSubmatchIndex(reason)
+		if loc == nil || !retryableTimeSeriesErrReason(reason[:loc[0]]) {
+			continue
+		}
+		for _, i := range timeSeriesIndicesFromRanges(reason[loc[2]:loc[3]]) {
+			if !seen[i] {
+				seen[i] = true
+				indices = append(indices, i)
+			}
+		}
+	}
+	return indices
+}
+
+func retryableTimeSeriesErrReason(reason string) bool {
+	for _, r := range retryableTimeSeriesErrReasons {
+		if strings.Contains(reason, r) {
+			return true
+		}
+	}
+	return false
+}
+
+// timeSeriesIndicesFromRanges expands ranges in the format "min-max,..." (max is optional)
+// into the list of indices they cover.
+func timeSeriesIndicesFromRanges(ranges string) []int {
+	var indices []int
+	for _, rng := range strings.Split(ranges, ",") {
+		rngSlice := strings.Split(rng, "-")
+
+		// strconv errors not possible due to regex above
+		min, _ := strconv.Atoi(rngSlice[0])
+		max := min
+		if len(rngSlice) > 1 {
+			max, _ = strconv.Atoi(rngSlice[1])
+		}
+		for i := min; i <= max; i++ {
+			indices = append(indices, i)
+		}
+	}
+	return indices
+}
+
 func droppedTimeSeriesFromMonitoringAPIError(req *monitoringpb.CreateTimeSeriesRequest, monitoringAPIerr error) int { //nolint: staticcheck
-	droppedTimeSeriesRangeMatches := timeSeriesErrRegex.FindAllStringSubmatch(monitoringAPIerr.Error(), -1)
-	if !strings.HasPrefix(monitoringAPIerr.Error(), "One or more TimeSeries could not be written:") || len(droppedTimeSeriesRangeMatches) == 0 {
+	msg := status.Convert(monitoringAPIerr).Message()
+	droppedTimeSeriesRangeMatches := timeSeriesErrRegex.FindAllStringSubmatch(msg, -1)
+	if !strings.HasPrefix(msg, partialTimeSeriesErrPrefix) || len(droppedTimeSeriesRangeMatches) == 0 {
 		return len(req.TimeSeries)
 	}
 
@@ -193,36 +501,42 @@ func droppedTimeSeriesFromMonitoringAPIError(req *monitoringpb.CreateTimeSeriesR
 type worker struct {
 	ctx     context.Context
 	timeout time.Duration
-	mc      *monitoring.MetricClient
+	// send sends a request and returns the count of dropped time series and errors.
+	send func(context.Context, *monitoringpb.CreateTimeSeriesRequest) (int, []error) //nolint: staticcheck
 
 	resp *response
 
 	respsChan chan *response
 	reqsChan  chan *monitoringpb.CreateTimeSeriesRequest //nolint: staticcheck
 
-	wg *sync.WaitGroup
+	wg       *sync.WaitGroup
+	inflight *sync.WaitGroup
 }
 
 func newWorker(
 	ctx context.Context,
-	mc *monitoring.MetricClient,
 	reqsChan chan *monitoringpb.CreateTimeSeriesRequest, //nolint: staticcheck
 	respsChan chan *response,
 	wg *sync.WaitGroup,
-	timeout time.Duration) *worker {
+	inflight *sync.WaitGroup,
+	timeout time.Duration,
+	send func(context.Context, *monitoringpb.CreateTimeSeriesRequest) (int, []error)) *worker { //nolint: staticcheck
 	return &worker{
 		ctx:       ctx,
-		mc:        mc,
+		timeout:   timeout,
+		send:      send,
 		resp:      &response{},
 		reqsChan:  reqsChan,
 		respsChan: respsChan,
 		wg:        wg,
+		inflight:  inflight,
 	}
 }
 
 func (w *worker) start() {
 	for req := range w.reqsChan {
 		w.sendReqWithTimeout(req)
+		w.inflight.Done()
 	}
 	w.respsChan <- w.resp
 	w.wg.Done()
@@ -232,7 +546,7 @@ func (w *worker) sendReqWithTimeout(req *monitoringpb.CreateTimeSeriesRequest) {
 	ctx, cancel := newContextWithTimeout(w.ctx, w.timeout)
 	defer cancel()
 
-	w.recordDroppedTimeseries(sendReq(ctx, w.mc, req))
+	w.recordDroppedTimeseries(w.send(ctx, req))
 }
 
 func (w *worker) recordDroppedTimeseries(numTimeSeries int, errors []error) {
@@ -19,13 +19,17 @@ import (
 	"errors"
 	"fmt"
 	"testing"
+	"time"
 
 	monitoring "cloud.google.com/go/monitoring/apiv3/v2"
+	"go.opencensus.io/stats/view"
 	"google.golang.org/api/option"
 	googlemetricpb "google.golang.org/genproto/googleapis/api/metric"
 	monitoringpb "google.golang.org/genproto/googleapis/monitoring/v3" //nolint: staticcheck
 	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
 	"google.golang.org/grpc/credentials/insecure"
+	"google.golang.org/grpc/status"
 )
 
 func TestWorkers(t *testing.T) {
@@ -37,13 +41,13 @@ func TestWorkers(t *testing.T) {
 	if err != nil {
 		t.Fatalf("Failed to create metric client %v", err)
 	}
-	m1 := newMetricsBatcher(ctx, "test", 1, c1, defaultTimeout) // batcher with 1 worker
+	m1 := newMetricsBatcher(ctx, "projects/test", 1, c1, defaultTimeout, sendOptions{}, nil) // batcher with 1 worker
 
 	c2, err := makeClient(addr)
 	if err != nil {
 		t.Fatalf("Failed to create metric client %v", err)
 	}
-	m2 := newMetricsBatcher(ctx, "test", 2, c2, defaultTimeout) // batcher with 2 workers
+	m2 := newMetricsBatcher(ctx, "projects/test", 2, c2, defaultTimeout, sendOptions{}, nil) // batcher with 2 workers
 
 	tss := makeTs(500, false) // make 500 time series, should be split to 3 reqs
 
@@ -200,7 +204,7 @@ func TestSendReqAndParseDropped(t *testing.T) {
 			var tsl []*monitoringpb.TimeSeries //nolint: staticcheck
 			tsl = append(tsl, makeTs(test.serviceTimeSeriesCount, true)...)
 			tsl = append(tsl, makeTs(test.nonServiceTimeSeriesCount, false)...)
-			d, errors := sendReq(context.Background(), mc, &monitoringpb.CreateTimeSeriesRequest{TimeSeries: tsl}) //nolint: staticcheck
+			d, errors := sendReq(context.Background(), mc, &monitoringpb.CreateTimeSeriesRequest{TimeSeries: tsl}, sendOptions{}) //nolint: staticcheck
 			if !test.expectedErr && len(errors) > 0 {
 				t.Fatalf("Expected no errors, got %v", errors)
 			}
@@ -213,3 +217,221 @@ func TestSendReqAndParseDropped(t *testing.T) {
 		})
 	}
 }
+
+func TestSendReqRetriesOnlyDroppedSubset(t *testing.T) {
+	var gotReqs []*monitoringpb.CreateTimeSeriesRequest //nolint: staticcheck
+	persistedCreateTimeSeries := createTimeSeries
+	createTimeSeries = func(ctx context.Context, c *monitoring.MetricClient, ts *monitoringpb.CreateTimeSeriesRequest) error { //nolint: staticcheck
+		gotReqs = append(gotReqs, ts)
+		if len(gotReqs) == 1 {
+			return errors.New("One or more TimeSeries could not be written: Internal error encountered. Please retry after a few seconds.: timeSeries[1,3-4]; Unknown metric: custom.googleapis.com/opencensus/test/metric/7: timeSeries[7]")
+		}
+		return nil
+	}
+	defer func() {
+		createTimeSeries = persistedCreateTimeSeries
+	}()
+
+	mc, _ := monitoring.NewMetricClient(context.Background())
+	tsl := makeTs(10, false)
+	d, errs := sendReq(context.Background(), mc, &monitoringpb.CreateTimeSeriesRequest{TimeSeries: tsl}, sendOptions{retryDropped: true}) //nolint: staticcheck
+	if len(errs) != 1 {
+		t.Fatalf("Want 1 error for the non-retryable time series, got %v", errs)
+	}
+	if d != 1 {
+		t.Fatalf("Want 1 dropped, got %v", d)
+	}
+	if len(gotReqs) != 2 {
+		t.Fatalf("Want 2 CreateTimeSeriesReqs, got %v", len(gotReqs))
+	}
+	want := []*monitoringpb.CreateTimeSeriesRequest{ //nolint: staticcheck
+		{TimeSeries: []*monitoringpb.TimeSeries{tsl[1], tsl[3], tsl[4]}}, //nolint: staticcheck
+	}
+	if diff := cmpTSReqs(gotReqs[1:], want); diff != "" {
+		t.Fatalf("Retried CreateTimeSeriesRequest mismatch -got +want: %s", diff)
+	}
+}
+
+func TestSendReqParsesStatusErrors(t *testing.T) {
+	var gotReqs []*monitoringpb.CreateTimeSeriesRequest //nolint: staticcheck
+	persistedCreateTimeSeries := createTimeSeries
+	createTimeSeries = func(ctx context.Context, c *monitoring.MetricClient, ts *monitoringpb.CreateTimeSeriesRequest) error { //nolint: staticcheck
+		gotReqs = append(gotReqs, ts)
+		if len(gotReqs) == 1 {
+			return status.Error(codes.InvalidArgument, "One or more TimeSeries could not be written: Internal error encountered. Please retry after a few seconds.: timeSeries[2]; Unknown metric: custom.googleapis.com/opencensus/test/metric/5: timeSeries[5]")
+		}
+		return nil
+	}
+	defer func() {
+		createTimeSeries = persistedCreateTimeSeries
+	}()
+
+	mc, _ := monitoring.NewMetricClient(context.Background())
+	tsl := makeTs(10, false)
+	d, errs := sendReq(context.Background(), mc, &monitoringpb.CreateTimeSeriesRequest{TimeSeries: tsl}, sendOptions{retryDropped: true}) //nolint: staticcheck
+	if len(errs) != 1 {
+		t.Fatalf("Want 1 error for the non-retryable time series, got %v", errs)
+	}
+	if d != 1 {
+		t.Fatalf("Want 1 dropped, got %v", d)
+	}
+	want := []*monitoringpb.CreateTimeSeriesRequest{ //nolint: staticcheck
+		{TimeSeries: []*monitoringpb.TimeSeries{tsl[2]}}, //nolint: staticcheck
+	}
+	if diff := cmpTSReqs(gotReqs[1:], want); diff != "" {
+		t.Fatalf("Retried CreateTimeSeriesRequest mismatch -got +want: %s", diff)
+	}
+}
+
+func TestSendReqDoesNotRetryWhenDisabled(t *testing.T) {
+	calls := 0
+	persistedCreateTimeSeries := createTimeSeries
+	createTimeSeries = func(ctx context.Context, c *monitoring.MetricClient, ts *monitoringpb.CreateTimeSeriesRequest) error { //nolint: staticcheck
+		calls++
+		return errors.New("One or more TimeSeries could not be written: Internal error encountered. Please retry after a few seconds.: timeSeries[1,3-4]")
+	}
+	defer func() {
+		createTimeSeries = persistedCreateTimeSeries
+	}()
+
+	mc, _ := monitoring.NewMetricClient(context.Background())
+	d, errs := sendReq(context.Background(), mc, &monitoringpb.CreateTimeSeriesRequest{TimeSeries: makeTs(10, false)}, sendOptions{}) //nolint: staticcheck
+	if len(errs) != 1 || d != 3 {
+		t.Fatalf("Want 3 dropped and 1 error, got %v dropped and %v", d, errs)
+	}
+	if calls != 1 {
+		t.Fatalf("Want 1 CreateTimeSeries call, got %v", calls)
+	}
+}
+
+func TestSendReqWithServiceTimeSeriesDisabled(t *testing.T) {
+	var regular, service int
+	persistedCreateTimeSeries := createTimeSeries
+	persistedCreateServiceTimeSeries := createServiceTimeSeries
+	createTimeSeries = func(ctx context.Context, c *monitoring.MetricClient, req *monitoringpb.CreateTimeSeriesRequest) error { //nolint: staticcheck
+		regular += len(req.TimeSeries)
+		return nil
+	}
+	createServiceTimeSeries = func(ctx context.Context, c *monitoring.MetricClient, req *monitoringpb.CreateTimeSeriesRequest) error { //nolint: staticcheck
+		service += len(req.TimeSeries)
+		return nil
+	}
+	defer func() {
+		createTimeSeries = persistedCreateTimeSeries
+		createServiceTimeSeries = persistedCreateServiceTimeSeries
+	}()
+
+	mc, _ := monitoring.NewMetricClient(context.Background())
+	tsl := append(makeTs(2, true), makeTs(3, false)...)
+	d, errs := sendReq(context.Background(), mc, &monitoringpb.CreateTimeSeriesRequest{TimeSeries: tsl}, sendOptions{disableServiceTimeSeries: true}) //nolint: staticcheck
+	if d != 0 || len(errs) != 0 {
+		t.Fatalf("Want no dropped time series and no errors, got %v dropped and %v", d, errs)
+	}
+	if regular != 5 || service != 0 {
+		t.Errorf("Want all 5 time series sent with CreateTimeSeries, got %v with CreateTimeSeries and %v with CreateServiceTimeSeries", regular, service)
+	}
+}
+
+func TestSendReqRecordsExportLatency(t *testing.T) {
+	persisted := createTimeSeries
+	createTimeSeries = func(ctx context.Context, c *monitoring.MetricClient, req *monitoringpb.CreateTimeSeriesRequest) error { //nolint: staticcheck
+		time.Sleep(5 * time.Millisecond)
+		return nil
+	}
+	defer func() { createTimeSeries = persisted }()
+
+	sm, err := newSelfMetrics("test.example.com/send_req_latency")
+	if err != nil {
+		t.Fatalf("newSelfMetrics() = %v", err)
+	}
+	v := view.Find(sm.exportLatency.Name())
+	defer view.Unregister(v)
+
+	mc, _ := monitoring.NewMetricClient(context.Background())
+	sendReq(context.Background(), mc, &monitoringpb.CreateTimeSeriesRequest{TimeSeries: makeTs(3, false)}, sendOptions{selfMetrics: sm}) //nolint: staticcheck
+
+	rows, err := view.RetrieveData(v.Name)
+	if err != nil {
+		t.Fatalf("RetrieveData() = %v", err)
+	}
+	if len(rows) != 1 {
+		t.Fatalf("Want 1 row, got %d", len(rows))
+	}
+	dist := rows[0].Data.(*view.DistributionData)
+	if dist.Count != 1 {
+		t.Errorf("Want 1 recorded latency, got %d", dist.Count)
+	}
+	if dist.Min < 5 || dist.Max > 10000 {
+		t.Errorf("Recorded latency %vms is not plausible for a 5ms call", dist.Min)
+	}
+}
+
+func TestSendReqReportsUploadSuccess(t *testing.T) {
+	fail := false
+	persistedCreateTimeSeries := createTimeSeries
+	persistedCreateServiceTimeSeries := createServiceTimeSeries
+	createTimeSeries = func(ctx context.Context, c *monitoring.MetricClient, req *monitoringpb.CreateTimeSeriesRequest) error { //nolint: staticcheck
+		if fail {
+			return errors.New("unavailable")
+		}
+		return nil
+	}
+	createServiceTimeSeries = func(ctx context.Context, c *monitoring.MetricClient, req *monitoringpb.CreateTimeSeriesRequest) error { //nolint: staticcheck
+		return nil
+	}
+	defer func() {
+		createTimeSeries = persistedCreateTimeSeries
+		createServiceTimeSeries = persistedCreateServiceTimeSeries
+	}()
+
+	var got [][]string
+	opts := sendOptions{onUploadSuccess: func(metricTypes []string) { got = append(got, metricTypes) }}
+	mc, _ := monitoring.NewMetricClient(context.Background())
+	tsl := append(makeTs(2, false), makeTs(1, false)...)
+	sendReq(context.Background(), mc, &monitoringpb.CreateTimeSeriesRequest{TimeSeries: tsl}, opts) //nolint: staticcheck
+
+	want := [][]string{{"custom.googleapis.com/opencensus/test/metric/0", "custom.googleapis.com/opencensus/test/metric/1"}}
+	if fmt.Sprint(got) != fmt.Sprint(want) {
+		t.Errorf("OnUploadSuccess got %v; want %v", got, want)
+	}
+
+	// Only the successful service request is reported.
+	got = nil
+	fail = true
+	tsl = append(makeTs(1, false), makeTs(1, true)...)
+	sendReq(context.Background(), mc, &monitoringpb.CreateTimeSeriesRequest{TimeSeries: tsl}, opts) //nolint: staticcheck
+	want = [][]string{{"kubernetes.io/opencensus/test/metric/0"}}
+	if fmt.Sprint(got) != fmt.Sprint(want) {
+		t.Errorf("OnUploadSuccess got %v; want %v", got, want)
+	}
+}
+
+func TestWorkerUsesConfiguredTimeout(t *testing.T) {
+	const timeout = 3 * time.Second
+	var gotTimeout time.Duration
+	persistedCreateTimeSeries := createTimeSeries
+	createTimeSeries = func(ctx context.Context, c *monitoring.MetricClient, ts *monitoringpb.CreateTimeSeriesRequest) error { //nolint: staticcheck
+		deadline, ok := ctx.Deadline()
+		if !ok {
+			t.Fatal("Want a deadline on the CreateTimeSeries context")
+		}
+		gotTimeout = time.Until(deadline)
+		return nil
+	}
+	defer func() {
+		createTimeSeries = persistedCreateTimeSeries
+	}()
+
+	ctx := context.Background()
+	mc, _ := monitoring.NewMetricClient(ctx)
+	mb := newMetricsBatcher(ctx, "projects/test", 1, mc, timeout, sendOptions{}, nil)
+	for _, ts := range makeTs(1, false) {
+		mb.addTimeSeries(ts)
+	}
+	if err := mb.close(ctx); err != nil {
+		t.Fatalf("Want no error, got %v", err)
+	}
+	if gotTimeout <= 0 || gotTimeout > timeout {
+		t.Fatalf("Want a deadline within %v, got %v", timeout, gotTimeout)
+	}
+}
@@ -42,6 +42,7 @@ import (
 )
 
 var errNilMetricOrMetricDescriptor = errors.New("non-nil metric or metric descriptor")
+var errNilPointTimestamp = errors.New("dropping point without timestamp")
 var percentileLabelKey = &metricspb.LabelKey{
 	Key:         "percentile",
 	Description: "the value at a given percentile of a distribution",
@@ -58,14 +59,18 @@ func (se *statsExporter) PushMetricsProto(ctx context.Context, node *commonpb.No
 
 	// Caches the resources seen so far
 	seenResources := make(map[*resourcepb.Resource]*monitoredrespb.MonitoredResource)
+	ctxRsc := se.resourceFromContext(ctx)
 
-	mb := newMetricsBatcher(ctx, se.o.ProjectID, se.o.NumberOfWorkers, se.c, se.o.Timeout)
+	mb := newMetricsBatcher(ctx, se.o.requestName(), se.o.numberOfWorkers(ctx), se.c, se.o.WorkerTimeout, se.sendOptions(), se.o.TransformRequest)
 	for _, metric := range metrics {
 		if len(metric.GetTimeseries()) == 0 {
 			// No TimeSeries to export, skip this metric.
 			continue
 		}
-		mappedRsc := se.getResource(rsc, metric, seenResources)
+		mappedRsc := ctxRsc
+		if mappedRsc == nil {
+			mappedRsc = se.getResource(rsc, metric, seenResources)
+		}
 		if metric.GetMetricDescriptor().GetType() == metricspb.MetricDescriptor_SUMMARY {
 			summaryMtcs := se.convertSummaryMetrics(metric)
 			for _, summaryMtc := range summaryMtcs {
@@ -210,12 +215,24 @@ func (se *statsExporter) getResource(rsc *resourcepb.Resource, metric *metricspb
 	}
 	mappedRsc, ok := seenRscs[resource]
 	if !ok {
-		mappedRsc = se.o.MapResource(resourcepbToResource(resource))
+		mappedRsc = se.o.withGeneratedTaskID(se.o.mapResource(resourcepbToResource(resource)))
 		seenRscs[resource] = mappedRsc
 	}
 	return mappedRsc
 }
 
+// resourceFromContext returns the monitored resource selected by
+// Options.ResourceFromContext for ctx, or nil if there is none.
+func (se *statsExporter) resourceFromContext(ctx context.Context) *monitoredrespb.MonitoredResource {
+	if se.o.ResourceFromContext == nil || ctx == nil {
+		return nil
+	}
+	if rsc := se.o.ResourceFromContext(ctx); rsc != nil {
+		return se.o.withGeneratedTaskID(rsc)
+	}
+	return nil
+}
+
 func resourcepbToResource(rsc *resourcepb.Resource) *resource.Resource {
 	if rsc == nil {
 		return globalResource
@@ -240,32 +257,43 @@ func (se *statsExporter) protoMetricToTimeSeries(ctx context.Context, mappedRsc
 
 	metricType := se.metricTypeFromProto(metric.GetMetricDescriptor().GetName())
 	metricLabelKeys := metric.GetMetricDescriptor().GetLabelKeys()
-	metricKind, valueType := protoMetricDescriptorTypeToMetricKind(metric)
+	metricKind, valueType := se.protoMetricKind(metric)
 	labelKeys := make([]string, 0, len(metricLabelKeys))
 	for _, key := range metricLabelKeys {
-		labelKeys = append(labelKeys, sanitize(key.GetKey()))
+		labelKeys = append(labelKeys, se.o.sanitize(key.GetKey()))
 	}
 
+	defaultLabels := se.metricLabels(metric.GetMetricDescriptor().GetName(), len(labelKeys))
 	for _, protoTimeSeries := range metric.Timeseries {
 		if len(protoTimeSeries.Points) == 0 {
 			// No points to send just move forward.
 			continue
 		}
 
-		sdPoints, err := se.protoTimeSeriesToMonitoringPoints(protoTimeSeries, metricKind)
+		sdPoints, err := se.protoTimeSeriesToMonitoringPoints(metricType, protoTimeSeries, metricKind)
 		if err != nil {
 			mb.recordDroppedTimeseries(1, err)
 			continue
 		}
+		if len(sdPoints) == 0 {
+			// All the points were dropped, and each one was already reported.
+			mb.recordDroppedTimeseries(1)
+			continue
+		}
+		if se.o.DropEmptyDistributions {
+			if sdPoints = dropEmptyDistributions(sdPoints); len(sdPoints) == 0 {
+				continue
+			}
+		}
 
 		// Each TimeSeries has labelValues which MUST be correlated
 		// with that from the MetricDescriptor
-		labels, err := labelsPerTimeSeries(se.defaultLabels, labelKeys, protoTimeSeries.GetLabelValues())
+		labels, err := se.labelsPerTimeSeries(defaultLabels, labelKeys, protoTimeSeries.GetLabelValues())
 		if err != nil {
 			mb.recordDroppedTimeseries(1, err)
 			continue
 		}
-		mb.addTimeSeries(&monitoringpb.TimeSeries{ //nolint: staticcheck
+		ts := &monitoringpb.TimeSeries{ //nolint: staticcheck
 			Metric: &googlemetricpb.Metric{
 				Type:   metricType,
 				Labels: labels,
@@ -274,11 +302,18 @@ func (se *statsExporter) protoMetricToTimeSeries(ctx context.Context, mappedRsc
 			ValueType:  valueType,
 			Resource:   mappedRsc,
 			Points:     sdPoints,
-		})
+		}
+		if se.seriesLimiter != nil {
+			if ok, err := se.seriesLimiter.allow(ts); !ok {
+				mb.recordDroppedTimeseries(1, err)
+				continue
+			}
+		}
+		mb.addTimeSeries(ts)
 	}
 }
 
-func labelsPerTimeSeries(defaults map[string]labelValue, labelKeys []string, labelValues []*metricspb.LabelValue) (map[string]string, error) {
+func (se *statsExporter) labelsPerTimeSeries(defaults map[string]labelValue, labelKeys []string, labelValues []*metricspb.LabelValue) (map[string]string, error) {
 	if len(labelKeys) != len(labelValues) {
 		return nil, fmt.Errorf("length mismatch: len(labelKeys)=%d len(labelValues)=%d", len(labelKeys), len(labelValues))
 	}
@@ -291,7 +326,7 @@ func labelsPerTimeSeries(defaults map[string]labelValue, labelKeys []string, lab
 	labels := make(map[string]string)
 	// Fill in the defaults firstly, irrespective of if the labelKeys and labelValues are mismatched.
 	for key, label := range defaults {
-		labels[key] = label.val
+		labels[key] = se.o.sanitizeLabelValue(key, label.val)
 	}
 
 	for i, labelKey := range labelKeys {
@@ -299,7 +334,7 @@ func labelsPerTimeSeries(defaults map[string]labelValue, labelKeys []string, lab
 		if !labelValue.GetHasValue() {
 			continue
 		}
-		labels[labelKey] = labelValue.GetValue()
+		labels[labelKey] = se.o.sanitizeLabelValue(labelKey, labelValue.GetValue())
 	}
 
 	return labels, nil
@@ -322,14 +357,14 @@ func (se *statsExporter) createMetricDescriptorFromMetricProto(ctx context.Conte
 		return nil
 	}
 
-	if builtinMetric(se.metricTypeFromProto(name)) {
+	if se.skipDescriptorCreation(se.metricTypeFromProto(name)) {
 		se.protoMetricDescriptors[name] = true
 		return nil
 	}
 
 	// Otherwise, we encountered a cache-miss and
 	// should create the metric descriptor remotely.
-	inMD, err := se.protoToMonitoringMetricDescriptor(metric, se.defaultLabels)
+	inMD, err := se.protoToMonitoringMetricDescriptor(metric, se.metricLabels(metric.GetMetricDescriptor().GetName(), len(metric.GetMetricDescriptor().GetLabelKeys())))
 	if err != nil {
 		return err
 	}
@@ -342,7 +377,7 @@ func (se *statsExporter) createMetricDescriptorFromMetricProto(ctx context.Conte
 	return nil
 }
 
-func (se *statsExporter) protoTimeSeriesToMonitoringPoints(ts *metricspb.TimeSeries, metricKind googlemetricpb.MetricDescriptor_MetricKind) ([]*monitoringpb.Point, error) { //nolint: staticcheck
+func (se *statsExporter) protoTimeSeriesToMonitoringPoints(metricType string, ts *metricspb.TimeSeries, metricKind googlemetricpb.MetricDescriptor_MetricKind) ([]*monitoringpb.Point, error) { //nolint: staticcheck
 	sptl := make([]*monitoringpb.Point, 0, len(ts.Points)) //nolint: staticcheck
 	for _, pt := range ts.Points {
 		// If we have a last value aggregation point i.e. MetricDescriptor_GAUGE
@@ -351,10 +386,24 @@ func (se *statsExporter) protoTimeSeriesToMonitoringPoints(ts *metricspb.TimeSer
 		if metricKind == googlemetricpb.MetricDescriptor_GAUGE {
 			startTime = nil
 		}
+		if ts := pt.GetTimestamp(); ts.GetSeconds() == 0 && ts.GetNanos() == 0 {
+			// The end time of the interval is required.
+			se.o.handleError(fmt.Errorf("metric %q: %w", metricType, errNilPointTimestamp))
+			continue
+		}
+		if err := se.checkPointAge(pt.GetTimestamp().AsTime()); err != nil {
+			return nil, err
+		}
 		spt, err := fromProtoPoint(startTime, pt)
 		if err != nil {
 			return nil, err
 		}
+		if err := se.checkDistributionCount(spt.GetValue()); err != nil {
+			return nil, err
+		}
+		if err := se.checkDistributionBuckets(spt.GetValue()); err != nil {
+			return nil, err
+		}
 		sptl = append(sptl, spt)
 	}
 	return sptl, nil
@@ -371,7 +420,7 @@ func (se *statsExporter) protoToMonitoringMetricDescriptor(metric *metricspb.Met
 	description := md.GetDescription()
 	metricType := se.metricTypeFromProto(metricName)
 	displayName := se.displayName(metricName)
-	metricKind, valueType := protoMetricDescriptorTypeToMetricKind(metric)
+	metricKind, valueType := se.protoMetricKind(metric)
 
 	sdm := &googlemetricpb.MetricDescriptor{
 		Name:        fmt.Sprintf("projects/%s/metricDescriptors/%s", se.o.ProjectID, metricType),
@@ -381,19 +430,19 @@ func (se *statsExporter) protoToMonitoringMetricDescriptor(metric *metricspb.Met
 		Type:        metricType,
 		MetricKind:  metricKind,
 		ValueType:   valueType,
-		Labels:      labelDescriptorsFromProto(additionalLabels, metric.GetMetricDescriptor().GetLabelKeys()),
+		Labels:      se.labelDescriptorsFromProto(additionalLabels, metric.GetMetricDescriptor().GetLabelKeys()),
 	}
 
 	return sdm, nil
 }
 
-func labelDescriptorsFromProto(defaults map[string]labelValue, protoLabelKeys []*metricspb.LabelKey) []*labelpb.LabelDescriptor {
+func (se *statsExporter) labelDescriptorsFromProto(defaults map[string]labelValue, protoLabelKeys []*metricspb.LabelKey) []*labelpb.LabelDescriptor {
 	labelDescriptors := make([]*labelpb.LabelDescriptor, 0, len(defaults)+len(protoLabelKeys))
 
 	// Fill in the defaults first.
 	for key, lbl := range defaults {
 		labelDescriptors = append(labelDescriptors, &labelpb.LabelDescriptor{
-			Key:         sanitize(key),
+			Key:         se.o.sanitize(key),
 			Description: lbl.desc,
 			ValueType:   labelpb.LabelDescriptor_STRING,
 		})
@@ -402,7 +451,7 @@ func labelDescriptorsFromProto(defaults map[string]labelValue, protoLabelKeys []
 	// Now fill in those from the metric.
 	for _, protoKey := range protoLabelKeys {
 		labelDescriptors = append(labelDescriptors, &labelpb.LabelDescriptor{
-			Key:         sanitize(protoKey.GetKey()),
+			Key:         se.o.sanitize(protoKey.GetKey()),
 			Description: protoKey.GetDescription(),
 			ValueType:   labelpb.LabelDescriptor_STRING, // We only use string tags
 		})
@@ -415,6 +464,12 @@ func (se *statsExporter) metricTypeFromProto(name string) string {
 	if se.o.GetMetricPrefix != nil {
 		prefix = se.o.GetMetricPrefix(name)
 	}
+	return metricTypeWithPrefix(prefix, name)
+}
+
+// metricTypeWithPrefix joins prefix and name, defaulting to the
+// "custom.googleapis.com/opencensus" domain if the result has none.
+func metricTypeWithPrefix(prefix, name string) string {
 	if prefix != "" {
 		name = path.Join(prefix, name)
 	}
@@ -526,13 +581,16 @@ func protoToMetricPoint(value interface{}) (*monitoringpb.TypedValue, error) { /
 			if bopts := dv.BucketOptions; bopts != nil && bopts.Type != nil {
 				bexp, ok := bopts.Type.(*metricspb.DistributionValue_BucketOptions_Explicit_)
 				if ok && bexp != nil && bexp.Explicit != nil {
+					if err := validateBucketBounds(bexp.Explicit.Bounds); err != nil {
+						return nil, err
+					}
 					insertZeroBound = shouldInsertZeroBound(bexp.Explicit.Bounds...)
 					mv.DistributionValue.BucketOptions = &distributionpb.Distribution_BucketOptions{
 						Options: &distributionpb.Distribution_BucketOptions_ExplicitBuckets{
 							ExplicitBuckets: &distributionpb.Distribution_BucketOptions_Explicit{
-								// The first bucket bound should be 0.0 because the Metrics first bucket is
-								// [0, first_bound) but Stackdriver monitoring bucket bounds begin with -infinity
-								// (first bucket is (-infinity, 0))
+								// A 0.0 bound is inserted before a positive first bound because Stackdriver
+								// monitoring bucket bounds begin with -infinity (first bucket is (-infinity, 0)),
+								// see shouldInsertZeroBound for how negative values are reported.
 								Bounds: addZeroBoundOnCondition(insertZeroBound, bexp.Explicit.Bounds...),
 							},
 						},
@@ -560,6 +618,36 @@ func bucketCounts(buckets []*metricspb.DistributionValue_Bucket) []int64 {
 	return bucketCounts
 }
 
+// protoMetricKind returns the metric kind and value type of the proto metric m.
+// If the descriptor of m has no type, the kind is Options.DefaultProtoMetricKind
+// and the value type is that of the first point of m.
+func (se *statsExporter) protoMetricKind(m *metricspb.Metric) (googlemetricpb.MetricDescriptor_MetricKind, googlemetricpb.MetricDescriptor_ValueType) {
+	metricKind, valueType := protoMetricDescriptorTypeToMetricKind(m)
+	if se.o.DefaultProtoMetricKind == googlemetricpb.MetricDescriptor_METRIC_KIND_UNSPECIFIED ||
+		m.GetMetricDescriptor() == nil || m.GetMetricDescriptor().GetType() != metricspb.MetricDescriptor_UNSPECIFIED {
+		return metricKind, valueType
+	}
+	return se.o.DefaultProtoMetricKind, protoPointsValueType(m)
+}
+
+// protoPointsValueType returns the value type of the first point of m.
+func protoPointsValueType(m *metricspb.Metric) googlemetricpb.MetricDescriptor_ValueType {
+	for _, ts := range m.GetTimeseries() {
+		for _, pt := range ts.GetPoints() {
+			switch pt.GetValue().(type) {
+			case *metricspb.Point_Int64Value:
+				return googlemetricpb.MetricDescriptor_INT64
+			case *metricspb.Point_DoubleValue:
+				return googlemetricpb.MetricDescriptor_DOUBLE
+			case *metricspb.Point_DistributionValue:
+				return googlemetricpb.MetricDescriptor_DISTRIBUTION
+			}
+			return googlemetricpb.MetricDescriptor_VALUE_TYPE_UNSPECIFIED
+		}
+	}
+	return googlemetricpb.MetricDescriptor_VALUE_TYPE_UNSPECIFIED
+}
+
 func protoMetricDescriptorTypeToMetricKind(m *metricspb.Metric) (googlemetricpb.MetricDescriptor_MetricKind, googlemetricpb.MetricDescriptor_ValueType) {
 	dt := m.GetMetricDescriptor()
 	if dt == nil {
@@ -372,6 +372,31 @@ func TestExportMaxTSPerRequestAcrossTwoMetrics(t *testing.T) {
 	executeTestCase(t, tcFromFile, se, server, nil)
 }
 
+func TestMultiExporterWritesToAllDestinations(t *testing.T) {
+	server1, conn1, doneFn1 := createFakeServerConn(t)
+	defer doneFn1()
+	server2, conn2, doneFn2 := createFakeServerConn(t)
+	defer doneFn2()
+
+	tc := readTestCaseFromFiles(t, "SingleMetric")
+	me := sd.NewMultiExporter(createExporter(t, conn1, defaultOpts), createExporter(t, conn2, defaultOpts))
+
+	dropped, err := me.PushMetricsProto(context.Background(), nil, nil, tc.inMetric)
+	if dropped != 0 || err != nil {
+		t.Fatalf("Error pushing metrics, dropped:%d err:%v", dropped, err)
+	}
+
+	for i, server := range []*fakeMetricsServer{server1, server2} {
+		gotTimeSeries := []*monitoringpb.CreateTimeSeriesRequest{}                            //nolint: staticcheck
+		server.forEachStackdriverTimeSeries(func(sdt *monitoringpb.CreateTimeSeriesRequest) { //nolint: staticcheck
+			gotTimeSeries = append(gotTimeSeries, sdt)
+		})
+		if diff, idx := requireTimeSeriesRequestEqual(t, gotTimeSeries, tc.outTSR); diff != "" {
+			t.Errorf("Destination[%d], TimeSeries[%d], Error: -got +want %s\n", i, idx, diff)
+		}
+	}
+}
+
 func createConn(t *testing.T, addr string) *grpc.ClientConn {
 	conn, err := grpc.Dial(addr, grpc.WithTransportCredentials(insecure.NewCredentials()))
 	if err != nil {
@@ -16,10 +16,14 @@ package stackdriver
 
 import (
 	"context"
+	"errors"
 	"fmt"
 	"strings"
+	"sync"
 	"testing"
+	"time"
 
+	monitoring "cloud.google.com/go/monitoring/apiv3/v2"
 	resourcepb "github.com/census-instrumentation/opencensus-proto/gen-go/resource/v1"
 	"github.com/golang/protobuf/ptypes/timestamp"
 	"google.golang.org/api/option"
@@ -558,6 +562,76 @@ func TestProtoMetricWithDifferentResource(t *testing.T) {
 				},
 			},
 		},
+		{
+			in: &metricspb.Metric{
+				MetricDescriptor: &metricspb.MetricDescriptor{
+					Name:        "with_generic_node_resource",
+					Description: "This is a test",
+					Unit:        "By",
+					Type:        metricspb.MetricDescriptor_CUMULATIVE_INT64,
+				},
+				Resource: &resourcepb.Resource{
+					Type: resourcekeys.HostType,
+					Labels: map[string]string{
+						stackdriverGenericNodeNamespace: "namespace1",
+						resourcekeys.HostKeyName:        "host1",
+						resourcekeys.CloudKeyZone:       "zone1",
+					},
+				},
+				Timeseries: []*metricspb.TimeSeries{
+					{
+						StartTimestamp: startTimestamp,
+						Points: []*metricspb.Point{
+							{
+								Timestamp: endTimestamp,
+								Value: &metricspb.Point_Int64Value{
+									Int64Value: 1,
+								},
+							},
+						},
+					},
+				},
+			},
+			statsExporter: &statsExporter{
+				o: Options{ProjectID: "foo", MapResource: DefaultMapResource},
+			},
+			want: []*monitoringpb.CreateTimeSeriesRequest{ //nolint: staticcheck
+				{
+					Name: "projects/foo",
+					TimeSeries: []*monitoringpb.TimeSeries{ //nolint: staticcheck
+						{
+							Metric: &googlemetricpb.Metric{
+								Type:   "custom.googleapis.com/opencensus/with_generic_node_resource",
+								Labels: nil,
+							},
+							Resource: &monitoredrespb.MonitoredResource{
+								Type: "generic_node",
+								Labels: map[string]string{
+									"location":  "zone1",
+									"namespace": "namespace1",
+									"node_id":   "host1",
+								},
+							},
+							MetricKind: googlemetricpb.MetricDescriptor_CUMULATIVE,
+							ValueType:  googlemetricpb.MetricDescriptor_INT64,
+							Points: []*monitoringpb.Point{ //nolint: staticcheck
+								{
+									Interval: &monitoringpb.TimeInterval{ //nolint: staticcheck
+										StartTime: startTimestamp,
+										EndTime:   endTimestamp,
+									},
+									Value: &monitoringpb.TypedValue{ //nolint: staticcheck
+										Value: &monitoringpb.TypedValue_Int64Value{
+											Int64Value: 1,
+										},
+									},
+								},
+							},
+						},
+					},
+				},
+			},
+		},
 	}
 
 	for i, tt := range tests {
@@ -585,8 +659,8 @@ func TestProtoMetricWithDifferentResource(t *testing.T) {
 		}
 	}
 
-	if len(seenResources) != 2 {
-		t.Errorf("Should cache 2 resources, got %d", len(seenResources))
+	if len(seenResources) != 3 {
+		t.Errorf("Should cache 3 resources, got %d", len(seenResources))
 	}
 }
 
@@ -884,6 +958,24 @@ func TestCombineTimeSeriesAndDeduplication(t *testing.T) {
 	}
 }
 
+func TestCombineTimeSeriesWithRequestNameFormatter(t *testing.T) {
+	se := &statsExporter{
+		o: Options{
+			ProjectID: "foo",
+			RequestNameFormatter: func(projectID string) string {
+				return "proxy/" + projectID + "/timeSeries"
+			},
+		},
+	}
+	got := se.combineTimeSeriesToCreateTimeSeriesRequest(makeTs(3, false))
+	if len(got) != 1 {
+		t.Fatalf("Want 1 request, got %d", len(got))
+	}
+	if want := "proxy/foo/timeSeries"; got[0].Name != want {
+		t.Errorf("Request name = %q, want %q", got[0].Name, want)
+	}
+}
+
 func TestConvertSummaryMetrics(t *testing.T) {
 	startTimestamp := &timestamp.Timestamp{
 		Seconds: 1543160298,
@@ -1150,7 +1242,562 @@ func makePercentileValue(val, percentile float64) *metricspb.SummaryValue_Snapsh
 }
 
 func protoMetricToTimeSeries(ctx context.Context, se *statsExporter, mappedRsc *monitoredrespb.MonitoredResource, metric *metricspb.Metric) ([]*monitoringpb.TimeSeries, error) { //nolint: staticcheck
-	mb := newMetricsBatcher(ctx, se.o.ProjectID, se.o.NumberOfWorkers, se.c, defaultTimeout)
+	mb := newMetricsBatcher(ctx, se.o.requestName(), se.o.NumberOfWorkers, se.c, defaultTimeout, sendOptions{}, nil)
 	se.protoMetricToTimeSeries(ctx, mappedRsc, metric, mb)
 	return mb.allTss, mb.close(ctx)
 }
+
+func TestPushMetricsProtoDropsNonIncreasingBucketBounds(t *testing.T) {
+	server, addr, doneFn := createFakeServer(t)
+	defer doneFn()
+
+	conn, err := grpc.Dial(addr, grpc.WithTransportCredentials(insecure.NewCredentials()))
+	if err != nil {
+		t.Fatalf("Failed to make a gRPC connection to the server: %v", err)
+	}
+	defer conn.Close()
+
+	se, err := newStatsExporter(Options{
+		ProjectID:               "bounds",
+		MonitoringClientOptions: []option.ClientOption{option.WithGRPCConn(conn)},
+		DefaultMonitoringLabels: &Labels{},
+		MapResource:             DefaultMapResource,
+	})
+	if err != nil {
+		t.Fatalf("Failed to create the statsExporter: %v", err)
+	}
+
+	startTimestamp := &timestamp.Timestamp{Seconds: 1543160298, Nanos: 100000090}
+	endTimestamp := &timestamp.Timestamp{Seconds: 1543160298, Nanos: 101000090}
+	makeDistTs := func(label string, bounds ...float64) *metricspb.TimeSeries {
+		return &metricspb.TimeSeries{
+			StartTimestamp: startTimestamp,
+			LabelValues:    makeLabelValue(label),
+			Points: []*metricspb.Point{
+				{
+					Timestamp: endTimestamp,
+					Value: &metricspb.Point_DistributionValue{
+						DistributionValue: &metricspb.DistributionValue{
+							Count: 1,
+							Sum:   1,
+							BucketOptions: &metricspb.DistributionValue_BucketOptions{
+								Type: &metricspb.DistributionValue_BucketOptions_Explicit_{
+									Explicit: &metricspb.DistributionValue_BucketOptions_Explicit{
+										Bounds: bounds,
+									},
+								},
+							},
+							Buckets: make([]*metricspb.DistributionValue_Bucket, len(bounds)+1),
+						},
+					},
+				},
+			},
+		}
+	}
+	metric := &metricspb.Metric{
+		MetricDescriptor: &metricspb.MetricDescriptor{
+			Name:      "dist_bounds",
+			Type:      metricspb.MetricDescriptor_CUMULATIVE_DISTRIBUTION,
+			LabelKeys: []*metricspb.LabelKey{{Key: "key1"}},
+		},
+		Timeseries: []*metricspb.TimeSeries{
+			makeDistTs("valid", 1, 2, 3),
+			makeDistTs("unsorted", 1, 3, 2),
+		},
+	}
+
+	dropped, err := se.PushMetricsProto(context.Background(), nil, nil, []*metricspb.Metric{metric})
+	if dropped != 1 {
+		t.Errorf("Want 1 dropped time series, got %d", dropped)
+	}
+	if err == nil || !strings.Contains(err.Error(), "strictly increasing") {
+		t.Errorf("Want a bucket bounds error, got %v", err)
+	}
+
+	var got []*monitoringpb.TimeSeries                                                    //nolint: staticcheck
+	server.forEachStackdriverTimeSeries(func(sdt *monitoringpb.CreateTimeSeriesRequest) { //nolint: staticcheck
+		got = append(got, sdt.TimeSeries...)
+	})
+	if len(got) != 1 || got[0].Metric.Labels["key1"] != "valid" {
+		t.Fatalf("Want only the valid time series sent, got %v", got)
+	}
+}
+
+func TestPushMetricsProtoResourceFromContext(t *testing.T) {
+	server, addr, doneFn := createFakeServer(t)
+	defer doneFn()
+
+	conn, err := grpc.Dial(addr, grpc.WithTransportCredentials(insecure.NewCredentials()))
+	if err != nil {
+		t.Fatalf("Failed to make a gRPC connection to the server: %v", err)
+	}
+	defer conn.Close()
+
+	type tenantKey struct{}
+	se, err := newStatsExporter(Options{
+		ProjectID:               "tenants",
+		MonitoringClientOptions: []option.ClientOption{option.WithGRPCConn(conn)},
+		DefaultMonitoringLabels: &Labels{},
+		MapResource:             DefaultMapResource,
+		ResourceFromContext: func(ctx context.Context) *monitoredrespb.MonitoredResource {
+			tenant, ok := ctx.Value(tenantKey{}).(string)
+			if !ok {
+				return nil
+			}
+			return &monitoredrespb.MonitoredResource{
+				Type:   "generic_node",
+				Labels: map[string]string{"project_id": "tenants", "location": "us-east1", "namespace": tenant, "node_id": "n1"},
+			}
+		},
+	})
+	if err != nil {
+		t.Fatalf("Failed to create the statsExporter: %v", err)
+	}
+
+	metric := &metricspb.Metric{
+		MetricDescriptor: &metricspb.MetricDescriptor{
+			Name: "requests",
+			Type: metricspb.MetricDescriptor_CUMULATIVE_INT64,
+		},
+		Timeseries: []*metricspb.TimeSeries{
+			{
+				StartTimestamp: &timestamp.Timestamp{Seconds: 1543160298},
+				Points: []*metricspb.Point{
+					{
+						Timestamp: &timestamp.Timestamp{Seconds: 1543160299},
+						Value:     &metricspb.Point_Int64Value{Int64Value: 1},
+					},
+				},
+			},
+		},
+	}
+
+	ctx := context.WithValue(context.Background(), tenantKey{}, "tenant-a")
+	if _, err := se.PushMetricsProto(ctx, nil, nil, []*metricspb.Metric{metric}); err != nil {
+		t.Fatalf("PushMetricsProto() = %v", err)
+	}
+	if _, err := se.PushMetricsProto(context.Background(), nil, nil, []*metricspb.Metric{metric}); err != nil {
+		t.Fatalf("PushMetricsProto() = %v", err)
+	}
+
+	var got []*monitoredrespb.MonitoredResource
+	server.forEachStackdriverTimeSeries(func(sdt *monitoringpb.CreateTimeSeriesRequest) { //nolint: staticcheck
+		for _, ts := range sdt.TimeSeries {
+			got = append(got, ts.Resource)
+		}
+	})
+	if len(got) != 2 {
+		t.Fatalf("Want 2 time series sent, got %d", len(got))
+	}
+	if got[0].Type != "generic_node" || got[0].Labels["namespace"] != "tenant-a" {
+		t.Errorf("Want the resource from the context, got %v", got[0])
+	}
+	if got[1].Type != "global" {
+		t.Errorf("Want the global resource without a context resource, got %v", got[1])
+	}
+}
+
+func TestPushMetricsProtoDefaultMonitoredResource(t *testing.T) {
+	server, addr, doneFn := createFakeServer(t)
+	defer doneFn()
+
+	conn, err := grpc.Dial(addr, grpc.WithTransportCredentials(insecure.NewCredentials()))
+	if err != nil {
+		t.Fatalf("Failed to make a gRPC connection to the server: %v", err)
+	}
+	defer conn.Close()
+
+	fallback := &monitoredrespb.MonitoredResource{
+		Type:   "generic_node",
+		Labels: map[string]string{"project_id": "foo", "location": "us-central1", "namespace": "ns", "node_id": "node"},
+	}
+	se, err := newStatsExporter(Options{
+		ProjectID:                "foo",
+		MonitoringClientOptions:  []option.ClientOption{option.WithGRPCConn(conn)},
+		DefaultMonitoringLabels:  &Labels{},
+		MapResource:              DefaultMapResource,
+		DefaultMonitoredResource: fallback,
+	})
+	if err != nil {
+		t.Fatalf("Failed to create the statsExporter: %v", err)
+	}
+
+	metric := &metricspb.Metric{
+		MetricDescriptor: &metricspb.MetricDescriptor{
+			Name: "requests",
+			Type: metricspb.MetricDescriptor_CUMULATIVE_INT64,
+		},
+		Timeseries: []*metricspb.TimeSeries{
+			{
+				StartTimestamp: &timestamp.Timestamp{Seconds: 1543160298},
+				Points: []*metricspb.Point{
+					{
+						Timestamp: &timestamp.Timestamp{Seconds: 1543160299},
+						Value:     &metricspb.Point_Int64Value{Int64Value: 1},
+					},
+				},
+			},
+		},
+	}
+
+	// Neither a nil resource nor one DefaultMapResource cannot map fall back to global.
+	unmapped := &resourcepb.Resource{Type: resourcekeys.ContainerType, Labels: map[string]string{}}
+	for _, rsc := range []*resourcepb.Resource{nil, unmapped} {
+		if _, err := se.PushMetricsProto(context.Background(), nil, rsc, []*metricspb.Metric{metric}); err != nil {
+			t.Fatalf("PushMetricsProto() = %v", err)
+		}
+	}
+
+	var got []*monitoredrespb.MonitoredResource
+	server.forEachStackdriverTimeSeries(func(sdt *monitoringpb.CreateTimeSeriesRequest) { //nolint: staticcheck
+		for _, ts := range sdt.TimeSeries {
+			got = append(got, ts.Resource)
+		}
+	})
+	if len(got) != 2 {
+		t.Fatalf("Want 2 time series sent, got %d", len(got))
+	}
+	for i, rsc := range got {
+		if diff := cmpResource(rsc, fallback); diff != "" {
+			t.Errorf("Time series %d: unexpected Resource -got +want: %s", i, diff)
+		}
+	}
+}
+
+func TestProtoDistributionMetricKinds(t *testing.T) {
+	startTimestamp := &timestamp.Timestamp{Seconds: 1543160298, Nanos: 100000090}
+	endTimestamp := &timestamp.Timestamp{Seconds: 1543160298, Nanos: 101000090}
+
+	tests := []struct {
+		descType      metricspb.MetricDescriptor_Type
+		wantKind      googlemetricpb.MetricDescriptor_MetricKind
+		wantStartTime *timestamp.Timestamp
+	}{
+		{
+			descType:      metricspb.MetricDescriptor_CUMULATIVE_DISTRIBUTION,
+			wantKind:      googlemetricpb.MetricDescriptor_CUMULATIVE,
+			wantStartTime: startTimestamp,
+		},
+		{
+			descType:      metricspb.MetricDescriptor_GAUGE_DISTRIBUTION,
+			wantKind:      googlemetricpb.MetricDescriptor_GAUGE,
+			wantStartTime: nil,
+		},
+	}
+
+	se := &statsExporter{o: Options{ProjectID: "foo"}}
+	for _, tt := range tests {
+		t.Run(tt.descType.String(), func(t *testing.T) {
+			metric := &metricspb.Metric{
+				MetricDescriptor: &metricspb.MetricDescriptor{
+					Name: "distribution_kind",
+					Type: tt.descType,
+				},
+				Timeseries: []*metricspb.TimeSeries{
+					{
+						StartTimestamp: startTimestamp,
+						Points: []*metricspb.Point{
+							{
+								Timestamp: endTimestamp,
+								Value: &metricspb.Point_DistributionValue{
+									DistributionValue: &metricspb.DistributionValue{
+										Count: 1,
+										Sum:   5,
+										BucketOptions: &metricspb.DistributionValue_BucketOptions{
+											Type: &metricspb.DistributionValue_BucketOptions_Explicit_{
+												Explicit: &metricspb.DistributionValue_BucketOptions_Explicit{
+													Bounds: []float64{10},
+												},
+											},
+										},
+										Buckets: []*metricspb.DistributionValue_Bucket{{Count: 1}, {}},
+									},
+								},
+							},
+						},
+					},
+				},
+			}
+
+			md, err := se.protoToMonitoringMetricDescriptor(metric, nil)
+			if err != nil {
+				t.Fatalf("protoToMonitoringMetricDescriptor() error = %v", err)
+			}
+			if md.MetricKind != tt.wantKind || md.ValueType != googlemetricpb.MetricDescriptor_DISTRIBUTION {
+				t.Errorf("descriptor kind, value type = %v, %v; want %v, DISTRIBUTION", md.MetricKind, md.ValueType, tt.wantKind)
+			}
+
+			tsl, err := protoMetricToTimeSeries(context.Background(), se, nil, metric)
+			if err != nil {
+				t.Fatalf("protoMetricToTimeSeries() error = %v", err)
+			}
+			if len(tsl) != 1 {
+				t.Fatalf("got %d time series; want 1", len(tsl))
+			}
+			if tsl[0].MetricKind != tt.wantKind {
+				t.Errorf("time series kind = %v; want %v", tsl[0].MetricKind, tt.wantKind)
+			}
+			interval := tsl[0].Points[0].Interval
+			if diff := cmp.Diff(interval.StartTime, tt.wantStartTime, protocmp.Transform()); diff != "" {
+				t.Errorf("start time -got +want: %s", diff)
+			}
+			if diff := cmp.Diff(interval.EndTime, endTimestamp, protocmp.Transform()); diff != "" {
+				t.Errorf("end time -got +want: %s", diff)
+			}
+		})
+	}
+}
+
+func BenchmarkCombineTimeSeriesWithDuplicates(b *testing.B) {
+	se := &statsExporter{o: Options{ProjectID: "foo"}}
+	// 2000 time series made of 20 distinct series repeated 100 times each.
+	tsl := make([]*monitoringpb.TimeSeries, 0, 2000) //nolint: staticcheck
+	for i := 0; i < 2000; i++ {
+		tsl = append(tsl, &monitoringpb.TimeSeries{ //nolint: staticcheck
+			Metric: &googlemetricpb.Metric{
+				Type:   fmt.Sprintf("custom.googleapis.com/opencensus/metric_%d", i%20),
+				Labels: map[string]string{"key": "value"},
+			},
+		})
+	}
+
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		se.combineTimeSeriesToCreateTimeSeriesRequest(tsl)
+	}
+}
+
+func TestPushMetricsProtoNumberOfWorkers(t *testing.T) {
+	_, addr, doneFn := createFakeServer(t)
+	defer doneFn()
+
+	conn, err := grpc.Dial(addr, grpc.WithTransportCredentials(insecure.NewCredentials()))
+	if err != nil {
+		t.Fatalf("Failed to make a gRPC connection to the server: %v", err)
+	}
+	defer conn.Close()
+
+	var mu sync.Mutex
+	var inflight, maxInflight int
+	persistedCreateTimeSeries := createTimeSeries
+	createTimeSeries = func(ctx context.Context, c *monitoring.MetricClient, req *monitoringpb.CreateTimeSeriesRequest) error { //nolint: staticcheck
+		mu.Lock()
+		inflight++
+		if inflight > maxInflight {
+			maxInflight = inflight
+		}
+		mu.Unlock()
+		time.Sleep(100 * time.Millisecond)
+		mu.Lock()
+		inflight--
+		mu.Unlock()
+		return nil
+	}
+	defer func() {
+		createTimeSeries = persistedCreateTimeSeries
+	}()
+
+	se, err := newStatsExporter(Options{
+		ProjectID:               "workers",
+		MonitoringClientOptions: []option.ClientOption{option.WithGRPCConn(conn)},
+		DefaultMonitoringLabels: &Labels{},
+		MapResource:             DefaultMapResource,
+		NumberOfWorkers:         2,
+	})
+	if err != nil {
+		t.Fatalf("Failed to create the statsExporter: %v", err)
+	}
+
+	// 8 requests of maxTimeSeriesPerUpload time series.
+	metric := &metricspb.Metric{
+		MetricDescriptor: &metricspb.MetricDescriptor{
+			Name:      "parallel",
+			Type:      metricspb.MetricDescriptor_CUMULATIVE_INT64,
+			LabelKeys: []*metricspb.LabelKey{{Key: "k"}},
+		},
+	}
+	for i := 0; i < 8*maxTimeSeriesPerUpload; i++ {
+		metric.Timeseries = append(metric.Timeseries, &metricspb.TimeSeries{
+			StartTimestamp: &timestamp.Timestamp{Seconds: 1543160298},
+			LabelValues:    []*metricspb.LabelValue{{Value: fmt.Sprint(i), HasValue: true}},
+			Points: []*metricspb.Point{{
+				Timestamp: &timestamp.Timestamp{Seconds: 1543160299},
+				Value:     &metricspb.Point_Int64Value{Int64Value: 1},
+			}},
+		})
+	}
+
+	tests := []struct {
+		name        string
+		ctx         context.Context
+		wantWorkers int
+	}{
+		{name: "NumberOfWorkers", ctx: context.Background(), wantWorkers: 2},
+		{name: "WithNumberOfWorkers", ctx: WithNumberOfWorkers(context.Background(), 4), wantWorkers: 4},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			maxInflight = 0
+			if _, err := se.PushMetricsProto(tt.ctx, nil, nil, []*metricspb.Metric{metric}); err != nil {
+				t.Fatalf("PushMetricsProto() = %v", err)
+			}
+			if maxInflight != tt.wantWorkers {
+				t.Errorf("Got %d concurrent CreateTimeSeries calls, want %d", maxInflight, tt.wantWorkers)
+			}
+		})
+	}
+}
+
+func TestProtoTimeSeriesDropsPointsWithoutTimestamp(t *testing.T) {
+	var errs []error
+	se := &statsExporter{o: Options{ProjectID: "foo", OnError: func(err error) { errs = append(errs, err) }}}
+	ts := &metricspb.TimeSeries{
+		StartTimestamp: &timestamp.Timestamp{Seconds: 1543160298},
+		Points: []*metricspb.Point{
+			{Value: &metricspb.Point_Int64Value{Int64Value: 1}},
+			{Timestamp: &timestamp.Timestamp{}, Value: &metricspb.Point_Int64Value{Int64Value: 2}},
+			{Timestamp: &timestamp.Timestamp{Seconds: 1543160299}, Value: &metricspb.Point_Int64Value{Int64Value: 3}},
+		},
+	}
+
+	got, err := se.protoTimeSeriesToMonitoringPoints("custom.googleapis.com/opencensus/no_timestamp", ts, googlemetricpb.MetricDescriptor_CUMULATIVE)
+	if err != nil {
+		t.Fatalf("protoTimeSeriesToMonitoringPoints() error = %v", err)
+	}
+	want := []*monitoringpb.Point{ //nolint: staticcheck
+		{
+			Interval: &monitoringpb.TimeInterval{ //nolint: staticcheck
+				StartTime: &timestamp.Timestamp{Seconds: 1543160298},
+				EndTime:   &timestamp.Timestamp{Seconds: 1543160299},
+			},
+			Value: &monitoringpb.TypedValue{Value: &monitoringpb.TypedValue_Int64Value{Int64Value: 3}}, //nolint: staticcheck
+		},
+	}
+	if diff := cmp.Diff(got, want, protocmp.Transform()); diff != "" {
+		t.Errorf("points -got +want: %s", diff)
+	}
+	if len(errs) != 2 {
+		t.Errorf("got %d errors reported; want 2: %v", len(errs), errs)
+	}
+	for _, err := range errs {
+		if !errors.Is(err, errNilPointTimestamp) || !strings.Contains(err.Error(), "custom.googleapis.com/opencensus/no_timestamp") {
+			t.Errorf("reported error = %v; want %v naming the metric", err, errNilPointTimestamp)
+		}
+	}
+}
+
+func TestPushMetricsProtoCountsSeriesWithoutTimestamps(t *testing.T) {
+	var errs []error
+	se := &statsExporter{
+		o: Options{
+			ProjectID:   "foo",
+			MapResource: DefaultMapResource,
+			OnError:     func(err error) { errs = append(errs, err) },
+		},
+		protoMetricDescriptors: map[string]bool{"no_timestamp": true},
+	}
+	metric := &metricspb.Metric{
+		MetricDescriptor: &metricspb.MetricDescriptor{Name: "no_timestamp", Type: metricspb.MetricDescriptor_CUMULATIVE_INT64},
+		Timeseries: []*metricspb.TimeSeries{{
+			StartTimestamp: &timestamp.Timestamp{Seconds: 1543160298},
+			Points:         []*metricspb.Point{{Value: &metricspb.Point_Int64Value{Int64Value: 1}}},
+		}},
+	}
+
+	dropped, err := se.PushMetricsProto(context.Background(), nil, nil, []*metricspb.Metric{metric})
+	if err != nil {
+		t.Fatalf("PushMetricsProto() = %v", err)
+	}
+	if dropped != 1 {
+		t.Errorf("PushMetricsProto() dropped %d time series; want 1", dropped)
+	}
+	if len(errs) != 1 {
+		t.Errorf("got %d errors reported; want 1: %v", len(errs), errs)
+	}
+}
+
+func TestProtoMetricDefaultMetricKind(t *testing.T) {
+	startTimestamp := &timestamp.Timestamp{Seconds: 1543160298}
+	endTimestamp := &timestamp.Timestamp{Seconds: 1543160358}
+	newMetric := func(typ metricspb.MetricDescriptor_Type) *metricspb.Metric {
+		return &metricspb.Metric{
+			MetricDescriptor: &metricspb.MetricDescriptor{Name: "untyped_metric", Type: typ},
+			Timeseries: []*metricspb.TimeSeries{{
+				StartTimestamp: startTimestamp,
+				Points: []*metricspb.Point{{
+					Timestamp: endTimestamp,
+					Value:     &metricspb.Point_DoubleValue{DoubleValue: 25},
+				}},
+			}},
+		}
+	}
+
+	tests := []struct {
+		name        string
+		defaultKind googlemetricpb.MetricDescriptor_MetricKind
+		in          *metricspb.Metric
+		wantKind    googlemetricpb.MetricDescriptor_MetricKind
+		wantType    googlemetricpb.MetricDescriptor_ValueType
+		wantStart   bool
+	}{
+		{
+			name:      "unset",
+			in:        newMetric(metricspb.MetricDescriptor_UNSPECIFIED),
+			wantKind:  googlemetricpb.MetricDescriptor_METRIC_KIND_UNSPECIFIED,
+			wantType:  googlemetricpb.MetricDescriptor_VALUE_TYPE_UNSPECIFIED,
+			wantStart: true,
+		},
+		{
+			name:        "gauge",
+			defaultKind: googlemetricpb.MetricDescriptor_GAUGE,
+			in:          newMetric(metricspb.MetricDescriptor_UNSPECIFIED),
+			wantKind:    googlemetricpb.MetricDescriptor_GAUGE,
+			wantType:    googlemetricpb.MetricDescriptor_DOUBLE,
+		},
+		{
+			name:        "cumulative",
+			defaultKind: googlemetricpb.MetricDescriptor_CUMULATIVE,
+			in:          newMetric(metricspb.MetricDescriptor_UNSPECIFIED),
+			wantKind:    googlemetricpb.MetricDescriptor_CUMULATIVE,
+			wantType:    googlemetricpb.MetricDescriptor_DOUBLE,
+			wantStart:   true,
+		},
+		{
+			name:        "typed metric keeps its kind",
+			defaultKind: googlemetricpb.MetricDescriptor_GAUGE,
+			in:          newMetric(metricspb.MetricDescriptor_CUMULATIVE_DOUBLE),
+			wantKind:    googlemetricpb.MetricDescriptor_CUMULATIVE,
+			wantType:    googlemetricpb.MetricDescriptor_DOUBLE,
+			wantStart:   true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			se := &statsExporter{o: Options{ProjectID: "foo", DefaultProtoMetricKind: tt.defaultKind}}
+
+			md, err := se.protoToMonitoringMetricDescriptor(tt.in, nil)
+			if err != nil {
+				t.Fatalf("protoToMonitoringMetricDescriptor() error = %v", err)
+			}
+			if md.MetricKind != tt.wantKind || md.ValueType != tt.wantType {
+				t.Errorf("descriptor kind = %v %v; want %v %v", md.MetricKind, md.ValueType, tt.wantKind, tt.wantType)
+			}
+
+			tsl, err := protoMetricToTimeSeries(context.Background(), se, &monitoredrespb.MonitoredResource{Type: "global"}, tt.in)
+			if err != nil {
+				t.Fatalf("protoMetricToTimeSeries() error = %v", err)
+			}
+			if len(tsl) != 1 || len(tsl[0].Points) != 1 {
+				t.Fatalf("protoMetricToTimeSeries() = %v; want 1 time series with 1 point", tsl)
+			}
+			ts := tsl[0]
+			if ts.MetricKind != tt.wantKind || ts.ValueType != tt.wantType {
+				t.Errorf("time series kind = %v %v; want %v %v", ts.MetricKind, ts.ValueType, tt.wantKind, tt.wantType)
+			}
+			if gotStart := ts.Points[0].Interval.StartTime != nil; gotStart != tt.wantStart {
+				t.Errorf("start time set = %t; want %t", gotStart, tt.wantStart)
+			}
+		})
+	}
+}
@@ -17,12 +17,21 @@ package stackdriver
 import (
 	"context"
 	"fmt"
+	"math"
+	"sort"
 	"strings"
 	"testing"
+	"time"
 
+	monitoring "cloud.google.com/go/monitoring/apiv3/v2"
 	"github.com/golang/protobuf/ptypes/any"
 	"github.com/golang/protobuf/ptypes/timestamp"
+	"github.com/google/go-cmp/cmp"
+	"google.golang.org/api/option"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/credentials/insecure"
 	"google.golang.org/protobuf/proto"
+	"google.golang.org/protobuf/testing/protocmp"
 
 	distributionpb "google.golang.org/genproto/googleapis/api/distribution"
 	labelpb "google.golang.org/genproto/googleapis/api/label"
@@ -31,7 +40,9 @@ import (
 	monitoringpb "google.golang.org/genproto/googleapis/monitoring/v3" //nolint: staticcheck
 
 	"github.com/launchdarkly/opencensus-go-exporter-stackdriver/monitoredresource"
+	"go.opencensus.io/metric"
 	"go.opencensus.io/metric/metricdata"
+	"go.opencensus.io/metric/metricproducer"
 	"go.opencensus.io/resource"
 	"go.opencensus.io/trace"
 )
@@ -85,6 +96,29 @@ func TestMetricResourceToMonitoringResource(t *testing.T) {
 	}
 }
 
+func TestMetricResourceToMonitoringResourceDefaultMonitoredResource(t *testing.T) {
+	fallback := &monitoredrespb.MonitoredResource{
+		Type:   "generic_node",
+		Labels: map[string]string{"project_id": "foo", "location": "us-central1", "namespace": "ns", "node_id": "node"},
+	}
+	se := &statsExporter{o: Options{ProjectID: "foo", DefaultMonitoredResource: fallback}}
+
+	tests := []struct {
+		in   *resource.Resource
+		want *monitoredrespb.MonitoredResource
+	}{
+		{in: nil, want: fallback},
+		{in: &resource.Resource{}, want: fallback},
+		{in: &resource.Resource{Type: "foo"}, want: &monitoredrespb.MonitoredResource{Type: "foo"}},
+	}
+	for i, tt := range tests {
+		got := se.metricRscToMpbRsc(tt.in)
+		if diff := cmpResource(got, tt.want); diff != "" {
+			t.Errorf("Test %d failed. Unexpected Resource -got +want: %s", i, diff)
+		}
+	}
+}
+
 func TestMetricToCreateTimeSeriesRequest(t *testing.T) {
 	startTimestamp := &timestamp.Timestamp{
 		Seconds: 1543160298,
@@ -426,6 +460,711 @@ func TestMetricTypeToMonitoringMetricKind(t *testing.T) {
 	}
 }
 
+func TestMetricToMpbMetricDescriptorOmitAbsentLabels(t *testing.T) {
+	md := &metricdata.Metric{
+		Descriptor: metricdata.Descriptor{
+			Name:      "with_absent_label",
+			Type:      metricdata.TypeGaugeInt64,
+			LabelKeys: []metricdata.LabelKey{{Key: "present"}, {Key: "absent"}, {Key: "sometimes"}},
+		},
+		TimeSeries: []*metricdata.TimeSeries{
+			{LabelValues: []metricdata.LabelValue{metricdata.NewLabelValue("a"), {}, {}}},
+			{LabelValues: []metricdata.LabelValue{metricdata.NewLabelValue("b"), {}, metricdata.NewLabelValue("c")}},
+		},
+	}
+
+	for _, tt := range []struct {
+		omit bool
+		want []string
+	}{
+		{omit: false, want: []string{"present", "absent", "sometimes"}},
+		{omit: true, want: []string{"present", "sometimes"}},
+	} {
+		se := &statsExporter{o: Options{ProjectID: "foo", OmitAbsentDescriptorLabels: tt.omit}}
+		got, err := se.metricToMpbMetricDescriptor(md)
+		if err != nil {
+			t.Fatalf("omit=%v: unexpected error: %v", tt.omit, err)
+		}
+		var gotKeys []string
+		for _, l := range got.Labels {
+			gotKeys = append(gotKeys, l.Key)
+		}
+		if diff := cmp.Diff(gotKeys, tt.want); diff != "" {
+			t.Errorf("omit=%v: label keys -got +want: %s", tt.omit, diff)
+		}
+	}
+}
+
+func TestMetricToMpbTsOriginalNameLabel(t *testing.T) {
+	se := &statsExporter{o: Options{ProjectID: "foo", IncludeOriginalNameLabel: true}}
+	newMetric := func(numKeys int) *metricdata.Metric {
+		m := &metricdata.Metric{
+			Descriptor: metricdata.Descriptor{Name: "my.metric/name", Type: metricdata.TypeGaugeInt64},
+			TimeSeries: []*metricdata.TimeSeries{{
+				Points: []metricdata.Point{metricdata.NewInt64Point(time.Now(), 1)},
+			}},
+		}
+		for i := 0; i < numKeys; i++ {
+			m.Descriptor.LabelKeys = append(m.Descriptor.LabelKeys, metricdata.LabelKey{Key: fmt.Sprintf("key_%d", i)})
+			m.TimeSeries[0].LabelValues = append(m.TimeSeries[0].LabelValues, metricdata.NewLabelValue("v"))
+		}
+		return m
+	}
+
+	tsl, err := se.metricToMpbTs(context.Background(), newMetric(1))
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if got := tsl[0].Metric.Labels[defaultOriginalNameLabelKey]; got != "my.metric/name" {
+		t.Errorf("label %q = %q; want %q", defaultOriginalNameLabelKey, got, "my.metric/name")
+	}
+
+	// A metric that already has the maximum number of labels is left untouched.
+	tsl, err = se.metricToMpbTs(context.Background(), newMetric(maxLabelsPerMetric))
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if got, ok := tsl[0].Metric.Labels[defaultOriginalNameLabelKey]; ok {
+		t.Errorf("label %q = %q; want it absent for a metric at the label limit", defaultOriginalNameLabelKey, got)
+	}
+}
+
+func TestMetricToMpbTsExporterVersionLabel(t *testing.T) {
+	se := &statsExporter{o: Options{ProjectID: "foo", IncludeExporterVersionLabel: true}}
+	newMetric := func(numKeys int) *metricdata.Metric {
+		m := &metricdata.Metric{
+			Descriptor: metricdata.Descriptor{Name: "versioned", Type: metricdata.TypeGaugeInt64},
+			TimeSeries: []*metricdata.TimeSeries{{
+				Points: []metricdata.Point{metricdata.NewInt64Point(time.Now(), 1)},
+			}},
+		}
+		for i := 0; i < numKeys; i++ {
+			m.Descriptor.LabelKeys = append(m.Descriptor.LabelKeys, metricdata.LabelKey{Key: fmt.Sprintf("key_%d", i)})
+			m.TimeSeries[0].LabelValues = append(m.TimeSeries[0].LabelValues, metricdata.NewLabelValue("v"))
+		}
+		return m
+	}
+
+	tsl, err := se.metricToMpbTs(context.Background(), newMetric(1))
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if got := tsl[0].Metric.Labels[exporterVersionLabelKey]; got != version {
+		t.Errorf("label %q = %q; want %q", exporterVersionLabelKey, got, version)
+	}
+	md, err := se.metricToMpbMetricDescriptor(newMetric(1))
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if len(md.Labels) != 2 {
+		t.Errorf("descriptor labels = %v; want key_0 and %q", md.Labels, exporterVersionLabelKey)
+	}
+
+	tsl, err = se.metricToMpbTs(context.Background(), newMetric(maxLabelsPerMetric))
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if got, ok := tsl[0].Metric.Labels[exporterVersionLabelKey]; ok {
+		t.Errorf("label %q = %q; want it absent for a metric at the label limit", exporterVersionLabelKey, got)
+	}
+}
+
+func TestMetricToMpbTsReportsDroppedLabels(t *testing.T) {
+	var errs []error
+	se := &statsExporter{o: Options{
+		ProjectID:                   "foo",
+		IncludeOriginalNameLabel:    true,
+		IncludeExporterVersionLabel: true,
+		OnError:                     func(err error) { errs = append(errs, err) },
+	}}
+	m := &metricdata.Metric{
+		Descriptor: metricdata.Descriptor{Name: "crowded", Type: metricdata.TypeGaugeInt64},
+		TimeSeries: []*metricdata.TimeSeries{{
+			Points: []metricdata.Point{metricdata.NewInt64Point(time.Now(), 1)},
+		}},
+	}
+	for i := 0; i < maxLabelsPerMetric-1; i++ {
+		m.Descriptor.LabelKeys = append(m.Descriptor.LabelKeys, metricdata.LabelKey{Key: fmt.Sprintf("key_%d", i)})
+		m.TimeSeries[0].LabelValues = append(m.TimeSeries[0].LabelValues, metricdata.NewLabelValue("v"))
+	}
+
+	for i := 0; i < 2; i++ {
+		tsl, err := se.metricToMpbTs(context.Background(), m)
+		if err != nil {
+			t.Fatalf("Unexpected error: %v", err)
+		}
+		if got := len(tsl[0].Metric.Labels); got != maxLabelsPerMetric {
+			t.Errorf("got %d labels; want %d", got, maxLabelsPerMetric)
+		}
+		if _, ok := tsl[0].Metric.Labels[exporterVersionLabelKey]; ok {
+			t.Errorf("label %q present; want it dropped at the label limit", exporterVersionLabelKey)
+		}
+	}
+	if len(errs) != 1 {
+		t.Fatalf("got %d errors, want the dropped label reported once: %v", len(errs), errs)
+	}
+	if want := `metric "crowded": dropped 1 optional labels`; !strings.Contains(errs[0].Error(), want) {
+		t.Errorf("error = %q; want it to contain %q", errs[0], want)
+	}
+}
+
+func TestMetricToMpbTsPointInterval(t *testing.T) {
+	now := time.Now()
+	override := &monitoringpb.TimeInterval{ //nolint: staticcheck
+		StartTime: timestampProto(now.Add(-10 * time.Second)),
+		EndTime:   timestampProto(now.Add(-5 * time.Second)),
+	}
+	var gotTypes []string
+	se := &statsExporter{o: Options{
+		ProjectID: "foo",
+		PointInterval: func(metricType string, pt *metricdata.Point) *monitoringpb.TimeInterval { //nolint: staticcheck
+			gotTypes = append(gotTypes, metricType)
+			if pt.Value.(int64) == 1 {
+				return override
+			}
+			return nil
+		},
+	}}
+	metric := &metricdata.Metric{
+		Descriptor: metricdata.Descriptor{Name: "intervals", Type: metricdata.TypeCumulativeInt64, LabelKeys: []metricdata.LabelKey{{Key: "k"}}},
+		TimeSeries: []*metricdata.TimeSeries{
+			{
+				LabelValues: []metricdata.LabelValue{metricdata.NewLabelValue("overridden")},
+				StartTime:   now.Add(-time.Minute),
+				Points:      []metricdata.Point{metricdata.NewInt64Point(now, 1)},
+			},
+			{
+				LabelValues: []metricdata.LabelValue{metricdata.NewLabelValue("derived")},
+				StartTime:   now.Add(-time.Minute),
+				Points:      []metricdata.Point{metricdata.NewInt64Point(now, 2)},
+			},
+		},
+	}
+
+	tsl, err := se.metricToMpbTs(context.Background(), metric)
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if len(tsl) != 2 {
+		t.Fatalf("got %d time series; want 2", len(tsl))
+	}
+	if diff := cmp.Diff(tsl[0].Points[0].Interval, override, protocmp.Transform()); diff != "" {
+		t.Errorf("overridden interval -got +want: %s", diff)
+	}
+	if got := tsl[1].Points[0].Interval.StartTime.AsTime(); !got.Equal(now.Add(-time.Minute)) {
+		t.Errorf("derived start time = %v; want %v", got, now.Add(-time.Minute))
+	}
+	if want := []string{"custom.googleapis.com/opencensus/intervals", "custom.googleapis.com/opencensus/intervals"}; !cmp.Equal(gotTypes, want) {
+		t.Errorf("PointInterval called with metric types %v; want %v", gotTypes, want)
+	}
+}
+
+func TestMetricToMpbTsClockSkew(t *testing.T) {
+	const skew = 2 * time.Minute
+	se := &statsExporter{o: Options{ProjectID: "foo", ClockSkew: skew}}
+	start := time.Now()
+	end := start.Add(time.Second)
+	metric := &metricdata.Metric{
+		Descriptor: metricdata.Descriptor{Name: "skewed", Type: metricdata.TypeCumulativeInt64},
+		TimeSeries: []*metricdata.TimeSeries{{
+			StartTime: start,
+			Points:    []metricdata.Point{metricdata.NewInt64Point(end, 1)},
+		}},
+	}
+
+	tsl, err := se.metricToMpbTs(context.Background(), metric)
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	interval := tsl[0].Points[0].Interval
+	if got, want := interval.StartTime.AsTime(), start.Add(-skew); !got.Equal(want) {
+		t.Errorf("start time = %v; want %v", got, want)
+	}
+	if got, want := interval.EndTime.AsTime(), end.Add(-skew); !got.Equal(want) {
+		t.Errorf("end time = %v; want %v", got, want)
+	}
+	if interval.EndTime.AsTime().Before(interval.StartTime.AsTime()) {
+		t.Errorf("end time %v is before start time %v", interval.EndTime.AsTime(), interval.StartTime.AsTime())
+	}
+}
+
+func TestMetricToMpbTsPerSeriesStartTime(t *testing.T) {
+	se := &statsExporter{o: Options{ProjectID: "foo"}}
+	end := time.Now()
+	starts := []time.Time{end.Add(-time.Hour), end.Add(-time.Minute)}
+	metric := &metricdata.Metric{
+		Descriptor: metricdata.Descriptor{
+			Name:      "restarted",
+			Type:      metricdata.TypeCumulativeInt64,
+			LabelKeys: []metricdata.LabelKey{{Key: "k"}},
+		},
+	}
+	for i, start := range starts {
+		metric.TimeSeries = append(metric.TimeSeries, &metricdata.TimeSeries{
+			LabelValues: []metricdata.LabelValue{metricdata.NewLabelValue(fmt.Sprint(i))},
+			StartTime:   start,
+			Points:      []metricdata.Point{metricdata.NewInt64Point(end, 1)},
+		})
+	}
+
+	tsl, err := se.metricToMpbTs(context.Background(), metric)
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if len(tsl) != len(starts) {
+		t.Fatalf("got %d time series; want %d", len(tsl), len(starts))
+	}
+	for i, ts := range tsl {
+		if got := ts.Points[0].Interval.StartTime.AsTime(); !got.Equal(starts[i]) {
+			t.Errorf("series %d: start time = %v; want %v", i, got, starts[i])
+		}
+	}
+}
+
+func TestMetricToMpbTsSampleSeries(t *testing.T) {
+	// Keep the users whose name has an even length.
+	sampler := func(metricType string, labels map[string]string) bool {
+		return metricType != "custom.googleapis.com/opencensus/per_user" || len(labels["user"])%2 == 0
+	}
+	se := &statsExporter{o: Options{ProjectID: "foo", SampleSeries: sampler}}
+	newMetric := func(name string) *metricdata.Metric {
+		m := &metricdata.Metric{
+			Descriptor: metricdata.Descriptor{
+				Name:      name,
+				Type:      metricdata.TypeGaugeInt64,
+				LabelKeys: []metricdata.LabelKey{{Key: "user"}},
+			},
+		}
+		for _, user := range []string{"al", "bob", "carl", "dave", "eve"} {
+			m.TimeSeries = append(m.TimeSeries, &metricdata.TimeSeries{
+				LabelValues: []metricdata.LabelValue{metricdata.NewLabelValue(user)},
+				Points:      []metricdata.Point{metricdata.NewInt64Point(time.Now(), 1)},
+			})
+		}
+		return m
+	}
+	exportedUsers := func(name string) []string {
+		tsl, err := se.metricToMpbTs(context.Background(), newMetric(name))
+		if err != nil {
+			t.Fatalf("Unexpected error: %v", err)
+		}
+		var users []string
+		for _, ts := range tsl {
+			users = append(users, ts.Metric.Labels["user"])
+		}
+		return users
+	}
+
+	for i := 0; i < 2; i++ {
+		if diff := cmp.Diff(exportedUsers("per_user"), []string{"al", "carl", "dave"}); diff != "" {
+			t.Errorf("export %d: sampled users -got +want: %s", i, diff)
+		}
+	}
+	if got := exportedUsers("other"); len(got) != 5 {
+		t.Errorf("Want all series of other metrics exported, got %v", got)
+	}
+}
+
+func TestMetricToMpbTsDropsPointsOutsideAgeWindow(t *testing.T) {
+	var gotErrs []error
+	se := &statsExporter{
+		o: Options{
+			ProjectID:   "foo",
+			MaxPointAge: time.Hour,
+			OnError:     func(err error) { gotErrs = append(gotErrs, err) },
+		},
+	}
+	now := time.Now()
+	newTs := func(label string, end time.Time) *metricdata.TimeSeries {
+		return &metricdata.TimeSeries{
+			LabelValues: []metricdata.LabelValue{metricdata.NewLabelValue(label)},
+			Points:      []metricdata.Point{metricdata.NewInt64Point(end, 1)},
+		}
+	}
+	metric := &metricdata.Metric{
+		Descriptor: metricdata.Descriptor{
+			Name:      "point_age",
+			Type:      metricdata.TypeGaugeInt64,
+			LabelKeys: []metricdata.LabelKey{{Key: "when"}},
+		},
+		TimeSeries: []*metricdata.TimeSeries{
+			newTs("fresh", now),
+			newTs("stale", now.Add(-2*time.Hour)),
+			newTs("future", now.Add(time.Hour)),
+		},
+	}
+
+	tsl, err := se.metricToMpbTs(context.Background(), metric)
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if len(tsl) != 1 || tsl[0].Metric.Labels["when"] != "fresh" {
+		t.Fatalf("got %v; want only the fresh time series", tsl)
+	}
+	if len(gotErrs) != 2 {
+		t.Errorf("got %d errors reported; want 2: %v", len(gotErrs), gotErrs)
+	}
+}
+
+func TestMetricToMpbTsEmitResetPoint(t *testing.T) {
+	se := &statsExporter{o: Options{ProjectID: "foo", EmitResetPoint: true}}
+	start := time.Now().Add(-time.Minute)
+	newMetric := func(end time.Time) *metricdata.Metric {
+		newTs := func(labelValue string, v int64) *metricdata.TimeSeries {
+			return &metricdata.TimeSeries{
+				StartTime:   start,
+				LabelValues: []metricdata.LabelValue{metricdata.NewLabelValue(labelValue)},
+				Points:      []metricdata.Point{metricdata.NewInt64Point(end, v)},
+			}
+		}
+		return &metricdata.Metric{
+			Descriptor: metricdata.Descriptor{
+				Name:      "reset_counter",
+				Type:      metricdata.TypeCumulativeInt64,
+				LabelKeys: []metricdata.LabelKey{{Key: "k"}},
+			},
+			TimeSeries: []*metricdata.TimeSeries{newTs("a", 5), newTs("b", 7)},
+		}
+	}
+
+	tsl, err := se.metricToMpbTs(context.Background(), newMetric(start.Add(10*time.Second)))
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if len(tsl) != 4 {
+		t.Fatalf("Want a reset point and a real point for both series, got %d time series", len(tsl))
+	}
+	for i, want := range []struct {
+		label string
+		value int64
+		reset bool
+	}{{"a", 0, true}, {"a", 5, false}, {"b", 0, true}, {"b", 7, false}} {
+		ts := tsl[i]
+		pt := ts.Points[0]
+		if ts.Metric.Labels["k"] != want.label || pt.Value.GetInt64Value() != want.value {
+			t.Errorf("time series %d = %v, %v; want %v, %v", i, ts.Metric.Labels["k"], pt.Value.GetInt64Value(), want.label, want.value)
+		}
+		if !pt.Interval.StartTime.AsTime().Equal(start) {
+			t.Errorf("time series %d start = %v; want %v", i, pt.Interval.StartTime.AsTime(), start)
+		}
+		if want.reset && !pt.Interval.EndTime.AsTime().Equal(start.Add(resetPointDuration)) {
+			t.Errorf("reset point %d end = %v; want %v", i, pt.Interval.EndTime.AsTime(), start.Add(resetPointDuration))
+		}
+	}
+	if got := se.combineTimeSeriesToCreateTimeSeriesRequest(tsl); len(got) != 2 || got[0].TimeSeries[0].Points[0].Value.GetInt64Value() != 0 {
+		t.Errorf("Want the reset points sent in the first of 2 requests, got %v", got)
+	}
+
+	tsl, err = se.metricToMpbTs(context.Background(), newMetric(start.Add(20*time.Second)))
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if len(tsl) != 2 {
+		t.Errorf("Want no reset points on the second export, got %d time series", len(tsl))
+	}
+}
+
+func TestSeriesSetEvictsStaleSeries(t *testing.T) {
+	now := time.Now()
+	s := &seriesSet{series: staleSeries{now: func() time.Time { return now }}}
+	if !s.add("stale") || !s.add("live") {
+		t.Fatal("add() = false for new time series")
+	}
+	now = now.Add(staleSeriesAge / 2)
+	if s.add("live") {
+		t.Error("add() = true for a recorded time series")
+	}
+	now = now.Add(staleSeriesAge / 2)
+	if s.add("live") {
+		t.Error("add() = true for a time series exported within the stale age")
+	}
+	if len(s.seen) != 1 {
+		t.Errorf("recorded %d time series; want the stale one evicted", len(s.seen))
+	}
+	if !s.add("stale") {
+		t.Error("add() = false for an evicted time series")
+	}
+}
+
+func TestMetricToMpbTsCumulativeDistributionsAsDelta(t *testing.T) {
+	se := &statsExporter{o: Options{ProjectID: "foo", CumulativeDistributionsAsDelta: true}}
+	start := time.Now().Add(-time.Minute)
+	newMetric := func(start, end time.Time, sum float64, counts ...int64) *metricdata.Metric {
+		var count int64
+		var buckets []metricdata.Bucket
+		for _, c := range counts {
+			count += c
+			buckets = append(buckets, metricdata.Bucket{Count: c})
+		}
+		return &metricdata.Metric{
+			Descriptor: metricdata.Descriptor{Name: "delta_dist", Type: metricdata.TypeCumulativeDistribution},
+			TimeSeries: []*metricdata.TimeSeries{{
+				StartTime: start,
+				Points: []metricdata.Point{{
+					Time: end,
+					Value: &metricdata.Distribution{
+						Count:         count,
+						Sum:           sum,
+						BucketOptions: &metricdata.BucketOptions{Bounds: []float64{10}},
+						Buckets:       buckets,
+					},
+				}},
+			}},
+		}
+	}
+	export := func(m *metricdata.Metric) *monitoringpb.Point { //nolint: staticcheck
+		tsl, err := se.metricToMpbTs(context.Background(), m)
+		if err != nil {
+			t.Fatalf("Unexpected error: %v", err)
+		}
+		se.commitSeries(tsl)
+		return tsl[0].Points[0]
+	}
+
+	end1, end2, end3 := start.Add(10*time.Second), start.Add(20*time.Second), start.Add(30*time.Second)
+	first := export(newMetric(start, end1, 25, 1, 2))
+	if got := first.Value.GetDistributionValue().BucketCounts; !cmp.Equal(got, []int64{0, 1, 2}) {
+		t.Errorf("first bucket counts = %v; want the full distribution [0 1 2]", got)
+	}
+
+	second := export(newMetric(start, end2, 70, 2, 5))
+	dist := second.Value.GetDistributionValue()
+	if !cmp.Equal(dist.BucketCounts, []int64{0, 1, 3}) || dist.Count != 4 {
+		t.Errorf("delta bucket counts, count = %v, %d; want [0 1 3], 4", dist.BucketCounts, dist.Count)
+	}
+	if dist.Mean != 45.0/4 {
+		t.Errorf("delta mean = %v; want %v", dist.Mean, 45.0/4)
+	}
+	if !second.Interval.StartTime.AsTime().Equal(end1) || !second.Interval.EndTime.AsTime().Equal(end2) {
+		t.Errorf("delta interval = %v; want [%v, %v]", second.Interval, end1, end2)
+	}
+
+	// A restarted time series resends the full distribution.
+	third := export(newMetric(end2, end3, 5, 1, 0))
+	if got := third.Value.GetDistributionValue().BucketCounts; !cmp.Equal(got, []int64{0, 1, 0}) {
+		t.Errorf("bucket counts after reset = %v; want the full distribution [0 1 0]", got)
+	}
+
+	md, err := se.metricToMpbMetricDescriptor(newMetric(start, end1, 0))
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if md.MetricKind != googlemetricpb.MetricDescriptor_DELTA {
+		t.Errorf("descriptor metric kind = %v; want DELTA", md.MetricKind)
+	}
+}
+
+func TestUploadMetricsCumulativeDistributionsAsDeltaFailedWrite(t *testing.T) {
+	oldCreateMetricDescriptor := createMetricDescriptor
+	oldCreateTimeSeries := createTimeSeries
+	defer func() {
+		createMetricDescriptor = oldCreateMetricDescriptor
+		createTimeSeries = oldCreateTimeSeries
+	}()
+	createMetricDescriptor = func(ctx context.Context, c *monitoring.MetricClient, mdr *monitoringpb.CreateMetricDescriptorRequest) (*googlemetricpb.MetricDescriptor, error) { //nolint: staticcheck
+		return mdr.MetricDescriptor, nil
+	}
+	var fail bool
+	var counts []int64
+	createTimeSeries = func(ctx context.Context, c *monitoring.MetricClient, req *monitoringpb.CreateTimeSeriesRequest) error { //nolint: staticcheck
+		counts = append(counts, req.TimeSeries[0].Points[0].GetValue().GetDistributionValue().GetCount())
+		if fail {
+			return fmt.Errorf("rejected")
+		}
+		return nil
+	}
+
+	se := &statsExporter{
+		metricDescriptors: make(map[string]bool),
+		o:                 Options{ProjectID: "foo", CumulativeDistributionsAsDelta: true},
+	}
+	start := time.Now().Add(-time.Minute)
+	upload := func(end time.Time, count int64) {
+		m := &metricdata.Metric{
+			Descriptor: metricdata.Descriptor{Name: "delta_dist", Type: metricdata.TypeCumulativeDistribution},
+			TimeSeries: []*metricdata.TimeSeries{{
+				StartTime: start,
+				Points: []metricdata.Point{{
+					Time: end,
+					Value: &metricdata.Distribution{
+						Count:         count,
+						BucketOptions: &metricdata.BucketOptions{Bounds: []float64{10}},
+						Buckets:       []metricdata.Bucket{{Count: count}, {}},
+					},
+				}},
+			}},
+		}
+		se.uploadMetrics(context.Background(), []*metricdata.Metric{m}) //nolint: errcheck
+	}
+
+	upload(start.Add(10*time.Second), 1)
+	fail = true
+	upload(start.Add(20*time.Second), 3)
+	fail = false
+	upload(start.Add(30*time.Second), 6)
+	// The delta of the third point starts from the first one, since the second
+	// one was not written.
+	if diff := cmp.Diff(counts, []int64{1, 2, 5}); diff != "" {
+		t.Errorf("sent counts -got +want: %s", diff)
+	}
+}
+
+func TestDistributionDeltasEvictsStaleSeries(t *testing.T) {
+	now := time.Now()
+	d := &distributionDeltas{series: staleSeries{now: func() time.Time { return now }}}
+	newPoint := func(count int64) *monitoringpb.Point { //nolint: staticcheck
+		return &monitoringpb.Point{ //nolint: staticcheck
+			Interval: &monitoringpb.TimeInterval{StartTime: timestampProto(time.Unix(0, 0)), EndTime: timestampProto(now)}, //nolint: staticcheck
+			Value: &monitoringpb.TypedValue{Value: &monitoringpb.TypedValue_DistributionValue{ //nolint: staticcheck
+				DistributionValue: &distributionpb.Distribution{Count: count, BucketCounts: []int64{count}},
+			}},
+		}
+	}
+	d.toDelta("stale", newPoint(1))
+	d.commit("stale")
+	d.toDelta("live", newPoint(1))
+	d.commit("live")
+
+	now = now.Add(staleSeriesAge)
+	d.toDelta("live", newPoint(2))
+	if _, ok := d.last["stale"]; ok {
+		t.Errorf("the state of a time series not exported for %v was kept", staleSeriesAge)
+	}
+	if _, ok := d.last["live"]; !ok {
+		t.Error("the state of an exported time series was evicted")
+	}
+}
+
+func TestDeltaWindowsCommit(t *testing.T) {
+	now := time.Now()
+	d := &deltaWindows{series: staleSeries{now: func() time.Time { return now }}}
+	start := now.Add(-time.Minute)
+	end1, end2, end3 := start.Add(10*time.Second), start.Add(20*time.Second), start.Add(30*time.Second)
+	window := func(key string, start, end time.Time) *monitoringpb.TimeInterval { //nolint: staticcheck
+		pt := &monitoringpb.Point{Interval: &monitoringpb.TimeInterval{StartTime: timestampProto(start), EndTime: timestampProto(end)}} //nolint: staticcheck
+
+		d.window(key, []*monitoringpb.Point{pt}) //nolint: staticcheck
+		return pt.Interval
+	}
+
+	window("series", start, end1)
+	d.commit("series")
+	// The second point is not written, so the third one starts at the end of the first.
+	window("series", end1, end2)
+	if got := window("series", end2, end3); !got.StartTime.AsTime().Equal(end1) {
+		t.Errorf("start = %v; want the end of the last point written %v", got.StartTime.AsTime(), end1)
+	}
+
+	window("stale", start, end1)
+	d.commit("stale")
+	now = now.Add(staleSeriesAge)
+	window("series", start, end1)
+	if _, ok := d.lastEnd["stale"]; ok {
+		t.Errorf("the state of a time series not exported for %v was kept", staleSeriesAge)
+	}
+}
+
+func TestMetricToMpbTsMetricKindOverride(t *testing.T) {
+	se := &statsExporter{o: Options{
+		ProjectID: "foo",
+		MetricKindOverride: func(d *metricdata.Descriptor) (googlemetricpb.MetricDescriptor_MetricKind, bool) {
+			return googlemetricpb.MetricDescriptor_DELTA, d.Name == "delta_gauge"
+		},
+	}}
+	newMetric := func(name string) *metricdata.Metric {
+		return &metricdata.Metric{
+			Descriptor: metricdata.Descriptor{Name: name, Type: metricdata.TypeGaugeInt64},
+			TimeSeries: []*metricdata.TimeSeries{{Points: []metricdata.Point{}}},
+		}
+	}
+	start := time.Now().Add(-time.Minute)
+	end1, end2, end3 := start.Add(10*time.Second), start.Add(20*time.Second), start.Add(30*time.Second)
+	export := func(m *metricdata.Metric, ends ...time.Time) []*monitoringpb.Point { //nolint: staticcheck
+		for i, end := range ends {
+			m.TimeSeries[0].Points = append(m.TimeSeries[0].Points, metricdata.NewInt64Point(end, int64(i+1)))
+		}
+		tsl, err := se.metricToMpbTs(context.Background(), m)
+		if err != nil {
+			t.Fatalf("Unexpected error: %v", err)
+		}
+		se.commitSeries(tsl)
+		return tsl[0].Points
+	}
+	interval := func(start, end time.Time) *monitoringpb.TimeInterval { //nolint: staticcheck
+		return &monitoringpb.TimeInterval{StartTime: timestampProto(start), EndTime: timestampProto(end)} //nolint: staticcheck
+	}
+
+	// The first point has no previous point and no start time.
+	pts := export(newMetric("delta_gauge"), end1, end2)
+	pts = append(pts, export(newMetric("delta_gauge"), end3)...)
+	var got []*monitoringpb.TimeInterval //nolint: staticcheck
+	for _, pt := range pts {
+		got = append(got, pt.Interval)
+	}
+	want := []*monitoringpb.TimeInterval{ //nolint: staticcheck
+		interval(end1.Add(-time.Millisecond), end1),
+		interval(end1, end2),
+		interval(end2, end3),
+	}
+	if diff := cmp.Diff(got, want, protocmp.Transform()); diff != "" {
+		t.Errorf("delta intervals -got +want: %s", diff)
+	}
+
+	if pts := export(newMetric("gauge"), end1); pts[0].Interval.StartTime != nil {
+		t.Errorf("gauge interval = %v; want no start time", pts[0].Interval)
+	}
+
+	for name, want := range map[string]googlemetricpb.MetricDescriptor_MetricKind{
+		"delta_gauge": googlemetricpb.MetricDescriptor_DELTA,
+		"gauge":       googlemetricpb.MetricDescriptor_GAUGE,
+	} {
+		md, err := se.metricToMpbMetricDescriptor(newMetric(name))
+		if err != nil {
+			t.Fatalf("Unexpected error: %v", err)
+		}
+		if md.MetricKind != want || md.ValueType != googlemetricpb.MetricDescriptor_INT64 {
+			t.Errorf("%s descriptor kind, value type = %v, %v; want %v, INT64", name, md.MetricKind, md.ValueType, want)
+		}
+	}
+}
+
+func TestCreateMetricDescriptorFromMetricFiltered(t *testing.T) {
+	oldCreateMetricDescriptor := createMetricDescriptor
+	defer func() {
+		createMetricDescriptor = oldCreateMetricDescriptor
+	}()
+
+	var created []string
+	createMetricDescriptor = func(ctx context.Context, c *monitoring.MetricClient, mdr *monitoringpb.CreateMetricDescriptorRequest) (*googlemetricpb.MetricDescriptor, error) { //nolint: staticcheck
+		created = append(created, mdr.MetricDescriptor.Type)
+		return mdr.MetricDescriptor, nil
+	}
+
+	se := &statsExporter{
+		metricDescriptors: make(map[string]bool),
+		o: Options{
+			ProjectID:              "foo",
+			CreateDescriptorFilter: func(metricType string) bool { return false },
+		},
+	}
+	metric := &metricdata.Metric{
+		Descriptor: metricdata.Descriptor{Name: "filtered_metric", Type: metricdata.TypeGaugeInt64},
+		TimeSeries: []*metricdata.TimeSeries{{
+			Points: []metricdata.Point{metricdata.NewInt64Point(time.Now(), 1)},
+		}},
+	}
+	if err := se.createMetricDescriptorFromMetric(context.Background(), metric); err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if len(created) != 0 {
+		t.Errorf("created descriptors %v; want none", created)
+	}
+	tsl, err := se.metricToMpbTs(context.Background(), metric)
+	if err != nil || len(tsl) != 1 {
+		t.Errorf("metricToMpbTs() = %v, %v; want one time series", tsl, err)
+	}
+}
+
 func TestMetricsToMonitoringMetrics_fromProtoPoint(t *testing.T) {
 	startTimestamp := &timestamp.Timestamp{
 		Seconds: 1543160298,
@@ -542,7 +1281,7 @@ func TestMetricsToMonitoringMetrics_fromProtoPoint(t *testing.T) {
 	}
 
 	for i, tt := range tests {
-		mpt, err := metricPointToMpbPoint(startTimestamp, tt.in, "foo")
+		mpt, err := se.metricPointToMpbPoint("", startTimestamp, tt.in)
 		if tt.wantErr != "" {
 			continue
 		}
@@ -1021,3 +1760,988 @@ func removeLabel(m map[string]string, remove map[string]string) map[string]strin
 	}
 	return newM
 }
+
+func TestForceExportUploadsCurrentMetrics(t *testing.T) {
+	server, addr, doneFn := createFakeServer(t)
+	defer doneFn()
+
+	conn, err := grpc.Dial(addr, grpc.WithTransportCredentials(insecure.NewCredentials()))
+	if err != nil {
+		t.Fatalf("Failed to make a gRPC connection to the server: %v", err)
+	}
+	defer conn.Close()
+
+	e, err := newStatsExporter(Options{
+		ProjectID:               "force-export",
+		MonitoringClientOptions: []option.ClientOption{option.WithGRPCConn(conn)},
+		DefaultMonitoringLabels: &Labels{},
+	})
+	if err != nil {
+		t.Fatalf("Failed to create the statsExporter: %v", err)
+	}
+
+	r := metric.NewRegistry()
+	gauge, err := r.AddInt64Gauge("force_export_gauge")
+	if err != nil {
+		t.Fatalf("Failed to add gauge: %v", err)
+	}
+	entry, err := gauge.GetEntry()
+	if err != nil {
+		t.Fatalf("Failed to get gauge entry: %v", err)
+	}
+	entry.Set(42)
+	metricproducer.GlobalManager().AddProducer(r)
+	defer metricproducer.GlobalManager().DeleteProducer(r)
+
+	if err := e.forceExport(context.Background()); err != nil {
+		t.Fatalf("Want no error, got %v", err)
+	}
+
+	var got []*monitoringpb.TimeSeries                                                    //nolint: staticcheck
+	server.forEachStackdriverTimeSeries(func(sdt *monitoringpb.CreateTimeSeriesRequest) { //nolint: staticcheck
+		for _, ts := range sdt.TimeSeries {
+			if ts.Metric.Type == "custom.googleapis.com/opencensus/force_export_gauge" {
+				got = append(got, ts)
+			}
+		}
+	})
+	if len(got) != 1 {
+		t.Fatalf("Want 1 time series uploaded, got %d", len(got))
+	}
+	if v := got[0].Points[0].GetValue().GetInt64Value(); v != 42 {
+		t.Errorf("Want uploaded value 42, got %d", v)
+	}
+}
+
+func TestMetricToMpbTsDropsNonIncreasingBucketBounds(t *testing.T) {
+	var gotErrs []error
+	se := &statsExporter{
+		o: Options{
+			ProjectID: "foo",
+			OnError:   func(err error) { gotErrs = append(gotErrs, err) },
+		},
+	}
+	now := time.Now()
+	makeDistTs := func(labelValue string, bounds ...float64) *metricdata.TimeSeries {
+		return &metricdata.TimeSeries{
+			StartTime:   now.Add(-time.Minute),
+			LabelValues: []metricdata.LabelValue{metricdata.NewLabelValue(labelValue)},
+			Points: []metricdata.Point{
+				metricdata.NewDistributionPoint(now, &metricdata.Distribution{
+					Count:         1,
+					Sum:           1,
+					BucketOptions: &metricdata.BucketOptions{Bounds: bounds},
+					Buckets:       make([]metricdata.Bucket, len(bounds)+1),
+				}),
+			},
+		}
+	}
+	metric := &metricdata.Metric{
+		Descriptor: metricdata.Descriptor{
+			Name:      "dist_bounds",
+			Type:      metricdata.TypeCumulativeDistribution,
+			LabelKeys: []metricdata.LabelKey{{Key: "k"}},
+		},
+		TimeSeries: []*metricdata.TimeSeries{
+			makeDistTs("valid", 1, 2, 3),
+			makeDistTs("unsorted", 1, 3, 2),
+			makeDistTs("duplicate", 1, 2, 2),
+		},
+	}
+
+	tsl, err := se.metricToMpbTs(context.Background(), metric)
+	if err != nil {
+		t.Fatalf("Want no error, got %v", err)
+	}
+	if len(tsl) != 1 || tsl[0].Metric.Labels["k"] != "valid" {
+		t.Fatalf("Want only the valid time series, got %v", tsl)
+	}
+	if len(gotErrs) != 2 {
+		t.Fatalf("Want 2 errors reported, got %v", gotErrs)
+	}
+	for _, err := range gotErrs {
+		if !strings.Contains(err.Error(), "strictly increasing") {
+			t.Errorf("Want a bucket bounds error, got %v", err)
+		}
+	}
+}
+
+func TestMetricToMpbTsDistributionCountPolicy(t *testing.T) {
+	now := time.Now()
+	metric := &metricdata.Metric{
+		Descriptor: metricdata.Descriptor{Name: "dist_count", Type: metricdata.TypeCumulativeDistribution},
+		TimeSeries: []*metricdata.TimeSeries{{
+			StartTime: now.Add(-time.Minute),
+			Points: []metricdata.Point{
+				// The count is ahead of the bucket counts.
+				metricdata.NewDistributionPoint(now, &metricdata.Distribution{
+					Count:         5,
+					Sum:           10,
+					BucketOptions: &metricdata.BucketOptions{Bounds: []float64{1, 2}},
+					Buckets:       []metricdata.Bucket{{Count: 1}, {Count: 1}, {Count: 1}},
+				}),
+			},
+		}},
+	}
+
+	tests := []struct {
+		name      string
+		policy    DistributionCountPolicy
+		wantCount int64
+		wantMean  float64
+		wantErr   bool
+	}{
+		{name: "keep", policy: KeepDistributionCount, wantCount: 5, wantMean: 2},
+		{name: "repair", policy: RepairDistributionCount, wantCount: 3, wantMean: 10.0 / 3},
+		{name: "reject", policy: RejectDistributionCount, wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var gotErrs []error
+			se := &statsExporter{o: Options{
+				ProjectID:               "foo",
+				DistributionCountPolicy: tt.policy,
+				OnError:                 func(err error) { gotErrs = append(gotErrs, err) },
+			}}
+			tsl, err := se.metricToMpbTs(context.Background(), metric)
+			if err != nil {
+				t.Fatalf("Want no error, got %v", err)
+			}
+			if tt.wantErr {
+				if len(tsl) != 0 || len(gotErrs) != 1 {
+					t.Errorf("Want the time series dropped and 1 error, got %v and %v", tsl, gotErrs)
+				}
+				return
+			}
+			if len(tsl) != 1 || len(gotErrs) != 0 {
+				t.Fatalf("Want 1 time series and no error, got %v and %v", tsl, gotErrs)
+			}
+			dist := tsl[0].Points[0].Value.GetDistributionValue()
+			if dist.Count != tt.wantCount || math.Abs(dist.Mean-tt.wantMean) > 1e-9 {
+				t.Errorf("Got count %d and mean %v, want %d and %v", dist.Count, dist.Mean, tt.wantCount, tt.wantMean)
+			}
+		})
+	}
+}
+
+func TestMetricToMpbTsMaxDistributionBuckets(t *testing.T) {
+	now := time.Now()
+	var buckets []metricdata.Bucket
+	for i := int64(1); i <= 8; i++ {
+		buckets = append(buckets, metricdata.Bucket{Count: i})
+	}
+	metric := &metricdata.Metric{
+		Descriptor: metricdata.Descriptor{Name: "many_buckets", Type: metricdata.TypeCumulativeDistribution},
+		TimeSeries: []*metricdata.TimeSeries{{
+			StartTime: now.Add(-time.Minute),
+			Points: []metricdata.Point{
+				metricdata.NewDistributionPoint(now, &metricdata.Distribution{
+					Count:         36,
+					Sum:           100,
+					BucketOptions: &metricdata.BucketOptions{Bounds: []float64{0, 1, 2, 3, 4, 5, 6}},
+					Buckets:       buckets,
+				}),
+			},
+		}},
+	}
+
+	tests := []struct {
+		name        string
+		max         int
+		policy      ExcessBucketsPolicy
+		wantBounds  []float64
+		wantBuckets []int64
+		wantErr     bool
+	}{
+		{
+			name:        "unlimited",
+			wantBounds:  []float64{0, 1, 2, 3, 4, 5, 6},
+			wantBuckets: []int64{1, 2, 3, 4, 5, 6, 7, 8},
+		},
+		{
+			name:        "within max",
+			max:         8,
+			wantBounds:  []float64{0, 1, 2, 3, 4, 5, 6},
+			wantBuckets: []int64{1, 2, 3, 4, 5, 6, 7, 8},
+		},
+		{
+			name:        "merge",
+			max:         3,
+			policy:      MergeExcessBuckets,
+			wantBounds:  []float64{2, 5},
+			wantBuckets: []int64{6, 15, 15},
+		},
+		{
+			name:    "reject",
+			max:     3,
+			policy:  RejectExcessBuckets,
+			wantErr: true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var gotErrs []error
+			se := &statsExporter{o: Options{
+				ProjectID:              "foo",
+				MaxDistributionBuckets: tt.max,
+				ExcessBucketsPolicy:    tt.policy,
+				OnError:                func(err error) { gotErrs = append(gotErrs, err) },
+			}}
+			tsl, err := se.metricToMpbTs(context.Background(), metric)
+			if err != nil {
+				t.Fatalf("Want no error, got %v", err)
+			}
+			if tt.wantErr {
+				if len(tsl) != 0 || len(gotErrs) != 1 || !strings.Contains(gotErrs[0].Error(), "more than the maximum of 3") {
+					t.Errorf("Want the time series dropped and 1 error, got %v and %v", tsl, gotErrs)
+				}
+				return
+			}
+			if len(tsl) != 1 || len(gotErrs) != 0 {
+				t.Fatalf("Want 1 time series and no error, got %v and %v", tsl, gotErrs)
+			}
+			dist := tsl[0].Points[0].Value.GetDistributionValue()
+			if dist.Count != 36 {
+				t.Errorf("Got count %d, want 36", dist.Count)
+			}
+			if diff := cmp.Diff(dist.BucketOptions.GetExplicitBuckets().GetBounds(), tt.wantBounds); diff != "" {
+				t.Errorf("Bounds -got +want: %s", diff)
+			}
+			if diff := cmp.Diff(dist.BucketCounts, tt.wantBuckets); diff != "" {
+				t.Errorf("Bucket counts -got +want: %s", diff)
+			}
+		})
+	}
+}
+
+func TestMetricToMpbTsDropEmptyDistributions(t *testing.T) {
+	se := &statsExporter{o: Options{ProjectID: "foo", DropEmptyDistributions: true}}
+	start := time.Now().Add(-time.Minute)
+	distMetric := func(counts ...int64) *metricdata.Metric {
+		metric := &metricdata.Metric{
+			Descriptor: metricdata.Descriptor{
+				Name:      "maybe_empty",
+				Type:      metricdata.TypeCumulativeDistribution,
+				LabelKeys: []metricdata.LabelKey{{Key: "k"}},
+			},
+		}
+		for i, count := range counts {
+			metric.TimeSeries = append(metric.TimeSeries, &metricdata.TimeSeries{
+				LabelValues: []metricdata.LabelValue{metricdata.NewLabelValue(fmt.Sprint(i))},
+				StartTime:   start,
+				Points: []metricdata.Point{metricdata.NewDistributionPoint(time.Now(), &metricdata.Distribution{
+					Count:         count,
+					Sum:           float64(count),
+					BucketOptions: &metricdata.BucketOptions{Bounds: []float64{2}},
+					Buckets:       []metricdata.Bucket{{Count: count}, {}},
+				})},
+			})
+		}
+		return metric
+	}
+
+	tsl, err := se.metricToMpbTs(context.Background(), distMetric(0, 3))
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if len(tsl) != 1 || tsl[0].Metric.Labels["k"] != "1" {
+		t.Errorf("Want only the non-empty time series, got %v", tsl)
+	}
+
+	// The first time series gets values later on.
+	tsl, err = se.metricToMpbTs(context.Background(), distMetric(2, 3))
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if len(tsl) != 2 {
+		t.Errorf("Want both time series once they have values, got %v", tsl)
+	}
+}
+
+func TestMetricToMpbTsDistributionRange(t *testing.T) {
+	now := time.Now()
+	metric := &metricdata.Metric{
+		Descriptor: metricdata.Descriptor{Name: "ranged", Type: metricdata.TypeCumulativeDistribution},
+		TimeSeries: []*metricdata.TimeSeries{{
+			StartTime: now.Add(-time.Minute),
+			Points: []metricdata.Point{metricdata.NewDistributionPoint(now, &metricdata.Distribution{
+				Count:         2,
+				Sum:           30,
+				BucketOptions: &metricdata.BucketOptions{Bounds: []float64{10, 20}},
+				Buckets:       []metricdata.Bucket{{Count: 1}, {}, {Count: 1}},
+			})},
+		}},
+	}
+	// metricdata distributions have no minimum and maximum to report.
+	for _, report := range []bool{false, true} {
+		se := &statsExporter{o: Options{ProjectID: "foo", ReportDistributionRange: report}}
+		tsl, err := se.metricToMpbTs(context.Background(), metric)
+		if err != nil {
+			t.Fatalf("Unexpected error: %v", err)
+		}
+		if r := tsl[0].Points[0].Value.GetDistributionValue().GetRange(); r != nil {
+			t.Errorf("ReportDistributionRange=%v: got range %v, want none", report, r)
+		}
+	}
+}
+
+func TestMetricToMpbTsDropsBucketsWithoutBucketOptions(t *testing.T) {
+	var gotErrs []error
+	se := &statsExporter{
+		o: Options{
+			ProjectID: "foo",
+			OnError:   func(err error) { gotErrs = append(gotErrs, err) },
+		},
+	}
+	now := time.Now()
+	makeDistTs := func(labelValue string, buckets []metricdata.Bucket) *metricdata.TimeSeries {
+		return &metricdata.TimeSeries{
+			StartTime:   now.Add(-time.Minute),
+			LabelValues: []metricdata.LabelValue{metricdata.NewLabelValue(labelValue)},
+			Points: []metricdata.Point{
+				metricdata.NewDistributionPoint(now, &metricdata.Distribution{
+					Count:   3,
+					Sum:     6,
+					Buckets: buckets,
+				}),
+			},
+		}
+	}
+	metric := &metricdata.Metric{
+		Descriptor: metricdata.Descriptor{
+			Name:      "dist_no_bucket_options",
+			Type:      metricdata.TypeCumulativeDistribution,
+			LabelKeys: []metricdata.LabelKey{{Key: "k"}},
+		},
+		TimeSeries: []*metricdata.TimeSeries{
+			makeDistTs("no_buckets", nil),
+			makeDistTs("buckets", []metricdata.Bucket{{Count: 1}, {Count: 2}}),
+		},
+	}
+
+	tsl, err := se.metricToMpbTs(context.Background(), metric)
+	if err != nil {
+		t.Fatalf("Want no error, got %v", err)
+	}
+	if len(tsl) != 1 || tsl[0].Metric.Labels["k"] != "no_buckets" {
+		t.Fatalf("Want only the time series without buckets, got %v", tsl)
+	}
+	if got := tsl[0].Points[0].GetValue().GetDistributionValue(); got.BucketOptions != nil || len(got.BucketCounts) != 0 {
+		t.Errorf("Want a distribution without buckets, got %v", got)
+	}
+	if len(gotErrs) != 1 || !strings.Contains(gotErrs[0].Error(), "2 buckets but no bucket options") {
+		t.Errorf("Want a missing bucket options error, got %v", gotErrs)
+	}
+}
+
+func TestSpanContextAttachmentIgnoresSampling(t *testing.T) {
+	traceID := trace.TraceID{0x4b, 0xf9, 0x2f, 0x35, 0x77, 0xb3, 0x4d, 0xa6, 0xa3, 0xce, 0x92, 0x9d, 0x0e, 0x0e, 0x47, 0x36}
+	spanID := trace.SpanID{0x00, 0xf0, 0x67, 0xaa, 0x0b, 0xa9, 0x02, 0xb7}
+	wantSpanName := fmt.Sprintf("projects/foo/traces/%s/spans/%s", traceID.String(), spanID.String())
+
+	for _, traceOptions := range []trace.TraceOptions{1, 0} {
+		spanCtx := trace.SpanContext{TraceID: traceID, SpanID: spanID, TraceOptions: traceOptions}
+		attachments := attachmentsToPbAttachments(metricdata.Attachments{metricdata.AttachmentKeySpanContext: spanCtx}, "foo")
+		if len(attachments) != 1 || attachments[0].TypeUrl != exemplarAttachmentTypeSpanCtx {
+			t.Fatalf("IsSampled=%v: want one SpanContext attachment, got %v", spanCtx.IsSampled(), attachments)
+		}
+		var got monitoringpb.SpanContext //nolint: staticcheck
+		if err := proto.Unmarshal(attachments[0].Value, &got); err != nil {
+			t.Fatalf("IsSampled=%v: failed to unmarshal attachment: %v", spanCtx.IsSampled(), err)
+		}
+		if got.SpanName != wantSpanName {
+			t.Errorf("IsSampled=%v: SpanName = %q, want %q", spanCtx.IsSampled(), got.SpanName, wantSpanName)
+		}
+	}
+}
+
+func TestMetricBucketsExemplarOnlyIfSampled(t *testing.T) {
+	sampled := trace.SpanContext{TraceID: trace.TraceID{1}, SpanID: trace.SpanID{1}, TraceOptions: 1}
+	unsampled := trace.SpanContext{TraceID: trace.TraceID{2}, SpanID: trace.SpanID{2}}
+	buckets := []metricdata.Bucket{
+		{Count: 1, Exemplar: &metricdata.Exemplar{Value: 1, Attachments: metricdata.Attachments{metricdata.AttachmentKeySpanContext: sampled}}},
+		{Count: 1, Exemplar: &metricdata.Exemplar{Value: 2, Attachments: metricdata.Attachments{metricdata.AttachmentKeySpanContext: unsampled}}},
+		{Count: 1, Exemplar: &metricdata.Exemplar{Value: 3}},
+	}
+
+	tests := []struct {
+		name          string
+		onlyIfSampled bool
+		want          []float64
+	}{
+		{name: "all exemplars", want: []float64{1, 2, 3}},
+		{name: "only if sampled", onlyIfSampled: true, want: []float64{1, 3}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			se := &statsExporter{o: Options{ProjectID: "foo", ExemplarOnlyIfSampled: tt.onlyIfSampled}}
+			bucketCounts, exemplars := se.metricBucketToBucketCountsAndExemplars(buckets)
+			if diff := cmp.Diff(bucketCounts, []int64{1, 1, 1}); diff != "" {
+				t.Errorf("bucket counts -got +want: %s", diff)
+			}
+			var got []float64
+			for _, e := range exemplars {
+				got = append(got, e.Value)
+			}
+			if diff := cmp.Diff(got, tt.want); diff != "" {
+				t.Errorf("exemplar values -got +want: %s", diff)
+			}
+		})
+	}
+}
+
+func TestUploadMetricsFlushRetryBudget(t *testing.T) {
+	oldCreateMetricDescriptor := createMetricDescriptor
+	oldCreateTimeSeries := createTimeSeries
+	defer func() {
+		createMetricDescriptor = oldCreateMetricDescriptor
+		createTimeSeries = oldCreateTimeSeries
+	}()
+	createMetricDescriptor = func(ctx context.Context, c *monitoring.MetricClient, mdr *monitoringpb.CreateMetricDescriptorRequest) (*googlemetricpb.MetricDescriptor, error) { //nolint: staticcheck
+		return mdr.MetricDescriptor, nil
+	}
+	var calls int
+	createTimeSeries = func(ctx context.Context, c *monitoring.MetricClient, req *monitoringpb.CreateTimeSeriesRequest) error { //nolint: staticcheck
+		calls++
+		return fmt.Errorf("One or more TimeSeries could not be written: Internal error encountered. Please retry after a few seconds.: timeSeries[0]")
+	}
+
+	// 450 time series are sent in 3 requests.
+	metric := &metricdata.Metric{
+		Descriptor: metricdata.Descriptor{
+			Name:      "retried",
+			Type:      metricdata.TypeCumulativeInt64,
+			LabelKeys: []metricdata.LabelKey{{Key: "k"}},
+		},
+	}
+	now := time.Now()
+	for i := 0; i < 450; i++ {
+		metric.TimeSeries = append(metric.TimeSeries, &metricdata.TimeSeries{
+			LabelValues: []metricdata.LabelValue{metricdata.NewLabelValue(fmt.Sprint(i))},
+			StartTime:   now.Add(-time.Minute),
+			Points:      []metricdata.Point{metricdata.NewInt64Point(now, 1)},
+		})
+	}
+
+	tests := []struct {
+		name         string
+		retryDropped bool
+		budget       int
+		wantCalls    int
+	}{
+		{name: "RetryDroppedTimeSeries only", wantCalls: 3},
+		{name: "no budget", retryDropped: true, wantCalls: 6},
+		{name: "budget", retryDropped: true, budget: 2, wantCalls: 5},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			calls = 0
+			se := &statsExporter{
+				metricDescriptors: make(map[string]bool),
+				o: Options{
+					ProjectID:                      "foo",
+					RetryDroppedTimeSeries:         true,
+					RetryDroppedTimeSeriesOnExport: tt.retryDropped,
+					FlushRetryBudget:               tt.budget,
+				},
+			}
+			if err := se.uploadMetrics(context.Background(), []*metricdata.Metric{metric}); err == nil {
+				t.Fatal("uploadMetrics() error = nil; want the dropped time series reported")
+			}
+			if calls != tt.wantCalls {
+				t.Errorf("CreateTimeSeries called %d times; want %d", calls, tt.wantCalls)
+			}
+		})
+	}
+}
+
+func TestUploadMetricsSyncFlushChunkSize(t *testing.T) {
+	oldCreateMetricDescriptor := createMetricDescriptor
+	oldCreateTimeSeries := createTimeSeries
+	defer func() {
+		createMetricDescriptor = oldCreateMetricDescriptor
+		createTimeSeries = oldCreateTimeSeries
+	}()
+	createMetricDescriptor = func(ctx context.Context, c *monitoring.MetricClient, mdr *monitoringpb.CreateMetricDescriptorRequest) (*googlemetricpb.MetricDescriptor, error) { //nolint: staticcheck
+		return mdr.MetricDescriptor, nil
+	}
+	var batches []int
+	createTimeSeries = func(ctx context.Context, c *monitoring.MetricClient, req *monitoringpb.CreateTimeSeriesRequest) error { //nolint: staticcheck
+		batches = append(batches, len(req.TimeSeries))
+		return nil
+	}
+
+	now := time.Now()
+	var metrics []*metricdata.Metric
+	for i := 0; i < 10; i++ {
+		metrics = append(metrics, &metricdata.Metric{
+			Descriptor: metricdata.Descriptor{
+				Name: fmt.Sprintf("chunked_%d", i),
+				Type: metricdata.TypeCumulativeInt64,
+			},
+			TimeSeries: []*metricdata.TimeSeries{{
+				StartTime: now.Add(-time.Minute),
+				Points:    []metricdata.Point{metricdata.NewInt64Point(now, 1)},
+			}},
+		})
+	}
+
+	tests := []struct {
+		name      string
+		chunkSize int
+		want      []int
+	}{
+		{name: "unset", want: []int{10}},
+		{name: "larger than metrics", chunkSize: 20, want: []int{10}},
+		{name: "chunked", chunkSize: 4, want: []int{4, 4, 2}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			batches = nil
+			se := &statsExporter{
+				metricDescriptors: make(map[string]bool),
+				o:                 Options{ProjectID: "foo", SyncFlushChunkSize: tt.chunkSize},
+			}
+			if err := se.uploadMetrics(context.Background(), metrics); err != nil {
+				t.Fatalf("uploadMetrics() error = %v", err)
+			}
+			if diff := cmp.Diff(batches, tt.want); diff != "" {
+				t.Errorf("time series per CreateTimeSeries call -got +want: %s", diff)
+			}
+		})
+	}
+}
+
+func TestPushMetricsWithDifferentLabels(t *testing.T) {
+	server, addr, doneFn := createFakeServer(t)
+	defer doneFn()
+
+	// Now create a gRPC connection to the agent.
+	conn, err := grpc.Dial(addr, grpc.WithTransportCredentials(insecure.NewCredentials()))
+	if err != nil {
+		t.Fatalf("Failed to make a gRPC connection to the agent: %v", err)
+	}
+	defer conn.Close()
+
+	// Finally create the OpenCensus stats exporter
+	exporterOptions := Options{
+		ProjectID:               "equivalence",
+		MonitoringClientOptions: []option.ClientOption{option.WithGRPCConn(conn)},
+
+		// Set empty labels to avoid the opencensus-task
+		DefaultMonitoringLabels: &Labels{},
+	}
+	se, err := newStatsExporter(exporterOptions)
+	if err != nil {
+		t.Fatalf("Failed to create the statsExporter: %v", err)
+	}
+
+	startTime := time.Unix(1543160298, 100000090)
+	endTime := time.Unix(1543160298, 101000090)
+
+	// Generate the metrics.
+	metrics := []*metricdata.Metric{
+		{
+			Descriptor: metricdata.Descriptor{
+				Name:        "ocagent.io/calls",
+				Description: "The number of the various calls",
+				LabelKeys:   []metricdata.LabelKey{{Key: "empty_key"}, {Key: "operation_type"}},
+				Unit:        metricdata.UnitDimensionless,
+				Type:        metricdata.TypeCumulativeInt64,
+			},
+			TimeSeries: []*metricdata.TimeSeries{
+				{
+					StartTime:   startTime,
+					LabelValues: []metricdata.LabelValue{metricdata.NewLabelValue(""), metricdata.NewLabelValue("test_1")},
+					Points:      []metricdata.Point{metricdata.NewInt64Point(endTime, 1)},
+				},
+				{
+					StartTime:   startTime,
+					LabelValues: []metricdata.LabelValue{metricdata.NewLabelValue(""), metricdata.NewLabelValue("test_2")},
+					Points:      []metricdata.Point{metricdata.NewInt64Point(endTime, 1)},
+				},
+			},
+		},
+	}
+
+	newTimeSeries := func(operationType string) *monitoringpb.TimeSeries { //nolint: staticcheck
+		return &monitoringpb.TimeSeries{ //nolint: staticcheck
+			Metric: &googlemetricpb.Metric{
+				Type: "custom.googleapis.com/opencensus/ocagent.io/calls",
+				Labels: map[string]string{
+					"empty_key":      "",
+					"operation_type": operationType,
+				},
+			},
+			Resource: &monitoredrespb.MonitoredResource{
+				Type: "global",
+			},
+			Points: []*monitoringpb.Point{ //nolint: staticcheck
+				{
+					Interval: &monitoringpb.TimeInterval{ //nolint: staticcheck
+						StartTime: timestampProto(startTime),
+						EndTime:   timestampProto(endTime),
+					},
+					Value: &monitoringpb.TypedValue{ //nolint: staticcheck
+						Value: &monitoringpb.TypedValue_Int64Value{
+							Int64Value: 1,
+						},
+					},
+				},
+			},
+		}
+	}
+	wantTimeSeries := []*monitoringpb.CreateTimeSeriesRequest{ //nolint: staticcheck
+		{
+			Name:       "projects/equivalence",
+			TimeSeries: []*monitoringpb.TimeSeries{newTimeSeries("test_1"), newTimeSeries("test_2")}, //nolint: staticcheck
+		},
+	}
+
+	// Push the metrics to the Stackdriver backend.
+	dropped, err := se.PushMetrics(context.Background(), metrics)
+	if dropped != 0 || err != nil {
+		t.Fatalf("Error pushing metrics, dropped:%d err:%v", dropped, err)
+	}
+
+	var gotTimeSeries []*monitoringpb.CreateTimeSeriesRequest                             //nolint: staticcheck
+	server.forEachStackdriverTimeSeries(func(sdt *monitoringpb.CreateTimeSeriesRequest) { //nolint: staticcheck
+		gotTimeSeries = append(gotTimeSeries, sdt)
+	})
+
+	requireTimeSeriesRequestEqual(t, gotTimeSeries, wantTimeSeries)
+}
+
+func TestResourceByDescriptorDefaultMonitoredResource(t *testing.T) {
+	fallback := &monitoredrespb.MonitoredResource{
+		Type:   "generic_node",
+		Labels: map[string]string{"project_id": "foo", "location": "us-central1", "namespace": "ns", "node_id": "node"},
+	}
+	se := &statsExporter{o: Options{
+		ProjectID:                "foo",
+		ResourceByDescriptor:     getResourceByDescriptor,
+		DefaultMonitoredResource: fallback,
+	}}
+
+	now := time.Now()
+	metric := &metricdata.Metric{
+		Descriptor: metricdata.Descriptor{
+			Name: "no_resource",
+			Type: metricdata.TypeCumulativeInt64,
+		},
+		TimeSeries: []*metricdata.TimeSeries{{
+			StartTime: now.Add(-time.Minute),
+			Points:    []metricdata.Point{metricdata.NewInt64Point(now, 1)},
+		}},
+	}
+	tsl, err := se.metricToMpbTs(context.Background(), metric)
+	if err != nil {
+		t.Fatalf("metricToMpbTs() error = %v", err)
+	}
+	if len(tsl) != 1 {
+		t.Fatalf("metricToMpbTs() = %v; want 1 time series", tsl)
+	}
+	if diff := cmpResource(tsl[0].Resource, fallback); diff != "" {
+		t.Errorf("Unexpected Resource -got +want: %s", diff)
+	}
+}
+
+func TestPushMetricsMetricFilter(t *testing.T) {
+	server, addr, doneFn := createFakeServer(t)
+	defer doneFn()
+
+	conn, err := grpc.Dial(addr, grpc.WithTransportCredentials(insecure.NewCredentials()))
+	if err != nil {
+		t.Fatalf("Failed to make a gRPC connection to the agent: %v", err)
+	}
+	defer conn.Close()
+
+	se, err := newStatsExporter(Options{
+		ProjectID:               "filter",
+		MonitoringClientOptions: []option.ClientOption{option.WithGRPCConn(conn)},
+		MetricFilter: func(m *metricdata.Metric) bool {
+			return !strings.HasPrefix(m.Descriptor.Name, "internal/")
+		},
+	})
+	if err != nil {
+		t.Fatalf("Failed to create the statsExporter: %v", err)
+	}
+
+	now := time.Now()
+	var metrics []*metricdata.Metric
+	for _, name := range []string{"app/requests", "internal/queue_length", "app/errors"} {
+		metrics = append(metrics, &metricdata.Metric{
+			Descriptor: metricdata.Descriptor{
+				Name: name,
+				Type: metricdata.TypeCumulativeInt64,
+			},
+			TimeSeries: []*metricdata.TimeSeries{{
+				StartTime: now.Add(-time.Minute),
+				Points:    []metricdata.Point{metricdata.NewInt64Point(now, 1)},
+			}},
+		})
+	}
+
+	dropped, err := se.PushMetrics(context.Background(), metrics)
+	if dropped != 0 || err != nil {
+		t.Fatalf("PushMetrics() = %d, %v; want no dropped time series", dropped, err)
+	}
+
+	var descriptors []string
+	server.forEachStackdriverMetricDescriptor(func(sdmd *monitoringpb.CreateMetricDescriptorRequest) { //nolint: staticcheck
+		descriptors = append(descriptors, sdmd.MetricDescriptor.Type)
+	})
+	var timeSeries []string
+	server.forEachStackdriverTimeSeries(func(sdt *monitoringpb.CreateTimeSeriesRequest) { //nolint: staticcheck
+		for _, ts := range sdt.TimeSeries {
+			timeSeries = append(timeSeries, ts.Metric.Type)
+		}
+	})
+	sort.Strings(descriptors)
+	sort.Strings(timeSeries)
+	want := []string{"custom.googleapis.com/opencensus/app/errors", "custom.googleapis.com/opencensus/app/requests"}
+	if diff := cmp.Diff(descriptors, want); diff != "" {
+		t.Errorf("created descriptors -got +want: %s", diff)
+	}
+	if diff := cmp.Diff(timeSeries, want); diff != "" {
+		t.Errorf("exported time series -got +want: %s", diff)
+	}
+}
+
+func TestPushMetricsEmitResetPoint(t *testing.T) {
+	server, addr, doneFn := createFakeServer(t)
+	defer doneFn()
+
+	conn, err := grpc.Dial(addr, grpc.WithTransportCredentials(insecure.NewCredentials()))
+	if err != nil {
+		t.Fatalf("Failed to make a gRPC connection to the agent: %v", err)
+	}
+	defer conn.Close()
+
+	se, err := newStatsExporter(Options{
+		ProjectID:               "reset",
+		MonitoringClientOptions: []option.ClientOption{option.WithGRPCConn(conn)},
+		DefaultMonitoringLabels: &Labels{},
+		EmitResetPoint:          true,
+		NumberOfWorkers:         4,
+		SyncFlushChunkSize:      1,
+	})
+	if err != nil {
+		t.Fatalf("Failed to create the statsExporter: %v", err)
+	}
+
+	now := time.Now()
+	var metrics []*metricdata.Metric
+	for _, name := range []string{"app/requests", "app/errors"} {
+		metrics = append(metrics, &metricdata.Metric{
+			Descriptor: metricdata.Descriptor{
+				Name: name,
+				Type: metricdata.TypeCumulativeInt64,
+			},
+			TimeSeries: []*metricdata.TimeSeries{{
+				StartTime: now.Add(-time.Minute),
+				Points:    []metricdata.Point{metricdata.NewInt64Point(now, 1)},
+			}},
+		})
+	}
+
+	dropped, err := se.PushMetrics(context.Background(), metrics)
+	if dropped != 0 || err != nil {
+		t.Fatalf("PushMetrics() = %d, %v; want no dropped time series", dropped, err)
+	}
+
+	var got []string
+	server.forEachStackdriverTimeSeries(func(sdt *monitoringpb.CreateTimeSeriesRequest) { //nolint: staticcheck
+		seen := make(map[string]bool)
+		for _, ts := range sdt.TimeSeries {
+			key := metricSignature(ts.Metric)
+			if seen[key] {
+				t.Errorf("CreateTimeSeriesRequest %v holds %q more than once", sdt, ts.Metric.Type)
+			}
+			seen[key] = true
+			got = append(got, fmt.Sprintf("%s=%d", ts.Metric.Type, ts.Points[0].Value.GetInt64Value()))
+		}
+	})
+	want := []string{
+		"custom.googleapis.com/opencensus/app/requests=0",
+		"custom.googleapis.com/opencensus/app/requests=1",
+		"custom.googleapis.com/opencensus/app/errors=0",
+		"custom.googleapis.com/opencensus/app/errors=1",
+	}
+	if diff := cmp.Diff(got, want); diff != "" {
+		t.Errorf("exported points -got +want: %s", diff)
+	}
+}
+
+func TestUploadMetricsMetricFilter(t *testing.T) {
+	oldCreateMetricDescriptor := createMetricDescriptor
+	oldCreateTimeSeries := createTimeSeries
+	defer func() {
+		createMetricDescriptor = oldCreateMetricDescriptor
+		createTimeSeries = oldCreateTimeSeries
+	}()
+	var descriptors, timeSeries []string
+	createMetricDescriptor = func(ctx context.Context, c *monitoring.MetricClient, mdr *monitoringpb.CreateMetricDescriptorRequest) (*googlemetricpb.MetricDescriptor, error) { //nolint: staticcheck
+		descriptors = append(descriptors, mdr.MetricDescriptor.Type)
+		return mdr.MetricDescriptor, nil
+	}
+	createTimeSeries = func(ctx context.Context, c *monitoring.MetricClient, req *monitoringpb.CreateTimeSeriesRequest) error { //nolint: staticcheck
+		for _, ts := range req.TimeSeries {
+			timeSeries = append(timeSeries, ts.Metric.Type)
+		}
+		return nil
+	}
+
+	se := &statsExporter{
+		metricDescriptors: make(map[string]bool),
+		o: Options{
+			ProjectID: "filter",
+			MetricFilter: func(m *metricdata.Metric) bool {
+				return !strings.HasPrefix(m.Descriptor.Name, "internal/")
+			},
+		},
+	}
+
+	now := time.Now()
+	var metrics []*metricdata.Metric
+	for _, name := range []string{"app/requests", "internal/queue_length", "app/errors"} {
+		metrics = append(metrics, &metricdata.Metric{
+			Descriptor: metricdata.Descriptor{
+				Name: name,
+				Type: metricdata.TypeCumulativeInt64,
+			},
+			TimeSeries: []*metricdata.TimeSeries{{
+				StartTime: now.Add(-time.Minute),
+				Points:    []metricdata.Point{metricdata.NewInt64Point(now, 1)},
+			}},
+		})
+	}
+	if err := se.uploadMetrics(context.Background(), metrics); err != nil {
+		t.Fatalf("uploadMetrics() error = %v", err)
+	}
+
+	sort.Strings(descriptors)
+	sort.Strings(timeSeries)
+	want := []string{"custom.googleapis.com/opencensus/app/errors", "custom.googleapis.com/opencensus/app/requests"}
+	if diff := cmp.Diff(descriptors, want); diff != "" {
+		t.Errorf("created descriptors -got +want: %s", diff)
+	}
+	if diff := cmp.Diff(timeSeries, want); diff != "" {
+		t.Errorf("exported time series -got +want: %s", diff)
+	}
+}
+
+func TestMetricToMpbTsResourceTypeFilter(t *testing.T) {
+	se := &statsExporter{o: Options{
+		ProjectID:          "foo",
+		ResourceTypeFilter: func(resourceType string) bool { return resourceType == "k8s_container" },
+	}}
+	now := time.Now()
+	newMetric := func(resourceType string) *metricdata.Metric {
+		return &metricdata.Metric{
+			Descriptor: metricdata.Descriptor{
+				Name: "filtered_by_resource",
+				Type: metricdata.TypeCumulativeInt64,
+			},
+			Resource: &resource.Resource{Type: resourceType},
+			TimeSeries: []*metricdata.TimeSeries{{
+				StartTime: now.Add(-time.Minute),
+				Points:    []metricdata.Point{metricdata.NewInt64Point(now, 1)},
+			}},
+		}
+	}
+
+	tests := []struct {
+		resourceType string
+		want         int
+	}{
+		{resourceType: "k8s_container", want: 1},
+		{resourceType: "gce_instance", want: 0},
+		{resourceType: "", want: 0},
+	}
+	for _, tt := range tests {
+		tsl, err := se.metricToMpbTs(context.Background(), newMetric(tt.resourceType))
+		if err != nil {
+			t.Fatalf("metricToMpbTs() error = %v", err)
+		}
+		if len(tsl) != tt.want {
+			t.Errorf("metricToMpbTs() with a %q resource = %d time series; want %d", tt.resourceType, len(tsl), tt.want)
+		}
+	}
+}
+
+func TestMetricToMpbTsResourceForTimeSeries(t *testing.T) {
+	se := &statsExporter{o: Options{
+		ProjectID: "foo",
+		ResourceForTimeSeries: func(m *metricdata.Metric, ts *metricdata.TimeSeries) *monitoredrespb.MonitoredResource {
+			pod := ts.LabelValues[0].Value
+			if pod == "" {
+				return nil
+			}
+			return &monitoredrespb.MonitoredResource{
+				Type:   "k8s_pod",
+				Labels: map[string]string{"pod_name": pod},
+			}
+		},
+	}}
+	now := time.Now()
+	newTs := func(pod string) *metricdata.TimeSeries {
+		return &metricdata.TimeSeries{
+			LabelValues: []metricdata.LabelValue{metricdata.NewLabelValue(pod)},
+			StartTime:   now.Add(-time.Minute),
+			Points:      []metricdata.Point{metricdata.NewInt64Point(now, 1)},
+		}
+	}
+	metric := &metricdata.Metric{
+		Descriptor: metricdata.Descriptor{
+			Name:      "per_series_resource",
+			Type:      metricdata.TypeCumulativeInt64,
+			LabelKeys: []metricdata.LabelKey{{Key: "pod"}},
+		},
+		Resource:   &resource.Resource{Type: "k8s_node", Labels: map[string]string{"node_name": "node"}},
+		TimeSeries: []*metricdata.TimeSeries{newTs("a"), newTs("b"), newTs("")},
+	}
+	tsl, err := se.metricToMpbTs(context.Background(), metric)
+	if err != nil {
+		t.Fatalf("metricToMpbTs() error = %v", err)
+	}
+	var got []*monitoredrespb.MonitoredResource
+	for _, ts := range tsl {
+		got = append(got, ts.Resource)
+	}
+	want := []*monitoredrespb.MonitoredResource{
+		{Type: "k8s_pod", Labels: map[string]string{"pod_name": "a"}},
+		{Type: "k8s_pod", Labels: map[string]string{"pod_name": "b"}},
+		{Type: "k8s_node", Labels: map[string]string{"node_name": "node"}},
+	}
+	if diff := cmp.Diff(got, want, protocmp.Transform()); diff != "" {
+		t.Errorf("time series resources -got +want: %s", diff)
+	}
+}
+
+func TestMetricToMpbTsOmitCumulativeStartTime(t *testing.T) {
+	se := &statsExporter{o: Options{ProjectID: "foo", OmitCumulativeStartTime: true, EmitResetPoint: true}}
+	now := time.Now()
+	metric := &metricdata.Metric{
+		Descriptor: metricdata.Descriptor{
+			Name: "omit_start",
+			Type: metricdata.TypeCumulativeInt64,
+		},
+		TimeSeries: []*metricdata.TimeSeries{{
+			StartTime: now.Add(-time.Minute),
+			Points:    []metricdata.Point{metricdata.NewInt64Point(now, 1)},
+		}},
+	}
+	tsl, err := se.metricToMpbTs(context.Background(), metric)
+	if err != nil {
+		t.Fatalf("metricToMpbTs() error = %v", err)
+	}
+	// No reset point can be anchored without a start time.
+	if len(tsl) != 1 || len(tsl[0].Points) != 1 {
+		t.Fatalf("metricToMpbTs() = %v; want a single point", tsl)
+	}
+	interval := tsl[0].Points[0].Interval
+	if interval.StartTime != nil || !interval.EndTime.AsTime().Equal(now) {
+		t.Errorf("point interval = %v; want only the end time %v", interval, now)
+	}
+}
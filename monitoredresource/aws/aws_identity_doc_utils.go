@@ -0,0 +1,113 @@
+// Copyright 2020, OpenCensus Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package aws
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"time"
+)
+
+// metadataEndpoint is the address of the EC2 instance metadata service.
+var metadataEndpoint = "http://169.254.169.254"
+
+// metadataTimeout bounds the whole detection, so that it fails fast outside
+// of EC2 where the metadata service does not answer.
+var metadataTimeout = time.Second
+
+// awsIdentityDocument is used to store parsed AWS Identity Document.
+type awsIdentityDocument struct {
+	// accountID is the AWS account number for the VM.
+	accountID string
+
+	// instanceID is the instance id of the instance.
+	instanceID string
+
+	// region is the AWS region for the VM.
+	region string
+}
+
+// retrieveAWSIdentityDocument attempts to retrieve AWS Identity Document.
+// If the environment is AWS EC2 Instance then a valid document is retrieved.
+// Relevant attributes from the document are stored in awsIdentityDoc.
+// This is only done once.
+func retrieveAWSIdentityDocument() *awsIdentityDocument {
+	ctx, cancel := context.WithTimeout(context.Background(), metadataTimeout)
+	defer cancel()
+
+	// IMDSv2 requires a session token, fall back to IMDSv1 without one.
+	token, err := metadataToken(ctx)
+	if err != nil && ctx.Err() != nil {
+		return nil
+	}
+	body, err := metadataGet(ctx, "/latest/dynamic/instance-identity/document", token)
+	if err != nil {
+		return nil
+	}
+	var doc struct {
+		AccountID  string `json:"accountId"`
+		InstanceID string `json:"instanceId"`
+		Region     string `json:"region"`
+	}
+	if err := json.Unmarshal(body, &doc); err != nil {
+		return nil
+	}
+	return &awsIdentityDocument{
+		accountID:  doc.AccountID,
+		instanceID: doc.InstanceID,
+		region:     doc.Region,
+	}
+}
+
+// metadataToken returns an IMDSv2 session token.
+func metadataToken(ctx context.Context) (string, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodPut, metadataEndpoint+"/latest/api/token", nil)
+	if err != nil {
+		return "", err
+	}
+	req.Header.Set("X-aws-ec2-metadata-token-ttl-seconds", "60")
+	body, err := metadataDo(req)
+	if err != nil {
+		return "", err
+	}
+	return string(body), nil
+}
+
+// metadataGet returns the metadata at path, authenticated with token if set.
+func metadataGet(ctx context.Context, path, token string) ([]byte, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, metadataEndpoint+path, nil)
+	if err != nil {
+		return nil, err
+	}
+	if token != "" {
+		req.Header.Set("X-aws-ec2-metadata-token", token)
+	}
+	return metadataDo(req)
+}
+
+func metadataDo(req *http.Request) ([]byte, error) {
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("metadata %s returned %s", req.URL.Path, resp.Status)
+	}
+	return io.ReadAll(resp.Body)
+}
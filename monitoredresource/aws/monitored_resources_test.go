@@ -0,0 +1,122 @@
+// Copyright 2020, OpenCensus Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package aws
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+const (
+	AWSAccountStr  = "123456789012"
+	AWSInstanceStr = "i-1234567890abcdef0"
+	AWSRegionStr   = "us-west-2"
+)
+
+func TestEC2InstanceMonitoredResources(t *testing.T) {
+	awsIdentityDoc := &awsIdentityDocument{
+		accountID:  AWSAccountStr,
+		instanceID: AWSInstanceStr,
+		region:     AWSRegionStr,
+	}
+	autoDetected := detectResourceType(awsIdentityDoc)
+	if autoDetected == nil {
+		t.Fatal("EC2InstanceMonitoredResource nil")
+	}
+	resType, labels := autoDetected.MonitoredResource()
+	if resType != "aws_ec2_instance" ||
+		labels["instance_id"] != AWSInstanceStr ||
+		labels["aws_account"] != AWSAccountStr ||
+		labels["region"] != "aws:"+AWSRegionStr {
+		t.Errorf("EC2InstanceMonitoredResource Failed: %v", autoDetected)
+	}
+}
+
+func TestRetrieveAWSIdentityDocument(t *testing.T) {
+	const token = "token1"
+	const doc = `{"accountId": "` + AWSAccountStr + `", "instanceId": "` + AWSInstanceStr + `", "region": "` + AWSRegionStr + `"}`
+	tests := []struct {
+		name    string
+		imdsV2  bool
+		handler http.HandlerFunc
+		want    *awsIdentityDocument
+	}{
+		{
+			name:   "IMDSv2",
+			imdsV2: true,
+			want:   &awsIdentityDocument{accountID: AWSAccountStr, instanceID: AWSInstanceStr, region: AWSRegionStr},
+		},
+		{
+			name: "IMDSv1",
+			want: &awsIdentityDocument{accountID: AWSAccountStr, instanceID: AWSInstanceStr, region: AWSRegionStr},
+		},
+		{
+			name: "not EC2",
+			handler: func(w http.ResponseWriter, r *http.Request) {
+				http.NotFound(w, r)
+			},
+		},
+		{
+			name: "metadata service does not answer",
+			handler: func(w http.ResponseWriter, r *http.Request) {
+				<-r.Context().Done()
+			},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			handler := tt.handler
+			if handler == nil {
+				handler = func(w http.ResponseWriter, r *http.Request) {
+					switch {
+					case r.Method == http.MethodPut && r.URL.Path == "/latest/api/token":
+						if !tt.imdsV2 {
+							http.NotFound(w, r)
+							return
+						}
+						w.Write([]byte(token))
+					case r.Method == http.MethodGet && r.URL.Path == "/latest/dynamic/instance-identity/document":
+						if tt.imdsV2 && r.Header.Get("X-aws-ec2-metadata-token") != token {
+							w.WriteHeader(http.StatusUnauthorized)
+							return
+						}
+						w.Write([]byte(doc))
+					default:
+						http.NotFound(w, r)
+					}
+				}
+			}
+			server := httptest.NewServer(handler)
+			defer server.Close()
+
+			oldEndpoint, oldTimeout := metadataEndpoint, metadataTimeout
+			defer func() {
+				metadataEndpoint, metadataTimeout = oldEndpoint, oldTimeout
+			}()
+			metadataEndpoint, metadataTimeout = server.URL, 100*time.Millisecond
+
+			start := time.Now()
+			got := retrieveAWSIdentityDocument()
+			if elapsed := time.Since(start); elapsed > time.Second {
+				t.Errorf("retrieveAWSIdentityDocument() took %v; want it to fail fast", elapsed)
+			}
+			if (got == nil) != (tt.want == nil) || got != nil && *got != *tt.want {
+				t.Errorf("retrieveAWSIdentityDocument() = %v; want %v", got, tt.want)
+			}
+		})
+	}
+}
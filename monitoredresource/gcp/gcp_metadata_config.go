@@ -17,12 +17,14 @@ package gcp
 import (
 	"log"
 	"os"
+	"path"
 	"strings"
 
 	"cloud.google.com/go/compute/metadata"
 )
 
-// gcpMetadata represents metadata retrieved from GCP (GKE and GCE) environment.
+// gcpMetadata represents metadata retrieved from GCP (GKE, GCE, Cloud Run and
+// Cloud Functions) environment.
 type gcpMetadata struct {
 
 	// projectID is the identifier of the GCP project associated with this resource, such as "my-project".
@@ -48,6 +50,21 @@ type gcpMetadata struct {
 
 	// monitoringV2 is currently always set to true as v1 has been deprecated.
 	monitoringV2 bool
+
+	// serviceName is the name of the Cloud Run service.
+	serviceName string
+
+	// revisionName is the name of the Cloud Run revision.
+	revisionName string
+
+	// configurationName is the name of the Cloud Run configuration.
+	configurationName string
+
+	// functionName is the name of the Cloud Function.
+	functionName string
+
+	// region is the region in which the Cloud Run service or Cloud Function is running.
+	region string
 }
 
 // retrieveGCPMetadata retrieves value of each Attribute from Metadata Server
@@ -82,9 +99,40 @@ func retrieveGCPMetadata() *gcpMetadata {
 	// Monitoring API v2 is now default.
 	gcpMetadata.monitoringV2 = true
 
+	// Cloud Run sets these environment variables for every container, see
+	// https://cloud.google.com/run/docs/container-contract#env-vars
+	gcpMetadata.serviceName = os.Getenv("K_SERVICE")
+	if gcpMetadata.serviceName != "" {
+		gcpMetadata.revisionName = os.Getenv("K_REVISION")
+		gcpMetadata.configurationName = os.Getenv("K_CONFIGURATION")
+	}
+	// Cloud Functions set FUNCTION_TARGET; newer runtimes also set K_SERVICE
+	// to the function name while older ones set FUNCTION_NAME and FUNCTION_REGION.
+	if os.Getenv("FUNCTION_TARGET") != "" {
+		gcpMetadata.functionName = os.Getenv("FUNCTION_NAME")
+		if gcpMetadata.functionName == "" {
+			gcpMetadata.functionName = gcpMetadata.serviceName
+		}
+		gcpMetadata.region = os.Getenv("FUNCTION_REGION")
+	}
+	if (gcpMetadata.serviceName != "" || gcpMetadata.functionName != "") && gcpMetadata.region == "" {
+		gcpMetadata.region, err = region()
+		logError(err)
+	}
+
 	return &gcpMetadata
 }
 
+// region returns the region of the instance. The metadata server reports it
+// as "projects/<project-number>/regions/<region>".
+func region() (string, error) {
+	region, err := metadata.Get("instance/region")
+	if err != nil {
+		return "", err
+	}
+	return path.Base(strings.TrimSpace(region)), nil
+}
+
 // logError logs error only if the error is present and it is not 'not defined'
 func logError(err error) {
 	if err != nil {
@@ -94,11 +94,71 @@ func (gce *GCEInstance) MonitoredResource() (resType string, labels map[string]s
 	return "gce_instance", labels
 }
 
+// CloudRunRevision represents cloud_run_revision type monitored resource.
+// For definition refer to
+// https://cloud.google.com/monitoring/api/resources#tag_cloud_run_revision
+type CloudRunRevision struct {
+
+	// ProjectID is the identifier of the GCP project associated with this resource, such as "my-project".
+	ProjectID string
+
+	// ServiceName is the name of the Cloud Run service.
+	ServiceName string
+
+	// RevisionName is the name of the Cloud Run revision.
+	RevisionName string
+
+	// ConfigurationName is the name of the Cloud Run configuration.
+	ConfigurationName string
+
+	// Location is the region in which the service is running.
+	Location string
+}
+
+// MonitoredResource returns resource type and resource labels for CloudRunRevision
+func (run *CloudRunRevision) MonitoredResource() (resType string, labels map[string]string) {
+	labels = map[string]string{
+		"project_id":         run.ProjectID,
+		"service_name":       run.ServiceName,
+		"revision_name":      run.RevisionName,
+		"configuration_name": run.ConfigurationName,
+		"location":           run.Location,
+	}
+	return "cloud_run_revision", labels
+}
+
+// CloudFunction represents cloud_function type monitored resource.
+// For definition refer to
+// https://cloud.google.com/monitoring/api/resources#tag_cloud_function
+type CloudFunction struct {
+
+	// ProjectID is the identifier of the GCP project associated with this resource, such as "my-project".
+	ProjectID string
+
+	// FunctionName is the name of the function.
+	FunctionName string
+
+	// Region is the region in which the function is running.
+	Region string
+}
+
+// MonitoredResource returns resource type and resource labels for CloudFunction
+func (fn *CloudFunction) MonitoredResource() (resType string, labels map[string]string) {
+	labels = map[string]string{
+		"project_id":    fn.ProjectID,
+		"function_name": fn.FunctionName,
+		"region":        fn.Region,
+	}
+	return "cloud_function", labels
+}
+
 // Autodetect auto detects monitored resources based on
 // the environment where the application is running.
 // It supports detection of following resource types
-// 1. gke_container:
-// 2. gce_instance:
+// 1. cloud_function:
+// 2. cloud_run_revision:
+// 3. gke_container:
+// 4. gce_instance:
 //
 // Returns MonitoredResInterface which implements getLabels() and getType()
 // For resource definition go to https://cloud.google.com/monitoring/api/resources
@@ -123,6 +183,30 @@ func createGCEInstanceMonitoredResource(gcpMetadata *gcpMetadata) *GCEInstance {
 	return &gceInstance
 }
 
+// createCloudRunRevisionMonitoredResource creates a cloud_run_revision monitored resource
+// gcpMetadata contains Cloud Run specific attributes.
+func createCloudRunRevisionMonitoredResource(gcpMetadata *gcpMetadata) *CloudRunRevision {
+	cloudRunRevision := CloudRunRevision{
+		ProjectID:         gcpMetadata.projectID,
+		ServiceName:       gcpMetadata.serviceName,
+		RevisionName:      gcpMetadata.revisionName,
+		ConfigurationName: gcpMetadata.configurationName,
+		Location:          gcpMetadata.region,
+	}
+	return &cloudRunRevision
+}
+
+// createCloudFunctionMonitoredResource creates a cloud_function monitored resource
+// gcpMetadata contains Cloud Functions specific attributes.
+func createCloudFunctionMonitoredResource(gcpMetadata *gcpMetadata) *CloudFunction {
+	cloudFunction := CloudFunction{
+		ProjectID:    gcpMetadata.projectID,
+		FunctionName: gcpMetadata.functionName,
+		Region:       gcpMetadata.region,
+	}
+	return &cloudFunction
+}
+
 // createGKEContainerMonitoredResource creates a gke_container monitored resource
 // gcpMetadata contains GCP (GKE or GCE) specific attributes.
 func createGKEContainerMonitoredResource(gcpMetadata *gcpMetadata) *GKEContainer {
@@ -146,11 +230,16 @@ var detectOnce sync.Once
 var autoDetected Interface
 
 // detectResourceType determines the resource type.
-// gcpMetadata contains GCP (GKE or GCE) specific attributes.
+// gcpMetadata contains GCP (GKE, GCE, Cloud Run or Cloud Functions) specific attributes.
 func detectResourceType(gcpMetadata *gcpMetadata) Interface {
-	if os.Getenv("KUBERNETES_SERVICE_HOST") != "" &&
+	if gcpMetadata != nil && gcpMetadata.instanceID != "" && gcpMetadata.functionName != "" {
+		return createCloudFunctionMonitoredResource(gcpMetadata)
+	} else if os.Getenv("KUBERNETES_SERVICE_HOST") != "" &&
 		gcpMetadata != nil && gcpMetadata.instanceID != "" {
+		// Knative pods on GKE also set K_SERVICE, so GKE is checked before Cloud Run.
 		return createGKEContainerMonitoredResource(gcpMetadata)
+	} else if gcpMetadata != nil && gcpMetadata.instanceID != "" && gcpMetadata.serviceName != "" {
+		return createCloudRunRevisionMonitoredResource(gcpMetadata)
 	} else if gcpMetadata != nil && gcpMetadata.instanceID != "" {
 		return createGCEInstanceMonitoredResource(gcpMetadata)
 	}
@@ -15,18 +15,26 @@
 package gcp
 
 import (
+	"net/http"
+	"net/http/httptest"
 	"os"
+	"strings"
 	"testing"
 )
 
 const (
-	GCPProjectIDStr     = "gcp-project"
-	GCPInstanceIDStr    = "instance"
-	GCPZoneStr          = "us-east1"
-	GKENamespaceStr     = "namespace"
-	GKEPodIDStr         = "pod-id"
-	GKEContainerNameStr = "container"
-	GKEClusterNameStr   = "cluster"
+	GCPProjectIDStr      = "gcp-project"
+	GCPInstanceIDStr     = "instance"
+	GCPZoneStr           = "us-east1"
+	GKENamespaceStr      = "namespace"
+	GKEPodIDStr          = "pod-id"
+	GKEContainerNameStr  = "container"
+	GKEClusterNameStr    = "cluster"
+	CloudRunServiceStr   = "service"
+	CloudRunRevisionStr  = "service-00001-abc"
+	CloudRunConfigStr    = "service-config"
+	CloudRunRegionStr    = "us-central1"
+	CloudFunctionNameStr = "function"
 )
 
 func TestGKEContainerMonitoredResources(t *testing.T) {
@@ -77,3 +85,126 @@ func TestGCEInstanceMonitoredResources(t *testing.T) {
 		t.Errorf("GCEInstanceMonitoredResource Failed: %v", autoDetected)
 	}
 }
+
+// setMetadataServer serves values, keyed by metadata path, from a stub
+// metadata server used by the metadata package for the rest of the test.
+func setMetadataServer(t *testing.T, values map[string]string) {
+	t.Helper()
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("Metadata-Flavor") != "Google" {
+			http.Error(w, "missing Metadata-Flavor header", http.StatusForbidden)
+			return
+		}
+		v, ok := values[strings.TrimPrefix(r.URL.Path, "/computeMetadata/v1/")]
+		if !ok {
+			http.NotFound(w, r)
+			return
+		}
+		w.Write([]byte(v))
+	}))
+	t.Cleanup(srv.Close)
+	t.Setenv("GCE_METADATA_HOST", strings.TrimPrefix(srv.URL, "http://"))
+}
+
+func TestCloudRunRevisionMonitoredResources(t *testing.T) {
+	setMetadataServer(t, map[string]string{
+		"instance/id":        GCPInstanceIDStr,
+		"project/project-id": GCPProjectIDStr,
+		"instance/zone":      "projects/123456/zones/" + CloudRunRegionStr + "-1",
+		"instance/region":    "projects/123456/regions/" + CloudRunRegionStr,
+	})
+	t.Setenv("KUBERNETES_SERVICE_HOST", "")
+	t.Setenv("K_SERVICE", CloudRunServiceStr)
+	t.Setenv("K_REVISION", CloudRunRevisionStr)
+	t.Setenv("K_CONFIGURATION", CloudRunConfigStr)
+
+	autoDetected := detectResourceType(retrieveGCPMetadata())
+
+	if autoDetected == nil {
+		t.Fatal("CloudRunRevisionMonitoredResource nil")
+	}
+	resType, labels := autoDetected.MonitoredResource()
+	if resType != "cloud_run_revision" ||
+		labels["project_id"] != GCPProjectIDStr ||
+		labels["service_name"] != CloudRunServiceStr ||
+		labels["revision_name"] != CloudRunRevisionStr ||
+		labels["configuration_name"] != CloudRunConfigStr ||
+		labels["location"] != CloudRunRegionStr {
+		t.Errorf("CloudRunRevisionMonitoredResource Failed: %v", autoDetected)
+	}
+}
+
+func TestKnativeOnGKEMonitoredResources(t *testing.T) {
+	t.Setenv("KUBERNETES_SERVICE_HOST", "127.0.0.1")
+	gcpMetadata := gcpMetadata{
+		instanceID:    GCPInstanceIDStr,
+		projectID:     GCPProjectIDStr,
+		zone:          GCPZoneStr,
+		clusterName:   GKEClusterNameStr,
+		containerName: GKEContainerNameStr,
+		namespaceID:   GKENamespaceStr,
+		podID:         GKEPodIDStr,
+		serviceName:   CloudRunServiceStr,
+		revisionName:  CloudRunRevisionStr,
+	}
+	autoDetected := detectResourceType(&gcpMetadata)
+
+	if autoDetected == nil {
+		t.Fatal("GKEContainerMonitoredResource nil")
+	}
+	if resType, _ := autoDetected.MonitoredResource(); resType != "k8s_container" {
+		t.Errorf("MonitoredResource() type = %q; want k8s_container", resType)
+	}
+}
+
+func TestCloudFunctionMonitoredResources(t *testing.T) {
+	setMetadataServer(t, map[string]string{
+		"instance/id":        GCPInstanceIDStr,
+		"project/project-id": GCPProjectIDStr,
+		"instance/zone":      "projects/123456/zones/" + CloudRunRegionStr + "-1",
+		"instance/region":    "projects/123456/regions/" + CloudRunRegionStr,
+	})
+	t.Setenv("KUBERNETES_SERVICE_HOST", "")
+
+	tests := []struct {
+		name string
+		env  map[string]string
+	}{
+		{
+			name: "K_SERVICE",
+			env: map[string]string{
+				"FUNCTION_TARGET": "HelloWorld",
+				"K_SERVICE":       CloudFunctionNameStr,
+				"K_REVISION":      CloudFunctionNameStr + "-00001-abc",
+			},
+		},
+		{
+			name: "FUNCTION_NAME",
+			env: map[string]string{
+				"FUNCTION_TARGET": "HelloWorld",
+				"FUNCTION_NAME":   CloudFunctionNameStr,
+				"FUNCTION_REGION": CloudRunRegionStr,
+			},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			for _, k := range []string{"FUNCTION_TARGET", "FUNCTION_NAME", "FUNCTION_REGION", "K_SERVICE", "K_REVISION", "K_CONFIGURATION"} {
+				t.Setenv(k, tt.env[k])
+			}
+
+			autoDetected := detectResourceType(retrieveGCPMetadata())
+
+			if autoDetected == nil {
+				t.Fatal("CloudFunctionMonitoredResource nil")
+			}
+			resType, labels := autoDetected.MonitoredResource()
+			if resType != "cloud_function" ||
+				labels["project_id"] != GCPProjectIDStr ||
+				labels["function_name"] != CloudFunctionNameStr ||
+				labels["region"] != CloudRunRegionStr {
+				t.Errorf("CloudFunctionMonitoredResource Failed: %v", autoDetected)
+			}
+		})
+	}
+}
@@ -0,0 +1,67 @@
+// Copyright 2024, OpenCensus Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package monitoredresource
+
+import (
+	"os"
+)
+
+// GenericTask represents generic_task type monitored resource.
+// For definition refer to
+// https://cloud.google.com/monitoring/api/resources#tag_generic_task
+type GenericTask struct {
+	// Location is the GCP or AWS region in which data about the resource is stored.
+	Location string
+
+	// Namespace is a namespace identifier, such as a cluster name.
+	Namespace string
+
+	// Job is an identifier for a grouping of related tasks, such as the name of a microservice.
+	Job string
+
+	// TaskID is a unique identifier for the task within the namespace and job, such as a pod name.
+	TaskID string
+}
+
+// MonitoredResource returns resource type and resource labels for GenericTask
+func (task *GenericTask) MonitoredResource() (resType string, labels map[string]string) {
+	labels = map[string]string{
+		"location":  task.Location,
+		"namespace": task.Namespace,
+		"job":       task.Job,
+		"task_id":   task.TaskID,
+	}
+	return "generic_task", labels
+}
+
+// GenericTaskFromEnv returns a generic_task monitored resource whose job, task_id,
+// namespace and location labels are read from the environment variables with the
+// given names, e.g. GenericTaskFromEnv("JOB_NAME", "POD_NAME", "POD_NAMESPACE", "REGION").
+// A label is left empty if its variable name is empty or the variable is unset.
+func GenericTaskFromEnv(jobEnv, taskIDEnv, namespaceEnv, locationEnv string) Interface {
+	return &GenericTask{
+		Location:  getEnv(locationEnv),
+		Namespace: getEnv(namespaceEnv),
+		Job:       getEnv(jobEnv),
+		TaskID:    getEnv(taskIDEnv),
+	}
+}
+
+func getEnv(name string) string {
+	if name == "" {
+		return ""
+	}
+	return os.Getenv(name)
+}
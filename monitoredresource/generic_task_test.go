@@ -0,0 +1,41 @@
+// Copyright 2024, OpenCensus Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package monitoredresource
+
+import (
+	"testing"
+)
+
+func TestGenericTaskFromEnv(t *testing.T) {
+	t.Setenv("TEST_JOB", "batch-worker")
+	t.Setenv("TEST_POD_NAME", "batch-worker-7d9f")
+	t.Setenv("TEST_NAMESPACE", "jobs")
+	t.Setenv("TEST_LOCATION", "us-east1")
+
+	resType, labels := GenericTaskFromEnv("TEST_JOB", "TEST_POD_NAME", "TEST_NAMESPACE", "TEST_LOCATION").MonitoredResource()
+	if resType != "generic_task" ||
+		labels["job"] != "batch-worker" ||
+		labels["task_id"] != "batch-worker-7d9f" ||
+		labels["namespace"] != "jobs" ||
+		labels["location"] != "us-east1" {
+		t.Errorf("GenericTaskFromEnv Failed: %s %v", resType, labels)
+	}
+
+	// Unset and unnamed variables leave their labels empty.
+	_, labels = GenericTaskFromEnv("TEST_JOB", "TEST_UNSET_TASK_ID", "", "TEST_LOCATION").MonitoredResource()
+	if labels["task_id"] != "" || labels["namespace"] != "" || labels["job"] != "batch-worker" {
+		t.Errorf("GenericTaskFromEnv with missing variables Failed: %v", labels)
+	}
+}
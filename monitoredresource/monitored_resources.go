@@ -17,6 +17,7 @@ package monitoredresource
 import (
 	"sync"
 
+	"github.com/launchdarkly/opencensus-go-exporter-stackdriver/monitoredresource/aws"
 	"github.com/launchdarkly/opencensus-go-exporter-stackdriver/monitoredresource/gcp"
 )
 
@@ -43,15 +44,13 @@ func Autodetect() Interface {
 			// It then determines the resource type
 			// In GCP and AWS environment both func finishes quickly. However,
 			// in an environment other than those (e.g local laptop) it
-			// takes 2 seconds for GCP and 5-6 for AWS.
+			// takes 2 seconds for GCP and at most 1 for AWS.
 			var wg sync.WaitGroup
-			// MODIFIED IN LAUNCHDARKLY FORK - BEGIN
-			wg.Add(1)
-			// go func() {
-			// 	defer wg.Done()
-			// 	awsDetect = aws.Autodetect()
-			// }()
-			// MODIFIED IN LAUNCHDARKLY FORK - END
+			wg.Add(2)
+			go func() {
+				defer wg.Done()
+				awsDetect = aws.Autodetect()
+			}()
 
 			go func() {
 				defer wg.Done()
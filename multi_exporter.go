@@ -0,0 +1,110 @@
+// Copyright 2024, OpenCensus Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package stackdriver
+
+import (
+	"context"
+	"fmt"
+
+	commonpb "github.com/census-instrumentation/opencensus-proto/gen-go/agent/common/v1"
+	metricspb "github.com/census-instrumentation/opencensus-proto/gen-go/metrics/v1"
+	resourcepb "github.com/census-instrumentation/opencensus-proto/gen-go/resource/v1"
+	"go.opencensus.io/metric/metricdata"
+	"go.opencensus.io/stats/view"
+)
+
+// MultiExporter writes the same stats and metrics to several Exporters,
+// for example to dual-write to two Cloud Monitoring projects during a
+// migration.
+//
+// Every destination converts and uploads the data independently, so a
+// failure in one destination does not prevent the upload to the others.
+// Asynchronous upload errors are reported through each Exporter's own
+// Options.OnError; synchronous errors are returned combined, prefixed with
+// the project of the destination that produced them.
+type MultiExporter struct {
+	exporters []*Exporter
+}
+
+// NewMultiExporter returns a MultiExporter that writes to all the given
+// exporters.
+func NewMultiExporter(exporters ...*Exporter) *MultiExporter {
+	return &MultiExporter{exporters: exporters}
+}
+
+// ExportView exports the view data to every destination.
+func (m *MultiExporter) ExportView(vd *view.Data) {
+	for _, e := range m.exporters {
+		e.ExportView(vd)
+	}
+}
+
+// ExportMetrics exports OpenCensus Metrics to every destination.
+func (m *MultiExporter) ExportMetrics(ctx context.Context, metrics []*metricdata.Metric) error {
+	var errs []error
+	for _, e := range m.exporters {
+		errs = appendDestinationError(errs, e, e.ExportMetrics(ctx, metrics))
+	}
+	return combineErrors(errs)
+}
+
+// PushMetricsProto exports OpenCensus Metrics Proto to every destination
+// synchronously. It returns the largest number of time series dropped by
+// any single destination.
+func (m *MultiExporter) PushMetricsProto(ctx context.Context, node *commonpb.Node, rsc *resourcepb.Resource, metrics []*metricspb.Metric) (int, error) {
+	var errs []error
+	maxDropped := 0
+	for _, e := range m.exporters {
+		dropped, err := e.PushMetricsProto(ctx, node, rsc, metrics)
+		if dropped > maxDropped {
+			maxDropped = dropped
+		}
+		errs = appendDestinationError(errs, e, err)
+	}
+	return maxDropped, combineErrors(errs)
+}
+
+// ForceExport synchronously uploads the current state of all registered
+// metric producers to every destination.
+func (m *MultiExporter) ForceExport(ctx context.Context) error {
+	var errs []error
+	for _, e := range m.exporters {
+		errs = appendDestinationError(errs, e, e.ForceExport(ctx))
+	}
+	return combineErrors(errs)
+}
+
+// Flush waits for exported data to be uploaded to every destination.
+func (m *MultiExporter) Flush() {
+	for _, e := range m.exporters {
+		e.Flush()
+	}
+}
+
+// Close closes every destination's client connections.
+func (m *MultiExporter) Close() error {
+	var errs []error
+	for _, e := range m.exporters {
+		errs = appendDestinationError(errs, e, e.Close())
+	}
+	return combineErrors(errs)
+}
+
+func appendDestinationError(errs []error, e *Exporter, err error) []error {
+	if err == nil {
+		return errs
+	}
+	return append(errs, fmt.Errorf("project %q: %v", e.statsExporter.o.ProjectID, err))
+}
@@ -0,0 +1,74 @@
+// Copyright 2024, OpenCensus Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package stackdriver
+
+import (
+	"context"
+	"sync"
+	"time"
+)
+
+// rateLimiter paces calls with a token bucket holding a single token, refilled
+// at a fixed rate. A nil *rateLimiter never waits.
+type rateLimiter struct {
+	interval time.Duration
+	now      func() time.Time
+	sleep    func(ctx context.Context, d time.Duration) error
+
+	mu   sync.Mutex
+	next time.Time // time the next token is available
+}
+
+func newRateLimiter(qps float64) *rateLimiter {
+	return &rateLimiter{
+		interval: time.Duration(float64(time.Second) / qps),
+		now:      time.Now,
+		sleep:    sleepContext,
+	}
+}
+
+// wait blocks until a call is allowed, or returns the error of ctx if it is
+// done first. The token of a call is taken even if ctx is done, so waiting
+// calls keep their order.
+func (l *rateLimiter) wait(ctx context.Context) error {
+	if l == nil {
+		return nil
+	}
+	l.mu.Lock()
+	now := l.now()
+	at := l.next
+	if at.Before(now) {
+		at = now
+	}
+	l.next = at.Add(l.interval)
+	l.mu.Unlock()
+
+	if d := at.Sub(now); d > 0 {
+		return l.sleep(ctx, d)
+	}
+	return nil
+}
+
+// sleepContext waits for d, or returns the error of ctx if it is done first.
+func sleepContext(ctx context.Context, d time.Duration) error {
+	t := time.NewTimer(d)
+	defer t.Stop()
+	select {
+	case <-ctx.Done():
+		return ctx.Err()
+	case <-t.C:
+		return nil
+	}
+}
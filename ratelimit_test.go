@@ -0,0 +1,81 @@
+// Copyright 2024, OpenCensus Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package stackdriver
+
+import (
+	"context"
+	"fmt"
+	"testing"
+	"time"
+
+	monitoring "cloud.google.com/go/monitoring/apiv3/v2"
+	"github.com/google/go-cmp/cmp"
+	metricpb "google.golang.org/genproto/googleapis/api/metric"
+	monitoringpb "google.golang.org/genproto/googleapis/monitoring/v3" //nolint: staticcheck
+)
+
+func TestCreateMetricDescriptorRateLimit(t *testing.T) {
+	start := time.Now()
+	now := start
+	l := newRateLimiter(2)
+	l.now = func() time.Time { return now }
+	l.sleep = func(ctx context.Context, d time.Duration) error {
+		now = now.Add(d)
+		return nil
+	}
+
+	var created []time.Duration
+	persisted := createMetricDescriptor
+	createMetricDescriptor = func(ctx context.Context, c *monitoring.MetricClient, mdr *monitoringpb.CreateMetricDescriptorRequest) (*metricpb.MetricDescriptor, error) { //nolint: staticcheck
+		created = append(created, now.Sub(start))
+		return mdr.MetricDescriptor, nil
+	}
+	defer func() { createMetricDescriptor = persisted }()
+
+	e := &statsExporter{
+		o:                 Options{ProjectID: "foo"},
+		metricDescriptors: make(map[string]bool),
+		mdLimiter:         l,
+	}
+	create := func(i int) {
+		md := &metricpb.MetricDescriptor{Type: fmt.Sprintf("custom.googleapis.com/opencensus/paced_%d", i)}
+		if err := e.createMetricDescriptor(context.Background(), md); err != nil {
+			t.Fatalf("createMetricDescriptor() error = %v", err)
+		}
+	}
+	for i := 0; i < 3; i++ {
+		create(i)
+	}
+	// After an idle period the next call is not delayed.
+	now = now.Add(5 * time.Second)
+	create(3)
+
+	want := []time.Duration{0, 500 * time.Millisecond, time.Second, 6 * time.Second}
+	if diff := cmp.Diff(created, want); diff != "" {
+		t.Errorf("CreateMetricDescriptor call times -got +want: %s", diff)
+	}
+}
+
+func TestRateLimiterContextDone(t *testing.T) {
+	l := newRateLimiter(0.001)
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	if err := l.wait(ctx); err != nil {
+		t.Fatalf("First wait() = %v; want no wait", err)
+	}
+	if err := l.wait(ctx); err != context.Canceled {
+		t.Errorf("wait() with a done context = %v; want %v", err, context.Canceled)
+	}
+}
@@ -0,0 +1,96 @@
+// Copyright 2024, OpenCensus Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package stackdriver
+
+import (
+	"sync"
+	"time"
+
+	"github.com/golang/protobuf/ptypes/timestamp"
+	distributionpb "google.golang.org/genproto/googleapis/api/distribution"
+	monitoringpb "google.golang.org/genproto/googleapis/monitoring/v3" //nolint: staticcheck
+)
+
+// resetPointDuration is the length of the interval of a reset point.
+// Stackdriver Monitoring rejects cumulative points whose interval is empty.
+const resetPointDuration = time.Millisecond
+
+// seriesSet records the time series exported so far. The time series not
+// exported for staleSeriesAge are forgotten. The zero value is ready to use.
+type seriesSet struct {
+	mu     sync.Mutex
+	seen   map[string]bool
+	series staleSeries
+}
+
+// add records the time series identified by key and reports whether it was
+// not recorded before.
+func (s *seriesSet) add(key string) bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.seen == nil {
+		s.seen = make(map[string]bool)
+	}
+	for _, stale := range s.series.touch(key) {
+		delete(s.seen, stale)
+	}
+	if s.seen[key] {
+		return false
+	}
+	s.seen[key] = true
+	return true
+}
+
+// resetPoint returns a zero-valued point of the same type as the cumulative
+// point pt, starting at the start time of pt.
+func resetPoint(pt *monitoringpb.Point) *monitoringpb.Point { //nolint: staticcheck
+	start := pt.GetInterval().GetStartTime()
+	if start == nil {
+		return nil
+	}
+	end := &timestamp.Timestamp{
+		Seconds: start.Seconds,
+		Nanos:   start.Nanos + int32(resetPointDuration),
+	}
+	if end.Nanos >= int32(time.Second) {
+		end.Seconds++
+		end.Nanos -= int32(time.Second)
+	}
+
+	value := &monitoringpb.TypedValue{} //nolint: staticcheck
+	switch v := pt.GetValue().GetValue().(type) {
+	case *monitoringpb.TypedValue_Int64Value:
+		value.Value = &monitoringpb.TypedValue_Int64Value{}
+	case *monitoringpb.TypedValue_DoubleValue:
+		value.Value = &monitoringpb.TypedValue_DoubleValue{}
+	case *monitoringpb.TypedValue_DistributionValue:
+		value.Value = &monitoringpb.TypedValue_DistributionValue{
+			DistributionValue: &distributionpb.Distribution{
+				BucketOptions: v.DistributionValue.GetBucketOptions(),
+				BucketCounts:  make([]int64, len(v.DistributionValue.GetBucketCounts())),
+			},
+		}
+	default:
+		return nil
+	}
+	return &monitoringpb.Point{ //nolint: staticcheck
+		Interval: &monitoringpb.TimeInterval{ //nolint: staticcheck
+			StartTime: start,
+			EndTime:   end,
+		},
+		Value: value,
+	}
+}
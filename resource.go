@@ -15,6 +15,7 @@
 package stackdriver // import "github.com/launchdarkly/opencensus-go-exporter-stackdriver"
 
 import (
+	"crypto/rand"
 	"fmt"
 	"sync"
 
@@ -34,6 +35,7 @@ const (
 	stackdriverGenericTaskNamespace = "contrib.opencensus.io/exporter/stackdriver/generic_task/namespace"
 	stackdriverGenericTaskJob       = "contrib.opencensus.io/exporter/stackdriver/generic_task/job"
 	stackdriverGenericTaskID        = "contrib.opencensus.io/exporter/stackdriver/generic_task/task_id"
+	stackdriverGenericNodeNamespace = "contrib.opencensus.io/exporter/stackdriver/generic_node/namespace"
 
 	knativeResType           = "knative_revision"
 	knativeServiceName       = "service_name"
@@ -46,11 +48,29 @@ const (
 	knativeTriggerType = "knative_trigger"
 	knativeTriggerName = "trigger_name"
 
+	istioCanonicalServiceType      = "istio_canonical_service"
+	istioMeshUID                   = "mesh_uid"
+	istioNamespaceName             = "namespace_name"
+	istioCanonicalServiceNamespace = "canonical_service_namespace"
+	istioCanonicalServiceName      = "canonical_service_name"
+
 	appEngineInstanceType = "gae_instance"
 
 	appEngineService  = "appengine.service.id"
 	appEngineVersion  = "appengine.version.id"
 	appEngineInstance = "appengine.instance.id"
+
+	cloudFunctionType = "cloud_function"
+
+	// OpenTelemetry function resource attributes.
+	cloudPlatform               = "cloud.platform"
+	cloudPlatformCloudFunctions = "gcp_cloud_functions"
+	faasName                    = "faas.name"
+
+	// OpenTelemetry service resource attributes.
+	serviceName       = "service.name"
+	serviceNamespace  = "service.namespace"
+	serviceInstanceID = "service.instance.id"
 )
 
 var (
@@ -63,6 +83,12 @@ var (
 	// autodetectedLabels stores all the labels from the autodetected monitored resource
 	// with a possible additional label for the GCP "location".
 	autodetectedLabels map[string]string
+
+	// generatedTaskIDOnce is used to lazy initialize generatedTaskID.
+	generatedTaskIDOnce sync.Once
+	// generatedTaskID is the random UUID used as the task_id of generic_task
+	// resources without one when Options.GenerateTaskID is set.
+	generatedTaskID string
 )
 
 func init() {
@@ -119,6 +145,22 @@ var appEngineInstanceMap = map[string]string{
 	"instance_id": appEngineInstance,
 }
 
+var cloudFunctionResourceMap = map[string]string{
+	"project_id":    stackdriverProjectID,
+	"region":        resourcekeys.CloudKeyRegion,
+	"function_name": faasName,
+}
+
+// otelResourceKeys maps OpenTelemetry semantic convention resource attribute
+// keys to the OpenCensus resource label keys with the same meaning. Keys that
+// both conventions share, such as "k8s.pod.name" or "cloud.region", need no
+// mapping.
+var otelResourceKeys = map[string]string{
+	"k8s.container.name":      resourcekeys.ContainerKeyName,
+	"k8s.node.name":           resourcekeys.HostKeyName,
+	"cloud.availability_zone": resourcekeys.CloudKeyZone,
+}
+
 // Generic task resource.
 var genericResourceMap = map[string]string{
 	"project_id": stackdriverProjectID,
@@ -128,6 +170,14 @@ var genericResourceMap = map[string]string{
 	"task_id":    stackdriverGenericTaskID,
 }
 
+// Generic node resource, for hosts outside of a cloud provider.
+var genericNodeResourceMap = map[string]string{
+	"project_id": stackdriverProjectID,
+	"location":   resourcekeys.CloudKeyZone,
+	"namespace":  stackdriverGenericNodeNamespace,
+	"node_id":    resourcekeys.HostKeyName,
+}
+
 var knativeRevisionResourceMap = map[string]string{
 	"project_id":             stackdriverProjectID,
 	"location":               resourcekeys.CloudKeyZone,
@@ -155,6 +205,15 @@ var knativeTriggerResourceMap = map[string]string{
 	knativeTriggerName:   knativeTriggerName,
 }
 
+var istioCanonicalServiceResourceMap = map[string]string{
+	"project_id":                   stackdriverProjectID,
+	"location":                     resourcekeys.CloudKeyZone,
+	istioMeshUID:                   istioMeshUID,
+	istioNamespaceName:             istioNamespaceName,
+	istioCanonicalServiceNamespace: istioCanonicalServiceNamespace,
+	istioCanonicalServiceName:      istioCanonicalServiceName,
+}
+
 // getAutodetectedLabels returns all the labels from the Monitored Resource detected
 // from the environment by calling monitoredresource.Autodetect. If a "zone" label is detected,
 // a "location" label is added with the same value to account for differences between
@@ -211,12 +270,25 @@ func DefaultMapResource(res *resource.Resource) *monitoredrespb.MonitoredResourc
 		}
 	}
 
+	res = fromOTelResource(res)
 	match := genericResourceMap
 	result := &monitoredrespb.MonitoredResource{
 		Type: "generic_task",
 	}
 
+	isK8s := res.Type == resourcekeys.ContainerType || res.Type == resourcekeys.K8SType
 	switch {
+	case res.Type == resourcekeys.ContainerType && res.Labels[resourcekeys.ContainerKeyName] != "":
+		result.Type = "k8s_container"
+		match = k8sContainerMap
+	// Pod- and node-scoped metrics have no container or pod name, and are
+	// mapped to the most specific resource their labels identify.
+	case isK8s && res.Labels[resourcekeys.K8SKeyPodName] != "":
+		result.Type = "k8s_pod"
+		match = k8sPodMap
+	case isK8s && res.Labels[resourcekeys.K8SKeyClusterName] != "" && res.Labels[resourcekeys.HostKeyName] != "":
+		result.Type = "k8s_node"
+		match = k8sNodeMap
 	case res.Type == resourcekeys.ContainerType:
 		result.Type = "k8s_container"
 		match = k8sContainerMap
@@ -229,12 +301,20 @@ func DefaultMapResource(res *resource.Resource) *monitoredrespb.MonitoredResourc
 	case res.Type == appEngineInstanceType:
 		result.Type = appEngineInstanceType
 		match = appEngineInstanceMap
+	case res.Type == cloudFunctionType:
+		result.Type = cloudFunctionType
+		match = cloudFunctionResourceMap
 	case res.Labels[resourcekeys.CloudKeyProvider] == resourcekeys.CloudProviderGCP:
 		result.Type = "gce_instance"
 		match = gcpResourceMap
 	case res.Labels[resourcekeys.CloudKeyProvider] == resourcekeys.CloudProviderAWS:
 		result.Type = "aws_ec2_instance"
 		match = awsResourceMap
+	// Hosts are only mapped to generic_node when they opt in with a namespace,
+	// other hosts keep falling back to global.
+	case res.Type == resourcekeys.HostType && res.Labels[stackdriverGenericNodeNamespace] != "":
+		result.Type = "generic_node"
+		match = genericNodeResourceMap
 	case res.Type == knativeResType:
 		result.Type = res.Type
 		match = knativeRevisionResourceMap
@@ -244,6 +324,13 @@ func DefaultMapResource(res *resource.Resource) *monitoredrespb.MonitoredResourc
 	case res.Type == knativeTriggerType:
 		result.Type = knativeTriggerType
 		match = knativeTriggerResourceMap
+	case res.Type == istioCanonicalServiceType:
+		result.Type = istioCanonicalServiceType
+		match = istioCanonicalServiceResourceMap
+	}
+
+	if result.Type == "generic_task" {
+		res = fromServiceResource(res)
 	}
 
 	var missing bool
@@ -264,3 +351,157 @@ func DefaultMapResource(res *resource.Resource) *monitoredrespb.MonitoredResourc
 	}
 	return result
 }
+
+// fromOTelResource returns res with the values of the OpenTelemetry resource
+// attributes it carries copied to their OpenCensus keys. The OpenTelemetry keys
+// take precedence since they are the more specific ones, e.g. "container.name"
+// is the name given by the container runtime while "k8s.container.name" is the
+// name in the pod spec. OpenTelemetry resources have no type, so when res.Type
+// is empty it is inferred from the Kubernetes and cloud.platform attributes.
+func fromOTelResource(res *resource.Resource) *resource.Resource {
+	labels := res.Labels
+	copied := false
+	for otelKey, ocKey := range otelResourceKeys {
+		v, ok := res.Labels[otelKey]
+		if !ok {
+			continue
+		}
+		if !copied {
+			labels = make(map[string]string, len(res.Labels))
+			for k, v := range res.Labels {
+				labels[k] = v
+			}
+			copied = true
+		}
+		labels[ocKey] = v
+	}
+
+	typ := res.Type
+	if typ == "" {
+		switch {
+		case labels[resourcekeys.K8SKeyPodName] != "" && labels[resourcekeys.ContainerKeyName] != "":
+			typ = resourcekeys.ContainerType
+		case labels[resourcekeys.K8SKeyPodName] != "",
+			labels[resourcekeys.K8SKeyClusterName] != "" && labels[resourcekeys.HostKeyName] != "":
+			typ = resourcekeys.K8SType
+		case labels[cloudPlatform] == cloudPlatformCloudFunctions:
+			typ = cloudFunctionType
+		}
+	}
+	if typ == res.Type && !copied {
+		return res
+	}
+	return &resource.Resource{Type: typ, Labels: labels}
+}
+
+// fromServiceResource returns res with the generic_task labels it lacks taken
+// from the OpenTelemetry service attributes, for plain processes identified by
+// their service.name rather than by a container or a host. The namespace
+// defaults to "default" and the task ID to the opencensus_task label value.
+func fromServiceResource(res *resource.Resource) *resource.Resource {
+	if res.Labels[serviceName] == "" {
+		return res
+	}
+	for _, key := range []string{resourcekeys.ContainerKeyName, resourcekeys.K8SKeyPodName, resourcekeys.HostKeyID, resourcekeys.HostKeyName} {
+		if res.Labels[key] != "" {
+			return res
+		}
+	}
+
+	labels := make(map[string]string, len(res.Labels)+3)
+	for k, v := range res.Labels {
+		labels[k] = v
+	}
+	setDefault := func(key string, values ...string) {
+		if labels[key] != "" {
+			return
+		}
+		for _, v := range values {
+			if v != "" {
+				labels[key] = v
+				return
+			}
+		}
+	}
+	setDefault(stackdriverGenericTaskJob, res.Labels[serviceName])
+	setDefault(stackdriverGenericTaskNamespace, res.Labels[serviceNamespace], "default")
+	setDefault(stackdriverGenericTaskID, res.Labels[serviceInstanceID], getTaskValue())
+	return &resource.Resource{Type: res.Type, Labels: labels}
+}
+
+// ResourceProjectConflictPolicy configures how to handle a "global" monitored
+// resource whose "project_id" label differs from Options.ProjectID.
+type ResourceProjectConflictPolicy int
+
+const (
+	// IgnoreResourceProjectConflict keeps the resource as configured.
+	IgnoreResourceProjectConflict ResourceProjectConflictPolicy = iota
+	// WarnResourceProjectConflict keeps the resource as configured and reports
+	// the conflict through Options.OnError.
+	WarnResourceProjectConflict
+	// FailResourceProjectConflict makes NewExporter return an error.
+	FailResourceProjectConflict
+	// AlignResourceProjectConflict sets the "project_id" label of the resource
+	// to Options.ProjectID.
+	AlignResourceProjectConflict
+)
+
+// resolveGlobalResourceProjectConflict applies o.GlobalResourceProjectConflict
+// to o.Resource.
+func (o *Options) resolveGlobalResourceProjectConflict() error {
+	if o.Resource == nil || o.Resource.Type != "global" {
+		return nil
+	}
+	resourceProjectID, ok := o.Resource.Labels["project_id"]
+	if !ok || resourceProjectID == o.ProjectID {
+		return nil
+	}
+	err := fmt.Errorf("stackdriver: global resource project_id %q differs from ProjectID %q", resourceProjectID, o.ProjectID)
+	switch o.GlobalResourceProjectConflict {
+	case WarnResourceProjectConflict:
+		o.handleError(err)
+	case FailResourceProjectConflict:
+		return err
+	case AlignResourceProjectConflict:
+		rsc := &monitoredrespb.MonitoredResource{
+			Type:   o.Resource.Type,
+			Labels: make(map[string]string, len(o.Resource.Labels)),
+		}
+		for k, v := range o.Resource.Labels {
+			rsc.Labels[k] = v
+		}
+		rsc.Labels["project_id"] = o.ProjectID
+		o.Resource = rsc
+	}
+	return nil
+}
+
+// getGeneratedTaskID returns a random version 4 UUID that stays the same for
+// the lifetime of the process.
+func getGeneratedTaskID() string {
+	generatedTaskIDOnce.Do(func() {
+		var b [16]byte
+		if _, err := rand.Read(b[:]); err != nil {
+			generatedTaskID = getTaskValue()
+			return
+		}
+		b[6] = b[6]&0x0f | 0x40
+		b[8] = b[8]&0x3f | 0x80
+		generatedTaskID = fmt.Sprintf("%x-%x-%x-%x-%x", b[0:4], b[4:6], b[6:8], b[8:10], b[10:])
+	})
+	return generatedTaskID
+}
+
+// withGeneratedTaskID returns mr, or a copy of mr with a generated task_id if
+// Options.GenerateTaskID is set and mr is a generic_task resource without one.
+func (o Options) withGeneratedTaskID(mr *monitoredrespb.MonitoredResource) *monitoredrespb.MonitoredResource {
+	if !o.GenerateTaskID || mr.GetType() != "generic_task" || mr.GetLabels()["task_id"] != "" {
+		return mr
+	}
+	labels := make(map[string]string, len(mr.Labels)+1)
+	for k, v := range mr.Labels {
+		labels[k] = v
+	}
+	labels["task_id"] = getGeneratedTaskID()
+	return &monitoredrespb.MonitoredResource{Type: mr.Type, Labels: labels}
+}
@@ -16,14 +16,18 @@ package stackdriver // import "github.com/launchdarkly/opencensus-go-exporter-st
 
 import (
 	"fmt"
+	"regexp"
 	"sync"
 	"testing"
+	"time"
 
 	"github.com/launchdarkly/opencensus-go-exporter-stackdriver/monitoredresource"
 	"github.com/launchdarkly/opencensus-go-exporter-stackdriver/monitoredresource/gcp"
 	"github.com/google/go-cmp/cmp"
 	"go.opencensus.io/resource"
 	"go.opencensus.io/resource/resourcekeys"
+	"go.opencensus.io/stats"
+	"go.opencensus.io/stats/view"
 	monitoredrespb "google.golang.org/genproto/googleapis/api/monitoredres"
 	"google.golang.org/protobuf/testing/protocmp"
 )
@@ -106,6 +110,70 @@ func TestDefaultMapResource(t *testing.T) {
 				},
 			},
 		},
+		// A container resource without a container name maps to its pod.
+		{
+			input: &resource.Resource{
+				Type: resourcekeys.ContainerType,
+				Labels: map[string]string{
+					stackdriverProjectID:             "proj1",
+					resourcekeys.K8SKeyClusterName:   "cluster1",
+					resourcekeys.K8SKeyPodName:       "pod1",
+					resourcekeys.K8SKeyNamespaceName: "namespace1",
+					resourcekeys.CloudKeyZone:        "zone1",
+				},
+			},
+			want: &monitoredrespb.MonitoredResource{
+				Type: "k8s_pod",
+				Labels: map[string]string{
+					"project_id":     "proj1",
+					"location":       "zone1",
+					"cluster_name":   "cluster1",
+					"namespace_name": "namespace1",
+					"pod_name":       "pod1",
+				},
+			},
+		},
+		// Kubernetes resources with only node and cluster names map to the node.
+		{
+			input: &resource.Resource{
+				Type: resourcekeys.ContainerType,
+				Labels: map[string]string{
+					stackdriverProjectID:           "proj1",
+					resourcekeys.K8SKeyClusterName: "cluster1",
+					resourcekeys.HostKeyName:       "node1",
+					resourcekeys.CloudKeyZone:      "zone1",
+				},
+			},
+			want: &monitoredrespb.MonitoredResource{
+				Type: "k8s_node",
+				Labels: map[string]string{
+					"project_id":   "proj1",
+					"location":     "zone1",
+					"cluster_name": "cluster1",
+					"node_name":    "node1",
+				},
+			},
+		},
+		{
+			input: &resource.Resource{
+				Type: resourcekeys.K8SType,
+				Labels: map[string]string{
+					stackdriverProjectID:           "proj1",
+					resourcekeys.K8SKeyClusterName: "cluster1",
+					resourcekeys.HostKeyName:       "node1",
+					resourcekeys.CloudKeyZone:      "zone1",
+				},
+			},
+			want: &monitoredrespb.MonitoredResource{
+				Type: "k8s_node",
+				Labels: map[string]string{
+					"project_id":   "proj1",
+					"location":     "zone1",
+					"cluster_name": "cluster1",
+					"node_name":    "node1",
+				},
+			},
+		},
 		// Don't match to k8s node if either cluster name or host type are not present
 		{
 			input: &resource.Resource{
@@ -164,33 +232,31 @@ func TestDefaultMapResource(t *testing.T) {
 				},
 			},
 		},
-		// REMOVED IN LAUNCHDARKLY FORK - BEGIN
-		// // Test autodecting missing Resource labels
-		// {
-		// 	input: &resource.Resource{
-		// 		Type: resourcekeys.CloudType,
-		// 		Labels: map[string]string{
-		// 			stackdriverProjectID:          "proj1",
-		// 			resourcekeys.CloudKeyProvider: resourcekeys.CloudProviderAWS,
-		// 			"extra_key":                   "must be ignored",
-		// 		},
-		// 	},
-		// 	autoRes: &monitoredresource.AWSEC2Instance{
-		// 		AWSAccount: "account1",
-		// 		InstanceID: "inst1",
-		// 		Region:     "region1",
-		// 	},
-		// 	want: &monitoredrespb.MonitoredResource{
-		// 		Type: "aws_ec2_instance",
-		// 		Labels: map[string]string{
-		// 			"project_id":  "proj1",
-		// 			"instance_id": "inst1",
-		// 			"region":      "aws:region1",
-		// 			"aws_account": "account1",
-		// 		},
-		// 	},
-		// },
-		// REMOVED IN LAUNCHDARKLY FORK - END
+		// Test autodecting missing Resource labels
+		{
+			input: &resource.Resource{
+				Type: resourcekeys.CloudType,
+				Labels: map[string]string{
+					stackdriverProjectID:          "proj1",
+					resourcekeys.CloudKeyProvider: resourcekeys.CloudProviderAWS,
+					"extra_key":                   "must be ignored",
+				},
+			},
+			autoRes: &monitoredresource.AWSEC2Instance{
+				AWSAccount: "account1",
+				InstanceID: "inst1",
+				Region:     "region1",
+			},
+			want: &monitoredrespb.MonitoredResource{
+				Type: "aws_ec2_instance",
+				Labels: map[string]string{
+					"project_id":  "proj1",
+					"instance_id": "inst1",
+					"region":      "aws:region1",
+					"aws_account": "account1",
+				},
+			},
+		},
 		// Test autodetecting partial missing Resource labels
 		{
 			input: &resource.Resource{
@@ -288,6 +354,64 @@ func TestDefaultMapResource(t *testing.T) {
 				},
 			},
 		},
+		// Service without container or host to generic_task.
+		{
+			input: &resource.Resource{
+				Labels: map[string]string{
+					stackdriverProjectID:      "proj1",
+					resourcekeys.CloudKeyZone: "zone1",
+					"service.name":            "service1",
+					"service.namespace":       "namespace1",
+					"service.instance.id":     "instance1",
+				},
+			},
+			want: &monitoredrespb.MonitoredResource{
+				Type: "generic_task",
+				Labels: map[string]string{
+					"project_id": "proj1",
+					"location":   "zone1",
+					"namespace":  "namespace1",
+					"job":        "service1",
+					"task_id":    "instance1",
+				},
+			},
+		},
+		{
+			input: &resource.Resource{
+				Labels: map[string]string{
+					stackdriverProjectID:      "proj1",
+					resourcekeys.CloudKeyZone: "zone1",
+					"service.name":            "service1",
+				},
+			},
+			want: &monitoredrespb.MonitoredResource{
+				Type: "generic_task",
+				Labels: map[string]string{
+					"project_id": "proj1",
+					"location":   "zone1",
+					"namespace":  "default",
+					"job":        "service1",
+					"task_id":    getTaskValue(),
+				},
+			},
+		},
+		// Service on a host is not a plain process.
+		{
+			input: &resource.Resource{
+				Labels: map[string]string{
+					stackdriverProjectID:      "proj1",
+					resourcekeys.CloudKeyZone: "zone1",
+					resourcekeys.HostKeyName:  "host1",
+					"service.name":            "service1",
+				},
+			},
+			want: &monitoredrespb.MonitoredResource{
+				Type: "global",
+				Labels: map[string]string{
+					"project_id": "proj1",
+				},
+			},
+		},
 		// nil to Global.
 		{
 			input: nil,
@@ -465,6 +589,256 @@ func TestDefaultMapResource(t *testing.T) {
 				},
 			},
 		},
+		// Mapping for istio_canonical_service with autodetected GCP metadata labels.
+		{
+			input: &resource.Resource{
+				Type: "istio_canonical_service",
+				Labels: map[string]string{
+					istioMeshUID:                   "proj-1234",
+					istioNamespaceName:             "namespace1",
+					istioCanonicalServiceNamespace: "namespace1",
+					istioCanonicalServiceName:      "reviews",
+				},
+			},
+			autoRes: &monitoredresource.GKEContainer{
+				ProjectID: "proj1",
+				Zone:      "zone1",
+			},
+			want: &monitoredrespb.MonitoredResource{
+				Type: "istio_canonical_service",
+				Labels: map[string]string{
+					"project_id":                  "proj1",
+					"location":                    "zone1",
+					"mesh_uid":                    "proj-1234",
+					"namespace_name":              "namespace1",
+					"canonical_service_namespace": "namespace1",
+					"canonical_service_name":      "reviews",
+				},
+			},
+		},
+		// Mapping for istio_canonical_service with explicit GCP metadata labels.
+		{
+			input: &resource.Resource{
+				Type: "istio_canonical_service",
+				Labels: map[string]string{
+					stackdriverProjectID:           "proj1",
+					resourcekeys.CloudKeyZone:      "zone1",
+					istioMeshUID:                   "proj-1234",
+					istioNamespaceName:             "namespace1",
+					istioCanonicalServiceNamespace: "namespace1",
+					istioCanonicalServiceName:      "reviews",
+				},
+			},
+			autoRes: &monitoredresource.GKEContainer{},
+			want: &monitoredrespb.MonitoredResource{
+				Type: "istio_canonical_service",
+				Labels: map[string]string{
+					"project_id":                  "proj1",
+					"location":                    "zone1",
+					"mesh_uid":                    "proj-1234",
+					"namespace_name":              "namespace1",
+					"canonical_service_namespace": "namespace1",
+					"canonical_service_name":      "reviews",
+				},
+			},
+		},
+		// Missing mesh_uid on istio_canonical_service falls back to global.
+		{
+			input: &resource.Resource{
+				Type: "istio_canonical_service",
+				Labels: map[string]string{
+					stackdriverProjectID:           "proj1",
+					resourcekeys.CloudKeyZone:      "zone1",
+					istioNamespaceName:             "namespace1",
+					istioCanonicalServiceNamespace: "namespace1",
+					istioCanonicalServiceName:      "reviews",
+				},
+			},
+			autoRes: &monitoredresource.GKEContainer{},
+			want: &monitoredrespb.MonitoredResource{
+				Type: "global",
+				Labels: map[string]string{
+					"project_id": "proj1",
+				},
+			},
+		},
+		// OpenTelemetry resources have no type and use their own keys.
+		{
+			input: &resource.Resource{
+				Labels: map[string]string{
+					stackdriverProjectID:      "proj1",
+					"cloud.provider":          "gcp",
+					"cloud.availability_zone": "zone1",
+					"k8s.cluster.name":        "cluster1",
+					"k8s.namespace.name":      "namespace1",
+					"k8s.pod.name":            "pod1",
+					"k8s.container.name":      "container-name1",
+					"container.name":          "k8s_container-name1_pod1",
+				},
+			},
+			want: &monitoredrespb.MonitoredResource{
+				Type: "k8s_container",
+				Labels: map[string]string{
+					"project_id":     "proj1",
+					"location":       "zone1",
+					"cluster_name":   "cluster1",
+					"namespace_name": "namespace1",
+					"pod_name":       "pod1",
+					"container_name": "container-name1",
+				},
+			},
+		},
+		{
+			input: &resource.Resource{
+				Labels: map[string]string{
+					stackdriverProjectID:      "proj1",
+					"cloud.provider":          "gcp",
+					"cloud.availability_zone": "zone1",
+					"k8s.cluster.name":        "cluster1",
+					"k8s.node.name":           "node1",
+				},
+			},
+			want: &monitoredrespb.MonitoredResource{
+				Type: "k8s_node",
+				Labels: map[string]string{
+					"project_id":   "proj1",
+					"location":     "zone1",
+					"cluster_name": "cluster1",
+					"node_name":    "node1",
+				},
+			},
+		},
+		{
+			input: &resource.Resource{
+				Labels: map[string]string{
+					stackdriverProjectID:      "proj1",
+					"cloud.provider":          "gcp",
+					"cloud.platform":          "gcp_compute_engine",
+					"cloud.availability_zone": "zone1",
+					"host.id":                 "inst1",
+					"host.name":               "host1",
+				},
+			},
+			want: &monitoredrespb.MonitoredResource{
+				Type: "gce_instance",
+				Labels: map[string]string{
+					"project_id":  "proj1",
+					"instance_id": "inst1",
+					"zone":        "zone1",
+				},
+			},
+		},
+		{
+			input: &resource.Resource{
+				Type: resourcekeys.CloudType,
+				Labels: map[string]string{
+					stackdriverProjectID:          "proj1",
+					resourcekeys.CloudKeyProvider: resourcekeys.CloudProviderGCP,
+					"cloud.availability_zone":     "us-central1-a",
+					resourcekeys.HostKeyID:        "inst1",
+				},
+			},
+			want: &monitoredrespb.MonitoredResource{
+				Type: "gce_instance",
+				Labels: map[string]string{
+					"project_id":  "proj1",
+					"instance_id": "inst1",
+					"zone":        "us-central1-a",
+				},
+			},
+		},
+		{
+			input: &resource.Resource{
+				Type: resourcekeys.HostType,
+				Labels: map[string]string{
+					stackdriverProjectID:            "proj1",
+					stackdriverGenericNodeNamespace: "namespace1",
+					resourcekeys.CloudKeyZone:       "zone1",
+					resourcekeys.HostKeyName:        "host1",
+				},
+			},
+			want: &monitoredrespb.MonitoredResource{
+				Type: "generic_node",
+				Labels: map[string]string{
+					"project_id": "proj1",
+					"location":   "zone1",
+					"namespace":  "namespace1",
+					"node_id":    "host1",
+				},
+			},
+		},
+		{
+			input: &resource.Resource{
+				Type: resourcekeys.HostType,
+				Labels: map[string]string{
+					stackdriverProjectID:      "proj1",
+					resourcekeys.CloudKeyZone: "zone1",
+					resourcekeys.HostKeyName:  "host1",
+				},
+			},
+			want: &monitoredrespb.MonitoredResource{
+				Type: "global",
+				Labels: map[string]string{
+					"project_id": "proj1",
+				},
+			},
+		},
+		{
+			input: &resource.Resource{
+				Type: cloudFunctionType,
+				Labels: map[string]string{
+					stackdriverProjectID:        "proj1",
+					resourcekeys.CloudKeyRegion: "region1",
+					faasName:                    "function1",
+				},
+			},
+			want: &monitoredrespb.MonitoredResource{
+				Type: "cloud_function",
+				Labels: map[string]string{
+					"project_id":    "proj1",
+					"region":        "region1",
+					"function_name": "function1",
+				},
+			},
+		},
+		{
+			input: &resource.Resource{
+				Labels: map[string]string{
+					stackdriverProjectID:          "proj1",
+					resourcekeys.CloudKeyProvider: resourcekeys.CloudProviderGCP,
+					cloudPlatform:                 cloudPlatformCloudFunctions,
+					resourcekeys.CloudKeyRegion:   "region1",
+					faasName:                      "function1",
+				},
+			},
+			want: &monitoredrespb.MonitoredResource{
+				Type: "cloud_function",
+				Labels: map[string]string{
+					"project_id":    "proj1",
+					"region":        "region1",
+					"function_name": "function1",
+				},
+			},
+		},
+		{
+			input: &resource.Resource{
+				Type:   cloudFunctionType,
+				Labels: map[string]string{},
+			},
+			autoRes: &gcp.CloudFunction{
+				ProjectID:    "proj1",
+				FunctionName: "function1",
+				Region:       "region1",
+			},
+			want: &monitoredrespb.MonitoredResource{
+				Type: "cloud_function",
+				Labels: map[string]string{
+					"project_id":    "proj1",
+					"region":        "region1",
+					"function_name": "function1",
+				},
+			},
+		},
 	}
 	for i, c := range cases {
 		t.Run(fmt.Sprintf("case-%d", i), func(t *testing.T) {
@@ -485,3 +859,64 @@ func TestDefaultMapResource(t *testing.T) {
 		})
 	}
 }
+
+func TestGenerateTaskID(t *testing.T) {
+	v := &view.View{
+		Name:        "generated_task_id_view",
+		Measure:     stats.Int64("test-measure/TestGenerateTaskID", "measure desc", stats.UnitDimensionless),
+		Aggregation: view.Count(),
+	}
+	data := &view.CountData{Value: 1}
+	vds := []*view.Data{newTestViewData(v, time.Now(), time.Now(), data, data)}
+	newResource := func(taskID string) *monitoredrespb.MonitoredResource {
+		labels := map[string]string{
+			"project_id": "proj1",
+			"location":   "zone1",
+			"namespace":  "namespace1",
+			"job":        "job1",
+		}
+		if taskID != "" {
+			labels["task_id"] = taskID
+		}
+		return &monitoredrespb.MonitoredResource{Type: "generic_task", Labels: labels}
+	}
+	taskIDs := func(o Options) []string {
+		e := &statsExporter{o: o}
+		var ids []string
+		for i := 0; i < 2; i++ {
+			for _, req := range e.makeReq(vds, maxTimeSeriesPerUpload) {
+				for _, ts := range req.TimeSeries {
+					ids = append(ids, ts.Resource.Labels["task_id"])
+				}
+			}
+		}
+		return ids
+	}
+
+	rsc := newResource("")
+	ids := taskIDs(Options{ProjectID: "proj1", Resource: rsc, GenerateTaskID: true})
+	uuid := regexp.MustCompile(`^[0-9a-f]{8}-[0-9a-f]{4}-4[0-9a-f]{3}-[89ab][0-9a-f]{3}-[0-9a-f]{12}$`)
+	if len(ids) != 4 || !uuid.MatchString(ids[0]) {
+		t.Fatalf("task_id = %v; want a UUID for every time series", ids)
+	}
+	for _, id := range ids {
+		if id != ids[0] {
+			t.Errorf("task_id = %v; want the same UUID for every time series", ids)
+			break
+		}
+	}
+	if _, ok := rsc.Labels["task_id"]; ok {
+		t.Errorf("Options.Resource was modified: %v", rsc)
+	}
+
+	for _, id := range taskIDs(Options{ProjectID: "proj1", Resource: newResource("task1"), GenerateTaskID: true}) {
+		if id != "task1" {
+			t.Errorf("task_id = %q; want the existing task_id %q", id, "task1")
+		}
+	}
+	for _, id := range taskIDs(Options{ProjectID: "proj1", Resource: newResource("")}) {
+		if id != "" {
+			t.Errorf("task_id = %q; want none without GenerateTaskID", id)
+		}
+	}
+}
@@ -0,0 +1,128 @@
+// Copyright 2024, OpenCensus Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package stackdriver
+
+import (
+	"context"
+	"time"
+
+	monitoring "cloud.google.com/go/monitoring/apiv3/v2"
+	monitoringpb "google.golang.org/genproto/googleapis/monitoring/v3" //nolint: staticcheck
+	"google.golang.org/genproto/googleapis/rpc/errdetails"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+const (
+	defaultRetryInitialBackoff = 100 * time.Millisecond
+	defaultRetryMaxBackoff     = 5 * time.Second
+	defaultRetryMultiplier     = 2
+)
+
+// RetryPolicy configures how CreateTimeSeries and CreateServiceTimeSeries calls
+// that fail with a transient error (Unavailable or DeadlineExceeded) are
+// retried with exponential backoff. Calls that partially succeeded are not
+// retried, since resending their written points would be rejected.
+//
+// When the error of a call carries a RetryInfo detail, such as the
+// ResourceExhausted errors of Stackdriver Monitoring, the call is retried
+// after the delay suggested by the server instead of the backoff.
+type RetryPolicy struct {
+	// MaxAttempts is the maximum number of calls, the first one included.
+	// Values lower than 2 disable retries.
+	MaxAttempts int
+
+	// InitialBackoff is the delay before the first retry.
+	// If it is not set, 100ms is used.
+	InitialBackoff time.Duration
+
+	// MaxBackoff caps the delay between two calls.
+	// If it is not set, 5s is used.
+	MaxBackoff time.Duration
+
+	// Multiplier is the factor applied to the delay after each retry.
+	// If it is lower than 1, 2 is used.
+	Multiplier float64
+}
+
+// retryableCodes are the gRPC codes of the failures that are safe to retry.
+var retryableCodes = map[codes.Code]bool{
+	codes.Unavailable:      true,
+	codes.DeadlineExceeded: true,
+}
+
+// wrap returns create retried according to p. Only the error of the last call
+// is returned, so the time series dropped by a retried request are counted once.
+// No retry is made if it could not complete before the deadline of the context,
+// or once budget is used up.
+func (p *RetryPolicy) wrap(
+	create func(context.Context, *monitoring.MetricClient, *monitoringpb.CreateTimeSeriesRequest) error, //nolint: staticcheck
+	budget *retryBudget,
+) func(context.Context, *monitoring.MetricClient, *monitoringpb.CreateTimeSeriesRequest) error { //nolint: staticcheck
+	if p == nil || p.MaxAttempts < 2 {
+		return create
+	}
+	initial, maxBackoff, multiplier := p.InitialBackoff, p.MaxBackoff, p.Multiplier
+	if initial <= 0 {
+		initial = defaultRetryInitialBackoff
+	}
+	if maxBackoff <= 0 {
+		maxBackoff = defaultRetryMaxBackoff
+	}
+	if multiplier < 1 {
+		multiplier = defaultRetryMultiplier
+	}
+	return func(ctx context.Context, c *monitoring.MetricClient, req *monitoringpb.CreateTimeSeriesRequest) error { //nolint: staticcheck
+		backoff := initial
+		for attempt := 1; ; attempt++ {
+			err := create(ctx, c, req)
+			if err == nil || attempt >= p.MaxAttempts {
+				return err
+			}
+			if backoff > maxBackoff {
+				backoff = maxBackoff
+			}
+			delay, ok := serverRetryDelay(err)
+			if !ok {
+				if !retryableCodes[status.Code(err)] {
+					return err
+				}
+				delay = backoff
+			}
+			if deadline, ok := ctx.Deadline(); ok && time.Until(deadline) <= delay {
+				return err
+			}
+			if !budget.take() {
+				return err
+			}
+			select {
+			case <-ctx.Done():
+				return err
+			case <-time.After(delay):
+			}
+			backoff = time.Duration(float64(backoff) * multiplier)
+		}
+	}
+}
+
+// serverRetryDelay returns the retry delay of the RetryInfo detail of err, if any.
+func serverRetryDelay(err error) (time.Duration, bool) {
+	for _, detail := range status.Convert(err).Details() {
+		if info, ok := detail.(*errdetails.RetryInfo); ok && info.GetRetryDelay() != nil {
+			return info.GetRetryDelay().AsDuration(), true
+		}
+	}
+	return 0, false
+}
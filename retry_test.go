@@ -0,0 +1,131 @@
+// Copyright 2024, OpenCensus Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package stackdriver
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	monitoring "cloud.google.com/go/monitoring/apiv3/v2"
+	monitoringpb "google.golang.org/genproto/googleapis/monitoring/v3" //nolint: staticcheck
+	"google.golang.org/genproto/googleapis/rpc/errdetails"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/types/known/durationpb"
+)
+
+func TestSendReqRetryPolicy(t *testing.T) {
+	policy := &RetryPolicy{MaxAttempts: 3, InitialBackoff: time.Millisecond}
+	tests := []struct {
+		name        string
+		failures    []codes.Code
+		wantCalls   int
+		wantDropped int
+		wantErrs    int
+	}{
+		{name: "fails twice then succeeds", failures: []codes.Code{codes.Unavailable, codes.DeadlineExceeded}, wantCalls: 3},
+		{name: "attempts exhausted", failures: []codes.Code{codes.Unavailable, codes.Unavailable, codes.Unavailable}, wantCalls: 3, wantDropped: 4, wantErrs: 1},
+		{name: "not retryable", failures: []codes.Code{codes.InvalidArgument}, wantCalls: 1, wantDropped: 4, wantErrs: 1},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			calls := 0
+			persisted := createTimeSeries
+			createTimeSeries = func(ctx context.Context, c *monitoring.MetricClient, req *monitoringpb.CreateTimeSeriesRequest) error { //nolint: staticcheck
+				calls++
+				if calls <= len(tt.failures) {
+					return status.Error(tt.failures[calls-1], "failed")
+				}
+				return nil
+			}
+			defer func() { createTimeSeries = persisted }()
+
+			mc, _ := monitoring.NewMetricClient(context.Background())
+			req := &monitoringpb.CreateTimeSeriesRequest{TimeSeries: makeTs(4, false)} //nolint: staticcheck
+			d, errs := sendReq(context.Background(), mc, req, sendOptions{retryPolicy: policy})
+			if calls != tt.wantCalls {
+				t.Errorf("Got %d CreateTimeSeries calls, want %d", calls, tt.wantCalls)
+			}
+			if d != tt.wantDropped || len(errs) != tt.wantErrs {
+				t.Errorf("Got %d dropped and %v, want %d dropped and %d errors", d, errs, tt.wantDropped, tt.wantErrs)
+			}
+		})
+	}
+}
+
+func TestRetryPolicyRespectsDeadline(t *testing.T) {
+	calls := 0
+	create := func(ctx context.Context, c *monitoring.MetricClient, req *monitoringpb.CreateTimeSeriesRequest) error { //nolint: staticcheck
+		calls++
+		return status.Error(codes.Unavailable, "unavailable")
+	}
+	policy := &RetryPolicy{MaxAttempts: 5, InitialBackoff: time.Second}
+	ctx, cancel := context.WithTimeout(context.Background(), 100*time.Millisecond)
+	defer cancel()
+	if err := policy.wrap(create, nil)(ctx, nil, &monitoringpb.CreateTimeSeriesRequest{}); status.Code(err) != codes.Unavailable { //nolint: staticcheck
+		t.Errorf("Got error %v, want the Unavailable error", err)
+	}
+	if calls != 1 {
+		t.Errorf("Got %d calls, want no retry past the deadline", calls)
+	}
+}
+
+func TestRetryPolicyRespectsBudget(t *testing.T) {
+	calls := 0
+	create := func(ctx context.Context, c *monitoring.MetricClient, req *monitoringpb.CreateTimeSeriesRequest) error { //nolint: staticcheck
+		calls++
+		return status.Error(codes.Unavailable, "unavailable")
+	}
+	policy := &RetryPolicy{MaxAttempts: 5, InitialBackoff: time.Millisecond}
+	budget := Options{FlushRetryBudget: 2}.newRetryBudget()
+	wrapped := policy.wrap(create, budget)
+	for i := 0; i < 2; i++ {
+		if err := wrapped(context.Background(), nil, &monitoringpb.CreateTimeSeriesRequest{}); status.Code(err) != codes.Unavailable { //nolint: staticcheck
+			t.Errorf("Got error %v, want the Unavailable error", err)
+		}
+	}
+	if want := 4; calls != want {
+		t.Errorf("Got %d calls, want %d: two first calls and the two retries of the budget", calls, want)
+	}
+}
+
+func TestRetryPolicyServerRetryDelay(t *testing.T) {
+	const retryDelay = 50 * time.Millisecond
+	st, err := status.New(codes.ResourceExhausted, "quota exceeded").WithDetails(&errdetails.RetryInfo{
+		RetryDelay: durationpb.New(retryDelay),
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	var calls []time.Time
+	create := func(ctx context.Context, c *monitoring.MetricClient, req *monitoringpb.CreateTimeSeriesRequest) error { //nolint: staticcheck
+		calls = append(calls, time.Now())
+		if len(calls) == 1 {
+			return st.Err()
+		}
+		return nil
+	}
+	policy := &RetryPolicy{MaxAttempts: 2, InitialBackoff: time.Millisecond}
+	if err := policy.wrap(create, nil)(context.Background(), nil, &monitoringpb.CreateTimeSeriesRequest{}); err != nil { //nolint: staticcheck
+		t.Fatalf("Got error %v, want the retry to succeed", err)
+	}
+	if len(calls) != 2 {
+		t.Fatalf("Got %d calls, want 2", len(calls))
+	}
+	if waited := calls[1].Sub(calls[0]); waited < retryDelay {
+		t.Errorf("Retried after %v, want at least the suggested %v", waited, retryDelay)
+	}
+}
@@ -21,16 +21,41 @@ import (
 
 const labelKeySizeLimit = 100
 
+// SanitizeRemoveInvalid is the Options.SanitizeReplacement that removes the
+// characters of label keys that are not letters or digits.
+const SanitizeRemoveInvalid = "\x00"
+
 // sanitize returns a string that is trunacated to 100 characters if it's too
 // long, and replaces non-alphanumeric characters to underscores.
 func sanitize(s string) string {
+	return sanitizeWith(s, "_")
+}
+
+// sanitizeWith is like sanitize but replaces the characters other than
+// letters, digits and underscores with replacement. Keys left empty are
+// replaced with "key".
+func sanitizeWith(s, replacement string) string {
 	if len(s) == 0 {
 		return s
 	}
 	if len(s) > labelKeySizeLimit {
 		s = s[:labelKeySizeLimit]
 	}
-	s = strings.Map(sanitizeRune, s)
+	var b strings.Builder
+	for _, r := range s {
+		if sanitizeRune(r) == r {
+			b.WriteRune(r)
+		} else {
+			b.WriteString(replacement)
+		}
+	}
+	s = b.String()
+	if len(s) > labelKeySizeLimit {
+		s = s[:labelKeySizeLimit]
+	}
+	if len(s) == 0 {
+		return "key"
+	}
 	if unicode.IsDigit(rune(s[0])) {
 		s = "key_" + s
 	}
@@ -40,6 +65,32 @@ func sanitize(s string) string {
 	return s
 }
 
+// sanitizeReplacement returns the replacement of the invalid characters of
+// label keys, as configured by SanitizeReplacement.
+func (o Options) sanitizeReplacement() string {
+	switch o.SanitizeReplacement {
+	case "":
+		return "_"
+	case SanitizeRemoveInvalid:
+		return ""
+	}
+	return strings.Map(sanitizeRune, o.SanitizeReplacement)
+}
+
+// sanitize returns the label key s sanitized with the configured replacement.
+func (o Options) sanitize(s string) string {
+	return sanitizeWith(s, o.sanitizeReplacement())
+}
+
+// sanitizeLabelValue returns the value of the label key as transformed by
+// SanitizeLabelValue, if set.
+func (o Options) sanitizeLabelValue(key, value string) string {
+	if o.SanitizeLabelValue == nil {
+		return value
+	}
+	return o.SanitizeLabelValue(key, value)
+}
+
 // converts anything that is not a letter or digit to an underscore
 func sanitizeRune(r rune) rune {
 	if unicode.IsLetter(r) || unicode.IsDigit(r) {
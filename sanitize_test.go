@@ -17,6 +17,11 @@ package stackdriver
 import (
 	"strings"
 	"testing"
+
+	metricspb "github.com/census-instrumentation/opencensus-proto/gen-go/metrics/v1"
+	"github.com/google/go-cmp/cmp"
+	"go.opencensus.io/metric/metricdata"
+	"go.opencensus.io/tag"
 )
 
 func TestSanitize(t *testing.T) {
@@ -65,3 +70,128 @@ func TestSanitize(t *testing.T) {
 		})
 	}
 }
+
+func TestSanitizeReplacement(t *testing.T) {
+	tests := []struct {
+		name        string
+		replacement string
+		input       string
+		want        string
+	}{
+		{
+			name:  "default replacement",
+			input: "test/key-1",
+			want:  "test_key_1",
+		},
+		{
+			name:        "custom replacement",
+			replacement: "x",
+			input:       "test/key-1",
+			want:        "testxkeyx1",
+		},
+		{
+			name:        "underscores are kept",
+			replacement: "x",
+			input:       "test_key",
+			want:        "test_key",
+		},
+		{
+			name:        "invalid replacement characters",
+			replacement: "-",
+			input:       "test/key",
+			want:        "test_key",
+		},
+		{
+			name:        "remove invalid characters",
+			replacement: SanitizeRemoveInvalid,
+			input:       "test/key-1",
+			want:        "testkey1",
+		},
+		{
+			name:        "leading digit after removal",
+			replacement: SanitizeRemoveInvalid,
+			input:       "/1key",
+			want:        "key_1key",
+		},
+		{
+			name:        "leading digit replacement",
+			replacement: "0",
+			input:       "/key",
+			want:        "key_0key",
+		},
+		{
+			name:        "all invalid removed",
+			replacement: SanitizeRemoveInvalid,
+			input:       "/-.",
+			want:        "key",
+		},
+		{
+			name:        "all invalid replaced",
+			replacement: "x",
+			input:       "/-.",
+			want:        "xxx",
+		},
+		{
+			name:        "long replacement is truncated",
+			replacement: "xx",
+			input:       strings.Repeat("/", 100),
+			want:        strings.Repeat("x", 100),
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			o := Options{SanitizeReplacement: tt.replacement}
+			if got, want := o.sanitize(tt.input), tt.want; got != want {
+				t.Errorf("sanitize() = %q; want %q", got, want)
+			}
+		})
+	}
+}
+
+func TestSanitizeLabelValue(t *testing.T) {
+	e := &statsExporter{o: Options{
+		SanitizeLabelValue: func(key, value string) string {
+			if len(value) > 5 {
+				value = value[:5]
+			}
+			return strings.ToLower(value)
+		},
+	}}
+	defaults := map[string]labelValue{"default/key": {val: "DEFAULT-VALUE"}}
+	want := map[string]string{
+		"default_key": "defau",
+		"test_key":    "hello",
+	}
+
+	t.Run("newLabels", func(t *testing.T) {
+		got := e.newLabels(defaults, []tag.Tag{{Key: tag.MustNewKey("test-key"), Value: "HELLO WORLD"}})
+		if diff := cmp.Diff(got, want); diff != "" {
+			t.Errorf("newLabels() -got +want: %s", diff)
+		}
+	})
+
+	t.Run("metricLabelsToTsLabels", func(t *testing.T) {
+		got, err := e.metricLabelsToTsLabels(defaults,
+			[]metricdata.LabelKey{{Key: "test-key"}},
+			[]metricdata.LabelValue{metricdata.NewLabelValue("HELLO WORLD")})
+		if err != nil {
+			t.Fatalf("metricLabelsToTsLabels() error = %v", err)
+		}
+		if diff := cmp.Diff(got, want); diff != "" {
+			t.Errorf("metricLabelsToTsLabels() -got +want: %s", diff)
+		}
+	})
+
+	t.Run("labelsPerTimeSeries", func(t *testing.T) {
+		got, err := e.labelsPerTimeSeries(map[string]labelValue{"default_key": {val: "DEFAULT-VALUE"}},
+			[]string{"test_key"},
+			[]*metricspb.LabelValue{{Value: "HELLO WORLD", HasValue: true}})
+		if err != nil {
+			t.Fatalf("labelsPerTimeSeries() error = %v", err)
+		}
+		if diff := cmp.Diff(got, want); diff != "" {
+			t.Errorf("labelsPerTimeSeries() -got +want: %s", diff)
+		}
+	})
+}
@@ -0,0 +1,68 @@
+// Copyright 2024, OpenCensus Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package stackdriver
+
+import (
+	"context"
+	"path"
+	"time"
+
+	"go.opencensus.io/stats"
+	"go.opencensus.io/stats/view"
+)
+
+// defaultSelfMetricsNamespace is the prefix of the names of the measures and
+// views recorded by the exporter about itself when Options.SelfMetricsNamespace
+// is not set.
+const defaultSelfMetricsNamespace = "opencensus.io/stackdriver_exporter"
+
+// exportLatencyBounds are the bucket bounds, in milliseconds, of the export
+// latency view.
+var exportLatencyBounds = []float64{1, 2, 5, 10, 20, 50, 100, 200, 500, 1000, 2000, 5000, 10000, 20000}
+
+// selfMetrics records metrics about the exporter itself.
+// A nil *selfMetrics records nothing.
+type selfMetrics struct {
+	exportLatency *stats.Float64Measure
+}
+
+// newSelfMetrics creates the measures of the exporter under namespace and
+// registers their views.
+func newSelfMetrics(namespace string) (*selfMetrics, error) {
+	if namespace == "" {
+		namespace = defaultSelfMetricsNamespace
+	}
+	m := &selfMetrics{
+		exportLatency: stats.Float64(path.Join(namespace, "export_latency"), "Latency of CreateTimeSeries calls", stats.UnitMilliseconds),
+	}
+	err := view.Register(&view.View{
+		Name:        m.exportLatency.Name(),
+		Description: m.exportLatency.Description(),
+		Measure:     m.exportLatency,
+		Aggregation: view.Distribution(exportLatencyBounds...),
+	})
+	if err != nil {
+		return nil, err
+	}
+	return m, nil
+}
+
+// recordExportLatency records the latency of a CreateTimeSeries call.
+func (m *selfMetrics) recordExportLatency(ctx context.Context, latency time.Duration) {
+	if m == nil {
+		return
+	}
+	stats.Record(ctx, m.exportLatency.M(float64(latency)/float64(time.Millisecond)))
+}
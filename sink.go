@@ -0,0 +1,113 @@
+// Copyright 2024, OpenCensus Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package stackdriver
+
+import (
+	"context"
+	"fmt"
+
+	monitoring "cloud.google.com/go/monitoring/apiv3/v2"
+	metricpb "google.golang.org/genproto/googleapis/api/metric"
+	monitoringpb "google.golang.org/genproto/googleapis/monitoring/v3" //nolint: staticcheck
+)
+
+// TimeSeriesSink receives the requests that the exporter would otherwise send
+// to Stackdriver Monitoring, e.g. to write the converted metrics to a file or
+// to another system.
+//
+// A TimeSeriesSink may also implement
+//
+//	GetMetricDescriptor(context.Context, *monitoringpb.GetMetricDescriptorRequest) (*metricpb.MetricDescriptor, error)
+//
+// which is required to use it with Options.RequireExistingDescriptor, and
+//
+//	DeleteMetricDescriptor(context.Context, *monitoringpb.DeleteMetricDescriptorRequest) error
+//
+// which is required to use it with Options.OverwriteMetricDescriptors and
+// Exporter.DeleteMetricDescriptor.
+type TimeSeriesSink interface {
+	CreateTimeSeries(ctx context.Context, req *monitoringpb.CreateTimeSeriesRequest) error                                           //nolint: staticcheck
+	CreateServiceTimeSeries(ctx context.Context, req *monitoringpb.CreateTimeSeriesRequest) error                                    //nolint: staticcheck
+	CreateMetricDescriptor(ctx context.Context, req *monitoringpb.CreateMetricDescriptorRequest) (*metricpb.MetricDescriptor, error) //nolint: staticcheck
+}
+
+type metricDescriptorGetter interface {
+	GetMetricDescriptor(ctx context.Context, req *monitoringpb.GetMetricDescriptorRequest) (*metricpb.MetricDescriptor, error) //nolint: staticcheck
+}
+
+type metricDescriptorDeleter interface {
+	DeleteMetricDescriptor(ctx context.Context, req *monitoringpb.DeleteMetricDescriptorRequest) error //nolint: staticcheck
+}
+
+// createTimeSeriesFunc returns the function sending CreateTimeSeries requests
+// to sink, or to Stackdriver Monitoring if sink is nil.
+func createTimeSeriesFunc(sink TimeSeriesSink) func(context.Context, *monitoring.MetricClient, *monitoringpb.CreateTimeSeriesRequest) error { //nolint: staticcheck
+	if sink == nil {
+		return createTimeSeries
+	}
+	return func(ctx context.Context, _ *monitoring.MetricClient, req *monitoringpb.CreateTimeSeriesRequest) error { //nolint: staticcheck
+		return sink.CreateTimeSeries(ctx, req)
+	}
+}
+
+// createServiceTimeSeriesFunc returns the function sending
+// CreateServiceTimeSeries requests to sink, or to Stackdriver Monitoring if
+// sink is nil.
+func createServiceTimeSeriesFunc(sink TimeSeriesSink) func(context.Context, *monitoring.MetricClient, *monitoringpb.CreateTimeSeriesRequest) error { //nolint: staticcheck
+	if sink == nil {
+		return createServiceTimeSeries
+	}
+	return func(ctx context.Context, _ *monitoring.MetricClient, req *monitoringpb.CreateTimeSeriesRequest) error { //nolint: staticcheck
+		return sink.CreateServiceTimeSeries(ctx, req)
+	}
+}
+
+// sendCreateMetricDescriptor sends mdr to the sink of e, or to Stackdriver
+// Monitoring if there is none, at the pace set by Options.MetricDescriptorQPS.
+func (e *statsExporter) sendCreateMetricDescriptor(ctx context.Context, mdr *monitoringpb.CreateMetricDescriptorRequest) (*metricpb.MetricDescriptor, error) { //nolint: staticcheck
+	if err := e.mdLimiter.wait(ctx); err != nil {
+		return nil, err
+	}
+	if e.o.Sink == nil {
+		return createMetricDescriptor(ctx, e.c, mdr)
+	}
+	return e.o.Sink.CreateMetricDescriptor(ctx, mdr)
+}
+
+// sendGetMetricDescriptor sends mdr to the sink of e, or to Stackdriver
+// Monitoring if there is none.
+func (e *statsExporter) sendGetMetricDescriptor(ctx context.Context, mdr *monitoringpb.GetMetricDescriptorRequest) (*metricpb.MetricDescriptor, error) { //nolint: staticcheck
+	if e.o.Sink == nil {
+		return getMetricDescriptor(ctx, e.c, mdr)
+	}
+	getter, ok := e.o.Sink.(metricDescriptorGetter)
+	if !ok {
+		return nil, fmt.Errorf("sink %T does not implement GetMetricDescriptor", e.o.Sink)
+	}
+	return getter.GetMetricDescriptor(ctx, mdr)
+}
+
+// sendDeleteMetricDescriptor sends mdr to the sink of e, or to Stackdriver
+// Monitoring if there is none.
+func (e *statsExporter) sendDeleteMetricDescriptor(ctx context.Context, mdr *monitoringpb.DeleteMetricDescriptorRequest) error { //nolint: staticcheck
+	if e.o.Sink == nil {
+		return deleteMetricDescriptor(ctx, e.c, mdr)
+	}
+	deleter, ok := e.o.Sink.(metricDescriptorDeleter)
+	if !ok {
+		return fmt.Errorf("sink %T does not implement DeleteMetricDescriptor", e.o.Sink)
+	}
+	return deleter.DeleteMetricDescriptor(ctx, mdr)
+}
@@ -0,0 +1,147 @@
+// Copyright 2024, OpenCensus Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package stackdriver
+
+import (
+	"context"
+	"sort"
+	"sync"
+	"testing"
+	"time"
+
+	metricspb "github.com/census-instrumentation/opencensus-proto/gen-go/metrics/v1"
+	"github.com/golang/protobuf/ptypes/timestamp"
+	"github.com/google/go-cmp/cmp"
+	"go.opencensus.io/metric/metricdata"
+	"go.opencensus.io/stats"
+	"go.opencensus.io/stats/view"
+	metricpb "google.golang.org/genproto/googleapis/api/metric"
+	monitoringpb "google.golang.org/genproto/googleapis/monitoring/v3" //nolint: staticcheck
+)
+
+// recordingSink is a TimeSeriesSink recording the metric types of the requests
+// it receives.
+type recordingSink struct {
+	mu          sync.Mutex
+	timeSeries  []string
+	descriptors []string
+}
+
+func (s *recordingSink) CreateTimeSeries(ctx context.Context, req *monitoringpb.CreateTimeSeriesRequest) error { //nolint: staticcheck
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.timeSeries = append(s.timeSeries, requestMetricTypes(req)...)
+	return nil
+}
+
+func (s *recordingSink) CreateServiceTimeSeries(ctx context.Context, req *monitoringpb.CreateTimeSeriesRequest) error { //nolint: staticcheck
+	return s.CreateTimeSeries(ctx, req)
+}
+
+func (s *recordingSink) CreateMetricDescriptor(ctx context.Context, req *monitoringpb.CreateMetricDescriptorRequest) (*metricpb.MetricDescriptor, error) { //nolint: staticcheck
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.descriptors = append(s.descriptors, req.MetricDescriptor.Type)
+	return req.MetricDescriptor, nil
+}
+
+func (s *recordingSink) received() (timeSeries, descriptors []string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	timeSeries = append(timeSeries, s.timeSeries...)
+	descriptors = append(descriptors, s.descriptors...)
+	sort.Strings(timeSeries)
+	sort.Strings(descriptors)
+	return timeSeries, descriptors
+}
+
+func TestSink(t *testing.T) {
+	sink := &recordingSink{}
+	// No client is created, so no credentials are needed.
+	se, err := newStatsExporter(Options{
+		ProjectID:               "sink",
+		Sink:                    sink,
+		DefaultMonitoringLabels: &Labels{},
+		MapResource:             DefaultMapResource,
+	})
+	if err != nil {
+		t.Fatalf("Failed to create the statsExporter: %v", err)
+	}
+	defer se.close()
+
+	now := time.Now()
+	v := &view.View{
+		Name:        "sink_view",
+		Measure:     stats.Int64("test-measure/TestSink", "measure desc", stats.UnitDimensionless),
+		Aggregation: view.Count(),
+	}
+	data := &view.CountData{Value: 1}
+	if err := se.uploadStats([]*view.Data{newTestViewData(v, now, now, data, data)}); err != nil {
+		t.Fatalf("uploadStats() error = %v", err)
+	}
+
+	metric := &metricdata.Metric{
+		Descriptor: metricdata.Descriptor{Name: "sink_metric", Type: metricdata.TypeCumulativeInt64},
+		TimeSeries: []*metricdata.TimeSeries{{
+			StartTime: now.Add(-time.Minute),
+			Points:    []metricdata.Point{metricdata.NewInt64Point(now, 1)},
+		}},
+	}
+	if err := se.uploadMetrics(context.Background(), []*metricdata.Metric{metric}); err != nil {
+		t.Fatalf("uploadMetrics() error = %v", err)
+	}
+
+	protoMetric := &metricspb.Metric{
+		MetricDescriptor: &metricspb.MetricDescriptor{Name: "sink_proto", Type: metricspb.MetricDescriptor_CUMULATIVE_INT64},
+		Timeseries: []*metricspb.TimeSeries{{
+			StartTimestamp: &timestamp.Timestamp{Seconds: now.Unix() - 60},
+			Points: []*metricspb.Point{{
+				Timestamp: &timestamp.Timestamp{Seconds: now.Unix()},
+				Value:     &metricspb.Point_Int64Value{Int64Value: 1},
+			}},
+		}},
+	}
+	if _, err := se.PushMetricsProto(context.Background(), nil, nil, []*metricspb.Metric{protoMetric}); err != nil {
+		t.Fatalf("PushMetricsProto() error = %v", err)
+	}
+
+	gotTimeSeries, gotDescriptors := sink.received()
+	wantTimeSeries := []string{
+		"custom.googleapis.com/opencensus/sink_metric",
+		"custom.googleapis.com/opencensus/sink_proto",
+		"custom.googleapis.com/opencensus/sink_view",
+	}
+	if diff := cmp.Diff(gotTimeSeries, wantTimeSeries); diff != "" {
+		t.Errorf("time series -got +want: %s", diff)
+	}
+	wantDescriptors := []string{
+		"custom.googleapis.com/opencensus/sink_metric",
+		"custom.googleapis.com/opencensus/sink_proto",
+		"custom.googleapis.com/opencensus/sink_view",
+	}
+	if diff := cmp.Diff(gotDescriptors, wantDescriptors); diff != "" {
+		t.Errorf("metric descriptors -got +want: %s", diff)
+	}
+}
+
+func TestSinkRequireExistingDescriptor(t *testing.T) {
+	se := &statsExporter{
+		o: Options{ProjectID: "sink", Sink: &recordingSink{}, RequireExistingDescriptor: true},
+	}
+	md := &metricpb.MetricDescriptor{Type: "custom.googleapis.com/opencensus/sink_view"}
+	if err := se.createMetricDescriptor(context.Background(), md); err == nil {
+		t.Error("createMetricDescriptor() error = nil; want an error for a sink without GetMetricDescriptor")
+	}
+}
@@ -64,12 +64,17 @@ import (
 	"go.opencensus.io/resource"
 	"go.opencensus.io/resource/resourcekeys"
 	"go.opencensus.io/stats/view"
+	"go.opencensus.io/tag"
 	"go.opencensus.io/trace"
 	"golang.org/x/oauth2/google"
 	"google.golang.org/api/option"
 	metricpb "google.golang.org/genproto/googleapis/api/metric"
 	monitoredrespb "google.golang.org/genproto/googleapis/api/monitoredres"
+	monitoringpb "google.golang.org/genproto/googleapis/monitoring/v3" //nolint: staticcheck
+	"google.golang.org/grpc"
 	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/encoding/gzip"
+	"google.golang.org/grpc/keepalive"
 	"google.golang.org/grpc/status"
 
 	commonpb "github.com/census-instrumentation/opencensus-proto/gen-go/agent/common/v1"
@@ -115,6 +120,19 @@ type Options struct {
 	// Optional.
 	TraceClientOptions []option.ClientOption
 
+	// CompressTraces compresses the requests of the Stackdriver Trace API client
+	// with gzip, which reduces the egress of large span batches at the cost of
+	// some CPU.
+	// Optional.
+	CompressTraces bool
+
+	// KeepaliveParams, if set, configures gRPC keepalive pings on the connections
+	// of the Stackdriver Monitoring and Trace API clients, so that dead
+	// connections are detected promptly. It has no effect on connections passed
+	// with option.WithGRPCConn.
+	// Optional.
+	KeepaliveParams *keepalive.ClientParameters
+
 	// BundleDelayThreshold determines the max amount of time
 	// the exporter can wait before uploading view data or trace spans to
 	// the backend.
@@ -176,6 +194,34 @@ type Options struct {
 	// Optional, but encouraged.
 	MonitoredResource monitoredresource.Interface
 
+	// GlobalResourceProjectConflict configures what NewExporter does when the
+	// monitored resource is "global" and its "project_id" label differs from
+	// ProjectID, in which case the data is written to ProjectID while the
+	// resource names another project.
+	// If unset, the conflict is ignored.
+	// Optional.
+	GlobalResourceProjectConflict ResourceProjectConflictPolicy
+
+	// FailOnGlobalResource makes NewExporter return an error when no resource
+	// is set through Resource, MonitoredResource or ResourceByDescriptor and
+	// ResourceDetector does not detect a resource other than "global", so that
+	// a misconfigured environment is not silently exported as "global".
+	// Optional.
+	FailOnGlobalResource bool
+
+	// OnResourceDetected, if set, is called once by NewExporter with the default
+	// monitored resource of the exporter, as set or detected and mapped, or the
+	// DefaultMonitoredResource if there is none. It helps finding out why metrics are
+	// written to an unexpected resource.
+	// Optional.
+	OnResourceDetected func(*monitoredrespb.MonitoredResource)
+
+	// DefaultMonitoredResource is the monitored resource used instead of the
+	// "global" resource when no resource is set or detected, or when MapResource
+	// maps a resource to "global", e.g. a generic_node resource with fixed labels.
+	// Optional. If unset, the "global" resource is used.
+	DefaultMonitoredResource *monitoredrespb.MonitoredResource
+
 	// ResourceDetector provides a hook to discover arbitrary resource information.
 	//
 	// The translation function provided in MapResource must be able to conver the
@@ -216,10 +262,123 @@ type Options struct {
 	// See: https://cloud.google.com/monitoring/api/ref_v3/rest/v3/projects.metricDescriptors#MetricDescriptor
 	GetMetricPrefix func(name string) string
 
+	// GetMetricPrefixForView allows customizing the metric prefix for the given view,
+	// e.g. to place the views of one subsystem under a different domain than the rest.
+	// Unlike GetMetricType, it only returns the prefix; the view name is appended to it.
+	// If the returned prefix has no domain, "custom.googleapis.com/opencensus/" is prepended.
+	// It is ignored if GetMetricType is set.
+	// Optional.
+	GetMetricPrefixForView func(view *view.View) string
+
+	// IncludeOriginalNameLabel adds the OpenCensus view or metric name, before
+	// any prefixing or sanitization, as a label on every time series and on the
+	// metric descriptor. The label is not added to metrics that already have
+	// as many labels as Stackdriver Monitoring allows; the first export of such
+	// a metric reports the dropped labels through OnError.
+	// Optional.
+	IncludeOriginalNameLabel bool
+
+	// OriginalNameLabelKey is the key of the label added by IncludeOriginalNameLabel.
+	// If it is empty, "opencensus_metric_name" is used.
+	// Optional.
+	OriginalNameLabelKey string
+
+	// IncludeExporterVersionLabel adds the version of this exporter as an
+	// "exporter_version" label on every time series and on the metric descriptor.
+	// Like the original name label, it is not added to metrics that already have
+	// as many labels as Stackdriver Monitoring allows.
+	// Optional.
+	IncludeExporterVersionLabel bool
+
+	// IncludeMeasureNameLabel adds the name of the measure of the view as a
+	// "opencensus_measure_name" label on the time series and metric descriptors
+	// exported for views. It has no effect on metrics exported via
+	// ExportMetrics or PushMetricsProto, which have no measure. The label is
+	// not added to views that already have as many labels as Stackdriver
+	// Monitoring allows.
+	// Optional.
+	IncludeMeasureNameLabel bool
+
+	// SanitizeReplacement replaces every character of a label key that is not
+	// a letter, a digit or an underscore. Characters of the replacement that are not letters,
+	// digits or underscores are themselves replaced with underscores. Set it to
+	// SanitizeRemoveInvalid to drop invalid characters instead.
+	// Optional. If unset, "_" is used.
+	SanitizeReplacement string
+
+	// SanitizeLabelValue, if set, is called with the sanitized key and the
+	// value of every metric label and returns the value to export, e.g. to
+	// truncate values Cloud Monitoring would reject.
+	// Optional.
+	SanitizeLabelValue func(key, value string) string
+
 	// DefaultTraceAttributes will be appended to every span that is exported to
 	// Stackdriver Trace.
 	DefaultTraceAttributes map[string]interface{}
 
+	// AddResourceProjectToSpans adds the project of the monitored resource as a
+	// "g.co/r/<type>/project_id" attribute to every exported span, which helps
+	// correlating traces across projects. If the resource has no project_id label,
+	// ProjectID is used.
+	// Optional.
+	AddResourceProjectToSpans bool
+
+	// DefaultSpanStatusMessages sets the message of exported span statuses that
+	// have a non-OK code but no message to the name of the canonical code, such
+	// as "NOT_FOUND", to make them easier to read in Stackdriver Trace.
+	// Optional.
+	DefaultSpanStatusMessages bool
+
+	// MaxAttributesPerAnnotation caps the number of attributes exported with
+	// every span annotation. The attributes over the cap, taken in key order,
+	// are dropped and counted in the DroppedAttributesCount of the annotation.
+	// Optional. If unset, annotations keep all their attributes.
+	MaxAttributesPerAnnotation int
+
+	// TraceAttributeKeyMapper, if set, is called with the key of every span,
+	// annotation and link attribute and returns the key to export it with,
+	// e.g. to rename "http.route" to "/http/route". If it returns "", the
+	// attribute is dropped and counted in the DroppedAttributesCount. Mapped
+	// keys longer than 128 bytes are dropped.
+	// Optional.
+	TraceAttributeKeyMapper func(string) string
+
+	// MaxAnnotationsPerSpan caps the number of annotations exported with every
+	// span. The annotations over the cap are dropped and counted in the
+	// DroppedAnnotationsCount of the span.
+	// Optional. If unset, or over the Stackdriver Trace limit, 32 is used.
+	MaxAnnotationsPerSpan int
+
+	// MaxMessageEventsPerSpan caps the number of message events exported with
+	// every span. The message events over the cap are dropped and counted in
+	// the DroppedMessageEventsCount of the span.
+	// Optional. If unset, or over the Stackdriver Trace limit, 128 is used.
+	MaxMessageEventsPerSpan int
+
+	// MaxAttributeValueLength is the length, in bytes, string span attribute
+	// values are truncated to. The truncated bytes are counted in the
+	// TruncatedByteCount of the value. Stackdriver Trace documents string
+	// attribute values of up to 256 bytes, so longer values may be rejected
+	// or truncated by the backend.
+	// Optional. If unset, 256 is used.
+	MaxAttributeValueLength int
+
+	// MessageEventName, if set, returns the name of a message event of the
+	// span, e.g. derived from the span name. Message events with a non-empty
+	// name are also exported as annotations described by the name and carrying
+	// the type, ID and sizes of the message as attributes, so they are labeled
+	// in Stackdriver Trace. Like other annotation descriptions, names are
+	// truncated to 256 bytes.
+	// Optional.
+	MessageEventName func(s *trace.SpanData, e trace.MessageEvent) string
+
+	// ReplaceNamedMessageEvents exports the message events named by
+	// MessageEventName only as annotations instead of alongside the bare
+	// message events. A message event is kept if the span has no room left
+	// for its annotation.
+	// Optional.
+	ReplaceNamedMessageEvents bool
+
 	// DefaultMonitoringLabels are labels added to every metric created by this
 	// exporter in Stackdriver Monitoring.
 	//
@@ -245,6 +404,8 @@ type Options struct {
 	// stats needs to be uploaded.
 	//
 	// Do not set a timeout on this context. Instead, set the Timeout option.
+	// If it has a deadline anyway, API calls never run past it, even if the
+	// Timeout option is longer.
 	//
 	// If unset, context.Background() will be used.
 	Context context.Context
@@ -255,15 +416,173 @@ type Options struct {
 	// or the unit is not important.
 	SkipCMD bool
 
+	// CreateDescriptorFilter, if set, is called with the metric type of every
+	// metric whose descriptor is about to be created. If it returns false, the
+	// descriptor is not created, but the metric's time series are still sent,
+	// relying on an existing or automatically created descriptor.
+	// It is ignored if SkipCMD is set.
+	// Optional.
+	CreateDescriptorFilter func(metricType string) bool
+
+	// AutoManagedMetricPrefixes lists metric type prefixes, such as
+	// "workload.googleapis.com/", whose descriptors are managed by Stackdriver
+	// Monitoring. The exporter does not create descriptors for metrics under
+	// these prefixes but still sends their time series.
+	// Optional.
+	AutoManagedMetricPrefixes []string
+
+	// ViewFilter, if set, is called with the view of every exported view data
+	// and returns whether the view should be exported. The data of views it
+	// rejects is dropped before being bundled, and no metric descriptor is
+	// created for them.
+	// Optional.
+	ViewFilter func(*view.View) bool
+
+	// MetricFilter, if set, is called with every metric passed to ExportMetrics
+	// or PushMetrics, or read from the registered producers, and returns
+	// whether the metric should be exported. No metric descriptor or time
+	// series is created for the metrics it rejects, and their time series are
+	// not counted as dropped.
+	// Optional.
+	MetricFilter func(*metricdata.Metric) bool
+
+	// RequireExistingDescriptor makes the exporter look up, instead of create,
+	// the descriptor of every metric it exports for the first time. If the
+	// descriptor does not exist, or has a different metric kind or value type,
+	// the time series of the metric are dropped and the error is reported
+	// through OnError. Use it when descriptors are managed out-of-band.
+	// It is ignored if SkipCMD is set.
+	// Optional.
+	RequireExistingDescriptor bool
+
+	// SkipUnconfirmedMetrics drops the time series of views and metrics whose
+	// descriptor could not be created, instead of sending time series that
+	// Stackdriver Monitoring would likely reject. The descriptor error is
+	// still reported. Time series of proto metrics are always dropped in
+	// that case.
+	// Optional.
+	SkipUnconfirmedMetrics bool
+
+	// OverwriteMetricDescriptors makes the exporter delete and recreate the
+	// descriptor of a metric when its creation conflicts with an existing
+	// descriptor, e.g. after the aggregation or the tag keys of a view changed.
+	// Deleting a descriptor also deletes the time series already written
+	// for it. It is ignored if RequireExistingDescriptor is set.
+	// Optional.
+	OverwriteMetricDescriptors bool
+
+	// DefaultProtoMetricKind is the metric kind of the proto metrics exported
+	// with ExportMetricsProto and PushMetricsProto whose descriptor has no
+	// type, e.g. GAUGE for gauge data pushed without a kind. The value type
+	// of such metrics is the type of their first point.
+	// Optional. If unset, the kind of such metrics is left unspecified.
+	DefaultProtoMetricKind metricpb.MetricDescriptor_MetricKind
+
+	// GenerateTaskID sets the task_id label of generic_task monitored resources
+	// that have none to a random UUID generated once per process, so that
+	// ephemeral tasks without a stable identifier do not write to the same
+	// time series.
+	// Optional.
+	GenerateTaskID bool
+
 	// Timeout for all API calls. If not set, defaults to 12 seconds.
+	// CreateTimeSeries calls made by the workers of ExportMetricsProto and
+	// PushMetricsProto are bounded by WorkerTimeout instead.
 	Timeout time.Duration
 
+	// WorkerTimeout is the timeout for each CreateTimeSeries call sent by the
+	// workers of ExportMetricsProto and PushMetricsProto. It is applied per
+	// request, on top of the context passed by the caller, and is independent
+	// from Timeout. If not set, defaults to 12 seconds.
+	WorkerTimeout time.Duration
+
+	// MaxPointAge, if set, drops time series whose point end time is older than
+	// MaxPointAge or more than five minutes in the future, which Stackdriver
+	// Monitoring would reject (it does not accept points older than 24 hours).
+	// Dropped time series are reported via OnError, or returned as an error by
+	// PushMetricsProto, so they do not cause fresh points in the same request
+	// to be rejected. It applies to ExportMetrics and the proto APIs.
+	// Optional.
+	MaxPointAge time.Duration
+
+	// ClockSkew is the known skew of the local clock ahead of Google's clocks.
+	// It is subtracted from the start and end times of the points exported by
+	// ExportView and ExportMetrics, so that they are not rejected as being in
+	// the future. Use a negative value for a clock that is behind.
+	// Optional.
+	ClockSkew time.Duration
+
+	// SampleSeries, if set, is called with the metric type and labels of every
+	// time series exported by ExportView and ExportMetrics, and the time series
+	// is dropped if it returns false. It allows exporting only a sample of the
+	// series of high-cardinality metrics. To keep the sample stable across
+	// exports, the decision should be a deterministic function of its arguments,
+	// e.g. a hash of the labels. Values are not scaled to account for the
+	// dropped series.
+	// Optional.
+	SampleSeries func(metricType string, labels map[string]string) bool
+
+	// PointInterval, if set, is called for every point exported by
+	// ExportMetrics. If it returns a non-nil interval, that interval is sent
+	// as is instead of the one derived from the start time of the time series
+	// and the time of the point; ClockSkew is not applied to it.
+	// Optional.
+	PointInterval func(metricType string, pt *metricdata.Point) *monitoringpb.TimeInterval //nolint: staticcheck
+
+	// DistributionCountPolicy configures how distribution points whose count
+	// differs from the sum of their bucket counts are handled. Stackdriver
+	// Monitoring rejects such points, which can be produced when bucket counts
+	// and the count are not updated atomically. By default they are sent
+	// unchanged. It applies to ExportMetrics and the proto APIs.
+	// Optional.
+	DistributionCountPolicy DistributionCountPolicy
+
+	// MaxDistributionBuckets is the maximum number of buckets of the exported
+	// distributions. Stackdriver Monitoring rejects distributions with too many
+	// buckets. Distributions with more buckets are handled according to
+	// ExcessBucketsPolicy.
+	// Optional. If unset, the number of buckets is not checked.
+	MaxDistributionBuckets int
+
+	// ExcessBucketsPolicy configures how distributions with more than
+	// MaxDistributionBuckets buckets are handled. By default their adjacent
+	// buckets are merged.
+	// Optional.
+	ExcessBucketsPolicy ExcessBucketsPolicy
+
+	// DropEmptyDistributions skips the distribution points that hold no value,
+	// i.e. whose count is zero, which saves quota. Only the empty points are
+	// skipped: a time series is exported again as soon as it gets values.
+	// Optional.
+	DropEmptyDistributions bool
+
+	// ReportDistributionRange sends the minimum and maximum recorded values of
+	// the distributions of views as the range of the distributions. It is not
+	// set on distributions without values. Distributions exported by
+	// ExportMetrics or the proto APIs carry no minimum or maximum, so they are
+	// sent without a range.
+	// Optional.
+	ReportDistributionRange bool
+
 	// ReportingInterval sets the interval between reporting metrics.
 	// If it is set to zero then default value is used.
 	ReportingInterval time.Duration
 
+	// MaxSeriesPerMetric caps the number of distinct label combinations exported
+	// for each metric type within a reporting window (ReportingInterval, or one
+	// minute if it is not set). Once a metric reaches the cap, time series with
+	// new label combinations are dropped until the window ends. The first drop
+	// of each metric per window is reported via OnError, or returned as an error
+	// by PushMetricsProto.
+	// If it is set to zero, the number of label combinations is not limited.
+	// Optional.
+	MaxSeriesPerMetric int
+
 	// NumberOfWorkers sets the number of go rountines that send requests
-	// to Stackdriver Monitoring and Trace. The minimum number of workers is 1.
+	// to Stackdriver Monitoring and Trace, including the requests of the views
+	// exported with ExportView. The minimum number of workers is 1.
+	// It can be overridden for a single PushMetricsProto or ExportMetricsProto
+	// call with WithNumberOfWorkers.
 	NumberOfWorkers int
 
 	// ResourceByDescriptor may be provided to supply monitored resource dynamically
@@ -284,9 +603,217 @@ type Options struct {
 	// which may contain more than one time-series.
 	ResourceByDescriptor func(*metricdata.Descriptor, map[string]string) (map[string]string, monitoredresource.Interface)
 
+	// ResourceForView may be provided to select the monitored resource of the
+	// time series of a view row, e.g. when a process reports metrics on behalf
+	// of several logical entities. It is called with the view and the row's tags.
+	// If it returns nil, the resource is determined as if it were not set.
+	// It applies to ExportView only.
+	// Optional.
+	ResourceForView func(*view.View, []tag.Tag) *monitoredrespb.MonitoredResource
+
+	// ResourceForTimeSeries may be provided to select the monitored resource of
+	// each time series of a metric, e.g. when a pipeline merges the series of
+	// several entities into one metric. It is called with the metric and the
+	// time series. If it returns nil, the resource of the metric is used.
+	// It is ignored if ResourceByDescriptor is set.
+	// It applies to ExportMetrics and PushMetrics.
+	// Optional.
+	ResourceForTimeSeries func(*metricdata.Metric, *metricdata.TimeSeries) *monitoredrespb.MonitoredResource
+
+	// ResourceFromContext may be provided to select the monitored resource from
+	// request-scoped context values, e.g. in a server exporting metrics on behalf
+	// of several tenants. It is called with the context passed to PushMetricsProto,
+	// ExportMetricsProto and ForceExport, and with Context by the bundled
+	// ExportMetrics uploads. If it returns nil, the resource is determined as if
+	// it were not set. ResourceByDescriptor takes precedence over it.
+	// Optional.
+	ResourceFromContext func(ctx context.Context) *monitoredrespb.MonitoredResource
+
+	// ExemplarOnlyIfSampled drops the exemplars attached to the span context of
+	// a trace that is not sampled, since that trace cannot be looked up.
+	// Exemplars without a span context are kept.
+	// Optional.
+	ExemplarOnlyIfSampled bool
+
+	// ExplodeDistributions exports every distribution metric as Prometheus-style
+	// counters instead of a Distribution: "<name>_bucket" holding the cumulative
+	// count of values less than or equal to its "le" label, "<name>_sum" and
+	// "<name>_count". It applies to ExportMetrics and PushMetrics.
+	// Optional.
+	ExplodeDistributions bool
+
+	// CumulativeDistributionsAsDelta exports cumulative distribution metrics as
+	// DELTA distributions holding the bucket counts recorded since the previous
+	// point written of the same time series, so the counts of a point that failed
+	// to be written are sent with the next one. The first point of a time series,
+	// and the first point after it was reset, are sent with the full distribution.
+	// It applies to ExportMetrics and PushMetrics.
+	// Optional.
+	CumulativeDistributionsAsDelta bool
+
+	// ResourceTypeFilter, if set, is called with the type of the monitored
+	// resource resolved for every time series, e.g. "k8s_container", and
+	// returns whether the time series should be exported. The time series it
+	// rejects are dropped without being reported.
+	// It applies to ExportMetrics and PushMetrics.
+	// Optional.
+	ResourceTypeFilter func(resourceType string) bool
+
+	// MetricKindOverride, if set, is called with the descriptor of every metric
+	// and may return the metric kind to export it with instead of the one
+	// derived from its type, e.g. DELTA for gauges recording the amount
+	// accumulated since their previous read. The values of the points are sent
+	// unchanged. Points of DELTA metrics get consecutive intervals, each one
+	// starting at the end of the previous point written of the time series.
+	// The first point of a time series, when it has no start time before its
+	// end time, gets a 1ms interval ending at its end time.
+	// It applies to ExportMetrics and PushMetrics.
+	// Optional.
+	MetricKindOverride func(*metricdata.Descriptor) (metricpb.MetricDescriptor_MetricKind, bool)
+
+	// OmitAbsentDescriptorLabels omits from the metric descriptors created for
+	// OpenCensus Metrics the label keys that have no present value in any of
+	// the metric's time series at the time the descriptor is created.
+	// By default every label key of the metric is declared, even keys whose
+	// values are never present (metricdata.LabelValue.Present is false).
+	// It applies to ExportMetrics and PushMetrics.
+	// Optional.
+	OmitAbsentDescriptorLabels bool
+
+	// OmitCumulativeStartTime sends the points of cumulative views and metrics
+	// without a start time, for sinks whose backend treats it as implicit.
+	// Stackdriver Monitoring rejects cumulative points without a start time,
+	// so only set it together with Sink. It is not applied to the
+	// distributions sent as delta by CumulativeDistributionsAsDelta, and
+	// EmitResetPoint has no effect when it is set.
+	// Optional.
+	OmitCumulativeStartTime bool
+
+	// EmitResetPoint precedes the first point exported for every cumulative time
+	// series with a zero-valued point at the start time of the series, to anchor
+	// the series after the process restarted. A time series not exported for an
+	// hour gets a reset point again. It applies to ExportMetrics and PushMetrics
+	// and is not applied to the distributions sent as delta by
+	// CumulativeDistributionsAsDelta.
+	// Optional.
+	EmitResetPoint bool
+
 	// Override the user agent value supplied to Monitoring APIs and included as an
 	// attribute in trace data.
 	UserAgent string
+
+	// RetryDroppedTimeSeries enables resending, once, the time series that a partially
+	// failed CreateTimeSeries call reported as dropped for a transient reason
+	// (e.g. an internal error). Only that subset is resent, not the whole request.
+	// It applies to ExportMetricsProto, PushMetricsProto and PushMetrics.
+	// Optional.
+	RetryDroppedTimeSeries bool
+
+	// RetryDroppedTimeSeriesOnExport is like RetryDroppedTimeSeries for the time
+	// series of views and of metrics read by ExportMetrics. Those retries count
+	// against FlushRetryBudget.
+	// Optional.
+	RetryDroppedTimeSeriesOnExport bool
+
+	// SyncFlushChunkSize bounds the number of metrics converted and uploaded at
+	// once when metricdata metrics are exported, by ExportMetrics, ForceExport
+	// or PushMetrics, so that the time series of a very large slice of metrics
+	// are not all held in memory together. The metrics are uploaded in chunks
+	// of at most SyncFlushChunkSize metrics.
+	// Optional. If unset, all the metrics are uploaded at once.
+	SyncFlushChunkSize int
+
+	// FlushRetryBudget caps the number of calls retried by RetryPolicy and
+	// RetryDroppedTimeSeriesOnExport within a single export of views or of
+	// metrics read by ExportMetrics.
+	// Once it is used up, the remaining failures of the export are returned
+	// without retrying them, which bounds the latency and cost of exports
+	// against a degraded backend. Zero means no limit.
+	// Optional.
+	FlushRetryBudget int
+
+	// RetryPolicy, if set, retries with exponential backoff the CreateTimeSeries
+	// and CreateServiceTimeSeries calls that fail with a transient error, within
+	// the deadline of the call. Calls failing with a server-suggested retry
+	// delay are retried after that delay. It applies to all metric export paths.
+	// Optional.
+	RetryPolicy *RetryPolicy
+
+	// DisableServiceTimeSeries sends the time series of service metrics, such as
+	// "kubernetes.io/" metrics, with CreateTimeSeries like every other metric
+	// instead of CreateServiceTimeSeries. Set it when the project owns the
+	// descriptors of these metrics.
+	// Optional.
+	DisableServiceTimeSeries bool
+
+	// Sink, if set, receives the CreateTimeSeries, CreateServiceTimeSeries and
+	// CreateMetricDescriptor requests instead of Stackdriver Monitoring, and no
+	// Stackdriver Monitoring client is created. The requests are the same as
+	// the ones that would be sent to Stackdriver Monitoring.
+	// Optional.
+	Sink TimeSeriesSink
+
+	// TransformRequest, if set, is called with every CreateTimeSeriesRequest right
+	// before it is sent to Stackdriver Monitoring. It may modify the request in place,
+	// return a different request, or return nil to drop the request entirely.
+	// Optional.
+	TransformRequest func(*monitoringpb.CreateTimeSeriesRequest) *monitoringpb.CreateTimeSeriesRequest //nolint: staticcheck
+
+	// EnableSelfMetrics enables metrics about the exporter itself, recorded with
+	// OpenCensus so they can be exported like any other view. It registers the
+	// "<SelfMetricsNamespace>/export_latency" distribution view, measuring the
+	// latency in milliseconds of the CreateTimeSeries calls of ExportMetricsProto
+	// and PushMetricsProto.
+	// Optional.
+	EnableSelfMetrics bool
+
+	// SelfMetricsNamespace is the prefix of the names of the measures and views
+	// registered by EnableSelfMetrics. If unset, "opencensus.io/stackdriver_exporter"
+	// is used.
+	// Optional.
+	SelfMetricsNamespace string
+
+	// RequestNameFormatter, if set, returns the Name of the CreateTimeSeriesRequests
+	// sent for projectID, for example to reach a proxy that expects a custom
+	// resource path. If unset, "projects/<projectID>" is used.
+	// Optional.
+	RequestNameFormatter func(projectID string) string
+
+	// MetricDescriptorQPS caps the rate of CreateMetricDescriptor calls, which
+	// Stackdriver Monitoring throttles, e.g. when thousands of views are
+	// registered at startup. Calls over the rate wait for their turn, within
+	// the deadline of the export.
+	// If it is not positive, calls are not paced.
+	// Optional.
+	MetricDescriptorQPS float64
+
+	// CircuitBreakerThreshold enables a circuit breaker around the CreateTimeSeries
+	// calls of ExportMetricsProto and PushMetricsProto. After this many consecutive
+	// calls failed as a whole with a transient error (Unavailable,
+	// DeadlineExceeded, ResourceExhausted or Internal), calls are skipped for
+	// CircuitBreakerCoolDown and their time series are counted as dropped.
+	// Time series rejected individually do not count. After the cool-down a
+	// single call is attempted, whose outcome decides whether the breaker
+	// closes or opens again.
+	// If it is not positive, there is no circuit breaker.
+	// Optional.
+	CircuitBreakerThreshold int
+
+	// CircuitBreakerCoolDown is how long calls are skipped once the circuit
+	// breaker opened. If unset, 30 seconds is used.
+	// Optional.
+	CircuitBreakerCoolDown time.Duration
+
+	// OnCircuitBreakerStateChange, if set, is called with true when the circuit
+	// breaker opens and with false when it closes again.
+	// Optional.
+	OnCircuitBreakerStateChange func(open bool)
+
+	// OnUploadSuccess, if set, is called after every successful CreateTimeSeries
+	// call with the distinct metric types of the time series it wrote, for
+	// example to reconcile which metrics were uploaded in the last cycle.
+	// Optional.
+	OnUploadSuccess func(metricTypes []string)
 }
 
 const defaultTimeout = 12 * time.Second
@@ -339,6 +866,7 @@ func NewExporter(o Options) (*Exporter, error) {
 		}
 	}
 
+	explicitResource := o.Resource != nil || o.MonitoredResource != nil || o.ResourceByDescriptor != nil
 	if o.MonitoredResource != nil {
 		o.Resource = convertMonitoredResourceToPB(o.MonitoredResource)
 	}
@@ -354,20 +882,29 @@ func NewExporter(o Options) (*Exporter, error) {
 		if err != nil {
 			return nil, fmt.Errorf("stackdriver: detect resource: %s", err)
 		}
-		// Populate internal resource labels for defaulting project_id, location, and
-		// generic resource labels of applicable monitored resources.
-		if res.Labels == nil {
-			res.Labels = make(map[string]string)
-		}
-		res.Labels[stackdriverProjectID] = o.ProjectID
-		res.Labels[resourcekeys.CloudKeyZone] = o.Location
-		res.Labels[stackdriverGenericTaskNamespace] = "default"
-		res.Labels[stackdriverGenericTaskJob] = path.Base(os.Args[0])
-		res.Labels[stackdriverGenericTaskID] = getTaskValue()
-		log.Printf("OpenCensus detected resource: %v", res)
+		// If nothing was detected, Resource is left unset and the global resource is used.
+		if res != nil {
+			// Populate internal resource labels for defaulting project_id, location, and
+			// generic resource labels of applicable monitored resources.
+			if res.Labels == nil {
+				res.Labels = make(map[string]string)
+			}
+			res.Labels[stackdriverProjectID] = o.ProjectID
+			res.Labels[resourcekeys.CloudKeyZone] = o.Location
+			res.Labels[stackdriverGenericTaskNamespace] = "default"
+			res.Labels[stackdriverGenericTaskJob] = path.Base(os.Args[0])
+			res.Labels[stackdriverGenericTaskID] = getTaskValue()
+			log.Printf("OpenCensus detected resource: %v", res)
 
-		o.Resource = o.MapResource(res)
-		log.Printf("OpenCensus using monitored resource: %v", o.Resource)
+			o.Resource = o.mapResource(res)
+			log.Printf("OpenCensus using monitored resource: %v", o.Resource)
+		}
+	}
+	if o.FailOnGlobalResource && !explicitResource && (o.Resource == nil || o.Resource.Type == "global") {
+		return nil, errors.New("stackdriver: no monitored resource was detected, refusing to use the global resource")
+	}
+	if err := o.resolveGlobalResourceProjectConflict(); err != nil {
+		return nil, err
 	}
 	if o.MetricPrefix != "" && !strings.HasSuffix(o.MetricPrefix, "/") {
 		o.MetricPrefix = o.MetricPrefix + "/"
@@ -384,6 +921,13 @@ func NewExporter(o Options) (*Exporter, error) {
 	if err != nil {
 		return nil, err
 	}
+	if o.OnResourceDetected != nil {
+		rsc := o.Resource
+		if rsc == nil {
+			rsc = o.fallbackResource()
+		}
+		o.OnResourceDetected(rsc)
+	}
 	return &Exporter{
 		statsExporter: se,
 		traceExporter: te,
@@ -414,6 +958,12 @@ func (e *Exporter) ExportMetrics(ctx context.Context, metrics []*metricdata.Metr
 	return e.statsExporter.ExportMetrics(ctx, metrics)
 }
 
+// PushMetrics exports OpenCensus Metrics to Stackdriver Monitoring synchronously,
+// without adding them to the bundler, and returns the number of dropped timeseries.
+func (e *Exporter) PushMetrics(ctx context.Context, metrics []*metricdata.Metric) (int, error) {
+	return e.statsExporter.PushMetrics(ctx, metrics)
+}
+
 // StartMetricsExporter starts exporter by creating an interval reader that reads metrics
 // from all registered producers at set interval and exports them.
 // Use StopMetricsExporter to stop exporting metrics.
@@ -432,11 +982,44 @@ func (e *Exporter) StartMetricsExporter() error {
 	return e.statsExporter.startMetricsReader()
 }
 
+// ForceExport reads the current state of the metrics from all registered
+// producers and uploads it to Stackdriver Monitoring synchronously, without
+// waiting for the next reporting interval.
+//
+// This is useful for request-triggered exports, e.g. from a debugging endpoint.
+func (e *Exporter) ForceExport(ctx context.Context) error {
+	return e.statsExporter.forceExport(ctx)
+}
+
 // StopMetricsExporter stops exporter from exporting metrics.
 func (e *Exporter) StopMetricsExporter() {
 	e.statsExporter.stopMetricsReader()
 }
 
+// Register starts exporting: it starts the metrics reader, which exports the
+// data of all registered views and metric producers, and registers e as a
+// trace exporter. e is not registered with view.RegisterExporter, since the
+// metrics reader already exports view data; see StartMetricsExporter.
+// Use Unregister to stop exporting.
+//    exporter, err := stackdriver.NewExporter(stackdriver.Options{})
+//    ...
+//    if err := exporter.Register(); err != nil { ... }
+//    defer exporter.Unregister()
+func (e *Exporter) Register() error {
+	if err := e.StartMetricsExporter(); err != nil {
+		return err
+	}
+	trace.RegisterExporter(e)
+	return nil
+}
+
+// Unregister undoes Register: it unregisters e as a trace exporter and stops
+// the metrics reader after a last export.
+func (e *Exporter) Unregister() {
+	trace.UnregisterExporter(e)
+	e.StopMetricsExporter()
+}
+
 // Close closes client connections.
 func (e *Exporter) Close() error {
 	tErr := e.traceExporter.close()
@@ -453,6 +1036,47 @@ func (e *Exporter) Close() error {
 	return nil
 }
 
+// Shutdown stops the metrics reader, flushes the pending metrics, then the
+// pending spans, and finally closes the client connections, all within ctx.
+// Every flush is started even if ctx is done before an earlier one completes.
+// In that case Shutdown returns ctx.Err() without closing the clients, since
+// the flushes keep running in the background and still use them.
+func (e *Exporter) Shutdown(ctx context.Context) error {
+	flushes := []func(){
+		func() {
+			e.StopMetricsExporter()
+			e.statsExporter.Flush()
+		},
+		e.traceExporter.Flush,
+	}
+	var errs []error
+	for _, flush := range flushes {
+		if err := runWithContext(ctx, flush); err != nil {
+			errs = append(errs, err)
+		}
+	}
+	if len(errs) > 0 {
+		return errs[0]
+	}
+	return e.Close()
+}
+
+// runWithContext runs f and waits for it to return, or for ctx to be done,
+// in which case it returns ctx.Err() and f keeps running.
+func runWithContext(ctx context.Context, f func()) error {
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		f()
+	}()
+	select {
+	case <-done:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
 // ExportSpan exports a SpanData to Stackdriver Trace.
 func (e *Exporter) ExportSpan(sd *trace.SpanData) {
 	if len(e.traceExporter.o.DefaultTraceAttributes) > 0 {
@@ -497,6 +1121,41 @@ func (e *Exporter) ViewToMetricDescriptor(ctx context.Context, v *view.View) (*m
 	return e.statsExporter.viewToMetricDescriptor(ctx, v)
 }
 
+// MetricType returns the Stackdriver Monitoring metric type that the exporter
+// uses for the time series and the metric descriptor of the view, for example
+// to reference the metric in dashboards or alerting policies.
+func (e *Exporter) MetricType(v *view.View) string {
+	return e.statsExporter.metricType(v)
+}
+
+// DeleteMetricDescriptor deletes the Stackdriver Monitoring metric descriptor
+// of metricType, and all its time series, e.g. to clean up the descriptor of a
+// view whose aggregation or tag keys changed. The exporter creates the
+// descriptor again on the next export of the metric.
+func (e *Exporter) DeleteMetricDescriptor(ctx context.Context, metricType string) error {
+	return e.statsExporter.deleteMetricDescriptor(ctx, metricType)
+}
+
+// fallbackResource returns the monitored resource used when there is no other:
+// DefaultMonitoredResource, or the "global" resource.
+func (o Options) fallbackResource() *monitoredrespb.MonitoredResource {
+	if o.DefaultMonitoredResource != nil {
+		return o.DefaultMonitoredResource
+	}
+	return &monitoredrespb.MonitoredResource{Type: "global"}
+}
+
+// mapResource maps res to a monitored resource with MapResource. The "global"
+// resource that MapResource falls back to, e.g. for a nil res or one with
+// missing labels, is replaced by DefaultMonitoredResource if it is set.
+func (o Options) mapResource(res *resource.Resource) *monitoredrespb.MonitoredResource {
+	mr := o.MapResource(res)
+	if o.DefaultMonitoredResource != nil && (mr == nil || mr.Type == "global") {
+		return o.DefaultMonitoredResource
+	}
+	return mr
+}
+
 func (o Options) handleError(err error) {
 	if o.OnError != nil {
 		o.OnError(err)
@@ -505,6 +1164,66 @@ func (o Options) handleError(err error) {
 	log.Printf("Failed to export to Stackdriver: %v", err)
 }
 
+// clientOptions returns the options of an API client: opts followed by the
+// options derived from o.
+func (o Options) clientOptions(opts ...option.ClientOption) []option.ClientOption {
+	out := append([]option.ClientOption(nil), opts...)
+	if o.KeepaliveParams != nil {
+		out = append(out, option.WithGRPCDialOption(grpc.WithKeepaliveParams(*o.KeepaliveParams)))
+	}
+	return out
+}
+
+// traceClientOptions returns the options of the Stackdriver Trace API client.
+func (o Options) traceClientOptions() []option.ClientOption {
+	opts := o.clientOptions(o.TraceClientOptions...)
+	if o.CompressTraces {
+		opts = append(opts, option.WithGRPCDialOption(grpc.WithDefaultCallOptions(grpc.UseCompressor(gzip.Name))))
+	}
+	return opts
+}
+
+// requestName returns the Name of the CreateTimeSeriesRequests sent to Stackdriver Monitoring.
+func (o Options) requestName() string {
+	if o.RequestNameFormatter != nil {
+		return o.RequestNameFormatter(o.ProjectID)
+	}
+	return fmt.Sprintf("projects/%s", o.ProjectID)
+}
+
+// reportUploadSuccess calls OnUploadSuccess, if set, with the metric types of req.
+func (o Options) reportUploadSuccess(req *monitoringpb.CreateTimeSeriesRequest) { //nolint: staticcheck
+	if o.OnUploadSuccess != nil {
+		o.OnUploadSuccess(requestMetricTypes(req))
+	}
+}
+
+// requestMetricTypes returns the distinct metric types of the time series of
+// req, in order of first appearance.
+func requestMetricTypes(req *monitoringpb.CreateTimeSeriesRequest) []string { //nolint: staticcheck
+	seen := make(map[string]bool)
+	var types []string
+	for _, ts := range req.GetTimeSeries() {
+		if typ := ts.GetMetric().GetType(); !seen[typ] {
+			seen[typ] = true
+			types = append(types, typ)
+		}
+	}
+	return types
+}
+
+// transformRequest applies the TransformRequest option to req, if set.
+// It returns nil if the request must not be sent.
+func (o Options) transformRequest(req *monitoringpb.CreateTimeSeriesRequest) *monitoringpb.CreateTimeSeriesRequest { //nolint: staticcheck
+	if o.TransformRequest == nil {
+		return req
+	}
+	return o.TransformRequest(req)
+}
+
+// newContextWithTimeout derives a context for an API call that is done after timeout,
+// or defaultTimeout if timeout is not positive. The derived context never outlives
+// ctx: if ctx has an earlier deadline, that deadline applies to the call.
 func newContextWithTimeout(ctx context.Context, timeout time.Duration) (context.Context, func()) {
 	if ctx == nil {
 		ctx = context.Background()
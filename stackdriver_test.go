@@ -16,22 +16,36 @@ package stackdriver
 
 import (
 	"context"
+	"fmt"
 	"io"
 	"net/http"
 	"net/http/httptest"
 	"os"
+	"strings"
+	"sync"
 	"testing"
 	"time"
 
+	monitoring "cloud.google.com/go/monitoring/apiv3/v2"
+	"github.com/google/go-cmp/cmp"
 	"github.com/launchdarkly/opencensus-go-exporter-stackdriver/internal/testpb"
 	"github.com/launchdarkly/opencensus-go-exporter-stackdriver/monitoredresource/gcp"
 	"go.opencensus.io/plugin/ochttp"
+	"go.opencensus.io/resource"
+	"go.opencensus.io/resource/resourcekeys"
+	"go.opencensus.io/stats"
 	"go.opencensus.io/stats/view"
 	"go.opencensus.io/trace"
 	"golang.org/x/net/context/ctxhttp"
 	"google.golang.org/api/option"
+	metricpb "google.golang.org/genproto/googleapis/api/metric"
+	monitoredrespb "google.golang.org/genproto/googleapis/api/monitoredres"
+	tracepb "google.golang.org/genproto/googleapis/devtools/cloudtrace/v2" //nolint: staticcheck
+	monitoringpb "google.golang.org/genproto/googleapis/monitoring/v3"     //nolint: staticcheck
 	"google.golang.org/grpc"
 	"google.golang.org/grpc/credentials/insecure"
+	"google.golang.org/grpc/keepalive"
+	"google.golang.org/protobuf/testing/protocmp"
 )
 
 var (
@@ -151,6 +165,288 @@ func TestUserAgent(t *testing.T) {
 	}
 }
 
+func TestGlobalResourceProjectConflict(t *testing.T) {
+	tests := []struct {
+		name          string
+		policy        ResourceProjectConflictPolicy
+		wantErr       bool
+		wantReported  bool
+		wantProjectID string
+	}{
+		{name: "ignore", policy: IgnoreResourceProjectConflict, wantProjectID: "bar"},
+		{name: "warn", policy: WarnResourceProjectConflict, wantReported: true, wantProjectID: "bar"},
+		{name: "fail", policy: FailResourceProjectConflict, wantErr: true},
+		{name: "align", policy: AlignResourceProjectConflict, wantProjectID: "foo"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			rsc := &monitoredrespb.MonitoredResource{
+				Type:   "global",
+				Labels: map[string]string{"project_id": "bar"},
+			}
+			var reported []error
+			e, err := NewExporter(Options{
+				ProjectID:                     "foo",
+				Resource:                      rsc,
+				GlobalResourceProjectConflict: tt.policy,
+				OnError:                       func(err error) { reported = append(reported, err) },
+			})
+			if tt.wantErr {
+				if err == nil {
+					t.Fatal("NewExporter() succeeded, want a project conflict error")
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("NewExporter() = %v", err)
+			}
+			defer e.Close()
+			if got := len(reported) > 0; got != tt.wantReported {
+				t.Errorf("Conflict reported = %v, want %v (%v)", got, tt.wantReported, reported)
+			}
+			if got := e.statsExporter.o.Resource.Labels["project_id"]; got != tt.wantProjectID {
+				t.Errorf("Resource project_id = %q, want %q", got, tt.wantProjectID)
+			}
+			if rsc.Labels["project_id"] != "bar" {
+				t.Errorf("The configured resource was modified: %v", rsc)
+			}
+		})
+	}
+}
+
+func TestFailOnGlobalResource(t *testing.T) {
+	detectNothing := func(context.Context) (*resource.Resource, error) {
+		return nil, nil
+	}
+	if _, err := NewExporter(Options{ProjectID: "foo", ResourceDetector: detectNothing, FailOnGlobalResource: true}); err == nil {
+		t.Error("NewExporter() succeeded with a global resource, want an error")
+	}
+	if _, err := NewExporter(Options{ProjectID: "foo", FailOnGlobalResource: true}); err == nil {
+		t.Error("NewExporter() succeeded without any resource, want an error")
+	}
+
+	e, err := NewExporter(Options{
+		ProjectID:            "foo",
+		FailOnGlobalResource: true,
+		ResourceDetector: func(context.Context) (*resource.Resource, error) {
+			return &resource.Resource{
+				Type:   resourcekeys.K8SType,
+				Labels: map[string]string{resourcekeys.K8SKeyClusterName: "c", resourcekeys.K8SKeyNamespaceName: "n", resourcekeys.K8SKeyPodName: "p"},
+			}, nil
+		},
+	})
+	if err != nil {
+		t.Fatalf("NewExporter() with a detected resource = %v", err)
+	}
+	defer e.Close()
+
+	// An explicit global resource is accepted.
+	e2, err := NewExporter(Options{ProjectID: "foo", Resource: &monitoredrespb.MonitoredResource{Type: "global"}, FailOnGlobalResource: true})
+	if err != nil {
+		t.Fatalf("NewExporter() with an explicit global resource = %v", err)
+	}
+	defer e2.Close()
+}
+
+func TestClientOptionsKeepalive(t *testing.T) {
+	userAgent := option.WithUserAgent("test")
+	if got := (Options{}).clientOptions(userAgent); len(got) != 1 {
+		t.Errorf("clientOptions() without KeepaliveParams = %v; want only the given option", got)
+	}
+
+	o := Options{KeepaliveParams: &keepalive.ClientParameters{Time: time.Minute, Timeout: 10 * time.Second, PermitWithoutStream: true}}
+	got := o.clientOptions(userAgent)
+	if len(got) != 2 {
+		t.Fatalf("clientOptions() with KeepaliveParams = %v; want 2 options", got)
+	}
+	if typ := fmt.Sprintf("%T", got[1]); !strings.Contains(typ, "GRPCDialOption") {
+		t.Errorf("clientOptions() added a %s; want a gRPC dial option", typ)
+	}
+}
+
+func TestRegisterAndUnregister(t *testing.T) {
+	e, err := NewExporter(Options{ProjectID: "foo"})
+	if err != nil {
+		t.Fatalf("NewExporter() = %v", err)
+	}
+	defer e.Close()
+	var spans []*tracepb.Span                            //nolint: staticcheck
+	e.traceExporter.uploadFn = func(s []*tracepb.Span) { //nolint: staticcheck
+		spans = append(spans, s...)
+	}
+	endSpan := func(name string) {
+		_, span := trace.StartSpan(context.Background(), name, trace.WithSampler(trace.AlwaysSample()))
+		span.End()
+		e.Flush()
+	}
+
+	if err := e.Register(); err != nil {
+		t.Fatalf("Register() = %v", err)
+	}
+	endSpan("registered")
+	if len(spans) != 1 {
+		t.Errorf("exported %d spans while registered; want 1", len(spans))
+	}
+	if err := e.statsExporter.ir.Start(); err == nil {
+		e.statsExporter.ir.Stop()
+		t.Error("metrics reader was not started by Register")
+	}
+
+	e.Unregister()
+	endSpan("unregistered")
+	if len(spans) != 1 {
+		t.Errorf("exported %d spans after Unregister; want 1", len(spans))
+	}
+	if err := e.statsExporter.ir.Start(); err != nil {
+		t.Errorf("metrics reader was not stopped by Unregister: %v", err)
+	}
+	e.statsExporter.ir.Stop()
+}
+
+func TestOnResourceDetected(t *testing.T) {
+	tests := []struct {
+		name     string
+		detector func(context.Context) (*resource.Resource, error)
+		want     *monitoredrespb.MonitoredResource
+	}{
+		{
+			name: "detected",
+			detector: func(context.Context) (*resource.Resource, error) {
+				return &resource.Resource{
+					Type:   resourcekeys.K8SType,
+					Labels: map[string]string{resourcekeys.K8SKeyClusterName: "c", resourcekeys.K8SKeyNamespaceName: "n", resourcekeys.K8SKeyPodName: "p", resourcekeys.CloudKeyZone: "z"},
+				}, nil
+			},
+			want: &monitoredrespb.MonitoredResource{
+				Type:   "k8s_pod",
+				Labels: map[string]string{"project_id": "foo", "location": "", "cluster_name": "c", "namespace_name": "n", "pod_name": "p"},
+			},
+		},
+		{
+			name:     "nothing detected",
+			detector: func(context.Context) (*resource.Resource, error) { return nil, nil },
+			want:     &monitoredrespb.MonitoredResource{Type: "global"},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var got []*monitoredrespb.MonitoredResource
+			e, err := NewExporter(Options{
+				ProjectID:          "foo",
+				ResourceDetector:   tt.detector,
+				OnResourceDetected: func(rsc *monitoredrespb.MonitoredResource) { got = append(got, rsc) },
+			})
+			if err != nil {
+				t.Fatalf("NewExporter() = %v", err)
+			}
+			defer e.Close()
+			if len(got) != 1 {
+				t.Fatalf("OnResourceDetected called %d times; want once", len(got))
+			}
+			if diff := cmp.Diff(got[0], tt.want, protocmp.Transform()); diff != "" {
+				t.Errorf("detected resource -got +want: %s", diff)
+			}
+		})
+	}
+}
+
+func TestShutdown(t *testing.T) {
+	oldCreateMetricDescriptor := createMetricDescriptor
+	oldCreateTimeSeries := createTimeSeries
+	defer func() {
+		createMetricDescriptor = oldCreateMetricDescriptor
+		createTimeSeries = oldCreateTimeSeries
+	}()
+	var mu sync.Mutex
+	var steps []string
+	var blockMetrics chan struct{}
+	record := func(step string) {
+		mu.Lock()
+		defer mu.Unlock()
+		steps = append(steps, step)
+	}
+	createMetricDescriptor = func(ctx context.Context, c *monitoring.MetricClient, mdr *monitoringpb.CreateMetricDescriptorRequest) (*metricpb.MetricDescriptor, error) { //nolint: staticcheck
+		return mdr.MetricDescriptor, nil
+	}
+	createTimeSeries = func(ctx context.Context, c *monitoring.MetricClient, req *monitoringpb.CreateTimeSeriesRequest) error { //nolint: staticcheck
+		if blockMetrics != nil {
+			<-blockMetrics
+		}
+		record("metrics")
+		return nil
+	}
+
+	newExporter := func(uploadSpans func()) *Exporter {
+		e, err := NewExporter(Options{ProjectID: "foo"})
+		if err != nil {
+			t.Fatalf("NewExporter() = %v", err)
+		}
+		e.traceExporter.uploadFn = func([]*tracepb.Span) { uploadSpans() } //nolint: staticcheck
+		v := &view.View{
+			Name:        "shutdown_view",
+			Measure:     stats.Int64("test-measure/TestShutdown", "measure desc", stats.UnitDimensionless),
+			Aggregation: view.Count(),
+		}
+		data := &view.CountData{Value: 1}
+		e.ExportView(newTestViewData(v, time.Now(), time.Now(), data, data))
+		e.ExportSpan(&trace.SpanData{SpanContext: trace.SpanContext{TraceID: traceID, SpanID: spanID}, Name: "span"})
+		return e
+	}
+
+	t.Run("ordering", func(t *testing.T) {
+		steps = nil
+		e := newExporter(func() { record("traces") })
+		if err := e.Shutdown(context.Background()); err != nil {
+			t.Fatalf("Shutdown() = %v", err)
+		}
+		if diff := cmp.Diff(steps, []string{"metrics", "traces"}); diff != "" {
+			t.Errorf("flushes -got +want: %s", diff)
+		}
+	})
+
+	t.Run("deadline", func(t *testing.T) {
+		unblock := make(chan struct{})
+		e := newExporter(func() { <-unblock })
+		ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+		defer cancel()
+		start := time.Now()
+		if err := e.Shutdown(ctx); err != context.DeadlineExceeded {
+			t.Errorf("Shutdown() = %v; want %v", err, context.DeadlineExceeded)
+		}
+		if elapsed := time.Since(start); elapsed > time.Second {
+			t.Errorf("Shutdown() returned after %v; want it to return at the deadline", elapsed)
+		}
+		close(unblock)
+		e.Flush()
+		// Closing the clients again would fail.
+		if err := e.Close(); err != nil {
+			t.Errorf("Close() = %v; want the clients left open by Shutdown", err)
+		}
+	})
+
+	t.Run("metrics deadline", func(t *testing.T) {
+		blockMetrics = make(chan struct{})
+		defer func() { blockMetrics = nil }()
+		traced := make(chan struct{})
+		e := newExporter(func() { close(traced) })
+		ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+		defer cancel()
+		if err := e.Shutdown(ctx); err != context.DeadlineExceeded {
+			t.Errorf("Shutdown() = %v; want %v", err, context.DeadlineExceeded)
+		}
+		select {
+		case <-traced:
+		case <-time.After(time.Second):
+			t.Error("spans not flushed after the metrics flush hit the deadline")
+		}
+		close(blockMetrics)
+		e.Flush()
+		if err := e.Close(); err != nil {
+			t.Errorf("Close() = %v; want the clients left open by Shutdown", err)
+		}
+	})
+}
+
 func TestClose(t *testing.T) {
 	projectID, ok := os.LookupEnv("STACKDRIVER_TEST_PROJECT_ID")
 	if !ok {
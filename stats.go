@@ -18,6 +18,7 @@ import (
 	"context"
 	"errors"
 	"fmt"
+	"math"
 	"os"
 	"path"
 	"sort"
@@ -42,6 +43,8 @@ import (
 	metricpb "google.golang.org/genproto/googleapis/api/metric"
 	monitoredrespb "google.golang.org/genproto/googleapis/api/monitoredres"
 	monitoringpb "google.golang.org/genproto/googleapis/monitoring/v3" //nolint: staticcheck
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
 	"google.golang.org/protobuf/proto"
 )
 
@@ -51,6 +54,19 @@ const (
 	opencensusTaskDescription = "Opencensus task identifier"
 	defaultDisplayNamePrefix  = "OpenCensus"
 	version                   = "0.13.3"
+
+	// maxLabelsPerMetric is the maximum number of labels Stackdriver Monitoring
+	// accepts on a metric descriptor.
+	maxLabelsPerMetric = 30
+
+	defaultOriginalNameLabelKey  = "opencensus_metric_name"
+	originalNameLabelDescription = "Original OpenCensus metric name"
+
+	exporterVersionLabelKey         = "exporter_version"
+	exporterVersionLabelDescription = "Version of the Stackdriver exporter"
+
+	measureNameLabelKey         = "opencensus_measure_name"
+	measureNameLabelDescription = "Name of the OpenCensus measure of the view"
 )
 
 // statsExporter exports stats to the Stackdriver Monitoring.
@@ -69,6 +85,16 @@ type statsExporter struct {
 	c             *monitoring.MetricClient
 	defaultLabels map[string]labelValue
 	ir            *metricexport.IntervalReader
+	seriesLimiter *seriesLimiter
+	distDeltas    distributionDeltas
+	deltaWindows  deltaWindows
+	selfMetrics   *selfMetrics
+	breaker       *circuitBreaker
+	mdLimiter     *rateLimiter // paces CreateMetricDescriptor calls
+
+	exportedSeries seriesSet // Cumulative time series that already got a reset point
+
+	droppedLabelsReported sync.Map // Names of the metrics whose dropped labels were reported
 
 	initReaderOnce sync.Once
 }
@@ -85,14 +111,17 @@ func newStatsExporter(o Options) (*statsExporter, error) {
 		return nil, errBlankProjectID
 	}
 
-	opts := append(o.MonitoringClientOptions, option.WithUserAgent(o.UserAgent))
+	opts := o.clientOptions(append(o.MonitoringClientOptions, option.WithUserAgent(o.UserAgent))...)
 	ctx := o.Context
 	if ctx == nil {
 		ctx = context.Background()
 	}
-	client, err := monitoring.NewMetricClient(ctx, opts...)
-	if err != nil {
-		return nil, err
+	var client *monitoring.MetricClient
+	var err error
+	if o.Sink == nil {
+		if client, err = monitoring.NewMetricClient(ctx, opts...); err != nil {
+			return nil, err
+		}
 	}
 	e := &statsExporter{
 		c:                      client,
@@ -100,6 +129,20 @@ func newStatsExporter(o Options) (*statsExporter, error) {
 		protoMetricDescriptors: make(map[string]bool),
 		metricDescriptors:      make(map[string]bool),
 	}
+	if o.MaxSeriesPerMetric > 0 {
+		e.seriesLimiter = newSeriesLimiter(o.MaxSeriesPerMetric, o.ReportingInterval)
+	}
+	if o.CircuitBreakerThreshold > 0 {
+		e.breaker = newCircuitBreaker(o.CircuitBreakerThreshold, o.CircuitBreakerCoolDown, o.OnCircuitBreakerStateChange)
+	}
+	if o.MetricDescriptorQPS > 0 {
+		e.mdLimiter = newRateLimiter(o.MetricDescriptorQPS)
+	}
+	if o.EnableSelfMetrics {
+		if e.selfMetrics, err = newSelfMetrics(o.SelfMetricsNamespace); err != nil {
+			return nil, err
+		}
+	}
 
 	var defaultLablesNotSanitized map[string]labelValue
 	if o.DefaultMonitoringLabels != nil {
@@ -113,7 +156,7 @@ func newStatsExporter(o Options) (*statsExporter, error) {
 	e.defaultLabels = make(map[string]labelValue)
 	// Fill in the defaults firstly, irrespective of if the labelKeys and labelValues are mismatched.
 	for key, label := range defaultLablesNotSanitized {
-		e.defaultLabels[sanitize(key)] = label
+		e.defaultLabels[o.sanitize(key)] = label
 	}
 
 	e.viewDataBundler = bundler.NewBundler((*view.Data)(nil), func(bundle interface{}) {
@@ -151,17 +194,26 @@ func (e *statsExporter) stopMetricsReader() {
 }
 
 func (e *statsExporter) close() error {
+	if e.c == nil {
+		return nil
+	}
 	return e.c.Close()
 }
 
 func (e *statsExporter) getMonitoredResource(v *view.View, tags []tag.Tag) ([]tag.Tag, *monitoredrespb.MonitoredResource) {
+	if e.o.ResourceForView != nil {
+		if resource := e.o.ResourceForView(v, tags); resource != nil {
+			return tags, e.o.withGeneratedTaskID(resource)
+		}
+	}
 	resource := e.o.Resource
+	if resource == nil && e.o.MonitoredResource != nil {
+		resource = convertMonitoredResourceToPB(e.o.MonitoredResource)
+	}
 	if resource == nil {
-		resource = &monitoredrespb.MonitoredResource{
-			Type: "global",
-		}
+		resource = e.o.fallbackResource()
 	}
-	return tags, resource
+	return tags, e.o.withGeneratedTaskID(resource)
 }
 
 // ExportView exports to the Stackdriver Monitoring if view data
@@ -170,6 +222,9 @@ func (e *statsExporter) ExportView(vd *view.Data) {
 	if len(vd.Rows) == 0 {
 		return
 	}
+	if e.o.ViewFilter != nil && !e.o.ViewFilter(vd.View) {
+		return
+	}
 	err := e.viewDataBundler.Add(vd, 1)
 	switch err {
 	case nil:
@@ -218,54 +273,149 @@ func (e *statsExporter) uploadStats(vds []*view.Data) error {
 	)
 	defer span.End()
 
+	var errs []error
+	budget := e.o.newRetryBudget()
+	exportable := make([]*view.Data, 0, len(vds))
 	for _, vd := range vds {
 		if err := e.createMetricDescriptorFromView(ctx, vd.View); err != nil {
 			span.SetStatus(trace.Status{Code: 2, Message: err.Error()})
-			return err
+			errs = append(errs, fmt.Errorf("failed to create metric descriptor for view %q: %v", vd.View.Name, err))
+			if e.o.RequireExistingDescriptor || e.o.SkipUnconfirmedMetrics {
+				continue
+			}
 		}
+		exportable = append(exportable, vd)
 	}
-	for _, req := range e.makeReq(vds, maxTimeSeriesPerUpload) {
-		if err := createTimeSeries(ctx, e.c, req); err != nil {
-			span.SetStatus(trace.Status{Code: 2, Message: err.Error()})
-			// TODO(jbd): Don't fail fast here, batch errors?
-			return err
+	var reqs []*monitoringpb.CreateTimeSeriesRequest //nolint: staticcheck
+	for _, req := range e.makeReq(exportable, maxTimeSeriesPerUpload) {
+		if req = e.o.transformRequest(req); req != nil {
+			reqs = append(reqs, req)
 		}
 	}
-	return nil
+	for _, err := range e.sendViewReqs(ctx, reqs, budget) {
+		span.SetStatus(trace.Status{Code: 2, Message: err.Error()})
+		errs = append(errs, err)
+	}
+	return combineErrors(errs)
 }
 
-func (e *statsExporter) makeReq(vds []*view.Data, limit int) []*monitoringpb.CreateTimeSeriesRequest { //nolint: staticcheck
-	var reqs []*monitoringpb.CreateTimeSeriesRequest //nolint: staticcheck
+// sendViewReqs sends the requests of uploadStats with NumberOfWorkers workers,
+// and returns the errors of all the requests.
+func (e *statsExporter) sendViewReqs(ctx context.Context, reqs []*monitoringpb.CreateTimeSeriesRequest, budget *retryBudget) []error { //nolint: staticcheck
+	if len(reqs) == 0 {
+		return nil
+	}
+	numWorkers := e.o.NumberOfWorkers
+	if numWorkers < minNumWorkers {
+		numWorkers = minNumWorkers
+	}
+	if numWorkers > len(reqs) {
+		numWorkers = len(reqs)
+	}
+
+	reqsChan := make(chan *monitoringpb.CreateTimeSeriesRequest, len(reqs)) //nolint: staticcheck
+	for _, req := range reqs {
+		reqsChan <- req
+	}
+	close(reqsChan)
 
-	var allTimeSeries []*monitoringpb.TimeSeries //nolint: staticcheck
+	send := func(ctx context.Context, req *monitoringpb.CreateTimeSeriesRequest) (int, []error) { //nolint: staticcheck
+		if err := e.sendTimeSeriesReq(ctx, req, createTimeSeriesFunc(e.o.Sink), budget); err != nil {
+			return 0, []error{err}
+		}
+		return 0, nil
+	}
+	respsChan := make(chan *response, numWorkers)
+	var wg, inflight sync.WaitGroup
+	wg.Add(numWorkers)
+	inflight.Add(len(reqs))
+	for i := 0; i < numWorkers; i++ {
+		go newWorker(ctx, reqsChan, respsChan, &wg, &inflight, e.o.Timeout, send).start()
+	}
+	wg.Wait()
+	close(respsChan)
+
+	var errs []error
+	for resp := range respsChan {
+		errs = append(errs, resp.errs...)
+	}
+	return errs
+}
+
+// combineErrors returns nil for no errors, the error itself for a single
+// error, and an error joining all messages otherwise.
+func combineErrors(errs []error) error {
+	numErrors := len(errs)
+	if numErrors == 0 {
+		return nil
+	} else if numErrors == 1 {
+		return errs[0]
+	}
+	errMsgs := make([]string, 0, numErrors)
+	for _, err := range errs {
+		errMsgs = append(errMsgs, err.Error())
+	}
+	return fmt.Errorf("[%s]", strings.Join(errMsgs, "; "))
+}
+
+func (e *statsExporter) makeReq(vds []*view.Data, limit int) []*monitoringpb.CreateTimeSeriesRequest { //nolint: staticcheck
+	var rows int
 	for _, vd := range vds {
+		rows += len(vd.Rows)
+	}
+	allTimeSeries := make([]*monitoringpb.TimeSeries, 0, rows) //nolint: staticcheck
+	for _, vd := range vds {
+		if len(vd.Rows) == 0 {
+			continue
+		}
+		labels := e.viewLabels(vd.View)
 		for _, row := range vd.Rows {
+			if dd, ok := row.Data.(*view.DistributionData); ok && dd.Count == 0 && e.o.DropEmptyDistributions {
+				continue
+			}
 			tags, resource := e.getMonitoredResource(vd.View, append([]tag.Tag(nil), row.Tags...))
 			ts := &monitoringpb.TimeSeries{ //nolint: staticcheck
 				Metric: &metricpb.Metric{
 					Type:   e.metricType(vd.View),
-					Labels: newLabels(e.defaultLabels, tags),
+					Labels: e.newLabels(labels, tags),
 				},
 				Resource: resource,
-				Points:   []*monitoringpb.Point{newPoint(vd.View, row, vd.Start, vd.End)}, //nolint: staticcheck
+				Points:   []*monitoringpb.Point{newPoint(vd.View, row, e.correctClockSkew(vd.Start), e.correctClockSkew(vd.End))}, //nolint: staticcheck
+			}
+			if e.o.OmitCumulativeStartTime && vd.View.Aggregation.Type != view.AggTypeLastValue {
+				ts.Points[0].Interval.StartTime = nil
+			}
+			if dd, ok := row.Data.(*view.DistributionData); ok && dd.Count > 0 && e.o.ReportDistributionRange {
+				ts.Points[0].Value.GetDistributionValue().Range = &distributionpb.Distribution_Range{
+					Min: dd.Min,
+					Max: dd.Max,
+				}
+			}
+			if err := e.checkDistributionBuckets(ts.Points[0].Value); err != nil {
+				e.o.handleError(fmt.Errorf("dropping time series of view %q: %v", vd.View.Name, err))
+				continue
+			}
+			if !e.sampleSeries(ts.Metric.Type, ts.Metric.Labels) {
+				continue
 			}
 			allTimeSeries = append(allTimeSeries, ts)
 		}
 	}
+	allTimeSeries = e.limitSeries(allTimeSeries)
 
-	var timeSeries []*monitoringpb.TimeSeries //nolint: staticcheck
-	for _, ts := range allTimeSeries {
-		timeSeries = append(timeSeries, ts)
-		if len(timeSeries) == limit {
-			ctsreql := e.combineTimeSeriesToCreateTimeSeriesRequest(timeSeries)
-			reqs = append(reqs, ctsreql...)
-			timeSeries = timeSeries[:0]
-		}
+	// Most exports fit in a single batch, which is combined without copying
+	// the time series again.
+	if limit <= 0 || len(allTimeSeries) <= limit {
+		return e.combineTimeSeriesToCreateTimeSeriesRequest(allTimeSeries)
 	}
 
-	if len(timeSeries) > 0 {
-		ctsreql := e.combineTimeSeriesToCreateTimeSeriesRequest(timeSeries)
-		reqs = append(reqs, ctsreql...)
+	var reqs []*monitoringpb.CreateTimeSeriesRequest //nolint: staticcheck
+	for start := 0; start < len(allTimeSeries); start += limit {
+		end := start + limit
+		if end > len(allTimeSeries) {
+			end = len(allTimeSeries)
+		}
+		reqs = append(reqs, e.combineTimeSeriesToCreateTimeSeriesRequest(allTimeSeries[start:end])...)
 	}
 	return reqs
 }
@@ -277,16 +427,13 @@ func (e *statsExporter) viewToMetricDescriptor(ctx context.Context, v *view.View
 
 	metricType := e.metricType(v)
 	var valueType metricpb.MetricDescriptor_ValueType
-	unit := m.Unit()
+	unit := viewUnit(v)
 	// Default metric Kind
 	metricKind := metricpb.MetricDescriptor_CUMULATIVE
 
 	switch agg.Type {
 	case view.AggTypeCount:
 		valueType = metricpb.MetricDescriptor_INT64
-		// If the aggregation type is count, which counts the number of recorded measurements, the unit must be "1",
-		// because this view does not apply to the recorded values.
-		unit = stats.UnitDimensionless
 	case view.AggTypeSum:
 		switch m.(type) {
 		case *stats.Int64Measure:
@@ -323,11 +470,24 @@ func (e *statsExporter) viewToMetricDescriptor(ctx context.Context, v *view.View
 		Type:        metricType,
 		MetricKind:  metricKind,
 		ValueType:   valueType,
-		Labels:      newLabelDescriptors(e.defaultLabels, v.TagKeys),
+		Labels:      e.newLabelDescriptors(e.viewLabels(v), v.TagKeys),
 	}
 	return res, nil
 }
 
+// viewUnit returns the unit of the metric descriptor of view v.
+//
+// A count aggregation counts the recorded measurements, not their values, so
+// its unit is always "1". Sum, distribution and last value aggregations keep
+// the unit of the measure: a sum of a dimensionless measure is "1" as well,
+// while e.g. a sum or distribution of a "ms" measure is "ms".
+func viewUnit(v *view.View) string {
+	if v.Aggregation.Type == view.AggTypeCount {
+		return stats.UnitDimensionless
+	}
+	return v.Measure.Unit()
+}
+
 // createMetricDescriptorFromView creates a MetricDescriptor for the given view data in Stackdriver Monitoring.
 // An error will be returned if there is already a metric descriptor created with the same name
 // but it has a different aggregation or keys.
@@ -346,7 +506,7 @@ func (e *statsExporter) createMetricDescriptorFromView(ctx context.Context, v *v
 		return nil
 	}
 
-	if builtinMetric(e.metricType(v)) {
+	if e.skipDescriptorCreation(e.metricType(v)) {
 		e.metricDescriptors[viewName] = true
 		return nil
 	}
@@ -365,6 +525,21 @@ func (e *statsExporter) createMetricDescriptorFromView(ctx context.Context, v *v
 	return nil
 }
 
+// skipDescriptorCreation reports whether the metric descriptor of the given
+// metric type must not be created, either because it is a built-in metric or
+// because CreateDescriptorFilter rejects it.
+func (e *statsExporter) skipDescriptorCreation(metricType string) bool {
+	if builtinMetric(metricType) {
+		return true
+	}
+	for _, prefix := range e.o.AutoManagedMetricPrefixes {
+		if strings.HasPrefix(metricType, prefix) {
+			return true
+		}
+	}
+	return e.o.CreateDescriptorFilter != nil && !e.o.CreateDescriptorFilter(metricType)
+}
+
 func (e *statsExporter) displayName(suffix string) string {
 	if hasDomain(suffix) {
 		// If the display name suffix is already prefixed with domain, skip adding extra prefix
@@ -390,32 +565,9 @@ func (e *statsExporter) combineTimeSeriesToCreateTimeSeriesRequest(ts []*monitor
 	// This scenario happens when we are using the OpenCensus Agent in which multiple metrics
 	// are streamed by various client applications.
 	// See https://github.com/census-ecosystem/opencensus-go-exporter-stackdriver/issues/73
-	uniqueTimeSeries := make([]*monitoringpb.TimeSeries, 0, len(ts))    //nolint: staticcheck
-	nonUniqueTimeSeries := make([]*monitoringpb.TimeSeries, 0, len(ts)) //nolint: staticcheck
-	seenMetrics := make(map[string]struct{})
-
-	for _, tti := range ts {
-		key := metricSignature(tti.Metric)
-		if _, alreadySeen := seenMetrics[key]; !alreadySeen {
-			uniqueTimeSeries = append(uniqueTimeSeries, tti)
-			seenMetrics[key] = struct{}{}
-		} else {
-			nonUniqueTimeSeries = append(nonUniqueTimeSeries, tti)
-		}
-	}
-
-	// UniqueTimeSeries can be bunched up together
-	// While for each nonUniqueTimeSeries, we have
-	// to make a unique CreateTimeSeriesRequest.
-	ctsreql = append(ctsreql, &monitoringpb.CreateTimeSeriesRequest{ //nolint: staticcheck
-		Name:       fmt.Sprintf("projects/%s", e.o.ProjectID),
-		TimeSeries: uniqueTimeSeries,
-	})
-
-	// Now recursively also combine the non-unique TimeSeries
-	// that were singly added to nonUniqueTimeSeries.
-	// The reason is that we need optimal combinations
-	// for optimal combinations because:
+	//
+	// The n-th occurrence of a metric signature therefore goes into the n-th request,
+	// which yields the fewest requests. For example:
 	// * "a/b/c"
 	// * "a/b/c"
 	// * "x/y/z"
@@ -425,11 +577,22 @@ func (e *statsExporter) combineTimeSeriesToCreateTimeSeriesRequest(ts []*monitor
 	// * "d/y/z"
 	//
 	// should produce:
-	//      CreateTimeSeries(uniqueTimeSeries)    :: ["a/b/c", "x/y/z", "p/y/z", "d/y/z"]
-	//      CreateTimeSeries(nonUniqueTimeSeries) :: ["a/b/c"]
-	//      CreateTimeSeries(nonUniqueTimeSeries) :: ["a/b/c", "x/y/z"]
-	nonUniqueRequests := e.combineTimeSeriesToCreateTimeSeriesRequest(nonUniqueTimeSeries)
-	ctsreql = append(ctsreql, nonUniqueRequests...)
+	//      CreateTimeSeries(firstOccurrences)  :: ["a/b/c", "x/y/z", "p/y/z", "d/y/z"]
+	//      CreateTimeSeries(secondOccurrences) :: ["a/b/c", "x/y/z"]
+	//      CreateTimeSeries(thirdOccurrences)  :: ["a/b/c"]
+	name := e.o.requestName()
+	occurrences := make(map[string]int, len(ts))
+	for _, tti := range ts {
+		key := metricSignature(tti.Metric)
+		n := occurrences[key]
+		occurrences[key] = n + 1
+		if n == len(ctsreql) {
+			ctsreql = append(ctsreql, &monitoringpb.CreateTimeSeriesRequest{ //nolint: staticcheck
+				Name: name,
+			})
+		}
+		ctsreql[n].TimeSeries = append(ctsreql[n].TimeSeries, tti)
+	}
 
 	return ctsreql
 }
@@ -476,6 +639,10 @@ func toValidTimeIntervalpb(start, end time.Time) *monitoringpb.TimeInterval { //
 	}
 }
 
+// newCumulativePoint returns the point of a cumulative row over [start, end].
+// view.Row carries no start time of its own, so all rows of a view data share
+// the start of the view data; per-series start times are only honored on the
+// metricdata path, which uses metricdata.TimeSeries.StartTime.
 func newCumulativePoint(v *view.View, row *view.Row, start, end time.Time) *monitoringpb.Point { //nolint: staticcheck
 	return &monitoringpb.Point{ //nolint: staticcheck
 		Interval: toValidTimeIntervalpb(start, end),
@@ -496,6 +663,22 @@ func newGaugePoint(v *view.View, row *view.Row, end time.Time) *monitoringpb.Poi
 	}
 }
 
+// float64ToInt64 converts the value of an Int64Measure aggregation, which
+// OpenCensus stores as a float64, back to an int64. Values are exact up to
+// 2^53 in magnitude; larger values have already been rounded by OpenCensus.
+// The value is rounded to the nearest integer and clamped to the int64 range.
+func float64ToInt64(v float64) int64 {
+	switch {
+	case math.IsNaN(v):
+		return 0
+	case v >= math.MaxInt64:
+		return math.MaxInt64
+	case v <= math.MinInt64:
+		return math.MinInt64
+	}
+	return int64(math.Round(v))
+}
+
 func newTypedValue(vd *view.View, r *view.Row) *monitoringpb.TypedValue { //nolint: staticcheck
 	switch v := r.Data.(type) {
 	case *view.CountData:
@@ -506,7 +689,7 @@ func newTypedValue(vd *view.View, r *view.Row) *monitoringpb.TypedValue { //noli
 		switch vd.Measure.(type) {
 		case *stats.Int64Measure:
 			return &monitoringpb.TypedValue{Value: &monitoringpb.TypedValue_Int64Value{ //nolint: staticcheck
-				Int64Value: int64(v.Value),
+				Int64Value: float64ToInt64(v.Value),
 			}}
 		case *stats.Float64Measure:
 			return &monitoringpb.TypedValue{Value: &monitoringpb.TypedValue_DoubleValue{ //nolint: staticcheck
@@ -520,11 +703,7 @@ func newTypedValue(vd *view.View, r *view.Row) *monitoringpb.TypedValue { //noli
 				Count:                 v.Count,
 				Mean:                  v.Mean,
 				SumOfSquaredDeviation: v.SumOfSquaredDev,
-				// TODO(songya): uncomment this once Stackdriver supports min/max.
-				// Range: &distributionpb.Distribution_Range{
-				// 	Min: v.Min,
-				// 	Max: v.Max,
-				// },
+				// The range is set by makeReq if Options.ReportDistributionRange is set.
 				BucketOptions: &distributionpb.Distribution_BucketOptions{
 					Options: &distributionpb.Distribution_BucketOptions_ExplicitBuckets{
 						ExplicitBuckets: &distributionpb.Distribution_BucketOptions_Explicit{
@@ -539,7 +718,7 @@ func newTypedValue(vd *view.View, r *view.Row) *monitoringpb.TypedValue { //noli
 		switch vd.Measure.(type) {
 		case *stats.Int64Measure:
 			return &monitoringpb.TypedValue{Value: &monitoringpb.TypedValue_Int64Value{ //nolint: staticcheck
-				Int64Value: int64(v.Value),
+				Int64Value: float64ToInt64(v.Value),
 			}}
 		case *stats.Float64Measure:
 			return &monitoringpb.TypedValue{Value: &monitoringpb.TypedValue_DoubleValue{ //nolint: staticcheck
@@ -550,6 +729,139 @@ func newTypedValue(vd *view.View, r *view.Row) *monitoringpb.TypedValue { //noli
 	return nil
 }
 
+// DistributionCountPolicy configures how to handle a distribution whose count
+// differs from the sum of its bucket counts.
+type DistributionCountPolicy int
+
+const (
+	// KeepDistributionCount sends the distribution unchanged.
+	KeepDistributionCount DistributionCountPolicy = iota
+	// RepairDistributionCount sets the count of the distribution to the sum of
+	// its bucket counts, and rescales its mean accordingly.
+	RepairDistributionCount
+	// RejectDistributionCount drops the time series of the distribution and
+	// reports an error.
+	RejectDistributionCount
+)
+
+// checkDistributionCount applies the DistributionCountPolicy to the
+// distribution held by v, if any. Distributions without buckets are left alone.
+func (e *statsExporter) checkDistributionCount(v *monitoringpb.TypedValue) error { //nolint: staticcheck
+	d := v.GetDistributionValue()
+	if e.o.DistributionCountPolicy == KeepDistributionCount || d == nil || len(d.BucketCounts) == 0 {
+		return nil
+	}
+	var sum int64
+	for _, c := range d.BucketCounts {
+		sum += c
+	}
+	if sum == d.Count {
+		return nil
+	}
+	if e.o.DistributionCountPolicy == RejectDistributionCount {
+		return fmt.Errorf("distribution count %d differs from the sum %d of its bucket counts", d.Count, sum)
+	}
+	if sum == 0 {
+		d.Mean, d.SumOfSquaredDeviation = 0, 0
+	} else {
+		d.Mean = d.Mean * float64(d.Count) / float64(sum)
+	}
+	d.Count = sum
+	return nil
+}
+
+// ExcessBucketsPolicy configures how to handle a distribution with more than
+// Options.MaxDistributionBuckets buckets.
+type ExcessBucketsPolicy int
+
+const (
+	// MergeExcessBuckets merges adjacent buckets of the distribution until it
+	// has at most the maximum number of buckets. The count of the distribution
+	// is unchanged and the remaining bucket bounds are a subset of the original
+	// ones. Distributions without explicit bucket bounds are dropped.
+	MergeExcessBuckets ExcessBucketsPolicy = iota
+	// RejectExcessBuckets drops the time series of the distribution and reports
+	// an error.
+	RejectExcessBuckets
+)
+
+// checkDistributionBuckets applies the ExcessBucketsPolicy to the distribution
+// held by v, if any, when it has more than MaxDistributionBuckets buckets.
+func (e *statsExporter) checkDistributionBuckets(v *monitoringpb.TypedValue) error { //nolint: staticcheck
+	d := v.GetDistributionValue()
+	max := e.o.MaxDistributionBuckets
+	if max <= 0 || d == nil || len(d.BucketCounts) <= max {
+		return nil
+	}
+	explicit := d.GetBucketOptions().GetExplicitBuckets()
+	if e.o.ExcessBucketsPolicy == RejectExcessBuckets || explicit == nil || len(explicit.Bounds)+1 != len(d.BucketCounts) {
+		return fmt.Errorf("distribution has %d buckets, more than the maximum of %d", len(d.BucketCounts), max)
+	}
+
+	// Bucket i holds the values in [Bounds[i-1], Bounds[i]), so merging the
+	// buckets of a group keeps the upper bound of its last bucket.
+	group := (len(d.BucketCounts) + max - 1) / max
+	counts := make([]int64, 0, max)
+	bounds := make([]float64, 0, max-1)
+	for start := 0; start < len(d.BucketCounts); start += group {
+		end := start + group
+		if end > len(d.BucketCounts) {
+			end = len(d.BucketCounts)
+		}
+		var count int64
+		for _, c := range d.BucketCounts[start:end] {
+			count += c
+		}
+		counts = append(counts, count)
+		if end < len(d.BucketCounts) {
+			bounds = append(bounds, explicit.Bounds[end-1])
+		}
+	}
+	d.BucketCounts = counts
+	d.BucketOptions = &distributionpb.Distribution_BucketOptions{
+		Options: &distributionpb.Distribution_BucketOptions_ExplicitBuckets{
+			ExplicitBuckets: &distributionpb.Distribution_BucketOptions_Explicit{Bounds: bounds},
+		},
+	}
+	return nil
+}
+
+// dropEmptyDistributions returns the points of pts, except the distributions
+// without any value.
+func dropEmptyDistributions(pts []*monitoringpb.Point) []*monitoringpb.Point { //nolint: staticcheck
+	kept := pts[:0]
+	for _, pt := range pts {
+		if d := pt.GetValue().GetDistributionValue(); d != nil && d.Count == 0 {
+			continue
+		}
+		kept = append(kept, pt)
+	}
+	return kept
+}
+
+// validateBucketBounds returns an error unless the bucket bounds are strictly
+// increasing, as required by Stackdriver Monitoring.
+func validateBucketBounds(bounds []float64) error {
+	for i := 1; i < len(bounds); i++ {
+		if bounds[i] <= bounds[i-1] {
+			return fmt.Errorf("distribution bucket bounds must be strictly increasing, got bounds[%d]=%v and bounds[%d]=%v", i-1, bounds[i-1], i, bounds[i])
+		}
+	}
+	return nil
+}
+
+// shouldInsertZeroBound reports whether a 0 bound must be prepended to the
+// bucket bounds of a distribution, which is the case when its first bound is
+// positive.
+//
+// OpenCensus has no underflow bucket: its first bucket holds every value below
+// the first bound, negative values included. Stackdriver Monitoring buckets
+// start with (-infinity, 0) instead, so the OpenCensus buckets are sent after an
+// always empty (-infinity, 0) bucket, and negative values are reported in the
+// [0, first bound) bucket. Distributions that may record negative values should
+// use a first bound of 0 or below, in which case no bound is inserted and
+// negative values below the first bound are reported in the (-infinity, first
+// bound) bucket.
 func shouldInsertZeroBound(bounds ...float64) bool {
 	if len(bounds) > 0 && bounds[0] > 0.0 {
 		return true
@@ -575,32 +887,115 @@ func (e *statsExporter) metricType(v *view.View) string {
 	if formatter := e.o.GetMetricType; formatter != nil {
 		return formatter(v)
 	}
+	if getPrefix := e.o.GetMetricPrefixForView; getPrefix != nil {
+		return metricTypeWithPrefix(getPrefix(v), v.Name)
+	}
 	return path.Join("custom.googleapis.com", "opencensus", v.Name)
 }
 
-func newLabels(defaults map[string]labelValue, tags []tag.Tag) map[string]string {
+// sampleSeries reports whether the time series of metricType with the given
+// labels is kept by the SampleSeries option.
+func (e *statsExporter) sampleSeries(metricType string, labels map[string]string) bool {
+	return e.o.SampleSeries == nil || e.o.SampleSeries(metricType, labels)
+}
+
+// metricLabels returns the default labels to add to the named metric, which has
+// numKeys label keys of its own. The original name label of IncludeOriginalNameLabel
+// and the exporter version label of IncludeExporterVersionLabel are included, in
+// that order, as long as the metric still has room for them. The labels left out
+// are reported through OnError.
+func (e *statsExporter) metricLabels(name string, numKeys int) map[string]labelValue {
+	labels, dropped := e.optionalLabels(name, numKeys, "")
+	e.reportDroppedLabels(name, dropped)
+	return labels
+}
+
+// viewLabels returns the default labels of the time series of view v, with
+// the measure name label of IncludeMeasureNameLabel after the labels of
+// metricLabels.
+func (e *statsExporter) viewLabels(v *view.View) map[string]labelValue {
+	var measureName string
+	if e.o.IncludeMeasureNameLabel && v.Measure != nil {
+		measureName = v.Measure.Name()
+	}
+	labels, dropped := e.optionalLabels(v.Name, len(v.TagKeys), measureName)
+	e.reportDroppedLabels(v.Name, dropped)
+	return labels
+}
+
+// optionalLabels returns the default labels of the named metric with the
+// optional labels that fit within maxLabelsPerMetric, and the number of
+// optional labels left out. The measure name label is added if measureName
+// is not empty.
+func (e *statsExporter) optionalLabels(name string, numKeys int, measureName string) (map[string]labelValue, int) {
+	if !e.o.IncludeOriginalNameLabel && !e.o.IncludeExporterVersionLabel && measureName == "" {
+		return e.defaultLabels, 0
+	}
+	labels := make(map[string]labelValue, len(e.defaultLabels)+3)
+	for k, lbl := range e.defaultLabels {
+		labels[k] = lbl
+	}
+	var dropped int
+	add := func(key string, lbl labelValue) {
+		if len(labels)+numKeys >= maxLabelsPerMetric {
+			dropped++
+			return
+		}
+		labels[key] = lbl
+	}
+	if e.o.IncludeOriginalNameLabel {
+		key := e.o.OriginalNameLabelKey
+		if key == "" {
+			key = defaultOriginalNameLabelKey
+		}
+		add(e.o.sanitize(key), labelValue{val: name, desc: originalNameLabelDescription})
+	}
+	if e.o.IncludeExporterVersionLabel {
+		add(exporterVersionLabelKey, labelValue{val: version, desc: exporterVersionLabelDescription})
+	}
+	if measureName != "" {
+		add(measureNameLabelKey, labelValue{val: measureName, desc: measureNameLabelDescription})
+	}
+	return labels, dropped
+}
+
+// reportDroppedLabels reports through OnError, once per metric, that dropped
+// optional labels of the named metric were left out by the label limit.
+func (e *statsExporter) reportDroppedLabels(name string, dropped int) {
+	if dropped == 0 {
+		return
+	}
+	if _, reported := e.droppedLabelsReported.LoadOrStore(name, true); reported {
+		return
+	}
+	e.o.handleError(fmt.Errorf("metric %q: dropped %d optional labels over the limit of %d labels per metric", name, dropped, maxLabelsPerMetric))
+}
+
+func (e *statsExporter) newLabels(defaults map[string]labelValue, tags []tag.Tag) map[string]string {
 	labels := make(map[string]string)
 	for k, lbl := range defaults {
-		labels[sanitize(k)] = lbl.val
+		key := e.o.sanitize(k)
+		labels[key] = e.o.sanitizeLabelValue(key, lbl.val)
 	}
 	for _, tag := range tags {
-		labels[sanitize(tag.Key.Name())] = tag.Value
+		key := e.o.sanitize(tag.Key.Name())
+		labels[key] = e.o.sanitizeLabelValue(key, tag.Value)
 	}
 	return labels
 }
 
-func newLabelDescriptors(defaults map[string]labelValue, keys []tag.Key) []*labelpb.LabelDescriptor {
+func (e *statsExporter) newLabelDescriptors(defaults map[string]labelValue, keys []tag.Key) []*labelpb.LabelDescriptor {
 	labelDescriptors := make([]*labelpb.LabelDescriptor, 0, len(keys)+len(defaults))
 	for key, lbl := range defaults {
 		labelDescriptors = append(labelDescriptors, &labelpb.LabelDescriptor{
-			Key:         sanitize(key),
+			Key:         e.o.sanitize(key),
 			Description: lbl.desc,
 			ValueType:   labelpb.LabelDescriptor_STRING,
 		})
 	}
 	for _, key := range keys {
 		labelDescriptors = append(labelDescriptors, &labelpb.LabelDescriptor{
-			Key:       sanitize(key.Name()),
+			Key:       e.o.sanitize(key.Name()),
 			ValueType: labelpb.LabelDescriptor_STRING, // We only use string tags
 		})
 	}
@@ -610,18 +1005,91 @@ func newLabelDescriptors(defaults map[string]labelValue, keys []tag.Key) []*labe
 func (e *statsExporter) createMetricDescriptor(ctx context.Context, md *metricpb.MetricDescriptor) error {
 	ctx, cancel := newContextWithTimeout(ctx, e.o.Timeout)
 	defer cancel()
+	if e.o.RequireExistingDescriptor {
+		return e.checkMetricDescriptor(ctx, md)
+	}
 	cmrdesc := &monitoringpb.CreateMetricDescriptorRequest{ //nolint: staticcheck
 		Name:             fmt.Sprintf("projects/%s", e.o.ProjectID),
 		MetricDescriptor: md,
 	}
-	_, err := createMetricDescriptor(ctx, e.c, cmrdesc)
+	_, err := e.sendCreateMetricDescriptor(ctx, cmrdesc)
+	if err != nil && e.o.OverwriteMetricDescriptors && descriptorConflict(err) {
+		dmdreq := &monitoringpb.DeleteMetricDescriptorRequest{ //nolint: staticcheck
+			Name: fmt.Sprintf("projects/%s/metricDescriptors/%s", e.o.ProjectID, md.Type),
+		}
+		if err := e.sendDeleteMetricDescriptor(ctx, dmdreq); err != nil {
+			return fmt.Errorf("failed to delete conflicting metric descriptor %q: %v", md.Type, err)
+		}
+		_, err = e.sendCreateMetricDescriptor(ctx, cmrdesc)
+	}
 	return err
 }
 
+// descriptorConflict reports whether the error of a CreateMetricDescriptor
+// call is caused by an existing descriptor of the same type.
+func descriptorConflict(err error) bool {
+	switch status.Code(err) {
+	case codes.AlreadyExists, codes.FailedPrecondition:
+		return true
+	}
+	return false
+}
+
+// deleteMetricDescriptor deletes the descriptor of metricType. The cache of
+// created descriptors is keyed by view and metric name rather than by metric
+// type, so it is cleared and the descriptors are created again on their next
+// export.
+func (e *statsExporter) deleteMetricDescriptor(ctx context.Context, metricType string) error {
+	ctx, cancel := newContextWithTimeout(ctx, e.o.Timeout)
+	defer cancel()
+
+	e.metricMu.Lock()
+	defer e.metricMu.Unlock()
+	e.protoMu.Lock()
+	defer e.protoMu.Unlock()
+
+	dmdreq := &monitoringpb.DeleteMetricDescriptorRequest{ //nolint: staticcheck
+		Name: fmt.Sprintf("projects/%s/metricDescriptors/%s", e.o.ProjectID, metricType),
+	}
+	if err := e.sendDeleteMetricDescriptor(ctx, dmdreq); err != nil {
+		return err
+	}
+	e.metricDescriptors = make(map[string]bool)
+	e.protoMetricDescriptors = make(map[string]bool)
+	return nil
+}
+
+// checkMetricDescriptor returns an error unless a descriptor compatible with md
+// already exists.
+func (e *statsExporter) checkMetricDescriptor(ctx context.Context, md *metricpb.MetricDescriptor) error {
+	gmdreq := &monitoringpb.GetMetricDescriptorRequest{ //nolint: staticcheck
+		Name: fmt.Sprintf("projects/%s/metricDescriptors/%s", e.o.ProjectID, md.Type),
+	}
+	existing, err := e.sendGetMetricDescriptor(ctx, gmdreq)
+	if status.Code(err) == codes.NotFound {
+		return fmt.Errorf("metric descriptor %q does not exist", md.Type)
+	}
+	if err != nil {
+		return err
+	}
+	if existing.MetricKind != md.MetricKind || existing.ValueType != md.ValueType {
+		return fmt.Errorf("metric descriptor %q is %s %s, want %s %s", md.Type, existing.MetricKind, existing.ValueType, md.MetricKind, md.ValueType)
+	}
+	return nil
+}
+
+var getMetricDescriptor = func(ctx context.Context, c *monitoring.MetricClient, mdr *monitoringpb.GetMetricDescriptorRequest) (*metricpb.MetricDescriptor, error) { //nolint: staticcheck
+	return c.GetMetricDescriptor(ctx, mdr)
+}
+
 var createMetricDescriptor = func(ctx context.Context, c *monitoring.MetricClient, mdr *monitoringpb.CreateMetricDescriptorRequest) (*metricpb.MetricDescriptor, error) { //nolint: staticcheck //nolint: staticcheck
 	return c.CreateMetricDescriptor(ctx, mdr)
 }
 
+var deleteMetricDescriptor = func(ctx context.Context, c *monitoring.MetricClient, mdr *monitoringpb.DeleteMetricDescriptorRequest) error { //nolint: staticcheck
+	return c.DeleteMetricDescriptor(ctx, mdr)
+}
+
 var createTimeSeries = func(ctx context.Context, c *monitoring.MetricClient, ts *monitoringpb.CreateTimeSeriesRequest) error { //nolint: staticcheck
 	return c.CreateTimeSeries(ctx, ts)
 }
@@ -16,7 +16,11 @@ package stackdriver
 
 import (
 	"context"
+	"errors"
 	"fmt"
+	"math"
+	"strings"
+	"sync"
 	"testing"
 	"time"
 
@@ -34,6 +38,8 @@ import (
 	monitoredrespb "google.golang.org/genproto/googleapis/api/monitoredres"
 	monitoringpb "google.golang.org/genproto/googleapis/monitoring/v3" //nolint: staticcheck
 	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
 	"google.golang.org/protobuf/testing/protocmp"
 )
 
@@ -494,6 +500,39 @@ func TestExporter_makeReq(t *testing.T) {
 	}
 }
 
+func TestExporter_metricTypeWithPrefixForView(t *testing.T) {
+	m := stats.Int64("test-measure/prefix_for_view", "measure desc", "1")
+	rpcView := &view.View{Name: "rpc/latency", Measure: m, Aggregation: view.Count()}
+	dbView := &view.View{Name: "db/latency", Measure: m, Aggregation: view.Count()}
+
+	opts := testOptions
+	opts.GetMetricPrefixForView = func(v *view.View) string {
+		if strings.HasPrefix(v.Name, "db/") {
+			return "external.googleapis.com/storage"
+		}
+		return "myorg"
+	}
+	e, err := newStatsExporter(opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if got, want := e.metricType(rpcView), "custom.googleapis.com/opencensus/myorg/rpc/latency"; got != want {
+		t.Errorf("metricType(rpcView) = %q; want %q", got, want)
+	}
+	if got, want := e.metricType(dbView), "external.googleapis.com/storage/db/latency"; got != want {
+		t.Errorf("metricType(dbView) = %q; want %q", got, want)
+	}
+
+	// GetMetricType takes precedence.
+	e.o.GetMetricType = func(v *view.View) string {
+		return "workload.googleapis.com/" + v.Name
+	}
+	if got, want := e.metricType(dbView), "workload.googleapis.com/db/latency"; got != want {
+		t.Errorf("metricType(dbView) = %q; want %q", got, want)
+	}
+}
+
 func TestTimeIntervalStaggering(t *testing.T) {
 	now := time.Now()
 
@@ -672,7 +711,7 @@ func TestExporter_createMetricDescriptorFromView(t *testing.T) {
 					Type:        "custom.googleapis.com/opencensus/test_view_sum",
 					MetricKind:  metricpb.MetricDescriptor_CUMULATIVE,
 					ValueType:   metricpb.MetricDescriptor_DOUBLE,
-					Labels:      newLabelDescriptors(e.defaultLabels, vd.View.TagKeys),
+					Labels:      e.newLabelDescriptors(e.defaultLabels, vd.View.TagKeys),
 				}, nil
 			}
 
@@ -693,6 +732,38 @@ func TestExporter_createMetricDescriptorFromView(t *testing.T) {
 	}
 }
 
+func TestExporter_viewToMetricDescriptorUnits(t *testing.T) {
+	dimensionless := stats.Int64("test-measure/TestExporter_viewToMetricDescriptorUnits/1", "measure desc", stats.UnitDimensionless)
+	latency := stats.Float64("test-measure/TestExporter_viewToMetricDescriptorUnits/ms", "measure desc", stats.UnitMilliseconds)
+	tests := []struct {
+		name     string
+		measure  stats.Measure
+		agg      *view.Aggregation
+		wantUnit string
+	}{
+		{name: "count of dimensionless", measure: dimensionless, agg: view.Count(), wantUnit: "1"},
+		{name: "count of ms", measure: latency, agg: view.Count(), wantUnit: "1"},
+		{name: "sum of dimensionless", measure: dimensionless, agg: view.Sum(), wantUnit: "1"},
+		{name: "sum of ms", measure: latency, agg: view.Sum(), wantUnit: "ms"},
+		{name: "distribution of dimensionless", measure: dimensionless, agg: view.Distribution(1, 2), wantUnit: "1"},
+		{name: "distribution of ms", measure: latency, agg: view.Distribution(1, 2), wantUnit: "ms"},
+		{name: "last value of ms", measure: latency, agg: view.LastValue(), wantUnit: "ms"},
+	}
+	e := &statsExporter{o: Options{ProjectID: "test_project"}}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			v := &view.View{Name: "unit_view", Measure: tt.measure, Aggregation: tt.agg}
+			md, err := e.viewToMetricDescriptor(context.Background(), v)
+			if err != nil {
+				t.Fatalf("viewToMetricDescriptor() error = %v", err)
+			}
+			if md.Unit != tt.wantUnit {
+				t.Errorf("unit = %q; want %q", md.Unit, tt.wantUnit)
+			}
+		})
+	}
+}
+
 func TestExporter_createMetricDescriptorFromView_CountAggregation(t *testing.T) {
 	oldCreateMetricDescriptor := createMetricDescriptor
 
@@ -748,7 +819,7 @@ func TestExporter_createMetricDescriptorFromView_CountAggregation(t *testing.T)
 			Type:        "custom.googleapis.com/opencensus/test_view_count",
 			MetricKind:  metricpb.MetricDescriptor_CUMULATIVE,
 			ValueType:   metricpb.MetricDescriptor_INT64,
-			Labels:      newLabelDescriptors(nil, vd.View.TagKeys),
+			Labels:      e.newLabelDescriptors(nil, vd.View.TagKeys),
 		}, nil
 	}
 	ctx := context.Background()
@@ -1106,6 +1177,48 @@ func TestExporter_makeReq_withCustomMonitoredResource(t *testing.T) {
 	}
 }
 
+func TestExporter_makeReq_withMonitoredResourceOnly(t *testing.T) {
+	v := &view.View{
+		Name:        "monitored_resource_view",
+		Measure:     stats.Int64("test-measure/TestExporter_makeReq_withMonitoredResourceOnly", "measure desc", stats.UnitDimensionless),
+		Aggregation: view.Count(),
+	}
+	// The stats exporter is created directly, so MonitoredResource is not
+	// converted into Resource like NewExporter does.
+	e, err := newStatsExporter(Options{
+		ProjectID:               "proj-id",
+		MonitoringClientOptions: authOptions,
+		MonitoredResource: &monitoredresource.GCEInstance{
+			ProjectID:  "proj-id",
+			InstanceID: "instance",
+			Zone:       "us-west-1a",
+		},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := &monitoredrespb.MonitoredResource{
+		Type: "gce_instance",
+		Labels: map[string]string{
+			"project_id":  "proj-id",
+			"instance_id": "instance",
+			"zone":        "us-west-1a",
+		},
+	}
+
+	start := time.Now()
+	data := &view.CountData{Value: 1}
+	reqs := e.makeReq([]*view.Data{newTestViewData(v, start, start.Add(time.Minute), data, data)}, maxTimeSeriesPerUpload)
+	if len(reqs) != 1 || len(reqs[0].TimeSeries) != 2 {
+		t.Fatalf("makeReq() = %v; want 1 request with 2 time series", reqs)
+	}
+	for _, ts := range reqs[0].TimeSeries {
+		if diff := cmp.Diff(ts.Resource, want, protocmp.Transform()); diff != "" {
+			t.Errorf("Resource differs, -got +want: %s", diff)
+		}
+	}
+}
+
 func TestSplitCreateTimeSeriesRequest(t *testing.T) {
 	tests := []struct {
 		name              string
@@ -1391,41 +1504,1237 @@ func TestExporter_customContext(t *testing.T) {
 	}
 }
 
-func newTestViewData(v *view.View, start, end time.Time, data1, data2 view.AggregationData) *view.Data {
-	key, _ := tag.NewKey("test-key")
-	tag1 := tag.Tag{Key: key, Value: "test-value-1"}
-	tag2 := tag.Tag{Key: key, Value: "test-value-2"}
-	return &view.Data{
-		View: v,
-		Rows: []*view.Row{
-			{
-				Tags: []tag.Tag{tag1},
-				Data: data1,
-			},
-			{
-				Tags: []tag.Tag{tag2},
-				Data: data2,
+func TestExporter_uploadStatsAggregatesDescriptorErrors(t *testing.T) {
+	oldCreateMetricDescriptor := createMetricDescriptor
+	oldCreateTimeSeries := createTimeSeries
+
+	defer func() {
+		createMetricDescriptor = oldCreateMetricDescriptor
+		createTimeSeries = oldCreateTimeSeries
+	}()
+
+	createMetricDescriptor = func(ctx context.Context, c *monitoring.MetricClient, mdr *monitoringpb.CreateMetricDescriptorRequest) (*metricpb.MetricDescriptor, error) { //nolint: staticcheck
+		if strings.HasSuffix(mdr.MetricDescriptor.Type, "bad_view") {
+			return nil, errors.New("descriptor rejected")
+		}
+		return mdr.MetricDescriptor, nil
+	}
+	var uploaded []string
+	createTimeSeries = func(ctx context.Context, c *monitoring.MetricClient, req *monitoringpb.CreateTimeSeriesRequest) error { //nolint: staticcheck
+		for _, ts := range req.TimeSeries {
+			uploaded = append(uploaded, ts.Metric.Type)
+		}
+		return errors.New("time series rejected")
+	}
+
+	m := stats.Int64("test-measure/TestExporter_uploadStatsAggregatesDescriptorErrors", "measure desc", stats.UnitDimensionless)
+	badView := &view.View{Name: "bad_view", Measure: m, Aggregation: view.Count()}
+	goodView := &view.View{Name: "good_view", Measure: m, Aggregation: view.Count()}
+	data := &view.CountData{Value: 1}
+	vds := []*view.Data{
+		newTestViewData(badView, time.Now(), time.Now(), data, data),
+		newTestViewData(goodView, time.Now(), time.Now(), data, data),
+	}
+
+	e := &statsExporter{
+		metricDescriptors: make(map[string]bool),
+		o:                 Options{ProjectID: "test_project"},
+	}
+	err := e.uploadStats(vds)
+	if err == nil {
+		t.Fatal("Exporter.uploadStats() error = nil; want combined error")
+	}
+	for _, want := range []string{
+		`failed to create metric descriptor for view "bad_view": descriptor rejected`,
+		"time series rejected",
+	} {
+		if !strings.Contains(err.Error(), want) {
+			t.Errorf("Exporter.uploadStats() error = %q; want it to contain %q", err, want)
+		}
+	}
+
+	wantType := "custom.googleapis.com/opencensus/good_view"
+	var found bool
+	for _, typ := range uploaded {
+		if typ == wantType {
+			found = true
+		}
+	}
+	if !found {
+		t.Errorf("uploaded metric types = %v; want %q to be uploaded", uploaded, wantType)
+	}
+}
+
+func TestExporter_uploadStatsRetryDroppedTimeSeriesOnExport(t *testing.T) {
+	oldCreateMetricDescriptor := createMetricDescriptor
+	oldCreateTimeSeries := createTimeSeries
+	defer func() {
+		createMetricDescriptor = oldCreateMetricDescriptor
+		createTimeSeries = oldCreateTimeSeries
+	}()
+
+	createMetricDescriptor = func(ctx context.Context, c *monitoring.MetricClient, mdr *monitoringpb.CreateMetricDescriptorRequest) (*metricpb.MetricDescriptor, error) { //nolint: staticcheck
+		return mdr.MetricDescriptor, nil
+	}
+	var sizes []int
+	createTimeSeries = func(ctx context.Context, c *monitoring.MetricClient, req *monitoringpb.CreateTimeSeriesRequest) error { //nolint: staticcheck
+		sizes = append(sizes, len(req.TimeSeries))
+		if len(sizes) == 1 {
+			return errors.New("One or more TimeSeries could not be written: Internal error encountered. Please retry after a few seconds.: timeSeries[1]")
+		}
+		return nil
+	}
+
+	m := stats.Int64("test-measure/TestExporter_uploadStatsRetryDroppedTimeSeriesOnExport", "measure desc", stats.UnitDimensionless)
+	v := &view.View{Name: "retried_view", Measure: m, Aggregation: view.Count()}
+	data := &view.CountData{Value: 1}
+
+	tests := []struct {
+		name      string
+		o         Options
+		wantSizes []int
+		wantErr   bool
+	}{
+		{
+			name:      "RetryDroppedTimeSeries only",
+			o:         Options{ProjectID: "test_project", RetryDroppedTimeSeries: true},
+			wantSizes: []int{2},
+			wantErr:   true,
+		},
+		{
+			name:      "RetryDroppedTimeSeriesOnExport",
+			o:         Options{ProjectID: "test_project", RetryDroppedTimeSeriesOnExport: true},
+			wantSizes: []int{2, 1},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			sizes = nil
+			e := &statsExporter{metricDescriptors: make(map[string]bool), o: tt.o}
+			err := e.uploadStats([]*view.Data{newTestViewData(v, time.Now(), time.Now(), data, data)})
+			if (err != nil) != tt.wantErr {
+				t.Errorf("Exporter.uploadStats() error = %v; want error %v", err, tt.wantErr)
+			}
+			if diff := cmp.Diff(sizes, tt.wantSizes); diff != "" {
+				t.Errorf("CreateTimeSeries request sizes -got +want: %s", diff)
+			}
+		})
+	}
+}
+
+func TestExporter_uploadStatsRequireExistingDescriptor(t *testing.T) {
+	oldGetMetricDescriptor := getMetricDescriptor
+	oldCreateMetricDescriptor := createMetricDescriptor
+	oldCreateTimeSeries := createTimeSeries
+	defer func() {
+		getMetricDescriptor = oldGetMetricDescriptor
+		createMetricDescriptor = oldCreateMetricDescriptor
+		createTimeSeries = oldCreateTimeSeries
+	}()
+
+	getMetricDescriptor = func(ctx context.Context, c *monitoring.MetricClient, mdr *monitoringpb.GetMetricDescriptorRequest) (*metricpb.MetricDescriptor, error) { //nolint: staticcheck
+		if strings.HasSuffix(mdr.Name, "missing_view") {
+			return nil, status.Error(codes.NotFound, "not found")
+		}
+		return &metricpb.MetricDescriptor{MetricKind: metricpb.MetricDescriptor_CUMULATIVE, ValueType: metricpb.MetricDescriptor_INT64}, nil
+	}
+	createMetricDescriptor = func(ctx context.Context, c *monitoring.MetricClient, mdr *monitoringpb.CreateMetricDescriptorRequest) (*metricpb.MetricDescriptor, error) { //nolint: staticcheck
+		t.Errorf("CreateMetricDescriptor called for %q", mdr.MetricDescriptor.Type)
+		return mdr.MetricDescriptor, nil
+	}
+	uploaded := make(map[string]int)
+	createTimeSeries = func(ctx context.Context, c *monitoring.MetricClient, req *monitoringpb.CreateTimeSeriesRequest) error { //nolint: staticcheck
+		for _, ts := range req.TimeSeries {
+			uploaded[ts.Metric.Type]++
+		}
+		return nil
+	}
+
+	m := stats.Int64("test-measure/TestExporter_uploadStatsRequireExistingDescriptor", "measure desc", stats.UnitDimensionless)
+	missingView := &view.View{Name: "missing_view", Measure: m, Aggregation: view.Count()}
+	existingView := &view.View{Name: "existing_view", Measure: m, Aggregation: view.Count()}
+	data := &view.CountData{Value: 1}
+	vds := []*view.Data{
+		newTestViewData(missingView, time.Now(), time.Now(), data, data),
+		newTestViewData(existingView, time.Now(), time.Now(), data, data),
+	}
+
+	e := &statsExporter{
+		metricDescriptors: make(map[string]bool),
+		o:                 Options{ProjectID: "test_project", RequireExistingDescriptor: true},
+	}
+	err := e.uploadStats(vds)
+	if err == nil || !strings.Contains(err.Error(), `metric descriptor "custom.googleapis.com/opencensus/missing_view" does not exist`) {
+		t.Errorf("Exporter.uploadStats() error = %v; want the missing descriptor reported", err)
+	}
+	want := map[string]int{"custom.googleapis.com/opencensus/existing_view": 2}
+	if diff := cmp.Diff(uploaded, want); diff != "" {
+		t.Errorf("uploaded time series -got +want: %s", diff)
+	}
+}
+
+func TestExporter_createMetricDescriptorFromViewOverwrite(t *testing.T) {
+	oldCreateMetricDescriptor := createMetricDescriptor
+	oldDeleteMetricDescriptor := deleteMetricDescriptor
+	defer func() {
+		createMetricDescriptor = oldCreateMetricDescriptor
+		deleteMetricDescriptor = oldDeleteMetricDescriptor
+	}()
+
+	var calls []string
+	deleted := false
+	createMetricDescriptor = func(ctx context.Context, c *monitoring.MetricClient, mdr *monitoringpb.CreateMetricDescriptorRequest) (*metricpb.MetricDescriptor, error) { //nolint: staticcheck
+		calls = append(calls, "create "+mdr.MetricDescriptor.Type)
+		if !deleted {
+			return nil, status.Error(codes.AlreadyExists, "descriptor exists with a different kind")
+		}
+		return mdr.MetricDescriptor, nil
+	}
+	deleteMetricDescriptor = func(ctx context.Context, c *monitoring.MetricClient, mdr *monitoringpb.DeleteMetricDescriptorRequest) error { //nolint: staticcheck
+		calls = append(calls, "delete "+mdr.Name)
+		deleted = true
+		return nil
+	}
+
+	v := &view.View{
+		Name:        "overwritten_view",
+		Measure:     stats.Int64("test-measure/TestExporter_createMetricDescriptorFromViewOverwrite", "measure desc", stats.UnitDimensionless),
+		Aggregation: view.Count(),
+	}
+
+	tests := []struct {
+		name      string
+		overwrite bool
+		wantErr   bool
+		wantCalls []string
+	}{
+		{
+			name:      "conflict reported",
+			wantErr:   true,
+			wantCalls: []string{"create custom.googleapis.com/opencensus/overwritten_view"},
+		},
+		{
+			name:      "overwritten",
+			overwrite: true,
+			wantCalls: []string{
+				"create custom.googleapis.com/opencensus/overwritten_view",
+				"delete projects/test_project/metricDescriptors/custom.googleapis.com/opencensus/overwritten_view",
+				"create custom.googleapis.com/opencensus/overwritten_view",
 			},
 		},
-		Start: start,
-		End:   end,
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			calls, deleted = nil, false
+			e := &statsExporter{
+				metricDescriptors: make(map[string]bool),
+				o:                 Options{ProjectID: "test_project", OverwriteMetricDescriptors: tt.overwrite},
+			}
+			err := e.createMetricDescriptorFromView(context.Background(), v)
+			if gotErr := err != nil; gotErr != tt.wantErr {
+				t.Errorf("createMetricDescriptorFromView() error = %v; want error %t", err, tt.wantErr)
+			}
+			if diff := cmp.Diff(calls, tt.wantCalls); diff != "" {
+				t.Errorf("calls -got +want: %s", diff)
+			}
+			if got, want := e.metricDescriptors[v.Name], !tt.wantErr; got != want {
+				t.Errorf("descriptor cached = %t; want %t", got, want)
+			}
+		})
 	}
 }
 
-func newTestDistViewData(v *view.View, start, end time.Time) *view.Data {
-	return &view.Data{
-		View: v,
-		Rows: []*view.Row{
-			{Data: &view.DistributionData{
-				Count:           5,
-				Min:             1,
-				Max:             7,
-				Mean:            3,
-				SumOfSquaredDev: 1.5,
-				CountPerBucket:  []int64{2, 2, 1},
-			}},
+func TestExporter_DeleteMetricDescriptor(t *testing.T) {
+	oldCreateMetricDescriptor := createMetricDescriptor
+	oldDeleteMetricDescriptor := deleteMetricDescriptor
+	defer func() {
+		createMetricDescriptor = oldCreateMetricDescriptor
+		deleteMetricDescriptor = oldDeleteMetricDescriptor
+	}()
+
+	var calls []string
+	createMetricDescriptor = func(ctx context.Context, c *monitoring.MetricClient, mdr *monitoringpb.CreateMetricDescriptorRequest) (*metricpb.MetricDescriptor, error) { //nolint: staticcheck
+		calls = append(calls, "create "+mdr.MetricDescriptor.Type)
+		return mdr.MetricDescriptor, nil
+	}
+	deleteMetricDescriptor = func(ctx context.Context, c *monitoring.MetricClient, mdr *monitoringpb.DeleteMetricDescriptorRequest) error { //nolint: staticcheck
+		calls = append(calls, "delete "+mdr.Name)
+		return nil
+	}
+
+	v := &view.View{
+		Name:        "deleted_view",
+		Measure:     stats.Int64("test-measure/TestExporter_DeleteMetricDescriptor", "measure desc", stats.UnitDimensionless),
+		Aggregation: view.Count(),
+	}
+	e := &Exporter{statsExporter: &statsExporter{
+		metricDescriptors:      make(map[string]bool),
+		protoMetricDescriptors: make(map[string]bool),
+		o:                      Options{ProjectID: "test_project"},
+	}}
+	ctx := context.Background()
+	for i := 0; i < 2; i++ {
+		if err := e.statsExporter.createMetricDescriptorFromView(ctx, v); err != nil {
+			t.Fatalf("createMetricDescriptorFromView() error = %v", err)
+		}
+	}
+	if err := e.DeleteMetricDescriptor(ctx, e.MetricType(v)); err != nil {
+		t.Fatalf("DeleteMetricDescriptor() error = %v", err)
+	}
+	if err := e.statsExporter.createMetricDescriptorFromView(ctx, v); err != nil {
+		t.Fatalf("createMetricDescriptorFromView() error = %v", err)
+	}
+
+	want := []string{
+		"create custom.googleapis.com/opencensus/deleted_view",
+		"delete projects/test_project/metricDescriptors/custom.googleapis.com/opencensus/deleted_view",
+		"create custom.googleapis.com/opencensus/deleted_view",
+	}
+	if diff := cmp.Diff(calls, want); diff != "" {
+		t.Errorf("calls -got +want: %s", diff)
+	}
+}
+
+func TestExporter_uploadStatsTransformRequest(t *testing.T) {
+	oldCreateTimeSeries := createTimeSeries
+	defer func() {
+		createTimeSeries = oldCreateTimeSeries
+	}()
+
+	var got []*monitoringpb.CreateTimeSeriesRequest                                                                             //nolint: staticcheck
+	createTimeSeries = func(ctx context.Context, c *monitoring.MetricClient, req *monitoringpb.CreateTimeSeriesRequest) error { //nolint: staticcheck
+		got = append(got, req)
+		return nil
+	}
+
+	v := &view.View{
+		Name:        "test_view_transform",
+		Measure:     stats.Int64("test-measure/TestExporter_uploadStatsTransformRequest", "measure desc", stats.UnitDimensionless),
+		Aggregation: view.Count(),
+	}
+	data := &view.CountData{Value: 1}
+	vd := newTestViewData(v, time.Now(), time.Now(), data, data)
+
+	tests := []struct {
+		name      string
+		transform func(*monitoringpb.CreateTimeSeriesRequest) *monitoringpb.CreateTimeSeriesRequest //nolint: staticcheck
+		wantReqs  int
+	}{
+		{
+			name: "drop label",
+			transform: func(req *monitoringpb.CreateTimeSeriesRequest) *monitoringpb.CreateTimeSeriesRequest { //nolint: staticcheck
+				for _, ts := range req.TimeSeries {
+					delete(ts.Metric.Labels, "test_key")
+				}
+				return req
+			},
+			wantReqs: 1,
 		},
-		Start: start,
-		End:   end,
+		{
+			name: "drop request",
+			transform: func(req *monitoringpb.CreateTimeSeriesRequest) *monitoringpb.CreateTimeSeriesRequest { //nolint: staticcheck
+				return nil
+			},
+			wantReqs: 0,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got = nil
+			e := &statsExporter{
+				o: Options{
+					ProjectID:               "test_project",
+					SkipCMD:                 true,
+					DefaultMonitoringLabels: &Labels{},
+					TransformRequest:        tt.transform,
+				},
+			}
+			if err := e.uploadStats([]*view.Data{vd}); err != nil {
+				t.Fatalf("Exporter.uploadStats() error = %v", err)
+			}
+			if len(got) != tt.wantReqs {
+				t.Fatalf("got %d requests; want %d", len(got), tt.wantReqs)
+			}
+			for _, req := range got {
+				for _, ts := range req.TimeSeries {
+					if _, ok := ts.Metric.Labels["test_key"]; ok {
+						t.Errorf("label test_key was not removed: %v", ts.Metric.Labels)
+					}
+				}
+			}
+		})
+	}
+}
+
+func TestExporter_uploadStatsBatchesErrors(t *testing.T) {
+	oldCreateTimeSeries := createTimeSeries
+	defer func() {
+		createTimeSeries = oldCreateTimeSeries
+	}()
+
+	var calls, uploaded int
+	createTimeSeries = func(ctx context.Context, c *monitoring.MetricClient, req *monitoringpb.CreateTimeSeriesRequest) error { //nolint: staticcheck
+		calls++
+		if calls == 2 {
+			return fmt.Errorf("request %d failed", calls)
+		}
+		uploaded += len(req.TimeSeries)
+		return nil
+	}
+
+	// 450 rows are sent in 3 requests of 200, 200 and 50 time series.
+	v := &view.View{
+		Name:        "test_view_batch_errors",
+		Measure:     stats.Int64("test-measure/TestExporter_uploadStatsBatchesErrors", "measure desc", stats.UnitDimensionless),
+		Aggregation: view.Count(),
+	}
+	vd := newTestCountViewData(v, 450)
+
+	e := &statsExporter{
+		o: Options{
+			ProjectID:               "test_project",
+			SkipCMD:                 true,
+			DefaultMonitoringLabels: &Labels{},
+		},
+	}
+	err := e.uploadStats([]*view.Data{vd})
+	if err == nil || err.Error() != "request 2 failed" {
+		t.Errorf("Exporter.uploadStats() error = %v; want only the second request reported", err)
+	}
+	if calls != 3 {
+		t.Errorf("got %d CreateTimeSeries calls; want 3", calls)
+	}
+	if uploaded != 250 {
+		t.Errorf("got %d time series uploaded; want 250", uploaded)
+	}
+}
+
+func TestExporter_uploadStatsNumberOfWorkers(t *testing.T) {
+	oldCreateTimeSeries := createTimeSeries
+	defer func() {
+		createTimeSeries = oldCreateTimeSeries
+	}()
+
+	var mu sync.Mutex
+	var calls, inflight, maxInflight int
+	createTimeSeries = func(ctx context.Context, c *monitoring.MetricClient, req *monitoringpb.CreateTimeSeriesRequest) error { //nolint: staticcheck
+		mu.Lock()
+		calls++
+		call := calls
+		inflight++
+		if inflight > maxInflight {
+			maxInflight = inflight
+		}
+		mu.Unlock()
+		time.Sleep(50 * time.Millisecond)
+		mu.Lock()
+		inflight--
+		mu.Unlock()
+		if call == 1 {
+			return fmt.Errorf("request failed")
+		}
+		return nil
+	}
+
+	// 800 rows are sent in 4 requests.
+	v := &view.View{
+		Name:        "test_view_workers",
+		Measure:     stats.Int64("test-measure/TestExporter_uploadStatsNumberOfWorkers", "measure desc", stats.UnitDimensionless),
+		Aggregation: view.Count(),
+	}
+	vd := newTestCountViewData(v, 800)
+
+	tests := []struct {
+		name            string
+		numberOfWorkers int
+		wantInflight    int
+	}{
+		{name: "default", wantInflight: 1},
+		{name: "one worker", numberOfWorkers: 1, wantInflight: 1},
+		{name: "four workers", numberOfWorkers: 4, wantInflight: 4},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			calls, maxInflight = 0, 0
+			e := &statsExporter{
+				o: Options{
+					ProjectID:               "test_project",
+					SkipCMD:                 true,
+					DefaultMonitoringLabels: &Labels{},
+					NumberOfWorkers:         tt.numberOfWorkers,
+				},
+			}
+			err := e.uploadStats([]*view.Data{vd})
+			if err == nil || err.Error() != "request failed" {
+				t.Errorf("Exporter.uploadStats() error = %v; want the failed request reported", err)
+			}
+			if calls != 4 {
+				t.Errorf("got %d CreateTimeSeries calls; want 4", calls)
+			}
+			if maxInflight != tt.wantInflight {
+				t.Errorf("got %d concurrent CreateTimeSeries calls; want %d", maxInflight, tt.wantInflight)
+			}
+		})
+	}
+}
+
+func TestExporter_includeOriginalNameLabel(t *testing.T) {
+	v := &view.View{
+		Name:        "test.view/original-name",
+		Measure:     stats.Int64("test-measure/TestExporter_includeOriginalNameLabel", "measure desc", stats.UnitDimensionless),
+		Aggregation: view.Count(),
+		TagKeys:     []tag.Key{tag.MustNewKey("test-key")},
+	}
+	data := &view.CountData{Value: 1}
+	vd := newTestViewData(v, time.Now(), time.Now(), data, data)
+
+	for _, tt := range []struct {
+		name    string
+		key     string
+		wantKey string
+	}{
+		{name: "default key", wantKey: "opencensus_metric_name"},
+		{name: "custom key", key: "oc-name", wantKey: "oc_name"},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			e := &statsExporter{
+				o: Options{
+					ProjectID:                "test_project",
+					IncludeOriginalNameLabel: true,
+					OriginalNameLabelKey:     tt.key,
+				},
+			}
+			for _, req := range e.makeReq([]*view.Data{vd}, maxTimeSeriesPerUpload) {
+				for _, ts := range req.TimeSeries {
+					if got := ts.Metric.Labels[tt.wantKey]; got != v.Name {
+						t.Errorf("label %q = %q; want %q", tt.wantKey, got, v.Name)
+					}
+				}
+			}
+
+			md, err := e.viewToMetricDescriptor(context.Background(), v)
+			if err != nil {
+				t.Fatalf("viewToMetricDescriptor() error = %v", err)
+			}
+			var found bool
+			for _, l := range md.Labels {
+				found = found || l.Key == tt.wantKey
+			}
+			if !found {
+				t.Errorf("metric descriptor labels %v do not declare %q", md.Labels, tt.wantKey)
+			}
+		})
+	}
+}
+
+func TestExporter_includeMeasureNameLabel(t *testing.T) {
+	m := stats.Int64("test-measure/TestExporter_includeMeasureNameLabel", "measure desc", stats.UnitDimensionless)
+	v := &view.View{
+		Name:        "test.view/measure-name",
+		Measure:     m,
+		Aggregation: view.Count(),
+		TagKeys:     []tag.Key{tag.MustNewKey("test-key")},
+	}
+	data := &view.CountData{Value: 1}
+	vd := newTestViewData(v, time.Now(), time.Now(), data, data)
+
+	e := &statsExporter{o: Options{ProjectID: "test_project", IncludeMeasureNameLabel: true}}
+	for _, req := range e.makeReq([]*view.Data{vd}, maxTimeSeriesPerUpload) {
+		for _, ts := range req.TimeSeries {
+			if got := ts.Metric.Labels[measureNameLabelKey]; got != m.Name() {
+				t.Errorf("label %q = %q; want %q", measureNameLabelKey, got, m.Name())
+			}
+		}
+	}
+	md, err := e.viewToMetricDescriptor(context.Background(), v)
+	if err != nil {
+		t.Fatalf("viewToMetricDescriptor() error = %v", err)
+	}
+	var found bool
+	for _, l := range md.Labels {
+		found = found || l.Key == measureNameLabelKey
+	}
+	if !found {
+		t.Errorf("metric descriptor labels %v do not declare %q", md.Labels, measureNameLabelKey)
+	}
+
+	full := *v
+	full.TagKeys = nil
+	for i := 0; i < maxLabelsPerMetric; i++ {
+		full.TagKeys = append(full.TagKeys, tag.MustNewKey(fmt.Sprintf("key%d", i)))
+	}
+	if labels := e.viewLabels(&full); len(labels) != 0 {
+		t.Errorf("viewLabels() of a view with %d tag keys = %v; want no labels", len(full.TagKeys), labels)
+	}
+}
+
+func TestExporter_uploadStatsRespectsParentDeadline(t *testing.T) {
+	oldCreateTimeSeries := createTimeSeries
+	defer func() {
+		createTimeSeries = oldCreateTimeSeries
+	}()
+
+	var gotDeadline time.Time
+	createTimeSeries = func(ctx context.Context, c *monitoring.MetricClient, req *monitoringpb.CreateTimeSeriesRequest) error { //nolint: staticcheck
+		gotDeadline, _ = ctx.Deadline()
+		return nil
+	}
+
+	parent, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	wantDeadline, _ := parent.Deadline()
+
+	v := &view.View{
+		Name:        "test_view_deadline",
+		Measure:     stats.Int64("test-measure/TestExporter_uploadStatsRespectsParentDeadline", "measure desc", stats.UnitDimensionless),
+		Aggregation: view.Count(),
+	}
+	data := &view.CountData{Value: 1}
+	vd := newTestViewData(v, time.Now(), time.Now(), data, data)
+
+	e := &statsExporter{
+		o: Options{
+			ProjectID: "test_project",
+			SkipCMD:   true,
+			Context:   parent,
+			Timeout:   time.Minute,
+		},
+	}
+	if err := e.uploadStats([]*view.Data{vd}); err != nil {
+		t.Fatalf("Exporter.uploadStats() error = %v", err)
+	}
+	if !gotDeadline.Equal(wantDeadline) {
+		t.Errorf("CreateTimeSeries deadline = %v; want the parent deadline %v", gotDeadline, wantDeadline)
+	}
+}
+
+func TestExporter_createDescriptorFilter(t *testing.T) {
+	oldCreateMetricDescriptor := createMetricDescriptor
+	oldCreateTimeSeries := createTimeSeries
+	defer func() {
+		createMetricDescriptor = oldCreateMetricDescriptor
+		createTimeSeries = oldCreateTimeSeries
+	}()
+
+	var gotDescriptors []string
+	createMetricDescriptor = func(ctx context.Context, c *monitoring.MetricClient, mdr *monitoringpb.CreateMetricDescriptorRequest) (*metricpb.MetricDescriptor, error) { //nolint: staticcheck
+		gotDescriptors = append(gotDescriptors, mdr.MetricDescriptor.Type)
+		return mdr.MetricDescriptor, nil
+	}
+	gotTimeSeries := make(map[string]bool)
+	createTimeSeries = func(ctx context.Context, c *monitoring.MetricClient, req *monitoringpb.CreateTimeSeriesRequest) error { //nolint: staticcheck
+		for _, ts := range req.TimeSeries {
+			gotTimeSeries[ts.Metric.Type] = true
+		}
+		return nil
+	}
+
+	m := stats.Int64("test-measure/TestExporter_createDescriptorFilter", "measure desc", stats.UnitDimensionless)
+	curated := &view.View{Name: "curated_view", Measure: m, Aggregation: view.Count()}
+	external := &view.View{Name: "external_view", Measure: m, Aggregation: view.Count()}
+	data := &view.CountData{Value: 1}
+	vds := []*view.Data{
+		newTestViewData(curated, time.Now(), time.Now(), data, data),
+		newTestViewData(external, time.Now(), time.Now(), data, data),
+	}
+
+	const curatedType = "custom.googleapis.com/opencensus/curated_view"
+	const externalType = "custom.googleapis.com/opencensus/external_view"
+	e := &statsExporter{
+		metricDescriptors: make(map[string]bool),
+		o: Options{
+			ProjectID:              "test_project",
+			CreateDescriptorFilter: func(metricType string) bool { return metricType == curatedType },
+		},
+	}
+	if err := e.uploadStats(vds); err != nil {
+		t.Fatalf("Exporter.uploadStats() error = %v", err)
+	}
+
+	if diff := cmp.Diff(gotDescriptors, []string{curatedType}); diff != "" {
+		t.Errorf("created descriptors -got +want: %s", diff)
+	}
+	for _, typ := range []string{curatedType, externalType} {
+		if !gotTimeSeries[typ] {
+			t.Errorf("time series of %q were not sent", typ)
+		}
+	}
+}
+
+func TestExporter_autoManagedMetricPrefixes(t *testing.T) {
+	oldCreateMetricDescriptor := createMetricDescriptor
+	oldCreateTimeSeries := createTimeSeries
+	defer func() {
+		createMetricDescriptor = oldCreateMetricDescriptor
+		createTimeSeries = oldCreateTimeSeries
+	}()
+
+	var gotDescriptors []string
+	createMetricDescriptor = func(ctx context.Context, c *monitoring.MetricClient, mdr *monitoringpb.CreateMetricDescriptorRequest) (*metricpb.MetricDescriptor, error) { //nolint: staticcheck
+		gotDescriptors = append(gotDescriptors, mdr.MetricDescriptor.Type)
+		return mdr.MetricDescriptor, nil
+	}
+	gotTimeSeries := make(map[string]bool)
+	createTimeSeries = func(ctx context.Context, c *monitoring.MetricClient, req *monitoringpb.CreateTimeSeriesRequest) error { //nolint: staticcheck
+		for _, ts := range req.TimeSeries {
+			gotTimeSeries[ts.Metric.Type] = true
+		}
+		return nil
+	}
+
+	m := stats.Int64("test-measure/TestExporter_autoManagedMetricPrefixes", "measure desc", stats.UnitDimensionless)
+	workload := &view.View{Name: "workload_view", Measure: m, Aggregation: view.Count()}
+	custom := &view.View{Name: "custom_view", Measure: m, Aggregation: view.Count()}
+	data := &view.CountData{Value: 1}
+	vds := []*view.Data{
+		newTestViewData(workload, time.Now(), time.Now(), data, data),
+		newTestViewData(custom, time.Now(), time.Now(), data, data),
+	}
+
+	const workloadType = "workload.googleapis.com/workload_view"
+	const customType = "custom.googleapis.com/opencensus/custom_view"
+	e := &statsExporter{
+		metricDescriptors: make(map[string]bool),
+		o: Options{
+			ProjectID:                 "test_project",
+			AutoManagedMetricPrefixes: []string{"workload.googleapis.com/"},
+			GetMetricPrefixForView: func(v *view.View) string {
+				if v == workload {
+					return "workload.googleapis.com"
+				}
+				return "custom.googleapis.com/opencensus"
+			},
+		},
+	}
+	if err := e.uploadStats(vds); err != nil {
+		t.Fatalf("Exporter.uploadStats() error = %v", err)
+	}
+
+	if diff := cmp.Diff(gotDescriptors, []string{customType}); diff != "" {
+		t.Errorf("created descriptors -got +want: %s", diff)
+	}
+	for _, typ := range []string{workloadType, customType} {
+		if !gotTimeSeries[typ] {
+			t.Errorf("time series of %q were not sent", typ)
+		}
+	}
+}
+
+func TestExporter_makeReq_withResourceForView(t *testing.T) {
+	m := stats.Int64("test-measure/TestExporter_makeReq_withResourceForView", "measure desc", stats.UnitDimensionless)
+	tenantA := &view.View{Name: "tenant_a_view", Measure: m, Aggregation: view.Count()}
+	tenantB := &view.View{Name: "tenant_b_view", Measure: m, Aggregation: view.Count()}
+	other := &view.View{Name: "other_view", Measure: m, Aggregation: view.Count()}
+
+	newResource := func(job string) *monitoredrespb.MonitoredResource {
+		return &monitoredrespb.MonitoredResource{
+			Type:   "generic_task",
+			Labels: map[string]string{"job": job},
+		}
+	}
+	defaultResource := newResource("default")
+	e := &statsExporter{
+		o: Options{
+			ProjectID:               "proj-id",
+			Resource:                defaultResource,
+			DefaultMonitoringLabels: &Labels{},
+			ResourceForView: func(v *view.View, tags []tag.Tag) *monitoredrespb.MonitoredResource {
+				switch v {
+				case tenantA:
+					return newResource("tenant-a")
+				case tenantB:
+					return newResource("tenant-b")
+				}
+				return nil
+			},
+		},
+	}
+
+	data := &view.CountData{Value: 1}
+	var vds []*view.Data
+	for _, v := range []*view.View{tenantA, tenantB, other} {
+		vds = append(vds, newTestViewData(v, time.Now(), time.Now(), data, data))
+	}
+	want := map[string]*monitoredrespb.MonitoredResource{
+		"custom.googleapis.com/opencensus/tenant_a_view": newResource("tenant-a"),
+		"custom.googleapis.com/opencensus/tenant_b_view": newResource("tenant-b"),
+		"custom.googleapis.com/opencensus/other_view":    defaultResource,
+	}
+	for _, req := range e.makeReq(vds, maxTimeSeriesPerUpload) {
+		for _, ts := range req.TimeSeries {
+			if diff := cmp.Diff(ts.Resource, want[ts.Metric.Type], protocmp.Transform()); diff != "" {
+				t.Errorf("resource of %q -got +want: %s", ts.Metric.Type, diff)
+			}
+		}
+	}
+}
+
+func newTestViewData(v *view.View, start, end time.Time, data1, data2 view.AggregationData) *view.Data {
+	key, _ := tag.NewKey("test-key")
+	tag1 := tag.Tag{Key: key, Value: "test-value-1"}
+	tag2 := tag.Tag{Key: key, Value: "test-value-2"}
+	return &view.Data{
+		View: v,
+		Rows: []*view.Row{
+			{
+				Tags: []tag.Tag{tag1},
+				Data: data1,
+			},
+			{
+				Tags: []tag.Tag{tag2},
+				Data: data2,
+			},
+		},
+		Start: start,
+		End:   end,
+	}
+}
+
+// newTestCountViewData returns view data with n rows of distinct tags.
+func newTestCountViewData(v *view.View, n int) *view.Data {
+	key, _ := tag.NewKey("test-key")
+	vd := &view.Data{View: v, Start: time.Now(), End: time.Now()}
+	for i := 0; i < n; i++ {
+		vd.Rows = append(vd.Rows, &view.Row{
+			Tags: []tag.Tag{{Key: key, Value: fmt.Sprint(i)}},
+			Data: &view.CountData{Value: 1},
+		})
+	}
+	return vd
+}
+
+func newTestDistViewData(v *view.View, start, end time.Time) *view.Data {
+	return &view.Data{
+		View: v,
+		Rows: []*view.Row{
+			{Data: &view.DistributionData{
+				Count:           5,
+				Min:             1,
+				Max:             7,
+				Mean:            3,
+				SumOfSquaredDev: 1.5,
+				CountPerBucket:  []int64{2, 2, 1},
+			}},
+		},
+		Start: start,
+		End:   end,
+	}
+}
+
+func TestExporterMetricType(t *testing.T) {
+	v := &view.View{
+		Name:        "example.com/views/latency",
+		Measure:     stats.Float64("latency", "latency", stats.UnitMilliseconds),
+		Aggregation: view.Count(),
+	}
+	tests := []struct {
+		name string
+		opts Options
+		want string
+	}{
+		{
+			name: "default domain",
+			want: "custom.googleapis.com/opencensus/example.com/views/latency",
+		},
+		{
+			name: "prefix for view",
+			opts: Options{GetMetricPrefixForView: func(*view.View) string { return "external.googleapis.com/prometheus" }},
+			want: "external.googleapis.com/prometheus/example.com/views/latency",
+		},
+		{
+			name: "metric type formatter",
+			opts: Options{GetMetricType: func(v *view.View) string { return "workload.googleapis.com/" + v.Name }},
+			want: "workload.googleapis.com/example.com/views/latency",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			opts := tt.opts
+			opts.ProjectID = "proj-id"
+			opts.MonitoringClientOptions = authOptions
+			se, err := newStatsExporter(opts)
+			if err != nil {
+				t.Fatal(err)
+			}
+			e := &Exporter{statsExporter: se}
+
+			got := e.MetricType(v)
+			if got != tt.want {
+				t.Errorf("MetricType() = %q; want %q", got, tt.want)
+			}
+			start := time.Now()
+			reqs := se.makeReq([]*view.Data{newTestViewData(v, start, start.Add(time.Minute), &view.CountData{Value: 1}, &view.CountData{Value: 2})}, maxTimeSeriesPerUpload)
+			if len(reqs) == 0 || len(reqs[0].TimeSeries) == 0 {
+				t.Fatalf("makeReq() produced no time series")
+			}
+			if reqType := reqs[0].TimeSeries[0].Metric.Type; reqType != got {
+				t.Errorf("request metric type = %q; want MetricType() = %q", reqType, got)
+			}
+		})
+	}
+}
+
+func TestNewTypedValueInt64SumIsExact(t *testing.T) {
+	v := &view.View{
+		Name:        "int_sum",
+		Measure:     stats.Int64("int_sum", "int sum", stats.UnitDimensionless),
+		Aggregation: view.Sum(),
+	}
+	tests := []struct {
+		sum  float64
+		want int64
+	}{
+		{sum: -(1 << 53) + 1, want: -(1 << 53) + 1},
+		{sum: -(1 << 53), want: -(1 << 53)},
+		{sum: 1 << 53, want: 1 << 53},
+		{sum: -12345678901234.0, want: -12345678901234},
+		{sum: -1e30, want: math.MinInt64},
+		{sum: 1e30, want: math.MaxInt64},
+	}
+	for _, tt := range tests {
+		got := newTypedValue(v, &view.Row{Data: &view.SumData{Value: tt.sum}}).GetInt64Value()
+		if got != tt.want {
+			t.Errorf("newTypedValue(SumData{%v}) = %d; want %d", tt.sum, got, tt.want)
+		}
+	}
+}
+
+func TestNewTypedValueUnderflowBucket(t *testing.T) {
+	m := stats.Float64("test-measure/TestNewTypedValueUnderflowBucket", "measure desc", stats.UnitDimensionless)
+	tests := []struct {
+		name       string
+		bounds     []float64
+		counts     []int64
+		wantBounds []float64
+		wantCounts []int64
+	}{
+		{
+			// The -5 recorded in the first OpenCensus bucket is reported in [0, 10).
+			name:       "positive first bound",
+			bounds:     []float64{10, 20},
+			counts:     []int64{2, 1, 0},
+			wantBounds: []float64{0, 10, 20},
+			wantCounts: []int64{0, 2, 1, 0},
+		},
+		{
+			// The -5 is reported in the (-infinity, 0) bucket.
+			name:       "zero first bound",
+			bounds:     []float64{0, 10, 20},
+			counts:     []int64{1, 1, 1, 0},
+			wantBounds: []float64{0, 10, 20},
+			wantCounts: []int64{1, 1, 1, 0},
+		},
+		{
+			// The -5 is reported in the [-10, 0) bucket.
+			name:       "negative first bound",
+			bounds:     []float64{-10, 0, 10},
+			counts:     []int64{0, 1, 1, 1},
+			wantBounds: []float64{-10, 0, 10},
+			wantCounts: []int64{0, 1, 1, 1},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			v := &view.View{Name: "underflow", Measure: m, Aggregation: view.Distribution(tt.bounds...)}
+			data := &view.DistributionData{Count: 3, Min: -5, Max: 15, Mean: 10.0 / 3, CountPerBucket: tt.counts}
+			dist := newTypedValue(v, &view.Row{Data: data}).GetDistributionValue()
+			if diff := cmp.Diff(dist.GetBucketOptions().GetExplicitBuckets().GetBounds(), tt.wantBounds); diff != "" {
+				t.Errorf("bounds -got +want: %s", diff)
+			}
+			if diff := cmp.Diff(dist.GetBucketCounts(), tt.wantCounts); diff != "" {
+				t.Errorf("bucket counts -got +want: %s", diff)
+			}
+		})
+	}
+}
+
+func TestExporter_makeReq_distributionRange(t *testing.T) {
+	v := &view.View{
+		Name:        "ranged_view",
+		Measure:     stats.Float64("test-measure/TestExporter_makeReq_distributionRange", "measure desc", stats.UnitMilliseconds),
+		Aggregation: view.Distribution(10, 20),
+	}
+	full := &view.DistributionData{Count: 2, Min: 5, Max: 25, Mean: 15, CountPerBucket: []int64{1, 0, 1}}
+	empty := &view.DistributionData{CountPerBucket: []int64{0, 0, 0}}
+	tests := []struct {
+		name      string
+		report    bool
+		data      *view.DistributionData
+		wantRange *distribution.Distribution_Range
+	}{
+		{name: "disabled", data: full},
+		{name: "enabled", report: true, data: full, wantRange: &distribution.Distribution_Range{Min: 5, Max: 25}},
+		{name: "enabled without values", report: true, data: empty},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			e := &statsExporter{o: Options{ProjectID: "proj-id", ReportDistributionRange: tt.report}}
+			reqs := e.makeReq([]*view.Data{newTestViewData(v, time.Now(), time.Now(), tt.data, tt.data)}, maxTimeSeriesPerUpload)
+			for _, ts := range reqs[0].TimeSeries {
+				got := ts.Points[0].Value.GetDistributionValue().GetRange()
+				if diff := cmp.Diff(got, tt.wantRange, protocmp.Transform()); diff != "" {
+					t.Errorf("range -got +want: %s", diff)
+				}
+			}
+		})
+	}
+}
+
+func TestExporter_makeReq_clockSkew(t *testing.T) {
+	const skew = -time.Minute
+	v := &view.View{
+		Name:        "skewed_view",
+		Measure:     stats.Int64("test-measure/TestExporter_makeReq_clockSkew", "measure desc", stats.UnitDimensionless),
+		Aggregation: view.Count(),
+	}
+	e := &statsExporter{o: Options{ProjectID: "proj-id", ClockSkew: skew}}
+	start := time.Now()
+	end := start.Add(time.Minute)
+	data := &view.CountData{Value: 1}
+	reqs := e.makeReq([]*view.Data{newTestViewData(v, start, end, data, data)}, maxTimeSeriesPerUpload)
+	for _, ts := range reqs[0].TimeSeries {
+		interval := ts.Points[0].Interval
+		if got, want := interval.StartTime.AsTime(), start.Add(-skew); !got.Equal(want) {
+			t.Errorf("start time = %v; want %v", got, want)
+		}
+		if got, want := interval.EndTime.AsTime(), end.Add(-skew); !got.Equal(want) {
+			t.Errorf("end time = %v; want %v", got, want)
+		}
+	}
+}
+
+func TestExporter_makeReq_sampleSeries(t *testing.T) {
+	v := &view.View{
+		Name:        "sampled_view",
+		Measure:     stats.Int64("test-measure/TestExporter_makeReq_sampleSeries", "measure desc", stats.UnitDimensionless),
+		Aggregation: view.Count(),
+	}
+	e := &statsExporter{o: Options{
+		ProjectID: "proj-id",
+		SampleSeries: func(metricType string, labels map[string]string) bool {
+			return labels["test_key"] == "test-value-2"
+		},
+	}}
+	start := time.Now()
+	data := &view.CountData{Value: 1}
+	for i := 0; i < 2; i++ {
+		reqs := e.makeReq([]*view.Data{newTestViewData(v, start, start.Add(time.Minute), data, data)}, maxTimeSeriesPerUpload)
+		if len(reqs) != 1 || len(reqs[0].TimeSeries) != 1 || reqs[0].TimeSeries[0].Metric.Labels["test_key"] != "test-value-2" {
+			t.Errorf("export %d: makeReq() = %v; want only the test-value-2 series", i, reqs)
+		}
+	}
+}
+
+func TestExporter_makeReq_rowsShareViewStart(t *testing.T) {
+	v := &view.View{
+		Name:        "shared_start_view",
+		Measure:     stats.Int64("test-measure/TestExporter_makeReq_rowsShareViewStart", "measure desc", stats.UnitDimensionless),
+		Aggregation: view.Count(),
+	}
+	e := &statsExporter{o: Options{ProjectID: "proj-id"}}
+	start := time.Now()
+	data := &view.CountData{Value: 1}
+	reqs := e.makeReq([]*view.Data{newTestViewData(v, start, start.Add(time.Minute), data, data)}, maxTimeSeriesPerUpload)
+	if len(reqs) != 1 || len(reqs[0].TimeSeries) != 2 {
+		t.Fatalf("makeReq() = %v; want one request with two time series", reqs)
+	}
+	for _, ts := range reqs[0].TimeSeries {
+		if got := ts.Points[0].Interval.StartTime.AsTime(); !got.Equal(start) {
+			t.Errorf("%v: start time = %v; want view data start %v", ts.Metric.Labels, got, start)
+		}
+	}
+}
+
+func TestExporter_makeReq_singleBatch(t *testing.T) {
+	v := &view.View{
+		Name:        "single_batch_view",
+		Measure:     stats.Int64("test-measure/TestExporter_makeReq_singleBatch", "measure desc", stats.UnitDimensionless),
+		Aggregation: view.Count(),
+	}
+	e := &statsExporter{o: Options{ProjectID: "proj-id"}}
+	vds := []*view.Data{newTestCountViewData(v, 5)}
+
+	// With a limit of one time series per request, makeReq takes the batching
+	// path; the single batch must hold the same time series in the same order.
+	var batched []*monitoringpb.TimeSeries //nolint: staticcheck
+	for _, req := range e.makeReq(vds, 1) {
+		batched = append(batched, req.TimeSeries...)
+	}
+	want := e.combineTimeSeriesToCreateTimeSeriesRequest(batched)
+
+	for _, limit := range []int{5, maxTimeSeriesPerUpload} {
+		got := e.makeReq(vds, limit)
+		if diff := cmp.Diff(got, want, protocmp.Transform()); diff != "" {
+			t.Errorf("makeReq(limit=%d) -got +want: %s", limit, diff)
+		}
+	}
+}
+
+func BenchmarkExporter_makeReq_singleView(b *testing.B) {
+	v := &view.View{
+		Name:        "benchmark_view",
+		Measure:     stats.Int64("test-measure/BenchmarkExporter_makeReq_singleView", "measure desc", stats.UnitDimensionless),
+		Aggregation: view.Count(),
+	}
+	e := &statsExporter{o: Options{ProjectID: "proj-id"}}
+	vds := []*view.Data{newTestCountViewData(v, 5)}
+
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		e.makeReq(vds, maxTimeSeriesPerUpload)
+	}
+}
+
+func TestExporter_uploadStatsSkipUnconfirmedMetrics(t *testing.T) {
+	oldCreateMetricDescriptor := createMetricDescriptor
+	oldCreateTimeSeries := createTimeSeries
+	defer func() {
+		createMetricDescriptor = oldCreateMetricDescriptor
+		createTimeSeries = oldCreateTimeSeries
+	}()
+
+	createMetricDescriptor = func(ctx context.Context, c *monitoring.MetricClient, mdr *monitoringpb.CreateMetricDescriptorRequest) (*metricpb.MetricDescriptor, error) { //nolint: staticcheck
+		if strings.HasSuffix(mdr.MetricDescriptor.Type, "rejected_view") {
+			return nil, status.Error(codes.InvalidArgument, "invalid descriptor")
+		}
+		return mdr.MetricDescriptor, nil
+	}
+	var uploaded map[string]int
+	createTimeSeries = func(ctx context.Context, c *monitoring.MetricClient, req *monitoringpb.CreateTimeSeriesRequest) error { //nolint: staticcheck
+		for _, ts := range req.TimeSeries {
+			uploaded[ts.Metric.Type]++
+		}
+		return nil
+	}
+
+	m := stats.Int64("test-measure/TestExporter_uploadStatsSkipUnconfirmedMetrics", "measure desc", stats.UnitDimensionless)
+	rejectedView := &view.View{Name: "rejected_view", Measure: m, Aggregation: view.Count()}
+	acceptedView := &view.View{Name: "accepted_view", Measure: m, Aggregation: view.Count()}
+	data := &view.CountData{Value: 1}
+	vds := []*view.Data{
+		newTestViewData(rejectedView, time.Now(), time.Now(), data, data),
+		newTestViewData(acceptedView, time.Now(), time.Now(), data, data),
+	}
+
+	tests := []struct {
+		name string
+		skip bool
+		want map[string]int
+	}{
+		{
+			name: "sent by default",
+			want: map[string]int{
+				"custom.googleapis.com/opencensus/rejected_view": 2,
+				"custom.googleapis.com/opencensus/accepted_view": 2,
+			},
+		},
+		{
+			name: "skipped",
+			skip: true,
+			want: map[string]int{"custom.googleapis.com/opencensus/accepted_view": 2},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			uploaded = make(map[string]int)
+			e := &statsExporter{
+				metricDescriptors: make(map[string]bool),
+				o:                 Options{ProjectID: "test_project", SkipUnconfirmedMetrics: tt.skip},
+			}
+			err := e.uploadStats(vds)
+			if err == nil || !strings.Contains(err.Error(), `failed to create metric descriptor for view "rejected_view"`) {
+				t.Errorf("Exporter.uploadStats() error = %v; want the descriptor error reported", err)
+			}
+			if diff := cmp.Diff(uploaded, tt.want); diff != "" {
+				t.Errorf("uploaded time series -got +want: %s", diff)
+			}
+		})
+	}
+}
+
+func TestExporter_ExportViewFilter(t *testing.T) {
+	oldCreateMetricDescriptor := createMetricDescriptor
+	oldCreateTimeSeries := createTimeSeries
+	defer func() {
+		createMetricDescriptor = oldCreateMetricDescriptor
+		createTimeSeries = oldCreateTimeSeries
+	}()
+
+	var mu sync.Mutex
+	var descriptors []string
+	uploaded := make(map[string]int)
+	createMetricDescriptor = func(ctx context.Context, c *monitoring.MetricClient, mdr *monitoringpb.CreateMetricDescriptorRequest) (*metricpb.MetricDescriptor, error) { //nolint: staticcheck
+		mu.Lock()
+		defer mu.Unlock()
+		descriptors = append(descriptors, mdr.MetricDescriptor.Type)
+		return mdr.MetricDescriptor, nil
+	}
+	createTimeSeries = func(ctx context.Context, c *monitoring.MetricClient, req *monitoringpb.CreateTimeSeriesRequest) error { //nolint: staticcheck
+		mu.Lock()
+		defer mu.Unlock()
+		for _, ts := range req.TimeSeries {
+			uploaded[ts.Metric.Type]++
+		}
+		return nil
+	}
+
+	var filterCalls int
+	e, err := newStatsExporter(Options{
+		ProjectID:               "test_project",
+		MonitoringClientOptions: authOptions,
+		ViewFilter: func(v *view.View) bool {
+			filterCalls++
+			return v.Name != "internal_view"
+		},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	m := stats.Int64("test-measure/TestExporter_ExportViewFilter", "measure desc", stats.UnitDimensionless)
+	internalView := &view.View{Name: "internal_view", Measure: m, Aggregation: view.Count()}
+	exportedView := &view.View{Name: "exported_view", Measure: m, Aggregation: view.Count()}
+	data := &view.CountData{Value: 1}
+	e.ExportView(newTestViewData(internalView, time.Now(), time.Now(), data, data))
+	e.ExportView(newTestViewData(exportedView, time.Now(), time.Now(), data, data))
+	e.Flush()
+
+	if filterCalls != 2 {
+		t.Errorf("ViewFilter called %d times; want once per view", filterCalls)
+	}
+	want := "custom.googleapis.com/opencensus/exported_view"
+	if diff := cmp.Diff(descriptors, []string{want}); diff != "" {
+		t.Errorf("created descriptors -got +want: %s", diff)
+	}
+	if diff := cmp.Diff(uploaded, map[string]int{want: 2}); diff != "" {
+		t.Errorf("uploaded time series -got +want: %s", diff)
+	}
+}
+
+func TestExporter_makeReqOmitCumulativeStartTime(t *testing.T) {
+	m := stats.Int64("test-measure/TestExporter_makeReqOmitCumulativeStartTime", "measure desc", stats.UnitDimensionless)
+	countView := &view.View{Name: "omit_start_count", Measure: m, Aggregation: view.Count()}
+	lastValueView := &view.View{Name: "omit_start_last_value", Measure: m, Aggregation: view.LastValue()}
+	now := time.Now()
+	vds := []*view.Data{
+		newTestViewData(countView, now.Add(-time.Minute), now, &view.CountData{Value: 1}, &view.CountData{Value: 2}),
+		newTestViewData(lastValueView, now.Add(-time.Minute), now, &view.LastValueData{Value: 1}, &view.LastValueData{Value: 2}),
+	}
+
+	for _, omit := range []bool{false, true} {
+		e := &statsExporter{o: Options{ProjectID: "proj-id", OmitCumulativeStartTime: omit}}
+		for _, req := range e.makeReq(vds, maxTimeSeriesPerUpload) {
+			for _, ts := range req.TimeSeries {
+				interval := ts.Points[0].Interval
+				cumulative := strings.HasSuffix(ts.Metric.Type, "omit_start_count")
+				if got, want := interval.StartTime != nil, cumulative && !omit; got != want {
+					t.Errorf("OmitCumulativeStartTime=%v: %s has start time = %v; want %v", omit, ts.Metric.Type, got, want)
+				}
+				if interval.EndTime == nil {
+					t.Errorf("OmitCumulativeStartTime=%v: %s has no end time", omit, ts.Metric.Type)
+				}
+			}
+		}
 	}
 }
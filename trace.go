@@ -18,12 +18,14 @@ import (
 	"context"
 	"fmt"
 	"log"
+	"math"
 	"sync"
 	"time"
 
 	tracingclient "cloud.google.com/go/trace/apiv2"
 	"go.opencensus.io/trace"
 	"google.golang.org/api/support/bundler"
+	monitoredrespb "google.golang.org/genproto/googleapis/api/monitoredres"
 	tracepb "google.golang.org/genproto/googleapis/devtools/cloudtrace/v2" //nolint: staticcheck
 	"google.golang.org/protobuf/proto"
 
@@ -36,7 +38,9 @@ import (
 type traceExporter struct {
 	o         Options
 	projectID string
-	bundler   *bundler.Bundler
+	// resource is the monitored resource whose labels are attached to exported spans.
+	resource *monitoredrespb.MonitoredResource
+	bundler  *bundler.Bundler
 	// uploadFn defaults to uploadSpans; it can be replaced for tests.
 	uploadFn func(spans []*tracepb.Span) //nolint: staticcheck
 	overflowLogger
@@ -50,7 +54,7 @@ func newTraceExporter(o Options) (*traceExporter, error) {
 	if ctx == nil {
 		ctx = context.Background()
 	}
-	client, err := tracingclient.NewClient(ctx, o.TraceClientOptions...)
+	client, err := tracingclient.NewClient(ctx, o.traceClientOptions()...)
 	if err != nil {
 		return nil, fmt.Errorf("stackdriver: couldn't initialize trace client: %v", err)
 	}
@@ -65,6 +69,7 @@ func newTraceExporterWithClient(o Options, c *tracingclient.Client) *traceExport
 		client:    c,
 		o:         o,
 	}
+	e.resource = e.spanResource(o.Resource)
 	b := bundler.NewBundler((*tracepb.Span)(nil), func(bundle interface{}) { //nolint: staticcheck
 		e.uploadFn(bundle.([]*tracepb.Span)) //nolint: staticcheck
 	})
@@ -97,7 +102,7 @@ func newTraceExporterWithClient(o Options, c *tracingclient.Client) *traceExport
 
 // ExportSpan exports a SpanData to Stackdriver Trace.
 func (e *traceExporter) ExportSpan(s *trace.SpanData) {
-	protoSpan := protoFromSpanData(s, e.projectID, e.o.Resource, e.o.UserAgent)
+	protoSpan := e.protoFromSpanData(s, e.resource)
 	protoSize := proto.Size(protoSpan)
 	err := e.bundler.Add(protoSpan, protoSize)
 	switch err {
@@ -134,13 +139,13 @@ func (e *traceExporter) pushTraceSpans(ctx context.Context, node *commonpb.Node,
 
 	protoSpans := make([]*tracepb.Span, 0, len(spans)) //nolint: staticcheck
 
-	res := e.o.Resource
+	res := e.resource
 	if r != nil {
-		res = e.o.MapResource(resourcepbToResource(r))
+		res = e.spanResource(e.o.MapResource(resourcepbToResource(r)))
 	}
 
 	for _, span := range spans {
-		protoSpans = append(protoSpans, protoFromSpanData(span, e.projectID, res, e.o.UserAgent))
+		protoSpans = append(protoSpans, e.protoFromSpanData(span, res))
 	}
 
 	req := tracepb.BatchWriteSpansRequest{ //nolint: staticcheck
@@ -159,6 +164,133 @@ func (e *traceExporter) pushTraceSpans(ctx context.Context, node *commonpb.Node,
 	return 0, nil
 }
 
+// protoFromSpanData converts the span to its protocol buffer form, applying the
+// exporter's options.
+func (e *traceExporter) protoFromSpanData(s *trace.SpanData, mr *monitoredrespb.MonitoredResource) *tracepb.Span { //nolint: staticcheck
+	sp := protoFromSpanData(s, e.projectID, mr, e.o.UserAgent, e.o.spanLimits())
+	if e.o.DefaultSpanStatusMessages && sp.GetStatus() != nil && sp.Status.Message == "" {
+		sp.Status.Message = canonicalCodeMessages[sp.Status.Code]
+	}
+	if e.o.MessageEventName != nil {
+		e.nameMessageEvents(s, sp)
+	}
+	if e.o.MaxAttributesPerAnnotation > 0 {
+		for _, event := range sp.GetTimeEvents().GetTimeEvent() {
+			limitAttributes(event.GetAnnotation().GetAttributes(), e.o.MaxAttributesPerAnnotation)
+		}
+	}
+	return sp
+}
+
+// nameMessageEvents exports the message events of sp named by
+// Options.MessageEventName as annotations, alongside or instead of the message
+// events. Named annotations over the per-span annotation limit are dropped.
+func (e *traceExporter) nameMessageEvents(s *trace.SpanData, sp *tracepb.Span) { //nolint: staticcheck
+	tes := sp.GetTimeEvents()
+	if tes == nil {
+		return
+	}
+	var annotations int
+	for _, te := range tes.TimeEvent {
+		if te.GetAnnotation() != nil {
+			annotations++
+		}
+	}
+	limits := e.o.spanLimits()
+	events := make([]*tracepb.Span_TimeEvent, 0, len(tes.TimeEvent)) //nolint: staticcheck
+	var messageEvents int
+	for _, te := range tes.TimeEvent {
+		if te.GetMessageEvent() == nil {
+			events = append(events, te)
+			continue
+		}
+		// Message events are converted in order, so the i-th one comes from
+		// the i-th event of the span.
+		me := s.MessageEvents[messageEvents]
+		messageEvents++
+		name := e.o.MessageEventName(s, me)
+		if name == "" {
+			events = append(events, te)
+			continue
+		}
+		if annotations >= limits.annotations {
+			// Without room for the annotation, the message event is kept even
+			// if it was to be replaced.
+			events = append(events, te)
+			if !e.o.ReplaceNamedMessageEvents {
+				tes.DroppedAnnotationsCount++
+			}
+			continue
+		}
+		if !e.o.ReplaceNamedMessageEvents {
+			events = append(events, te)
+		}
+		annotations++
+		annotation := &tracepb.Span_TimeEvent_Annotation{Description: trunc(name, maxAnnotationDescription)} //nolint: staticcheck
+		copyAttributes(&annotation.Attributes, map[string]interface{}{
+			"message.type":              messageEventTypes[me.EventType],
+			"message.id":                me.MessageID,
+			"message.uncompressed_size": me.UncompressedByteSize,
+			"message.compressed_size":   me.CompressedByteSize,
+		}, limits)
+		events = append(events, &tracepb.Span_TimeEvent{ //nolint: staticcheck
+			Time:  te.Time,
+			Value: &tracepb.Span_TimeEvent_Annotation_{Annotation: annotation},
+		})
+	}
+	tes.TimeEvent = events
+}
+
+// messageEventTypes are the names of the message event types exported as the
+// "message.type" attribute of named message events.
+var messageEventTypes = map[trace.MessageEventType]string{
+	trace.MessageEventTypeUnspecified: "UNSPECIFIED",
+	trace.MessageEventTypeSent:        "SENT",
+	trace.MessageEventTypeRecv:        "RECEIVED",
+}
+
+// spanLimits returns the caps and the key mapping applied to exported spans.
+func (o Options) spanLimits() spanLimits {
+	return spanLimits{
+		annotations:    clampLimit(o.MaxAnnotationsPerSpan, maxAnnotationEventsPerSpan, maxAnnotationEventsPerSpan),
+		messageEvents:  clampLimit(o.MaxMessageEventsPerSpan, maxMessageEventsPerSpan, maxMessageEventsPerSpan),
+		attributeValue: clampLimit(o.MaxAttributeValueLength, maxAttributeStringValue, math.MaxInt),
+		mapKey:         o.TraceAttributeKeyMapper,
+	}
+}
+
+// clampLimit returns v, or def if v is not positive, capped at max.
+func clampLimit(v, def, max int) int {
+	switch {
+	case v <= 0:
+		return def
+	case v > max:
+		return max
+	}
+	return v
+}
+
+// spanResource returns the monitored resource to attach to spans. If
+// Options.AddResourceProjectToSpans is set and mr has no project_id label,
+// a copy of mr with the exporter's project is returned.
+func (e *traceExporter) spanResource(mr *monitoredrespb.MonitoredResource) *monitoredrespb.MonitoredResource {
+	if mr == nil || !e.o.AddResourceProjectToSpans {
+		return mr
+	}
+	if _, ok := mr.Labels["project_id"]; ok {
+		return mr
+	}
+	out := &monitoredrespb.MonitoredResource{
+		Type:   mr.Type,
+		Labels: make(map[string]string, len(mr.Labels)+1),
+	}
+	for k, v := range mr.Labels {
+		out.Labels[k] = v
+	}
+	out.Labels["project_id"] = e.projectID
+	return out
+}
+
 // uploadSpans uploads a set of spans to Stackdriver.
 func (e *traceExporter) uploadSpans(spans []*tracepb.Span) { //nolint: staticcheck
 	req := tracepb.BatchWriteSpansRequest{ //nolint: staticcheck
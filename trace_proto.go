@@ -17,6 +17,7 @@ package stackdriver
 import (
 	"fmt"
 	"math"
+	"sort"
 	"strconv"
 	"time"
 	"unicode/utf8"
@@ -31,11 +32,17 @@ import (
 )
 
 const (
+	// maxAnnotationEventsPerSpan and maxMessageEventsPerSpan are the most
+	// annotations and message events Stackdriver Trace accepts per span.
 	maxAnnotationEventsPerSpan = 32
 	maxMessageEventsPerSpan    = 128
 	maxAttributeStringValue    = 256
 	agentLabel                 = "g.co/agent"
 
+	// maxAnnotationDescription is the longest annotation description, in
+	// bytes, accepted by Stackdriver Trace.
+	maxAnnotationDescription = 256
+
 	labelHTTPHost       = `/http/host`
 	labelHTTPMethod     = `/http/method`
 	labelHTTPStatusCode = `/http/status_code`
@@ -43,8 +50,55 @@ const (
 	labelHTTPUserAgent  = `/http/user_agent`
 )
 
-// proto returns a protocol buffer representation of a SpanData.
-func protoFromSpanData(s *trace.SpanData, projectID string, mr *monitoredrespb.MonitoredResource, userAgent string) *tracepb.Span { //nolint: staticcheck
+// httpBoolAttributes maps boolean HTTP span attributes to the labels they are
+// exported as. Their values are exported as "true" or "false" strings.
+var httpBoolAttributes = map[string]string{
+	"http.secure":    `/http/secure`,
+	"http.cache_hit": `/http/cache_hit`,
+}
+
+// canonicalCodeMessages maps the canonical status codes used by OpenCensus
+// to the messages set on spans when Options.DefaultSpanStatusMessages is set.
+var canonicalCodeMessages = map[int32]string{
+	trace.StatusCodeCancelled:          "CANCELLED",
+	trace.StatusCodeUnknown:            "UNKNOWN",
+	trace.StatusCodeInvalidArgument:    "INVALID_ARGUMENT",
+	trace.StatusCodeDeadlineExceeded:   "DEADLINE_EXCEEDED",
+	trace.StatusCodeNotFound:           "NOT_FOUND",
+	trace.StatusCodeAlreadyExists:      "ALREADY_EXISTS",
+	trace.StatusCodePermissionDenied:   "PERMISSION_DENIED",
+	trace.StatusCodeResourceExhausted:  "RESOURCE_EXHAUSTED",
+	trace.StatusCodeFailedPrecondition: "FAILED_PRECONDITION",
+	trace.StatusCodeAborted:            "ABORTED",
+	trace.StatusCodeOutOfRange:         "OUT_OF_RANGE",
+	trace.StatusCodeUnimplemented:      "UNIMPLEMENTED",
+	trace.StatusCodeInternal:           "INTERNAL",
+	trace.StatusCodeUnavailable:        "UNAVAILABLE",
+	trace.StatusCodeDataLoss:           "DATA_LOSS",
+	trace.StatusCodeUnauthenticated:    "UNAUTHENTICATED",
+}
+
+// spanLimits are the caps and the key mapping applied when converting spans.
+type spanLimits struct {
+	annotations    int // annotations per span
+	messageEvents  int // message events per span
+	attributeValue int // bytes of string attribute values
+
+	// mapKey, if set, returns the key to export an attribute with,
+	// or "" to drop it.
+	mapKey func(string) string
+}
+
+// defaultSpanLimits are the caps applied when the options do not set them.
+var defaultSpanLimits = spanLimits{
+	annotations:    maxAnnotationEventsPerSpan,
+	messageEvents:  maxMessageEventsPerSpan,
+	attributeValue: maxAttributeStringValue,
+}
+
+// protoFromSpanData converts the span to its protocol buffer form, dropping
+// the events and truncating the attribute values over limits.
+func protoFromSpanData(s *trace.SpanData, projectID string, mr *monitoredrespb.MonitoredResource, userAgent string, limits spanLimits) *tracepb.Span { //nolint: staticcheck
 	if s == nil {
 		return nil
 	}
@@ -76,19 +130,19 @@ func protoFromSpanData(s *trace.SpanData, projectID string, mr *monitoredrespb.M
 	}
 
 	var annotations, droppedAnnotationsCount, messageEvents, droppedMessageEventsCount int
-	copyAttributes(&sp.Attributes, s.Attributes)
+	copyAttributes(&sp.Attributes, s.Attributes, limits)
 
 	// Copy MonitoredResources as span Attributes
 	sp.Attributes = copyMonitoredResourceAttributes(sp.Attributes, mr)
 
 	as := s.Annotations
 	for i, a := range as {
-		if annotations >= maxAnnotationEventsPerSpan {
+		if annotations >= limits.annotations {
 			droppedAnnotationsCount = len(as) - i
 			break
 		}
-		annotation := &tracepb.Span_TimeEvent_Annotation{Description: trunc(a.Message, maxAttributeStringValue)} //nolint: staticcheck
-		copyAttributes(&annotation.Attributes, a.Attributes)
+		annotation := &tracepb.Span_TimeEvent_Annotation{Description: trunc(a.Message, maxAnnotationDescription)} //nolint: staticcheck
+		copyAttributes(&annotation.Attributes, a.Attributes, limits)
 		event := &tracepb.Span_TimeEvent{ //nolint: staticcheck
 			Time:  timestampProto(a.Time),
 			Value: &tracepb.Span_TimeEvent_Annotation_{Annotation: annotation},
@@ -123,7 +177,7 @@ func protoFromSpanData(s *trace.SpanData, projectID string, mr *monitoredrespb.M
 
 	es := s.MessageEvents
 	for i, e := range es {
-		if messageEvents >= maxMessageEventsPerSpan {
+		if messageEvents >= limits.messageEvents {
 			droppedMessageEventsCount = len(es) - i
 			break
 		}
@@ -134,6 +188,8 @@ func protoFromSpanData(s *trace.SpanData, projectID string, mr *monitoredrespb.M
 		sp.TimeEvents.TimeEvent = append(sp.TimeEvents.TimeEvent, &tracepb.Span_TimeEvent{ //nolint: staticcheck
 			Time: timestampProto(e.Time),
 			Value: &tracepb.Span_TimeEvent_MessageEvent_{
+				// The sizes are proto3 scalars, so unknown (zero) sizes are
+				// omitted from the request rather than reported as 0.
 				MessageEvent: &tracepb.Span_TimeEvent_MessageEvent{ //nolint: staticcheck
 					Type:                  tracepb.Span_TimeEvent_MessageEvent_Type(e.EventType), //nolint: staticcheck
 					Id:                    e.MessageID,
@@ -161,7 +217,7 @@ func protoFromSpanData(s *trace.SpanData, projectID string, mr *monitoredrespb.M
 				SpanId:  l.SpanID.String(),
 				Type:    tracepb.Span_Link_Type(l.Type), //nolint: staticcheck
 			}
-			copyAttributes(&link.Attributes, l.Attributes)
+			copyAttributes(&link.Attributes, l.Attributes, limits)
 			sp.Links.Link = append(sp.Links.Link, link)
 		}
 	}
@@ -189,15 +245,16 @@ func copyMonitoredResourceAttributes(out *tracepb.Span_Attributes, mr *monitored
 		out.AttributeMap = make(map[string]*tracepb.AttributeValue) //nolint: staticcheck
 	}
 	for k, v := range mr.Labels {
-		av := attributeValue(v)
+		av := attributeValue(v, maxAttributeStringValue)
 		out.AttributeMap[fmt.Sprintf("g.co/r/%s/%s", mr.Type, k)] = av
 	}
 	return out
 }
 
-// copyAttributes copies a map of attributes to a proto map field.
+// copyAttributes copies a map of attributes to a proto map field, mapping
+// their keys and truncating their string values according to limits.
 // It creates the map if it is nil.
-func copyAttributes(out **tracepb.Span_Attributes, in map[string]interface{}) { //nolint: staticcheck
+func copyAttributes(out **tracepb.Span_Attributes, in map[string]interface{}, limits spanLimits) { //nolint: staticcheck
 	if len(in) == 0 {
 		return
 	}
@@ -209,10 +266,16 @@ func copyAttributes(out **tracepb.Span_Attributes, in map[string]interface{}) {
 	}
 	var dropped int32
 	for key, value := range in {
-		av := attributeValue(value)
+		av := attributeValue(value, limits.attributeValue)
 		if av == nil {
 			continue
 		}
+		if limits.mapKey != nil {
+			if key = limits.mapKey(key); key == "" {
+				dropped++
+				continue
+			}
+		}
 		switch key {
 		case ochttp.PathAttribute:
 			(*out).AttributeMap[labelHTTPPath] = av
@@ -225,6 +288,12 @@ func copyAttributes(out **tracepb.Span_Attributes, in map[string]interface{}) {
 		case ochttp.StatusCodeAttribute:
 			(*out).AttributeMap[labelHTTPStatusCode] = av
 		default:
+			if label, ok := httpBoolAttributes[key]; ok {
+				if b, ok := value.(bool); ok {
+					(*out).AttributeMap[label] = attributeValue(strconv.FormatBool(b), limits.attributeValue)
+					continue
+				}
+			}
 			if len(key) > 128 {
 				dropped++
 				continue
@@ -235,7 +304,25 @@ func copyAttributes(out **tracepb.Span_Attributes, in map[string]interface{}) {
 	(*out).DroppedAttributesCount = dropped
 }
 
-func attributeValue(v interface{}) *tracepb.AttributeValue { //nolint: staticcheck
+// limitAttributes drops the attributes over max, in key order, and counts them
+// as dropped.
+func limitAttributes(attrs *tracepb.Span_Attributes, max int) { //nolint: staticcheck
+	if len(attrs.GetAttributeMap()) <= max {
+		return
+	}
+	keys := make([]string, 0, len(attrs.AttributeMap))
+	for k := range attrs.AttributeMap {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	for _, k := range keys[max:] {
+		delete(attrs.AttributeMap, k)
+	}
+	attrs.DroppedAttributesCount += clip32(len(keys) - max)
+}
+
+// attributeValue converts an attribute value, truncating strings to valueLimit bytes.
+func attributeValue(v interface{}, valueLimit int) *tracepb.AttributeValue { //nolint: staticcheck
 	switch value := v.(type) {
 	case bool:
 		return &tracepb.AttributeValue{ //nolint: staticcheck
@@ -246,15 +333,17 @@ func attributeValue(v interface{}) *tracepb.AttributeValue { //nolint: staticche
 			Value: &tracepb.AttributeValue_IntValue{IntValue: value},
 		}
 	case float64:
+		// The Stackdriver Trace v2 AttributeValue only holds strings, integers
+		// and booleans, so doubles are sent as strings.
 		// TODO: set double value if Stackdriver Trace support it in the future.
 		return &tracepb.AttributeValue{ //nolint: staticcheck
 			Value: &tracepb.AttributeValue_StringValue{
 				StringValue: trunc(strconv.FormatFloat(value, 'f', -1, 64),
-					maxAttributeStringValue)},
+					valueLimit)},
 		}
 	case string:
 		return &tracepb.AttributeValue{ //nolint: staticcheck
-			Value: &tracepb.AttributeValue_StringValue{StringValue: trunc(value, maxAttributeStringValue)},
+			Value: &tracepb.AttributeValue_StringValue{StringValue: trunc(value, valueLimit)},
 		}
 	}
 	return nil
@@ -284,7 +284,7 @@ func TestExportTrace(t *testing.T) {
 
 	var spbs spans
 	for _, s := range te.spans {
-		spbs = append(spbs, protoFromSpanData(s, "testproject", nil, defaultUserAgent))
+		spbs = append(spbs, protoFromSpanData(s, "testproject", nil, defaultUserAgent, defaultSpanLimits))
 	}
 	sort.Sort(spbs)
 
@@ -404,7 +404,7 @@ func TestExportTraceWithMonitoredResource(t *testing.T) {
 	mr := createGCEInstanceMonitoredResource()
 
 	for _, s := range te.spans {
-		gceSpbs = append(gceSpbs, protoFromSpanData(s, "testproject", mr, defaultUserAgent))
+		gceSpbs = append(gceSpbs, protoFromSpanData(s, "testproject", mr, defaultUserAgent, defaultSpanLimits))
 	}
 
 	for _, span := range gceSpbs {
@@ -418,7 +418,7 @@ func TestExportTraceWithMonitoredResource(t *testing.T) {
 	mr = createGKEContainerMonitoredResource()
 
 	for _, s := range te.spans {
-		gkeSpbs = append(gkeSpbs, protoFromSpanData(s, "testproject", mr, defaultUserAgent))
+		gkeSpbs = append(gkeSpbs, protoFromSpanData(s, "testproject", mr, defaultUserAgent, defaultSpanLimits))
 	}
 
 	for _, span := range gkeSpbs {
@@ -435,7 +435,7 @@ func TestExportTraceWithMonitoredResource(t *testing.T) {
 	var awsEc2Spbs spans
 	mr = createAWSEC2MonitoredResource()
 	for _, s := range te.spans {
-		awsEc2Spbs = append(awsEc2Spbs, protoFromSpanData(s, "testproject", mr, defaultUserAgent))
+		awsEc2Spbs = append(awsEc2Spbs, protoFromSpanData(s, "testproject", mr, defaultUserAgent, defaultSpanLimits))
 	}
 
 	for _, span := range awsEc2Spbs {
@@ -446,6 +446,100 @@ func TestExportTraceWithMonitoredResource(t *testing.T) {
 
 }
 
+func TestMessageEventWithoutSizes(t *testing.T) {
+	sd := &trace.SpanData{
+		SpanContext: trace.SpanContext{TraceID: traceID, SpanID: spanID},
+		Name:        "span",
+		MessageEvents: []trace.MessageEvent{
+			{Time: time.Now(), EventType: trace.MessageEventTypeSent, MessageID: 7},
+		},
+	}
+	sp := protoFromSpanData(sd, "testproject", nil, "", defaultSpanLimits)
+	events := sp.GetTimeEvents().GetTimeEvent()
+	if len(events) != 1 {
+		t.Fatalf("Want 1 time event, got %v", events)
+	}
+	me := events[0].GetMessageEvent()
+	if me.GetId() != 7 {
+		t.Errorf("MessageEvent Id = %d, want 7", me.GetId())
+	}
+	if got := prototext.Format(me); strings.Contains(got, "size_bytes") {
+		t.Errorf("Want the sizes omitted from the message event, got %s", got)
+	}
+}
+
+func TestHTTPBoolAttributes(t *testing.T) {
+	sd := &trace.SpanData{
+		SpanContext: trace.SpanContext{TraceID: traceID, SpanID: spanID},
+		Name:        "span",
+		Attributes: map[string]interface{}{
+			"http.secure":    true,
+			"http.cache_hit": false,
+		},
+	}
+	sp := protoFromSpanData(sd, "testproject", nil, "", defaultSpanLimits)
+	attrs := sp.GetAttributes().GetAttributeMap()
+	for label, want := range map[string]string{"/http/secure": "true", "/http/cache_hit": "false"} {
+		if got := attrs[label].GetStringValue().GetValue(); got != want {
+			t.Errorf("attribute %q = %q, want %q", label, got, want)
+		}
+	}
+	for _, key := range []string{"http.secure", "http.cache_hit"} {
+		if _, ok := attrs[key]; ok {
+			t.Errorf("attribute %q was exported under its original key", key)
+		}
+	}
+}
+
+func TestAttributeKeyMapping(t *testing.T) {
+	sd := &trace.SpanData{
+		SpanContext: trace.SpanContext{TraceID: traceID, SpanID: spanID},
+		Name:        "span",
+		Attributes: map[string]interface{}{
+			"http.route":  "/users/{id}",
+			"internal.id": "42",
+			"user":        "alice",
+		},
+	}
+	limits := defaultSpanLimits
+	limits.mapKey = func(key string) string {
+		switch key {
+		case "http.route":
+			return "/http/route"
+		case "internal.id":
+			return ""
+		}
+		return key
+	}
+	sp := protoFromSpanData(sd, "testproject", nil, "", limits)
+	attrs := sp.GetAttributes()
+	got := make(map[string]string)
+	for k, v := range attrs.GetAttributeMap() {
+		if k != agentLabel {
+			got[k] = v.GetStringValue().GetValue()
+		}
+	}
+	want := map[string]string{"/http/route": "/users/{id}", "user": "alice"}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("attributes = %v, want %v", got, want)
+	}
+	if attrs.GetDroppedAttributesCount() != 1 {
+		t.Errorf("DroppedAttributesCount = %d, want 1", attrs.GetDroppedAttributesCount())
+	}
+}
+
+func TestFloatAttributes(t *testing.T) {
+	sd := &trace.SpanData{
+		SpanContext: trace.SpanContext{TraceID: traceID, SpanID: spanID},
+		Name:        "span",
+		Attributes:  map[string]interface{}{"ratio": 3.14},
+	}
+	sp := protoFromSpanData(sd, "testproject", nil, "", defaultSpanLimits)
+	if got := sp.GetAttributes().GetAttributeMap()["ratio"].GetStringValue().GetValue(); got != "3.14" {
+		t.Errorf("attribute %q = %q, want %q", "ratio", got, "3.14")
+	}
+}
+
 func TestEnums(t *testing.T) {
 	for _, test := range []struct {
 		x trace.LinkType
@@ -508,7 +602,7 @@ func BenchmarkProto(b *testing.B) {
 	}
 	var x int
 	for i := 0; i < b.N; i++ {
-		s := protoFromSpanData(sd, `testproject`, nil, defaultUserAgent)
+		s := protoFromSpanData(sd, `testproject`, nil, defaultUserAgent, defaultSpanLimits)
 		x += len(s.Name)
 	}
 	if x == 0 {
@@ -17,12 +17,21 @@ package stackdriver
 import (
 	"context"
 	"fmt"
+	"net"
+	"sort"
+	"strings"
 	"sync"
 	"testing"
 	"time"
 
+	"github.com/google/go-cmp/cmp"
 	"go.opencensus.io/trace"
+	"google.golang.org/api/option"
+	monitoredrespb "google.golang.org/genproto/googleapis/api/monitoredres"
 	tracepb "google.golang.org/genproto/googleapis/devtools/cloudtrace/v2" //nolint: staticcheck
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/credentials/insecure"
+	grpcstats "google.golang.org/grpc/stats"
 )
 
 func TestBundling(t *testing.T) {
@@ -211,3 +220,447 @@ func makeSampleSpanData(userAgent string) *trace.SpanData {
 	}
 	return sd
 }
+
+func TestTraceSpansResourceProjectAttribute(t *testing.T) {
+	const projectKey = "g.co/r/generic_task/project_id"
+	resource := &monitoredrespb.MonitoredResource{
+		Type:   "generic_task",
+		Labels: map[string]string{"job": "job1"},
+	}
+	for _, tt := range []struct {
+		name        string
+		enabled     bool
+		wantPresent bool
+	}{
+		{name: "disabled", enabled: false, wantPresent: false},
+		{name: "enabled", enabled: true, wantPresent: true},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			e := newTraceExporterWithClient(Options{
+				ProjectID:                 "proj1",
+				Resource:                  resource,
+				AddResourceProjectToSpans: tt.enabled,
+				Context:                   context.Background(),
+				Timeout:                   10 * time.Millisecond,
+			}, nil)
+
+			var attrs map[string]*tracepb.AttributeValue //nolint: staticcheck
+			e.uploadFn = func(spans []*tracepb.Span) {   //nolint: staticcheck
+				attrs = spans[0].Attributes.AttributeMap
+			}
+			e.ExportSpan(makeSampleSpanData(""))
+			e.Flush()
+
+			got, ok := attrs[projectKey]
+			if ok != tt.wantPresent {
+				t.Fatalf("%s attribute present = %v; want %v", projectKey, ok, tt.wantPresent)
+			}
+			if ok && got.GetStringValue().Value != "proj1" {
+				t.Errorf("%s = %q; want %q", projectKey, got.GetStringValue().Value, "proj1")
+			}
+			if _, ok := resource.Labels["project_id"]; ok {
+				t.Errorf("configured resource must not be modified")
+			}
+		})
+	}
+}
+
+func TestTraceSpansDefaultStatusMessages(t *testing.T) {
+	for _, tt := range []struct {
+		name    string
+		enabled bool
+		status  trace.Status
+		want    string
+	}{
+		{name: "not found without message", enabled: true, status: trace.Status{Code: trace.StatusCodeNotFound}, want: "NOT_FOUND"},
+		{name: "unavailable without message", enabled: true, status: trace.Status{Code: trace.StatusCodeUnavailable}, want: "UNAVAILABLE"},
+		{name: "explicit message", enabled: true, status: trace.Status{Code: trace.StatusCodeInternal, Message: "boom"}, want: "boom"},
+		{name: "disabled", enabled: false, status: trace.Status{Code: trace.StatusCodeNotFound}, want: ""},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			e := newTraceExporterWithClient(Options{
+				DefaultSpanStatusMessages: tt.enabled,
+				Context:                   context.Background(),
+				Timeout:                   10 * time.Millisecond,
+			}, nil)
+
+			var got *tracepb.Span                      //nolint: staticcheck
+			e.uploadFn = func(spans []*tracepb.Span) { //nolint: staticcheck
+				got = spans[0]
+			}
+			sd := makeSampleSpanData("")
+			sd.Status = tt.status
+			e.ExportSpan(sd)
+			e.Flush()
+
+			if got.GetStatus().GetCode() != tt.status.Code {
+				t.Errorf("status code = %d; want %d", got.GetStatus().GetCode(), tt.status.Code)
+			}
+			if msg := got.GetStatus().GetMessage(); msg != tt.want {
+				t.Errorf("status message = %q; want %q", msg, tt.want)
+			}
+		})
+	}
+}
+
+func TestTraceSpansMaxAttributesPerAnnotation(t *testing.T) {
+	e := newTraceExporterWithClient(Options{
+		MaxAttributesPerAnnotation: 4,
+		Context:                    context.Background(),
+		Timeout:                    10 * time.Millisecond,
+	}, nil)
+
+	var got *tracepb.Span                      //nolint: staticcheck
+	e.uploadFn = func(spans []*tracepb.Span) { //nolint: staticcheck
+		got = spans[0]
+	}
+	attributes := map[string]interface{}{
+		strings.Repeat("k", 129): "too long",
+	}
+	for i := 0; i < 10; i++ {
+		attributes[fmt.Sprintf("a%d", i)] = int64(i)
+	}
+	sd := makeSampleSpanData("")
+	sd.Annotations = []trace.Annotation{
+		{Time: sd.StartTime, Message: "over capacity", Attributes: attributes},
+		{Time: sd.StartTime, Message: "under capacity", Attributes: map[string]interface{}{"b": true}},
+	}
+	e.ExportSpan(sd)
+	e.Flush()
+
+	var annotations []*tracepb.Span_TimeEvent_Annotation //nolint: staticcheck
+	for _, event := range got.GetTimeEvents().GetTimeEvent() {
+		if a := event.GetAnnotation(); a != nil {
+			annotations = append(annotations, a)
+		}
+	}
+	if len(annotations) != 2 {
+		t.Fatalf("got %d annotations; want 2", len(annotations))
+	}
+	over := annotations[0].GetAttributes()
+	var keys []string
+	for k := range over.GetAttributeMap() {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	if diff := cmp.Diff(keys, []string{"a0", "a1", "a2", "a3"}); diff != "" {
+		t.Errorf("annotation attributes -got +want: %s", diff)
+	}
+	// The attribute with a too long key was already dropped.
+	if got, want := over.GetDroppedAttributesCount(), int32(7); got != want {
+		t.Errorf("DroppedAttributesCount = %d; want %d", got, want)
+	}
+	under := annotations[1].GetAttributes()
+	if len(under.GetAttributeMap()) != 1 || under.GetDroppedAttributesCount() != 0 {
+		t.Errorf("annotation under capacity = %v; want unchanged", under)
+	}
+}
+
+func TestTraceSpansMaxAttributeValueLength(t *testing.T) {
+	value := strings.Repeat("x", 2048)
+	tests := []struct {
+		name          string
+		limit         int
+		wantLen       int
+		wantTruncated int32
+	}{
+		{name: "default", wantLen: 256, wantTruncated: 2048 - 256},
+		{name: "raised under value", limit: 1024, wantLen: 1024, wantTruncated: 1024},
+		{name: "raised over value", limit: 4096, wantLen: 2048},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			e := newTraceExporterWithClient(Options{
+				MaxAttributeValueLength: tt.limit,
+				Context:                 context.Background(),
+				Timeout:                 10 * time.Millisecond,
+			}, nil)
+
+			var got *tracepb.Span                      //nolint: staticcheck
+			e.uploadFn = func(spans []*tracepb.Span) { //nolint: staticcheck
+				got = spans[0]
+			}
+			sd := makeSampleSpanData("")
+			sd.Attributes = map[string]interface{}{"db.statement": value}
+			e.ExportSpan(sd)
+			e.Flush()
+
+			sv := got.GetAttributes().GetAttributeMap()["db.statement"].GetStringValue()
+			if len(sv.GetValue()) != tt.wantLen || sv.GetTruncatedByteCount() != tt.wantTruncated {
+				t.Errorf("attribute value length, truncated bytes = %d, %d; want %d, %d",
+					len(sv.GetValue()), sv.GetTruncatedByteCount(), tt.wantLen, tt.wantTruncated)
+			}
+		})
+	}
+}
+
+func TestTraceSpansMaxEventsPerSpan(t *testing.T) {
+	tests := []struct {
+		name                string
+		maxAnnotations      int
+		maxMessageEvents    int
+		wantAnnotations     int
+		wantMessageEvents   int
+		wantDroppedAnnots   int32
+		wantDroppedMessages int32
+	}{
+		{name: "default", wantAnnotations: 32, wantMessageEvents: 40, wantDroppedAnnots: 8},
+		// Stackdriver Trace accepts at most 32 annotations per span.
+		{name: "over the limit", maxAnnotations: 40, wantAnnotations: 32, wantMessageEvents: 40, wantDroppedAnnots: 8},
+		{name: "lowered", maxAnnotations: 10, maxMessageEvents: 25, wantAnnotations: 10, wantMessageEvents: 25, wantDroppedAnnots: 30, wantDroppedMessages: 15},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			e := newTraceExporterWithClient(Options{
+				MaxAnnotationsPerSpan:   tt.maxAnnotations,
+				MaxMessageEventsPerSpan: tt.maxMessageEvents,
+				Context:                 context.Background(),
+				Timeout:                 10 * time.Millisecond,
+			}, nil)
+
+			var got *tracepb.Span                      //nolint: staticcheck
+			e.uploadFn = func(spans []*tracepb.Span) { //nolint: staticcheck
+				got = spans[0]
+			}
+			sd := makeSampleSpanData("")
+			sd.Annotations, sd.MessageEvents = nil, nil
+			for i := 0; i < 40; i++ {
+				sd.Annotations = append(sd.Annotations, trace.Annotation{Time: sd.StartTime, Message: fmt.Sprintf("a%d", i)})
+				sd.MessageEvents = append(sd.MessageEvents, trace.MessageEvent{Time: sd.StartTime, MessageID: int64(i)})
+			}
+			e.ExportSpan(sd)
+			e.Flush()
+
+			var annotations, messageEvents int
+			for _, event := range got.GetTimeEvents().GetTimeEvent() {
+				if event.GetAnnotation() != nil {
+					annotations++
+				}
+				if event.GetMessageEvent() != nil {
+					messageEvents++
+				}
+			}
+			if annotations != tt.wantAnnotations || messageEvents != tt.wantMessageEvents {
+				t.Errorf("got %d annotations and %d message events; want %d and %d",
+					annotations, messageEvents, tt.wantAnnotations, tt.wantMessageEvents)
+			}
+			tes := got.GetTimeEvents()
+			if tes.GetDroppedAnnotationsCount() != tt.wantDroppedAnnots || tes.GetDroppedMessageEventsCount() != tt.wantDroppedMessages {
+				t.Errorf("dropped annotations, message events = %d, %d; want %d, %d",
+					tes.GetDroppedAnnotationsCount(), tes.GetDroppedMessageEventsCount(), tt.wantDroppedAnnots, tt.wantDroppedMessages)
+			}
+		})
+	}
+}
+
+func TestTraceSpansMessageEventName(t *testing.T) {
+	for _, replace := range []bool{false, true} {
+		t.Run(fmt.Sprintf("replace=%v", replace), func(t *testing.T) {
+			e := newTraceExporterWithClient(Options{
+				MessageEventName: func(s *trace.SpanData, me trace.MessageEvent) string {
+					if me.MessageID != 1 {
+						return ""
+					}
+					return s.Name + ".request"
+				},
+				ReplaceNamedMessageEvents: replace,
+				Context:                   context.Background(),
+				Timeout:                   10 * time.Millisecond,
+			}, nil)
+
+			var got *tracepb.Span                      //nolint: staticcheck
+			e.uploadFn = func(spans []*tracepb.Span) { //nolint: staticcheck
+				got = spans[0]
+			}
+			sd := makeSampleSpanData("")
+			sd.Name = "span"
+			sd.Annotations = nil
+			sd.MessageEvents = []trace.MessageEvent{
+				{Time: sd.StartTime, EventType: trace.MessageEventTypeSent, MessageID: 1, UncompressedByteSize: 10},
+				{Time: sd.EndTime, EventType: trace.MessageEventTypeRecv, MessageID: 2},
+			}
+			e.ExportSpan(sd)
+			e.Flush()
+
+			var annotations []*tracepb.Span_TimeEvent_Annotation //nolint: staticcheck
+			var messageIDs []int64
+			for _, event := range got.GetTimeEvents().GetTimeEvent() {
+				if a := event.GetAnnotation(); a != nil {
+					annotations = append(annotations, a)
+				}
+				if me := event.GetMessageEvent(); me != nil {
+					messageIDs = append(messageIDs, me.GetId())
+				}
+			}
+			if len(annotations) != 1 {
+				t.Fatalf("got %d annotations; want 1", len(annotations))
+			}
+			if got, want := annotations[0].GetDescription().GetValue(), "span.request"; got != want {
+				t.Errorf("annotation description = %q; want %q", got, want)
+			}
+			attrs := annotations[0].GetAttributes().GetAttributeMap()
+			if got, want := attrs["message.type"].GetStringValue().GetValue(), "SENT"; got != want {
+				t.Errorf("message.type = %q; want %q", got, want)
+			}
+			if got, want := attrs["message.uncompressed_size"].GetIntValue(), int64(10); got != want {
+				t.Errorf("message.uncompressed_size = %d; want %d", got, want)
+			}
+			wantIDs := []int64{1, 2}
+			if replace {
+				wantIDs = []int64{2}
+			}
+			if diff := cmp.Diff(messageIDs, wantIDs); diff != "" {
+				t.Errorf("message event IDs -got +want: %s", diff)
+			}
+		})
+	}
+}
+
+func TestTraceSpansMessageEventNameTruncated(t *testing.T) {
+	name := strings.Repeat("x", 300)
+	e := newTraceExporterWithClient(Options{
+		MessageEventName: func(s *trace.SpanData, me trace.MessageEvent) string {
+			return name
+		},
+		// Annotation descriptions do not follow the attribute value length.
+		MaxAttributeValueLength: 7,
+		Context:                 context.Background(),
+		Timeout:                 10 * time.Millisecond,
+	}, nil)
+
+	var got *tracepb.Span                      //nolint: staticcheck
+	e.uploadFn = func(spans []*tracepb.Span) { //nolint: staticcheck
+		got = spans[0]
+	}
+	sd := makeSampleSpanData("")
+	sd.Annotations = nil
+	sd.MessageEvents = []trace.MessageEvent{{Time: sd.StartTime, EventType: trace.MessageEventTypeSent, MessageID: 1}}
+	e.ExportSpan(sd)
+	e.Flush()
+
+	for _, event := range got.GetTimeEvents().GetTimeEvent() {
+		if a := event.GetAnnotation(); a != nil {
+			if got, want := a.GetDescription().GetValue(), name[:256]; got != want {
+				t.Errorf("annotation description = %q; want %q", got, want)
+			}
+			if got, want := a.GetDescription().GetTruncatedByteCount(), int32(300-256); got != want {
+				t.Errorf("truncated byte count = %d; want %d", got, want)
+			}
+			return
+		}
+	}
+	t.Fatal("no annotation exported for the named message event")
+}
+
+func TestTraceSpansMessageEventNameAtAnnotationLimit(t *testing.T) {
+	for _, replace := range []bool{false, true} {
+		t.Run(fmt.Sprintf("replace=%v", replace), func(t *testing.T) {
+			e := newTraceExporterWithClient(Options{
+				MessageEventName: func(s *trace.SpanData, me trace.MessageEvent) string {
+					return "named"
+				},
+				ReplaceNamedMessageEvents: replace,
+				MaxAnnotationsPerSpan:     1,
+				Context:                   context.Background(),
+				Timeout:                   10 * time.Millisecond,
+			}, nil)
+
+			var got *tracepb.Span                      //nolint: staticcheck
+			e.uploadFn = func(spans []*tracepb.Span) { //nolint: staticcheck
+				got = spans[0]
+			}
+			sd := makeSampleSpanData("")
+			sd.Annotations = []trace.Annotation{{Time: sd.StartTime, Message: "annotation"}}
+			sd.MessageEvents = []trace.MessageEvent{
+				{Time: sd.StartTime, EventType: trace.MessageEventTypeSent, MessageID: 1},
+			}
+			e.ExportSpan(sd)
+			e.Flush()
+
+			var annotations int
+			var messageIDs []int64
+			for _, event := range got.GetTimeEvents().GetTimeEvent() {
+				if event.GetAnnotation() != nil {
+					annotations++
+				}
+				if me := event.GetMessageEvent(); me != nil {
+					messageIDs = append(messageIDs, me.GetId())
+				}
+			}
+			if annotations != 1 {
+				t.Errorf("got %d annotations; want only the span annotation", annotations)
+			}
+			if diff := cmp.Diff(messageIDs, []int64{1}); diff != "" {
+				t.Errorf("message event IDs -got +want: %s", diff)
+			}
+			wantDropped := int32(1)
+			if replace {
+				wantDropped = 0
+			}
+			if got := got.GetTimeEvents().GetDroppedAnnotationsCount(); got != wantDropped {
+				t.Errorf("dropped annotations = %d; want %d", got, wantDropped)
+			}
+		})
+	}
+}
+
+func TestTraceSpansCompression(t *testing.T) {
+	lis, err := net.Listen("tcp", "localhost:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	encodings := make(chan string, 1)
+	srv := grpc.NewServer(grpc.StatsHandler(headerHandler(func(h *grpcstats.InHeader) {
+		encodings <- h.Compression
+	})))
+	tracepb.RegisterTraceServiceServer(srv, &tracepb.UnimplementedTraceServiceServer{}) //nolint: staticcheck
+	go srv.Serve(lis)                                                                   //nolint: errcheck
+	defer srv.Stop()
+
+	e, err := newTraceExporter(Options{
+		ProjectID: "testproject",
+		TraceClientOptions: []option.ClientOption{
+			option.WithEndpoint(lis.Addr().String()),
+			option.WithoutAuthentication(),
+			option.WithGRPCDialOption(grpc.WithTransportCredentials(insecure.NewCredentials())),
+		},
+		CompressTraces: true,
+		Context:        context.Background(),
+		Timeout:        time.Second,
+		OnError:        func(error) {},
+	})
+	if err != nil {
+		t.Fatalf("newTraceExporter() = %v", err)
+	}
+	e.ExportSpan(makeSampleSpanData(""))
+	e.Flush()
+
+	select {
+	case got := <-encodings:
+		if got != "gzip" {
+			t.Errorf("grpc-encoding = %q; want gzip", got)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("BatchWriteSpans was not called")
+	}
+}
+
+// headerHandler is a gRPC stats handler calling itself with the headers of
+// incoming RPCs.
+type headerHandler func(*grpcstats.InHeader)
+
+func (h headerHandler) TagRPC(ctx context.Context, _ *grpcstats.RPCTagInfo) context.Context {
+	return ctx
+}
+
+func (h headerHandler) HandleRPC(_ context.Context, s grpcstats.RPCStats) {
+	if in, ok := s.(*grpcstats.InHeader); ok {
+		h(in)
+	}
+}
+
+func (h headerHandler) TagConn(ctx context.Context, _ *grpcstats.ConnTagInfo) context.Context {
+	return ctx
+}
+
+func (h headerHandler) HandleConn(context.Context, grpcstats.ConnStats) {}